@@ -0,0 +1,59 @@
+// Copyright 2019, 1533 Systems, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package driveline
+
+import (
+	"context"
+	"net/http"
+)
+
+// Authenticator provides the credentials of the client. Authenticate is called
+// before each connection attempt, to add credentials to the handshake request,
+// for instance an Authorization header or query parameters.
+//
+// When the server rejects the credentials with 401 or 403, Authenticate is
+// called again with refresh set, and the attempt is retried once before it
+// counts as a failure toward MaxReconnect.
+type Authenticator interface {
+	Authenticate(ctx context.Context, request *http.Request, refresh bool) error
+}
+
+// AuthenticatorFunc is a function used as an Authenticator.
+type AuthenticatorFunc func(ctx context.Context, request *http.Request, refresh bool) error
+
+// Authenticate calls f.
+func (f AuthenticatorFunc) Authenticate(ctx context.Context, request *http.Request, refresh bool) error {
+	return f(ctx, request, refresh)
+}
+
+// BearerToken returns an Authenticator sending the token returned by token in
+// an Authorization header. refresh is set when the previous token was rejected,
+// for instance to refresh an OAuth access token.
+func BearerToken(token func(ctx context.Context, refresh bool) (string, error)) Authenticator {
+	return AuthenticatorFunc(func(ctx context.Context, request *http.Request, refresh bool) error {
+		t, err := token(ctx, refresh)
+		if err != nil {
+			return err
+		}
+		request.Header.Set("Authorization", "Bearer "+t)
+		return nil
+	})
+}
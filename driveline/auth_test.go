@@ -0,0 +1,70 @@
+// Copyright 2019, 1533 Systems, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package driveline
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestBearerToken(t *testing.T) {
+	t.Run("sets the Authorization header", func(t *testing.T) {
+		var refreshes []bool
+		authenticator := BearerToken(func(ctx context.Context, refresh bool) (string, error) {
+			refreshes = append(refreshes, refresh)
+			if refresh {
+				return "fresh", nil
+			}
+			return "cached", nil
+		})
+		request, _ := http.NewRequest("GET", "http://localhost/", nil)
+		if err := authenticator.Authenticate(context.Background(), request, false); err != nil {
+			t.Fatal(err)
+		}
+		if request.Header.Get("Authorization") != "Bearer cached" {
+			t.Fail()
+		}
+		if err := authenticator.Authenticate(context.Background(), request, true); err != nil {
+			t.Fatal(err)
+		}
+		if request.Header.Get("Authorization") != "Bearer fresh" {
+			t.Fail()
+		}
+		if len(refreshes) != 2 || refreshes[0] || !refreshes[1] {
+			t.Fail()
+		}
+	})
+	t.Run("returns token errors", func(t *testing.T) {
+		failure := errors.New("token endpoint unavailable")
+		authenticator := BearerToken(func(context.Context, bool) (string, error) {
+			return "", failure
+		})
+		request, _ := http.NewRequest("GET", "http://localhost/", nil)
+		if authenticator.Authenticate(context.Background(), request, false) != failure {
+			t.Fail()
+		}
+		if request.Header.Get("Authorization") != "" {
+			t.Fail()
+		}
+	})
+}
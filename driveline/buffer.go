@@ -0,0 +1,57 @@
+// Copyright 2019, 1533 Systems, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package driveline
+
+import (
+	"sync"
+)
+
+// maxPooledCommandSize bounds the buffers kept for reuse, so that a few large
+// records do not pin memory.
+const maxPooledCommandSize = 4 * 1024 * 1024
+
+// commandBuffer holds an encoded command. It goes back to commandBuffers once
+// the WebSocket wrote it.
+type commandBuffer struct {
+	buf []byte
+}
+
+var commandBuffers = sync.Pool{
+	New: func() interface{} {
+		return new(commandBuffer)
+	},
+}
+
+func getCommandBuffer() *commandBuffer {
+	return commandBuffers.Get().(*commandBuffer)
+}
+
+func (b *commandBuffer) Bytes() []byte {
+	return b.buf
+}
+
+func (b *commandBuffer) Release() {
+	if cap(b.buf) > maxPooledCommandSize {
+		b.buf = nil
+	}
+	b.buf = b.buf[:0]
+	commandBuffers.Put(b)
+}
@@ -0,0 +1,84 @@
+// Copyright 2019, 1533 Systems, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package driveline
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	ws "github.com/1533-systems/golang-sdk/driveline/websocket"
+)
+
+// bufferWebSocket records the buffers written, and releases them.
+type bufferWebSocket struct {
+	discardWebSocket
+	written [][]byte
+}
+
+func (w *bufferWebSocket) WriteBuffer(b ws.Buffer) error {
+	w.written = append(w.written, append([]byte(nil), b.Bytes()...))
+	b.Release()
+	return nil
+}
+
+func TestCommandBuffer(t *testing.T) {
+	t.Run("is reset when released", func(t *testing.T) {
+		b := getCommandBuffer()
+		b.buf = append(b.buf, "command"...)
+		b.Release()
+		if len(b.buf) != 0 {
+			t.Fail()
+		}
+	})
+
+	t.Run("drops large buffers when released", func(t *testing.T) {
+		b := getCommandBuffer()
+		b.buf = make([]byte, maxPooledCommandSize+1)
+		b.Release()
+		if b.buf != nil {
+			t.Fail()
+		}
+	})
+
+	t.Run("sends pooled commands to buffer writers", func(t *testing.T) {
+		w := new(bufferWebSocket)
+		c, _ := NewClient(context.Background(), "ws://test", websocketProvider(func(context.Context, string, ...ws.Option) (ws.WebSocket, error) {
+			return w, nil
+		}))
+		defer c.Close()
+		if err := c.Append("stream", []byte("data")); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Store("key", []byte("data")); err != nil {
+			t.Fatal(err)
+		}
+		if len(w.written) != 2 {
+			t.FailNow()
+		}
+		if !bytes.Equal(w.written[0], encodeAppendByName("stream", []byte("data"))) {
+			t.Fail()
+		}
+		if !bytes.Equal(w.written[1], encodeStore("key", []byte("data"), nil)) {
+			t.Fail()
+		}
+	})
+}
@@ -0,0 +1,173 @@
+// Copyright 2019, 1533 Systems, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+/*
+Package cbor encodes and decodes CBOR (RFC 8949), the format of the payloads
+that Driveline queries with DQL.
+
+Marshal and Unmarshal convert Go values like encoding/json does: structs are
+encoded as maps keyed by field name, which a "cbor" struct tag can change:
+
+	type Order struct {
+		ID     string  `cbor:"id"`
+		Amount float64 `cbor:"amount,omitempty"`
+		Notes  string  `cbor:"-"`
+	}
+
+	payload, err := cbor.Marshal(Order{ID: "o-1", Amount: 12.5})
+	err = client.Append("orders", payload)
+
+	var order Order
+	err = cbor.Unmarshal(record.Record, &order)
+
+Decoding into an interface{} value returns bool, uint64, int64, float64,
+string, []byte, []interface{}, map[string]interface{} (or
+map[interface{}]interface{} when keys are not all strings), time.Time for
+date tags, Tag for other tags, and nil for null and undefined.
+
+Encoder and Decoder read and write sequences of items on streams. Indefinite
+length strings, arrays and maps are decoded, but never encoded.
+*/
+package cbor
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Major types.
+const (
+	majorUnsigned = 0
+	majorNegative = 1
+	majorBytes    = 2
+	majorText     = 3
+	majorArray    = 4
+	majorMap      = 5
+	majorTag      = 6
+	majorSimple   = 7
+)
+
+// Simple values and floats.
+const (
+	simpleFalse     = 20
+	simpleTrue      = 21
+	simpleNull      = 22
+	simpleUndefined = 23
+	simpleFloat16   = 25
+	simpleFloat32   = 26
+	simpleFloat64   = 27
+
+	indefinite = 31
+	breakCode  = 0xff
+)
+
+// Date tags.
+const (
+	tagDateTime  = 0
+	tagEpochTime = 1
+)
+
+// maxNestingDepth limits the nesting of arrays, maps and tags in decoded
+// items.
+const maxNestingDepth = 1000
+
+// Tag is a tagged item. Tags without a Go equivalent decode to Tag when the
+// target is an interface{}.
+type Tag struct {
+	Number  uint64
+	Content interface{}
+}
+
+// RawMessage is an encoded item. It delays decoding, or inserts an encoded
+// item as is.
+type RawMessage []byte
+
+// MarshalCBOR returns m, or null when m is empty.
+func (m RawMessage) MarshalCBOR() ([]byte, error) {
+	if len(m) == 0 {
+		return []byte{majorSimple<<5 | simpleNull}, nil
+	}
+	return m, nil
+}
+
+// UnmarshalCBOR sets *m to a copy of data.
+func (m *RawMessage) UnmarshalCBOR(data []byte) error {
+	*m = append((*m)[:0], data...)
+	return nil
+}
+
+// Marshaler is implemented by types encoding themselves to a CBOR item.
+type Marshaler interface {
+	MarshalCBOR() ([]byte, error)
+}
+
+// Unmarshaler is implemented by types decoding themselves from a CBOR item.
+// data is only valid for the duration of the call.
+type Unmarshaler interface {
+	UnmarshalCBOR(data []byte) error
+}
+
+// SyntaxError is returned for malformed CBOR data. Offset is the position of
+// the invalid item.
+type SyntaxError struct {
+	Offset int
+	Reason string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("cbor: %s at offset %d", e.Reason, e.Offset)
+}
+
+// UnmarshalTypeError is returned when an item cannot be stored in a Go value.
+type UnmarshalTypeError struct {
+	Value  string       // description of the CBOR item, such as "text string"
+	Type   reflect.Type // type of the Go value
+	Offset int
+}
+
+func (e *UnmarshalTypeError) Error() string {
+	return fmt.Sprintf("cbor: cannot decode %s into Go value of type %s at offset %d", e.Value, e.Type, e.Offset)
+}
+
+// UnsupportedTypeError is returned when encoding a value of a type without a
+// CBOR representation, such as a channel or a function.
+type UnsupportedTypeError struct {
+	Type reflect.Type
+}
+
+func (e *UnsupportedTypeError) Error() string {
+	return "cbor: unsupported type " + e.Type.String()
+}
+
+// InvalidUnmarshalError is returned when the target of Unmarshal is not a
+// non-nil pointer.
+type InvalidUnmarshalError struct {
+	Type reflect.Type
+}
+
+func (e *InvalidUnmarshalError) Error() string {
+	if e.Type == nil {
+		return "cbor: Unmarshal(nil)"
+	}
+	if e.Type.Kind() != reflect.Ptr {
+		return "cbor: Unmarshal(non-pointer " + e.Type.String() + ")"
+	}
+	return "cbor: Unmarshal(nil " + e.Type.String() + ")"
+}
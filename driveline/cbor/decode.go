@@ -0,0 +1,830 @@
+// Copyright 2019, 1533 Systems, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cbor
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"reflect"
+	"time"
+)
+
+var (
+	unmarshalerType = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+	interfaceType   = reflect.TypeOf((*interface{})(nil)).Elem()
+)
+
+// Unmarshal decodes the CBOR item in data into the value pointed to by v.
+//
+// It reverses Marshal, allocating pointers, slices and maps as needed, and
+// ignoring map keys that match no struct field; keys match field names
+// exactly, or else ignoring case. Integers decode into floats, and tags whose
+// meaning the target does not use are ignored. Null and undefined set
+// pointers, slices, maps and interfaces to nil, and leave other values
+// unchanged. c.f. the package documentation for interface{} values.
+func Unmarshal(data []byte, v interface{}) error {
+	end, err := scan(data, 0, 0)
+	if err != nil {
+		return syntaxError(err, len(data))
+	}
+	if end != len(data) {
+		return &SyntaxError{Offset: end, Reason: "trailing data after the item"}
+	}
+	return unmarshalItem(data, v)
+}
+
+func unmarshalItem(data []byte, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return &InvalidUnmarshalError{Type: reflect.TypeOf(v)}
+	}
+	d := &decodeState{data: data}
+	return d.value(rv)
+}
+
+func syntaxError(err error, size int) error {
+	if err == io.ErrUnexpectedEOF {
+		return &SyntaxError{Offset: size, Reason: "unexpected end of data"}
+	}
+	return err
+}
+
+// A Decoder reads CBOR items from a stream.
+type Decoder struct {
+	r   io.Reader
+	buf []byte
+	off int // start of the unread data in buf
+	err error
+}
+
+// NewDecoder returns a Decoder reading from r. It may read more data than the
+// items it decodes.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: r}
+}
+
+// Decode reads the next item and stores it in the value pointed to by v, c.f.
+// Unmarshal. It returns io.EOF when the stream ends between items.
+func (dec *Decoder) Decode(v interface{}) error {
+	for {
+		data := dec.buf[dec.off:]
+		end, err := scan(data, 0, 0)
+		if err == nil {
+			dec.off += end
+			return unmarshalItem(data[:end], v)
+		}
+		if err != io.ErrUnexpectedEOF {
+			return err
+		}
+		if dec.err != nil {
+			if dec.err == io.EOF && len(data) > 0 {
+				return io.ErrUnexpectedEOF
+			}
+			return dec.err
+		}
+		dec.refill()
+	}
+}
+
+// refill reads more data, at least doubling the buffer when it is full, so
+// that long items are scanned a logarithmic number of times.
+func (dec *Decoder) refill() {
+	if dec.off > 0 {
+		n := copy(dec.buf, dec.buf[dec.off:])
+		dec.buf = dec.buf[:n]
+		dec.off = 0
+	}
+	if free := cap(dec.buf) - len(dec.buf); free < len(dec.buf) || free < 512 {
+		buf := make([]byte, len(dec.buf), 2*cap(dec.buf)+512)
+		copy(buf, dec.buf)
+		dec.buf = buf
+	}
+	n, err := dec.r.Read(dec.buf[len(dec.buf):cap(dec.buf)])
+	dec.buf = dec.buf[:len(dec.buf)+n]
+	if err != nil {
+		dec.err = err
+	}
+}
+
+// readHead reads the initial byte and argument of the item at pos. info is the
+// additional information; for indefinite lengths and simple values, arg is 0.
+func readHead(data []byte, pos int) (major byte, info byte, arg uint64, next int, err error) {
+	if pos >= len(data) {
+		return 0, 0, 0, 0, io.ErrUnexpectedEOF
+	}
+	major, info = data[pos]>>5, data[pos]&0x1f
+	next = pos + 1
+	var size int
+	switch {
+	case info < 24:
+		return major, info, uint64(info), next, nil
+	case info == 24:
+		size = 1
+	case info == 25:
+		size = 2
+	case info == 26:
+		size = 4
+	case info == 27:
+		size = 8
+	case info == indefinite:
+		return major, info, 0, next, nil
+	default:
+		return 0, 0, 0, 0, &SyntaxError{Offset: pos, Reason: "reserved additional information"}
+	}
+	if len(data)-next < size {
+		return 0, 0, 0, 0, io.ErrUnexpectedEOF
+	}
+	switch size {
+	case 1:
+		arg = uint64(data[next])
+	case 2:
+		arg = uint64(binary.BigEndian.Uint16(data[next:]))
+	case 4:
+		arg = uint64(binary.BigEndian.Uint32(data[next:]))
+	default:
+		arg = binary.BigEndian.Uint64(data[next:])
+	}
+	return major, info, arg, next + size, nil
+}
+
+// scan checks that the item at pos is well-formed, and returns its end. It
+// returns io.ErrUnexpectedEOF when the item is truncated.
+func scan(data []byte, pos int, depth int) (int, error) {
+	if depth > maxNestingDepth {
+		return 0, &SyntaxError{Offset: pos, Reason: "maximum nesting depth exceeded"}
+	}
+	major, info, arg, next, err := readHead(data, pos)
+	if err != nil {
+		return 0, err
+	}
+	if info == indefinite {
+		switch major {
+		case majorBytes, majorText:
+			for {
+				if next >= len(data) {
+					return 0, io.ErrUnexpectedEOF
+				}
+				if data[next] == breakCode {
+					return next + 1, nil
+				}
+				chunkMajor, chunkInfo, _, _, err := readHead(data, next)
+				if err != nil {
+					return 0, err
+				}
+				if chunkMajor != major || chunkInfo == indefinite {
+					return 0, &SyntaxError{Offset: next, Reason: "invalid chunk of indefinite length string"}
+				}
+				if next, err = scan(data, next, depth+1); err != nil {
+					return 0, err
+				}
+			}
+		case majorArray, majorMap:
+			for items := 0; ; items++ {
+				if next >= len(data) {
+					return 0, io.ErrUnexpectedEOF
+				}
+				if data[next] == breakCode {
+					if major == majorMap && items%2 != 0 {
+						return 0, &SyntaxError{Offset: next, Reason: "map without a value for its last key"}
+					}
+					return next + 1, nil
+				}
+				if next, err = scan(data, next, depth+1); err != nil {
+					return 0, err
+				}
+			}
+		case majorSimple:
+			return 0, &SyntaxError{Offset: pos, Reason: "unexpected break"}
+		default:
+			return 0, &SyntaxError{Offset: pos, Reason: "indefinite length integer or tag"}
+		}
+	}
+	switch major {
+	case majorBytes, majorText:
+		if arg > uint64(len(data)-next) {
+			return 0, io.ErrUnexpectedEOF
+		}
+		return next + int(arg), nil
+	case majorArray, majorMap:
+		items := arg
+		if major == majorMap {
+			if items > math.MaxUint64/2 {
+				return 0, io.ErrUnexpectedEOF
+			}
+			items *= 2
+		}
+		// Every item takes at least one byte.
+		if items > uint64(len(data)-next) {
+			return 0, io.ErrUnexpectedEOF
+		}
+		for i := uint64(0); i < items; i++ {
+			if next, err = scan(data, next, depth+1); err != nil {
+				return 0, err
+			}
+		}
+		return next, nil
+	case majorTag:
+		return scan(data, next, depth+1)
+	case majorSimple:
+		if info == 24 && arg < 32 {
+			return 0, &SyntaxError{Offset: pos, Reason: "invalid simple value encoding"}
+		}
+	}
+	return next, nil
+}
+
+// decodeState decodes a well-formed item.
+type decodeState struct {
+	data []byte
+	off  int
+}
+
+func (d *decodeState) head() (major byte, info byte, arg uint64) {
+	major, info, arg, d.off, _ = readHead(d.data, d.off)
+	return major, info, arg
+}
+
+func (d *decodeState) skip() {
+	d.off, _ = scan(d.data, d.off, 0)
+}
+
+// isBreak consumes the break ending an indefinite length item, if it is next.
+func (d *decodeState) isBreak() bool {
+	if d.data[d.off] == breakCode {
+		d.off++
+		return true
+	}
+	return false
+}
+
+func (d *decodeState) typeError(value string, t reflect.Type, offset int) error {
+	return &UnmarshalTypeError{Value: value, Type: t, Offset: offset}
+}
+
+// describe names the item at the current offset, for type errors.
+func (d *decodeState) describe() string {
+	major, info := d.data[d.off]>>5, d.data[d.off]&0x1f
+	switch major {
+	case majorUnsigned:
+		return "unsigned integer"
+	case majorNegative:
+		return "negative integer"
+	case majorBytes:
+		return "byte string"
+	case majorText:
+		return "text string"
+	case majorArray:
+		return "array"
+	case majorMap:
+		return "map"
+	case majorTag:
+		return "tag"
+	}
+	switch info {
+	case simpleFalse, simpleTrue:
+		return "bool"
+	case simpleNull:
+		return "null"
+	case simpleUndefined:
+		return "undefined"
+	case simpleFloat16, simpleFloat32, simpleFloat64:
+		return "float"
+	}
+	return "simple value"
+}
+
+// isNull tells whether the item at the current offset is null or undefined.
+func (d *decodeState) isNull() bool {
+	b := d.data[d.off]
+	return b == majorSimple<<5|simpleNull || b == majorSimple<<5|simpleUndefined
+}
+
+// indirect walks down v, allocating pointers, until it reaches a value that is
+// not a pointer or that implements Unmarshaler. With decodingNull, it stops at
+// the last pointer, so that it can be set to nil.
+func indirect(v reflect.Value, decodingNull bool) (Unmarshaler, reflect.Value) {
+	// Start from the address of named values, whose methods may have pointer
+	// receivers.
+	if v.Kind() != reflect.Ptr && v.Type().Name() != "" && v.CanAddr() {
+		if u, ok := v.Addr().Interface().(Unmarshaler); ok {
+			return u, reflect.Value{}
+		}
+	}
+	for {
+		if v.Kind() == reflect.Interface && !v.IsNil() {
+			e := v.Elem()
+			if e.Kind() == reflect.Ptr && !e.IsNil() && (!decodingNull || e.Elem().Kind() == reflect.Ptr) {
+				v = e
+				continue
+			}
+		}
+		if v.Kind() != reflect.Ptr {
+			break
+		}
+		if decodingNull && v.CanSet() {
+			break
+		}
+		if v.Elem().Kind() == reflect.Interface && v.Elem().Elem() == v {
+			// A pointer to an interface holding the pointer itself.
+			v = v.Elem()
+			break
+		}
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		if v.Type().NumMethod() > 0 && v.CanInterface() {
+			if u, ok := v.Interface().(Unmarshaler); ok {
+				return u, reflect.Value{}
+			}
+		}
+		v = v.Elem()
+	}
+	return nil, v
+}
+
+func (d *decodeState) value(v reflect.Value) error {
+	start := d.off
+	if d.isNull() {
+		d.off++
+		u, v := indirect(v, true)
+		if u != nil {
+			return u.UnmarshalCBOR(d.data[start:d.off])
+		}
+		switch v.Kind() {
+		case reflect.Interface, reflect.Ptr, reflect.Map, reflect.Slice:
+			v.Set(reflect.Zero(v.Type()))
+		}
+		return nil
+	}
+	u, v := indirect(v, false)
+	if u != nil {
+		d.skip()
+		return u.UnmarshalCBOR(d.data[start:d.off])
+	}
+	if v.Kind() == reflect.Interface {
+		if v.NumMethod() > 0 {
+			return d.typeError(d.describe(), v.Type(), start)
+		}
+		value, err := d.interfaceValue(0)
+		if err != nil {
+			return err
+		}
+		if value == nil {
+			v.Set(reflect.Zero(v.Type()))
+		} else {
+			v.Set(reflect.ValueOf(value))
+		}
+		return nil
+	}
+	switch v.Type() {
+	case timeType:
+		return d.timeValue(v)
+	case tagType:
+		major, _, arg := d.head()
+		if major != majorTag {
+			d.off = start
+			d.skip()
+			return d.typeError(d.describe(), v.Type(), start)
+		}
+		content, err := d.interfaceValue(0)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(Tag{Number: arg, Content: content}))
+		return nil
+	}
+
+	major, info, arg := d.head()
+	switch major {
+	case majorUnsigned:
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if arg > math.MaxInt64 || v.OverflowInt(int64(arg)) {
+				return d.typeError("unsigned integer overflowing "+v.Type().String(), v.Type(), start)
+			}
+			v.SetInt(int64(arg))
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			if v.OverflowUint(arg) {
+				return d.typeError("unsigned integer overflowing "+v.Type().String(), v.Type(), start)
+			}
+			v.SetUint(arg)
+		case reflect.Float32, reflect.Float64:
+			v.SetFloat(float64(arg))
+		default:
+			return d.typeError("unsigned integer", v.Type(), start)
+		}
+	case majorNegative:
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if arg > math.MaxInt64 || v.OverflowInt(-1-int64(arg)) {
+				return d.typeError("negative integer overflowing "+v.Type().String(), v.Type(), start)
+			}
+			v.SetInt(-1 - int64(arg))
+		case reflect.Float32, reflect.Float64:
+			v.SetFloat(-1 - float64(arg))
+		default:
+			return d.typeError("negative integer", v.Type(), start)
+		}
+	case majorBytes:
+		b := d.stringContent(major, info, arg)
+		switch {
+		case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8:
+			v.SetBytes(append([]byte(nil), b...))
+		case v.Kind() == reflect.Array && v.Type().Elem().Kind() == reflect.Uint8:
+			for i := 0; i < v.Len(); i++ {
+				if i < len(b) {
+					v.Index(i).SetUint(uint64(b[i]))
+				} else {
+					v.Index(i).SetUint(0)
+				}
+			}
+		default:
+			return d.typeError("byte string", v.Type(), start)
+		}
+	case majorText:
+		s := d.stringContent(major, info, arg)
+		if v.Kind() != reflect.String {
+			return d.typeError("text string", v.Type(), start)
+		}
+		v.SetString(string(s))
+	case majorArray:
+		return d.array(v, info, arg, start)
+	case majorMap:
+		return d.mapValue(v, info, arg, start)
+	case majorTag:
+		// The meaning of the tag is not used by the target.
+		return d.value(v)
+	case majorSimple:
+		switch info {
+		case simpleFalse, simpleTrue:
+			if v.Kind() != reflect.Bool {
+				return d.typeError("bool", v.Type(), start)
+			}
+			v.SetBool(info == simpleTrue)
+		case simpleFloat16, simpleFloat32, simpleFloat64:
+			if v.Kind() != reflect.Float32 && v.Kind() != reflect.Float64 {
+				return d.typeError("float", v.Type(), start)
+			}
+			f := decodeFloat(info, arg)
+			if v.OverflowFloat(f) {
+				return d.typeError("float overflowing "+v.Type().String(), v.Type(), start)
+			}
+			v.SetFloat(f)
+		default:
+			return d.typeError("simple value", v.Type(), start)
+		}
+	}
+	return nil
+}
+
+// stringContent returns the content of a string whose head was read, joining
+// the chunks of indefinite length strings.
+func (d *decodeState) stringContent(major byte, info byte, arg uint64) []byte {
+	if info != indefinite {
+		b := d.data[d.off : d.off+int(arg)]
+		d.off += int(arg)
+		return b
+	}
+	var b []byte
+	for !d.isBreak() {
+		_, _, n := d.head()
+		b = append(b, d.data[d.off:d.off+int(n)]...)
+		d.off += int(n)
+	}
+	return b
+}
+
+func (d *decodeState) array(v reflect.Value, info byte, count uint64, start int) error {
+	switch v.Kind() {
+	case reflect.Slice:
+		if info == indefinite {
+			v.SetLen(0)
+			for i := 0; !d.isBreak(); i++ {
+				if i >= v.Cap() {
+					v.Set(reflect.Append(v, reflect.Zero(v.Type().Elem())))
+				} else {
+					v.SetLen(i + 1)
+				}
+				if err := d.value(v.Index(i)); err != nil {
+					return err
+				}
+			}
+			if v.IsNil() {
+				v.Set(reflect.MakeSlice(v.Type(), 0, 0))
+			}
+			return nil
+		}
+		v.Set(reflect.MakeSlice(v.Type(), int(count), int(count)))
+		for i := 0; i < int(count); i++ {
+			if err := d.value(v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Array:
+		i := 0
+		for ; info == indefinite && !d.isBreak() || info != indefinite && uint64(i) < count; i++ {
+			if i >= v.Len() {
+				d.skip()
+				continue
+			}
+			if err := d.value(v.Index(i)); err != nil {
+				return err
+			}
+		}
+		for ; i < v.Len(); i++ {
+			v.Index(i).Set(reflect.Zero(v.Type().Elem()))
+		}
+	default:
+		d.off = start
+		d.skip()
+		return d.typeError("array", v.Type(), start)
+	}
+	return nil
+}
+
+func (d *decodeState) mapValue(v reflect.Value, info byte, count uint64, start int) error {
+	more := func(i int) bool {
+		if info == indefinite {
+			return !d.isBreak()
+		}
+		return uint64(i) < count
+	}
+	switch v.Kind() {
+	case reflect.Map:
+		t := v.Type()
+		if !t.Key().Comparable() {
+			d.off = start
+			d.skip()
+			return d.typeError("map", t, start)
+		}
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(t))
+		}
+		for i := 0; more(i); i++ {
+			keyStart := d.off
+			key := reflect.New(t.Key()).Elem()
+			if err := d.value(key); err != nil {
+				return err
+			}
+			if !hashable(key) {
+				return d.typeError("unhashable map key", t, keyStart)
+			}
+			elem := reflect.New(t.Elem()).Elem()
+			if err := d.value(elem); err != nil {
+				return err
+			}
+			v.SetMapIndex(key, elem)
+		}
+	case reflect.Struct:
+		fields := cachedFields(v.Type())
+		for i := 0; more(i); i++ {
+			major, _, _, _, _ := readHead(d.data, d.off)
+			if major != majorText {
+				d.skip()
+				d.skip()
+				continue
+			}
+			_, info, arg := d.head()
+			name := d.stringContent(majorText, info, arg)
+			f := fields.lookup(string(name))
+			if f == nil {
+				d.skip()
+				continue
+			}
+			if err := d.value(fieldByIndexAlloc(v, f.index)); err != nil {
+				return err
+			}
+		}
+	default:
+		d.off = start
+		d.skip()
+		return d.typeError("map", v.Type(), start)
+	}
+	return nil
+}
+
+// hashable tells whether v can be a map key. Types like Tag are comparable,
+// but not their values holding slices or maps.
+func hashable(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Interface:
+		return v.IsNil() || hashable(v.Elem())
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if !hashable(v.Field(i)) {
+				return false
+			}
+		}
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if !hashable(v.Index(i)) {
+				return false
+			}
+		}
+	case reflect.Slice, reflect.Map, reflect.Func:
+		return false
+	}
+	return true
+}
+
+// fieldByIndexAlloc returns the field of v at index, allocating the embedded
+// pointers on the way.
+func fieldByIndexAlloc(v reflect.Value, index []int) reflect.Value {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v
+}
+
+func (d *decodeState) timeValue(v reflect.Value) error {
+	start := d.off
+	value, err := d.interfaceValue(0)
+	if err != nil {
+		return err
+	}
+	var t time.Time
+	switch value := value.(type) {
+	case time.Time:
+		t = value
+	case string:
+		if t, err = time.Parse(time.RFC3339Nano, value); err != nil {
+			return d.typeError("text string not in RFC 3339 format", v.Type(), start)
+		}
+	case uint64, int64, float64:
+		t = epochTime(value)
+	default:
+		d.off = start
+		return d.typeError(d.describe(), v.Type(), start)
+	}
+	v.Set(reflect.ValueOf(t))
+	return nil
+}
+
+func epochTime(value interface{}) time.Time {
+	switch value := value.(type) {
+	case uint64:
+		return time.Unix(int64(value), 0)
+	case int64:
+		return time.Unix(value, 0)
+	default:
+		f := value.(float64)
+		sec, frac := math.Modf(f)
+		return time.Unix(int64(sec), int64(frac*1e9))
+	}
+}
+
+// interfaceValue decodes the next item into a generic Go value.
+func (d *decodeState) interfaceValue(depth int) (interface{}, error) {
+	start := d.off
+	major, info, arg := d.head()
+	switch major {
+	case majorUnsigned:
+		return arg, nil
+	case majorNegative:
+		if arg > math.MaxInt64 {
+			return nil, d.typeError("negative integer overflowing int64", interfaceType, start)
+		}
+		return -1 - int64(arg), nil
+	case majorBytes:
+		return append([]byte(nil), d.stringContent(major, info, arg)...), nil
+	case majorText:
+		return string(d.stringContent(major, info, arg)), nil
+	case majorArray:
+		var items []interface{}
+		if info != indefinite {
+			items = make([]interface{}, 0, int(arg))
+		}
+		for i := 0; info == indefinite && !d.isBreak() || info != indefinite && uint64(i) < arg; i++ {
+			item, err := d.interfaceValue(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		if items == nil {
+			items = []interface{}{}
+		}
+		return items, nil
+	case majorMap:
+		var keys, values []interface{}
+		texts := true
+		for i := 0; info == indefinite && !d.isBreak() || info != indefinite && uint64(i) < arg; i++ {
+			keyStart := d.off
+			key, err := d.interfaceValue(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			if !hashable(reflect.ValueOf(key)) {
+				return nil, d.typeError("unhashable map key", interfaceType, keyStart)
+			}
+			value, err := d.interfaceValue(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			_, isText := key.(string)
+			texts = texts && isText
+			keys = append(keys, key)
+			values = append(values, value)
+		}
+		if texts {
+			m := make(map[string]interface{}, len(keys))
+			for i, key := range keys {
+				m[key.(string)] = values[i]
+			}
+			return m, nil
+		}
+		m := make(map[interface{}]interface{}, len(keys))
+		for i, key := range keys {
+			m[key] = values[i]
+		}
+		return m, nil
+	case majorTag:
+		content, err := d.interfaceValue(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		switch c := content.(type) {
+		case string:
+			if arg == tagDateTime {
+				if t, err := time.Parse(time.RFC3339Nano, c); err == nil {
+					return t, nil
+				}
+			}
+		case uint64, int64, float64:
+			if arg == tagEpochTime {
+				return epochTime(c), nil
+			}
+		}
+		return Tag{Number: arg, Content: content}, nil
+	}
+	switch info {
+	case simpleFalse:
+		return false, nil
+	case simpleTrue:
+		return true, nil
+	case simpleNull, simpleUndefined:
+		return nil, nil
+	case simpleFloat16, simpleFloat32, simpleFloat64:
+		return decodeFloat(info, arg), nil
+	}
+	return nil, d.typeError("simple value", interfaceType, start)
+}
+
+func decodeFloat(info byte, bits uint64) float64 {
+	switch info {
+	case simpleFloat16:
+		return float16ToFloat64(uint16(bits))
+	case simpleFloat32:
+		return float64(math.Float32frombits(uint32(bits)))
+	default:
+		return math.Float64frombits(bits)
+	}
+}
+
+// float16ToFloat64 converts a half-precision float, c.f. RFC 8949 appendix D.
+func float16ToFloat64(h uint16) float64 {
+	exp := int(h>>10) & 0x1f
+	mant := float64(h & 0x3ff)
+	var f float64
+	switch exp {
+	case 0:
+		f = math.Ldexp(mant, -24)
+	case 31:
+		if mant == 0 {
+			f = math.Inf(1)
+		} else {
+			f = math.NaN()
+		}
+	default:
+		f = math.Ldexp(mant+1024, exp-25)
+	}
+	if h&0x8000 != 0 {
+		return -f
+	}
+	return f
+}
@@ -0,0 +1,62 @@
+// Copyright 2019, 1533 Systems, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cbor
+
+import (
+	"bytes"
+	"testing"
+)
+
+// Run with: go test -fuzz FuzzUnmarshal
+
+func FuzzUnmarshal(f *testing.F) {
+	for _, seed := range []string{
+		"00", "3b7fffffffffffffff", "f97e00", "5f42010243030405ff",
+		"9f018202039f0405ffff", "bf61610161629f0203ffff", "c11a514b67b0",
+		"a2626964636f2d3166616d6f756e74f94a40",
+	} {
+		f.Add(mustDecodeHex(f, seed))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var value interface{}
+		if err := Unmarshal(data, &value); err != nil {
+			switch err.(type) {
+			case *SyntaxError, *UnmarshalTypeError:
+			default:
+				t.Fatalf("unexpected error type %T", err)
+			}
+			return
+		}
+		var order struct {
+			ID     string        `cbor:"id"`
+			Amount float64       `cbor:"amount"`
+			Items  []interface{} `cbor:"items"`
+		}
+		Unmarshal(data, &order)
+		if _, err := Marshal(value); err != nil {
+			t.Fatalf("decoded value cannot be encoded: %v", err)
+		}
+		dec := NewDecoder(bytes.NewReader(data))
+		if err := dec.Decode(&value); err != nil {
+			t.Fatalf("Decoder failed on a valid item: %v", err)
+		}
+	})
+}
@@ -0,0 +1,321 @@
+// Copyright 2019, 1533 Systems, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cbor
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"math"
+	"reflect"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func mustDecodeHex(t testing.TB, s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestUnmarshal(t *testing.T) {
+	t.Run("decodes the examples of RFC 8949 into interface values", func(t *testing.T) {
+		for _, test := range []struct {
+			encoded string
+			value   interface{}
+		}{
+			{"00", uint64(0)},
+			{"17", uint64(23)},
+			{"1903e8", uint64(1000)},
+			{"1bffffffffffffffff", uint64(math.MaxUint64)},
+			{"20", int64(-1)},
+			{"3903e7", int64(-1000)},
+			{"3b7fffffffffffffff", int64(math.MinInt64)},
+			{"f90000", 0.0},
+			{"f93c00", 1.0},
+			{"f97bff", 65504.0},
+			{"f90001", 5.960464477539063e-8},
+			{"f9c400", -4.0},
+			{"f97c00", math.Inf(1)},
+			{"fa47c35000", 100000.0},
+			{"fb3ff199999999999a", 1.1},
+			{"f4", false},
+			{"f5", true},
+			{"f6", nil},
+			{"f7", nil},
+			{"4401020304", []byte{1, 2, 3, 4}},
+			{"6449455446", "IETF"},
+			{"8301820203820405", []interface{}{uint64(1), []interface{}{uint64(2), uint64(3)}, []interface{}{uint64(4), uint64(5)}}},
+			{"a201020304", map[interface{}]interface{}{uint64(1): uint64(2), uint64(3): uint64(4)}},
+			{"a26161016162820203", map[string]interface{}{"a": uint64(1), "b": []interface{}{uint64(2), uint64(3)}}},
+			{"c074323031332d30332d32315432303a30343a30305a", time.Date(2013, 3, 21, 20, 4, 0, 0, time.UTC)},
+			{"c11a514b67b0", time.Unix(1363896240, 0)},
+			{"d82076687474703a2f2f7777772e6578616d706c652e636f6d", Tag{Number: 32, Content: "http://www.example.com"}},
+			// Indefinite lengths.
+			{"5f42010243030405ff", []byte{1, 2, 3, 4, 5}},
+			{"7f657374726561646d696e67ff", "streaming"},
+			{"9fff", []interface{}{}},
+			{"9f018202039f0405ffff", []interface{}{uint64(1), []interface{}{uint64(2), uint64(3)}, []interface{}{uint64(4), uint64(5)}}},
+			{"bf61610161629f0203ffff", map[string]interface{}{"a": uint64(1), "b": []interface{}{uint64(2), uint64(3)}}},
+		} {
+			var value interface{}
+			err := Unmarshal(mustDecodeHex(t, test.encoded), &value)
+			if err != nil || !reflect.DeepEqual(value, test.value) {
+				t.Errorf("Unmarshal(%s) = %#v, %v; want %#v", test.encoded, value, err, test.value)
+			}
+		}
+	})
+
+	t.Run("decodes NaN", func(t *testing.T) {
+		var f float64
+		if err := Unmarshal([]byte{0xf9, 0x7e, 0x00}, &f); err != nil || !math.IsNaN(f) {
+			t.Fail()
+		}
+	})
+
+	t.Run("decodes into typed values", func(t *testing.T) {
+		var i int16
+		var u uint8
+		var f float32
+		var s string
+		var b []byte
+		var a [3]int
+		var p *string
+		var m map[string]int
+		var tm time.Time
+		var tag Tag
+		for _, test := range []struct {
+			encoded string
+			target  interface{}
+			value   interface{}
+		}{
+			{"3863", &i, int16(-100)},
+			{"1818", &u, uint8(24)},
+			{"1864", &f, float32(100)},
+			{"fa47c35000", &f, float32(100000)},
+			{"6449455446", &s, "IETF"},
+			{"d8206449455446", &s, "IETF"},
+			{"4401020304", &b, []byte{1, 2, 3, 4}},
+			{"8401020304", &a, [3]int{1, 2, 3}},
+			{"8109", &a, [3]int{9, 0, 0}},
+			{"6161", &p, func() *string { s := "a"; return &s }()},
+			{"f6", &p, (*string)(nil)},
+			{"a2616101616202", &m, map[string]int{"a": 1, "b": 2}},
+			{"1a514b67b0", &tm, time.Unix(1363896240, 0)},
+			{"c074323031332d30332d32315432303a30343a30305a", &tm, time.Date(2013, 3, 21, 20, 4, 0, 0, time.UTC)},
+			{"c24101", &tag, Tag{Number: 2, Content: []byte{1}}},
+		} {
+			err := Unmarshal(mustDecodeHex(t, test.encoded), test.target)
+			value := reflect.ValueOf(test.target).Elem().Interface()
+			if err != nil || !reflect.DeepEqual(value, test.value) {
+				t.Errorf("Unmarshal(%s) = %#v, %v; want %#v", test.encoded, value, err, test.value)
+			}
+		}
+	})
+
+	t.Run("decodes structs by field name or tag", func(t *testing.T) {
+		type Embedded struct {
+			Shared string
+		}
+		type order struct {
+			*Embedded
+			ID     string   `cbor:"id"`
+			Amount float64  `cbor:"amount"`
+			Tags   []string `cbor:"tags"`
+			Notes  string   `cbor:"-"`
+		}
+		in := map[interface{}]interface{}{
+			"id":      "o-1",
+			"AMOUNT":  12,
+			"tags":    []string{"a", "b"},
+			"shared":  "s",
+			"Notes":   "ignored",
+			"unknown": map[string]int{"x": 1},
+			uint64(1): "ignored",
+		}
+		encoded, err := Marshal(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out order
+		if err := Unmarshal(encoded, &out); err != nil {
+			t.Fatal(err)
+		}
+		if out.ID != "o-1" || out.Amount != 12 || !reflect.DeepEqual(out.Tags, []string{"a", "b"}) {
+			t.Fail()
+		}
+		if out.Embedded == nil || out.Shared != "s" || out.Notes != "" {
+			t.Fail()
+		}
+	})
+
+	t.Run("round trips values", func(t *testing.T) {
+		type point struct {
+			X, Y  int
+			Label string `cbor:"label,omitempty"`
+		}
+		in := map[string][]point{"a": {{1, -2, "p"}, {3, 4, ""}}, "b": nil}
+		encoded, err := Marshal(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out map[string][]point
+		if err := Unmarshal(encoded, &out); err != nil || !reflect.DeepEqual(in, out) {
+			t.Fail()
+		}
+	})
+
+	t.Run("uses Unmarshaler", func(t *testing.T) {
+		var out struct {
+			Raw RawMessage
+		}
+		if err := Unmarshal(mustDecodeHex(t, "a16352617782010f"), &out); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(out.Raw, []byte{0x82, 0x01, 0x0f}) {
+			t.Fail()
+		}
+	})
+
+	t.Run("fails with malformed data", func(t *testing.T) {
+		for _, test := range []struct {
+			encoded string
+			offset  int
+		}{
+			{"", 0},
+			{"18", 1},
+			{"62c3", 2},
+			{"84010203", 4},
+			{"8301", 2},
+			{"9b0000000100000000", 9},
+			{"bb7fffffffffffffff", 9},
+			{"a101", 2},
+			{"1c", 0},
+			{"ff", 0},
+			{"1f", 0},
+			{"5f01ff", 1},
+			{"5f5fffff", 1},
+			{"bf01ff", 2},
+			{"f801", 0},
+			{"0000", 1},
+		} {
+			var value interface{}
+			err := Unmarshal(mustDecodeHex(t, test.encoded), &value)
+			if err, ok := err.(*SyntaxError); !ok || err.Offset != test.offset {
+				t.Errorf("Unmarshal(%s) = %v; want a syntax error at offset %d", test.encoded, err, test.offset)
+			}
+		}
+	})
+
+	t.Run("fails with deeply nested data", func(t *testing.T) {
+		var value interface{}
+		if _, ok := Unmarshal(bytes.Repeat([]byte{0x81}, 2000), &value).(*SyntaxError); !ok {
+			t.Fail()
+		}
+	})
+
+	t.Run("fails with mismatched types", func(t *testing.T) {
+		var i int8
+		var u uint
+		var s string
+		var m map[string]int
+		var keys map[interface{}]int
+		var value interface{}
+		for _, test := range []struct {
+			encoded string
+			target  interface{}
+		}{
+			{"190100", &i},
+			{"20", &u},
+			{"01", &s},
+			{"6161", &i},
+			{"80", &m},
+			{"a18001", &keys},
+			{"3bffffffffffffffff", &value},
+			{"f0", &value},
+			{"a1cf8030", &value},
+		} {
+			err := Unmarshal(mustDecodeHex(t, test.encoded), test.target)
+			if _, ok := err.(*UnmarshalTypeError); !ok {
+				t.Errorf("Unmarshal(%s) = %v; want a type error", test.encoded, err)
+			}
+		}
+	})
+
+	t.Run("fails with an invalid target", func(t *testing.T) {
+		var value int
+		for _, target := range []interface{}{nil, value, (*int)(nil)} {
+			if _, ok := Unmarshal([]byte{0x01}, target).(*InvalidUnmarshalError); !ok {
+				t.Fail()
+			}
+		}
+	})
+}
+
+func TestDecoder(t *testing.T) {
+	t.Run("decodes a sequence of items", func(t *testing.T) {
+		var buf bytes.Buffer
+		enc := NewEncoder(&buf)
+		long := string(bytes.Repeat([]byte{'x'}, 4096))
+		for _, v := range []interface{}{1, long, []int{2, 3}} {
+			if err := enc.Encode(v); err != nil {
+				t.Fatal(err)
+			}
+		}
+		dec := NewDecoder(iotest.OneByteReader(&buf))
+		var i int
+		var s string
+		var a []int
+		for _, v := range []interface{}{&i, &s, &a} {
+			if err := dec.Decode(v); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if i != 1 || s != long || !reflect.DeepEqual(a, []int{2, 3}) {
+			t.Fail()
+		}
+		if err := dec.Decode(&i); err != io.EOF {
+			t.Fail()
+		}
+	})
+
+	t.Run("fails with a truncated item", func(t *testing.T) {
+		var value interface{}
+		dec := NewDecoder(bytes.NewReader([]byte{0x01, 0x82, 0x01}))
+		if err := dec.Decode(&value); err != nil {
+			t.Fail()
+		}
+		if err := dec.Decode(&value); err != io.ErrUnexpectedEOF {
+			t.Fail()
+		}
+	})
+
+	t.Run("fails with malformed data", func(t *testing.T) {
+		var value interface{}
+		dec := NewDecoder(bytes.NewReader([]byte{0xff}))
+		if _, ok := dec.Decode(&value).(*SyntaxError); !ok {
+			t.Fail()
+		}
+	})
+}
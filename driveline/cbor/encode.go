@@ -0,0 +1,319 @@
+// Copyright 2019, 1533 Systems, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cbor
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"reflect"
+	"sort"
+	"time"
+)
+
+var errEncodeDepth = errors.New("cbor: maximum nesting depth exceeded, is the value cyclic?")
+
+var (
+	marshalerType = reflect.TypeOf((*Marshaler)(nil)).Elem()
+	timeType      = reflect.TypeOf(time.Time{})
+	tagType       = reflect.TypeOf(Tag{})
+)
+
+// Marshal returns the CBOR encoding of v.
+//
+// Integers are encoded in the shortest form, float32 and float64 values as
+// single and double precision floats, byte slices and arrays as byte strings,
+// other slices and arrays as arrays, maps as maps sorted by encoded key,
+// structs as maps keyed by field name, time.Time as an RFC 3339 date (tag 0),
+// and nil pointers, slices, maps and interfaces as null. Struct fields follow
+// the rules of encoding/json, with the "cbor" struct tag.
+func Marshal(v interface{}) ([]byte, error) {
+	e := new(encodeState)
+	if err := e.value(reflect.ValueOf(v), 0); err != nil {
+		return nil, err
+	}
+	return e.buf, nil
+}
+
+// An Encoder writes CBOR items to a stream.
+type Encoder struct {
+	w io.Writer
+	e encodeState
+}
+
+// NewEncoder returns an Encoder writing to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Encode writes the CBOR encoding of v, c.f. Marshal.
+func (enc *Encoder) Encode(v interface{}) error {
+	enc.e.buf = enc.e.buf[:0]
+	if err := enc.e.value(reflect.ValueOf(v), 0); err != nil {
+		return err
+	}
+	_, err := enc.w.Write(enc.e.buf)
+	return err
+}
+
+type encodeState struct {
+	buf []byte
+}
+
+// appendHead appends the initial byte of an item of the major type major and
+// its argument n, in the shortest form.
+func appendHead(dst []byte, major byte, n uint64) []byte {
+	m := major << 5
+	switch {
+	case n < 24:
+		return append(dst, m|byte(n))
+	case n <= math.MaxUint8:
+		return append(dst, m|24, byte(n))
+	case n <= math.MaxUint16:
+		return append(dst, m|25, byte(n>>8), byte(n))
+	case n <= math.MaxUint32:
+		return append(dst, m|26, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	default:
+		return append(dst, m|27, byte(n>>56), byte(n>>48), byte(n>>40), byte(n>>32),
+			byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+}
+
+func (e *encodeState) head(major byte, n uint64) {
+	e.buf = appendHead(e.buf, major, n)
+}
+
+func (e *encodeState) simple(value byte) {
+	e.buf = append(e.buf, majorSimple<<5|value)
+}
+
+func (e *encodeState) int(n int64) {
+	if n < 0 {
+		e.head(majorNegative, uint64(-1-n))
+	} else {
+		e.head(majorUnsigned, uint64(n))
+	}
+}
+
+func (e *encodeState) text(s string) {
+	e.head(majorText, uint64(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+func (e *encodeState) value(v reflect.Value, depth int) error {
+	if depth > maxNestingDepth {
+		return errEncodeDepth
+	}
+	if !v.IsValid() {
+		e.simple(simpleNull)
+		return nil
+	}
+	t := v.Type()
+	if t.Implements(marshalerType) {
+		if (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && v.IsNil() {
+			e.simple(simpleNull)
+			return nil
+		}
+		return e.marshaler(v.Interface().(Marshaler))
+	}
+	if v.Kind() != reflect.Ptr && v.CanAddr() && reflect.PtrTo(t).Implements(marshalerType) {
+		return e.marshaler(v.Addr().Interface().(Marshaler))
+	}
+	switch t {
+	case timeType:
+		e.head(majorTag, tagDateTime)
+		e.text(v.Interface().(time.Time).Format(time.RFC3339Nano))
+		return nil
+	case tagType:
+		tag := v.Interface().(Tag)
+		e.head(majorTag, tag.Number)
+		return e.value(reflect.ValueOf(tag.Content), depth+1)
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			e.simple(simpleTrue)
+		} else {
+			e.simple(simpleFalse)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		e.int(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		e.head(majorUnsigned, v.Uint())
+	case reflect.Float32:
+		bits := math.Float32bits(float32(v.Float()))
+		e.buf = append(e.buf, majorSimple<<5|simpleFloat32, byte(bits>>24), byte(bits>>16), byte(bits>>8), byte(bits))
+	case reflect.Float64:
+		bits := math.Float64bits(v.Float())
+		e.buf = append(e.buf, majorSimple<<5|simpleFloat64, byte(bits>>56), byte(bits>>48), byte(bits>>40), byte(bits>>32),
+			byte(bits>>24), byte(bits>>16), byte(bits>>8), byte(bits))
+	case reflect.String:
+		e.text(v.String())
+	case reflect.Slice:
+		if v.IsNil() {
+			e.simple(simpleNull)
+			return nil
+		}
+		if t.Elem().Kind() == reflect.Uint8 {
+			e.head(majorBytes, uint64(v.Len()))
+			e.buf = append(e.buf, v.Bytes()...)
+			return nil
+		}
+		return e.array(v, depth)
+	case reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			e.head(majorBytes, uint64(v.Len()))
+			for i := 0; i < v.Len(); i++ {
+				e.buf = append(e.buf, byte(v.Index(i).Uint()))
+			}
+			return nil
+		}
+		return e.array(v, depth)
+	case reflect.Map:
+		if v.IsNil() {
+			e.simple(simpleNull)
+			return nil
+		}
+		return e.mapValue(v, depth)
+	case reflect.Struct:
+		return e.structValue(v, depth)
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			e.simple(simpleNull)
+			return nil
+		}
+		return e.value(v.Elem(), depth+1)
+	default:
+		return &UnsupportedTypeError{Type: t}
+	}
+	return nil
+}
+
+func (e *encodeState) marshaler(m Marshaler) error {
+	b, err := m.MarshalCBOR()
+	if err != nil {
+		return err
+	}
+	e.buf = append(e.buf, b...)
+	return nil
+}
+
+func (e *encodeState) array(v reflect.Value, depth int) error {
+	n := v.Len()
+	e.head(majorArray, uint64(n))
+	for i := 0; i < n; i++ {
+		if err := e.value(v.Index(i), depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mapValue encodes the entries of a map sorted by encoded key, so that the
+// encoding is deterministic, c.f. RFC 8949 section 4.2.1.
+func (e *encodeState) mapValue(v reflect.Value, depth int) error {
+	type entry struct {
+		key   []byte
+		value reflect.Value
+	}
+	entries := make([]entry, 0, v.Len())
+	keys := new(encodeState)
+	iter := v.MapRange()
+	for iter.Next() {
+		start := len(keys.buf)
+		if err := keys.value(iter.Key(), depth+1); err != nil {
+			return err
+		}
+		entries = append(entries, entry{key: keys.buf[start:len(keys.buf):len(keys.buf)], value: iter.Value()})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return bytes.Compare(entries[i].key, entries[j].key) < 0
+	})
+	e.head(majorMap, uint64(len(entries)))
+	for _, entry := range entries {
+		e.buf = append(e.buf, entry.key...)
+		if err := e.value(entry.value, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *encodeState) structValue(v reflect.Value, depth int) error {
+	fields := cachedFields(v.Type())
+	values := make([]reflect.Value, len(fields.list))
+	count := 0
+	for i := range fields.list {
+		f := &fields.list[i]
+		fv, ok := fieldByIndex(v, f.index)
+		if !ok || (f.omitEmpty && isEmptyValue(fv)) {
+			continue
+		}
+		values[i] = fv
+		count++
+	}
+	e.head(majorMap, uint64(count))
+	for i, fv := range values {
+		if !fv.IsValid() {
+			continue
+		}
+		e.text(fields.list[i].name)
+		if err := e.value(fv, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fieldByIndex returns the field of v at index, or false when it is in a nil
+// embedded pointer.
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
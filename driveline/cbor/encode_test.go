@@ -0,0 +1,134 @@
+// Copyright 2019, 1533 Systems, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cbor
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestMarshal(t *testing.T) {
+	t.Run("encodes the examples of RFC 8949", func(t *testing.T) {
+		for _, test := range []struct {
+			value   interface{}
+			encoded string
+		}{
+			{0, "00"},
+			{uint8(23), "17"},
+			{24, "1818"},
+			{100, "1864"},
+			{1000, "1903e8"},
+			{uint32(1000000), "1a000f4240"},
+			{uint64(1000000000000), "1b000000e8d4a51000"},
+			{uint64(math.MaxUint64), "1bffffffffffffffff"},
+			{-1, "20"},
+			{int8(-100), "3863"},
+			{-1000, "3903e7"},
+			{int64(math.MinInt64), "3b7fffffffffffffff"},
+			{1.1, "fb3ff199999999999a"},
+			{float32(100000.0), "fa47c35000"},
+			{-4.1, "fbc010666666666666"},
+			{false, "f4"},
+			{true, "f5"},
+			{nil, "f6"},
+			{[]byte{1, 2, 3, 4}, "4401020304"},
+			{"", "60"},
+			{"IETF", "6449455446"},
+			{"ü", "62c3bc"},
+			{[]int{}, "80"},
+			{[]interface{}{1, []int{2, 3}, [2]int{4, 5}}, "8301820203820405"},
+			{map[string]int{}, "a0"},
+			{map[int]int{3: 4, 1: 2}, "a201020304"},
+			{map[string]interface{}{"b": []int{2, 3}, "a": 1}, "a26161016162820203"},
+			{Tag{Number: 32, Content: "http://www.example.com"}, "d82076687474703a2f2f7777772e6578616d706c652e636f6d"},
+			{time.Date(2013, 3, 21, 20, 4, 0, 0, time.UTC), "c074323031332d30332d32315432303a30343a30305a"},
+		} {
+			encoded, err := Marshal(test.value)
+			if err != nil || hex.EncodeToString(encoded) != test.encoded {
+				t.Errorf("Marshal(%#v) = %x, %v; want %s", test.value, encoded, err, test.encoded)
+			}
+		}
+	})
+
+	t.Run("encodes nil pointers, slices and raw messages as null", func(t *testing.T) {
+		for _, value := range []interface{}{(*int)(nil), []string(nil), map[string]int(nil), RawMessage(nil)} {
+			encoded, err := Marshal(value)
+			if err != nil || !bytes.Equal(encoded, []byte{0xf6}) {
+				t.Errorf("Marshal(%#v) = %x, %v", value, encoded, err)
+			}
+		}
+	})
+
+	t.Run("encodes struct fields by name or tag", func(t *testing.T) {
+		type Embedded struct {
+			Shared string
+		}
+		type order struct {
+			Embedded
+			ID      string  `cbor:"id"`
+			Amount  float64 `cbor:"amount,omitempty"`
+			Notes   string  `cbor:"-"`
+			private int
+		}
+		encoded, err := Marshal(order{Embedded: Embedded{"s"}, ID: "o-1", Notes: "n", private: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// {"Shared": "s", "id": "o-1"}, in the order of the fields.
+		if hex.EncodeToString(encoded) != "a2665368617265646173626964636f2d31" {
+			t.Errorf("got %x", encoded)
+		}
+	})
+
+	t.Run("uses Marshaler", func(t *testing.T) {
+		encoded, err := Marshal([]interface{}{RawMessage{0x01}, &RawMessage{0x02}})
+		if err != nil || !bytes.Equal(encoded, []byte{0x82, 0x01, 0x02}) {
+			t.Fail()
+		}
+	})
+
+	t.Run("fails with unsupported types", func(t *testing.T) {
+		if _, err := Marshal(make(chan int)); err == nil {
+			t.Fail()
+		} else if _, ok := err.(*UnsupportedTypeError); !ok {
+			t.Fail()
+		}
+		if _, err := Marshal(map[string]interface{}{"f": func() {}}); err == nil {
+			t.Fail()
+		}
+	})
+}
+
+func TestEncoder(t *testing.T) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	for _, v := range []interface{}{1, "a", []int{2}} {
+		if err := enc.Encode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if hex.EncodeToString(buf.Bytes()) != "0161618102" {
+		t.Fail()
+	}
+}
@@ -0,0 +1,161 @@
+// Copyright 2019, 1533 Systems, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cbor
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// field is an encoded field of a struct, possibly of an embedded struct.
+type field struct {
+	name      string
+	index     []int
+	tagged    bool
+	omitEmpty bool
+}
+
+type structFields struct {
+	list   []field
+	byName map[string]*field
+}
+
+var fieldCache sync.Map // map[reflect.Type]*structFields
+
+func cachedFields(t reflect.Type) *structFields {
+	if f, ok := fieldCache.Load(t); ok {
+		return f.(*structFields)
+	}
+	f, _ := fieldCache.LoadOrStore(t, typeFields(t))
+	return f.(*structFields)
+}
+
+// typeFields returns the fields of t, with the rules of encoding/json: fields
+// of embedded structs are promoted, and shallower or tagged fields hide the
+// others of the same name.
+func typeFields(t reflect.Type) *structFields {
+	var all []field
+	var collect func(t reflect.Type, index []int, visited map[reflect.Type]bool)
+	collect = func(t reflect.Type, index []int, visited map[reflect.Type]bool) {
+		if visited[t] {
+			return
+		}
+		visited[t] = true
+		defer delete(visited, t)
+		for i := 0; i < t.NumField(); i++ {
+			sf := t.Field(i)
+			tag := sf.Tag.Get("cbor")
+			if tag == "-" {
+				continue
+			}
+			name, options := tag, ""
+			if comma := strings.IndexByte(tag, ','); comma >= 0 {
+				name, options = tag[:comma], tag[comma+1:]
+			}
+			ft := sf.Type
+			if sf.Anonymous && name == "" {
+				if ft.Kind() == reflect.Ptr {
+					ft = ft.Elem()
+				}
+				if ft.Kind() == reflect.Struct {
+					if sf.PkgPath != "" && sf.Type.Kind() == reflect.Ptr {
+						// Cannot allocate an unexported embedded pointer.
+						continue
+					}
+					collect(ft, append(append([]int(nil), index...), i), visited)
+					continue
+				}
+			}
+			if sf.PkgPath != "" {
+				continue
+			}
+			f := field{
+				name:   name,
+				index:  append(append([]int(nil), index...), i),
+				tagged: name != "",
+			}
+			if f.name == "" {
+				f.name = sf.Name
+			}
+			for _, option := range strings.Split(options, ",") {
+				if option == "omitempty" {
+					f.omitEmpty = true
+				}
+			}
+			all = append(all, f)
+		}
+	}
+	collect(t, nil, make(map[reflect.Type]bool))
+
+	// Keep the dominant field of every name.
+	sort.SliceStable(all, func(i, j int) bool {
+		if all[i].name != all[j].name {
+			return all[i].name < all[j].name
+		}
+		if len(all[i].index) != len(all[j].index) {
+			return len(all[i].index) < len(all[j].index)
+		}
+		return all[i].tagged && !all[j].tagged
+	})
+	var list []field
+	for i := 0; i < len(all); {
+		j := i + 1
+		for j < len(all) && all[j].name == all[i].name {
+			j++
+		}
+		dominant := all[i]
+		if j-i == 1 || len(all[i+1].index) > len(dominant.index) || (dominant.tagged && !all[i+1].tagged) {
+			list = append(list, dominant)
+		}
+		i = j
+	}
+	// Encode in declaration order.
+	sort.Slice(list, func(i, j int) bool {
+		a, b := list[i].index, list[j].index
+		for k := 0; k < len(a) && k < len(b); k++ {
+			if a[k] != b[k] {
+				return a[k] < b[k]
+			}
+		}
+		return len(a) < len(b)
+	})
+	fields := &structFields{list: list, byName: make(map[string]*field, len(list))}
+	for i := range fields.list {
+		fields.byName[fields.list[i].name] = &fields.list[i]
+	}
+	return fields
+}
+
+// lookup returns the field named name, ignoring case when there is no exact
+// match.
+func (s *structFields) lookup(name string) *field {
+	if f := s.byName[name]; f != nil {
+		return f
+	}
+	for i := range s.list {
+		if strings.EqualFold(s.list[i].name, name) {
+			return &s.list[i]
+		}
+	}
+	return nil
+}
@@ -24,258 +24,189 @@ import (
 	"encoding/binary"
 )
 
-func encodeAppendByID(streamID uint64, rec []byte) []byte {
-	buf := make([]byte, 5+sizeOfNumber(streamID)+1+sizeOfBytes(rec))
-	_ = buf[4] // bounds check elimination
-	// Envelope
-	buf[0] = cborArray | 4
-	// Command
-	buf[1] = cborTextString | 3
-	buf[2] = 'a'
-	buf[3] = 'p'
-	buf[4] = 'p'
+// The append* functions append the encoding of a command to dst and return the
+// extended buffer, so that buffers can be reused. The encode* functions return
+// the command in a new buffer of the exact size.
+
+func appendAppendByID(dst []byte, streamID uint64, rec []byte) []byte {
+	// Envelope, Command
+	dst = append(dst, cborArray|4, cborTextString|3, 'a', 'p', 'p')
 	// streamID
-	off := encodeNumberWithType(buf, 5, streamID, cborUnsignedInteger)
+	dst = appendNumberWithType(dst, streamID, cborUnsignedInteger)
 	// Options
-	buf[off] = cborUndefined
-	off++
+	dst = append(dst, cborUndefined)
 	// Data
-	encodeBytesWithType(buf, off, rec, cborByteString)
-	// Done
-	return buf
+	return appendBytesWithType(dst, rec, cborByteString)
 }
 
-func encodeAppendByName(stream string, rec []byte) []byte {
-	buf := make([]byte, 5+sizeOfBytes([]byte(stream))+1+sizeOfBytes(rec))
-	_ = buf[4] // bounds check elimination
-	// Envelope
-	buf[0] = cborArray | 4
-	// Command
-	buf[1] = cborTextString | 3
-	buf[2] = 'a'
-	buf[3] = 'p'
-	buf[4] = 'p'
+func encodeAppendByID(streamID uint64, rec []byte) []byte {
+	return appendAppendByID(make([]byte, 0, 5+sizeOfNumber(streamID)+1+sizeOfBytes(rec)), streamID, rec)
+}
+
+func appendAppendByName(dst []byte, stream string, rec []byte) []byte {
+	// Envelope, Command
+	dst = append(dst, cborArray|4, cborTextString|3, 'a', 'p', 'p')
 	// StreamName
-	off := encodeBytesWithType(buf, 5, []byte(stream), cborTextString)
+	dst = appendText(dst, stream)
 	// Options
-	buf[off] = cborUndefined
-	off++
+	dst = append(dst, cborUndefined)
 	// Data
-	encodeBytesWithType(buf, off, rec, cborByteString)
-	// Done
-	return buf
+	return appendBytesWithType(dst, rec, cborByteString)
 }
 
-func encodeCancel(consumerID uint64) []byte {
-	buf := make([]byte, 5+sizeOfNumber(consumerID)+1)
-	_ = buf[4] // bounds check elimination
-	// Envelope
-	buf[0] = cborArray | 3
-	// Command
-	buf[1] = cborTextString | 3
-	buf[2] = 'c'
-	buf[3] = 'a'
-	buf[4] = 'n'
+func encodeAppendByName(stream string, rec []byte) []byte {
+	return appendAppendByName(make([]byte, 0, 5+sizeOfText(stream)+1+sizeOfBytes(rec)), stream, rec)
+}
+
+func appendCancel(dst []byte, consumerID uint64) []byte {
+	// Envelope, Command
+	dst = append(dst, cborArray|3, cborTextString|3, 'c', 'a', 'n')
 	// ConsumerID
-	off := encodeNumberWithType(buf, 5, consumerID, cborUnsignedInteger)
+	dst = appendNumberWithType(dst, consumerID, cborUnsignedInteger)
 	// Options
-	buf[off] = cborUndefined
-	// Done
-	return buf
+	return append(dst, cborUndefined)
 }
 
-func encodeDefine(aliasID uint8, streamName string) []byte {
-	buf := make([]byte, 5+sizeOfNumber(uint64(aliasID))+sizeOfBytes([]byte(streamName)))
-	_ = buf[4] // bounds check elimination
-	// Envelope
-	buf[0] = cborArray | 3
-	// Command
-	buf[1] = cborTextString | 3
-	buf[2] = 'd'
-	buf[3] = 'e'
-	buf[4] = 'f'
+func encodeCancel(consumerID uint64) []byte {
+	return appendCancel(make([]byte, 0, 5+sizeOfNumber(consumerID)+1), consumerID)
+}
+
+func appendDefine(dst []byte, aliasID uint8, streamName string) []byte {
+	// Envelope, Command
+	dst = append(dst, cborArray|3, cborTextString|3, 'd', 'e', 'f')
 	// AliasID
-	off := encodeNumberWithType(buf, 5, uint64(aliasID), cborUnsignedInteger)
+	dst = appendNumberWithType(dst, uint64(aliasID), cborUnsignedInteger)
 	// Stream Name
-	encodeBytesWithType(buf, off, []byte(streamName), cborTextString)
-	// Done
-	return buf
+	return appendText(dst, streamName)
 }
 
-func encodeList(isStream bool, consumerID uint64, pattern string) []byte {
-	buf := make([]byte, 5+sizeOfNumber(consumerID)+1+sizeOfBytes([]byte(pattern)))
-	_ = buf[4] // bounds check elimination
-	// Envelope
-	buf[0] = cborArray | 4
-	// Command
-	buf[1] = cborTextString | 3
+func encodeDefine(aliasID uint8, streamName string) []byte {
+	return appendDefine(make([]byte, 0, 5+sizeOfNumber(uint64(aliasID))+sizeOfText(streamName)), aliasID, streamName)
+}
+
+func appendList(dst []byte, isStream bool, consumerID uint64, pattern string) []byte {
+	// Envelope, Command
 	if isStream {
-		buf[2] = 's'
-		buf[3] = 'l'
-		buf[4] = 's'
+		dst = append(dst, cborArray|4, cborTextString|3, 's', 'l', 's')
 	} else {
-		buf[2] = 'l'
-		buf[3] = 's'
-		buf[4] = 't'
+		dst = append(dst, cborArray|4, cborTextString|3, 'l', 's', 't')
 	}
 	// ConsumerID
-	off := encodeNumberWithType(buf, 5, consumerID, cborUnsignedInteger)
+	dst = appendNumberWithType(dst, consumerID, cborUnsignedInteger)
 	// Options
-	buf[off] = cborUndefined
-	off++
+	dst = append(dst, cborUndefined)
 	// Pattern
-	encodeBytesWithType(buf, off, []byte(pattern), cborTextString)
-	// Done
-	return buf
+	return appendText(dst, pattern)
 }
 
-func encodeLoad(consumerID uint64, key string) []byte {
-	buf := make([]byte, 4+sizeOfNumber(consumerID)+1+sizeOfBytes([]byte(key)))
-	_ = buf[3] // bounds check elimination
-	// Envelope
-	buf[0] = cborArray | 4
-	// Command
-	buf[1] = cborTextString | 2
-	buf[2] = 'l'
-	buf[3] = 'd'
+func encodeList(isStream bool, consumerID uint64, pattern string) []byte {
+	return appendList(make([]byte, 0, 5+sizeOfNumber(consumerID)+1+sizeOfText(pattern)), isStream, consumerID, pattern)
+}
+
+func appendLoad(dst []byte, consumerID uint64, key string) []byte {
+	// Envelope, Command
+	dst = append(dst, cborArray|4, cborTextString|2, 'l', 'd')
 	// ConsumerID
-	off := encodeNumberWithType(buf, 4, consumerID, cborUnsignedInteger)
+	dst = appendNumberWithType(dst, consumerID, cborUnsignedInteger)
 	// Options
-	buf[off] = cborUndefined
-	off++
-	// Stream
-	encodeBytesWithType(buf, off, []byte(key), cborTextString)
-	// Done
-	return buf
+	dst = append(dst, cborUndefined)
+	// Key
+	return appendText(dst, key)
 }
 
-func encodeQuery(isContinuous bool, consumerID uint64, dql string, options *QueryOptions) []byte {
-	buf := make([]byte, 4+sizeOfNumber(consumerID)+sizeOfQueryOptions(options)+sizeOfBytes([]byte(dql)))
-	_ = buf[3] // bounds check elimination
-	// Envelope
-	buf[0] = cborArray | 4
-	// Command
-	buf[1] = cborTextString | 2
+func encodeLoad(consumerID uint64, key string) []byte {
+	return appendLoad(make([]byte, 0, 4+sizeOfNumber(consumerID)+1+sizeOfText(key)), consumerID, key)
+}
+
+func appendQuery(dst []byte, isContinuous bool, consumerID uint64, dql string, options *QueryOptions) []byte {
+	// Envelope, Command
 	if isContinuous {
-		buf[2] = 's'
+		dst = append(dst, cborArray|4, cborTextString|2, 's', 'q')
 	} else {
-		buf[2] = 'q'
+		dst = append(dst, cborArray|4, cborTextString|2, 'q', 'q')
 	}
-	buf[3] = 'q'
 	// ConsumerID
-	off := encodeNumberWithType(buf, 4, consumerID, cborUnsignedInteger)
+	dst = appendNumberWithType(dst, consumerID, cborUnsignedInteger)
 	// Options
-	off = encodeQueryOptions(buf, off, options)
+	dst = appendQueryOptions(dst, options)
 	// Query
-	encodeBytesWithType(buf, off, []byte(dql), cborTextString)
-	// Done
-	return buf
+	return appendText(dst, dql)
 }
 
-func encodeRemove(key string) []byte {
-	buf := make([]byte, 4+1+sizeOfBytes([]byte(key)))
-	_ = buf[3] // bounds check elimination
-	// Envelope
-	buf[0] = cborArray | 3
-	// Command
-	buf[1] = cborTextString | 2
-	buf[2] = 'r'
-	buf[3] = 'm'
-	// Options
-	buf[4] = cborUndefined
+func encodeQuery(isContinuous bool, consumerID uint64, dql string, options *QueryOptions) []byte {
+	size := 4 + sizeOfNumber(consumerID) + sizeOfQueryOptions(options) + sizeOfText(dql)
+	return appendQuery(make([]byte, 0, size), isContinuous, consumerID, dql, options)
+}
+
+func appendRemove(dst []byte, key string) []byte {
+	// Envelope, Command, Options
+	dst = append(dst, cborArray|3, cborTextString|2, 'r', 'm', cborUndefined)
 	// Key
-	encodeBytesWithType(buf, 5, []byte(key), cborTextString)
-	// Done
-	return buf
+	return appendText(dst, key)
+}
+
+func encodeRemove(key string) []byte {
+	return appendRemove(make([]byte, 0, 4+1+sizeOfText(key)), key)
+}
+
+func appendRemoveMatches(dst []byte, pattern string) []byte {
+	// Envelope, Command, Options
+	dst = append(dst, cborArray|3, cborTextString|3, 'r', 'm', 'k', cborUndefined)
+	// Pattern
+	return appendText(dst, pattern)
 }
 
 func encodeRemoveMatches(pattern string) []byte {
-	buf := make([]byte, 5+1+sizeOfBytes([]byte(pattern)))
-	_ = buf[4] // bounds check elimination
-	// Envelope
-	buf[0] = cborArray | 3
-	// Command
-	buf[1] = cborTextString | 3
-	buf[2] = 'r'
-	buf[3] = 'm'
-	buf[4] = 'k'
-	// Options
-	buf[5] = cborUndefined
-	// Stream Name
-	encodeBytesWithType(buf, 6, []byte(pattern), cborTextString)
-	// Done
-	return buf
+	return appendRemoveMatches(make([]byte, 0, 5+1+sizeOfText(pattern)), pattern)
 }
 
-func encodeStore(key string, data []byte, options *StoreOptions) []byte {
-	buf := make([]byte, 4+sizeOfBytes([]byte(key))+sizeOfStoreOptions(options)+sizeOfBytes(data))
-	_ = buf[3] // bounds check elimination
-	// Envelope
-	buf[0] = cborArray | 4
-	// Command
-	buf[1] = cborTextString | 2
-	buf[2] = 's'
-	buf[3] = 't'
+func appendStore(dst []byte, key string, data []byte, options *StoreOptions) []byte {
+	// Envelope, Command
+	dst = append(dst, cborArray|4, cborTextString|2, 's', 't')
 	// Key
-	off := encodeBytesWithType(buf, 4, []byte(key), cborTextString)
+	dst = appendText(dst, key)
 	// Options
-	off = encodeStoreOptions(buf, off, options)
+	dst = appendStoreOptions(dst, options)
 	// Data
-	encodeBytesWithType(buf, off, data, cborByteString)
-	// Done
-	return buf
+	return appendBytesWithType(dst, data, cborByteString)
 }
 
-func encodeSync(consumerID uint64) []byte {
-	buf := make([]byte, 5+sizeOfNumber(consumerID))
-	_ = buf[4] // bounds check elimination
-	// Envelope
-	buf[0] = cborArray | 2
-	// Command
-	buf[1] = cborTextString | 3
-	buf[2] = 's'
-	buf[3] = 'y'
-	buf[4] = 'n'
+func encodeStore(key string, data []byte, options *StoreOptions) []byte {
+	size := 4 + sizeOfText(key) + sizeOfStoreOptions(options) + sizeOfBytes(data)
+	return appendStore(make([]byte, 0, size), key, data, options)
+}
+
+func appendSync(dst []byte, consumerID uint64) []byte {
+	// Envelope, Command
+	dst = append(dst, cborArray|2, cborTextString|3, 's', 'y', 'n')
 	// consumerId
-	encodeNumberWithType(buf, 5, consumerID, cborUnsignedInteger)
-	// Done
-	return buf
+	return appendNumberWithType(dst, consumerID, cborUnsignedInteger)
+}
+
+func encodeSync(consumerID uint64) []byte {
+	return appendSync(make([]byte, 0, 5+sizeOfNumber(consumerID)), consumerID)
+}
+
+func appendTruncateByID(dst []byte, stream uint64) []byte {
+	// Envelope, Command, Options
+	dst = append(dst, cborArray|3, cborTextString|3, 't', 'r', 'c', cborUndefined)
+	// Stream
+	return appendNumberWithType(dst, stream, cborUnsignedInteger)
 }
 
 func encodeTruncateByID(stream uint64) []byte {
-	buf := make([]byte, 5+1+sizeOfNumber(stream))
-	_ = buf[5] // bounds check elimination
-	// Envelope
-	buf[0] = cborArray | 3
-	// Command
-	buf[1] = cborTextString | 3
-	buf[2] = 't'
-	buf[3] = 'r'
-	buf[4] = 'c'
-	// Options
-	buf[5] = cborUndefined
+	return appendTruncateByID(make([]byte, 0, 5+1+sizeOfNumber(stream)), stream)
+}
+
+func appendTruncateByName(dst []byte, stream string) []byte {
+	// Envelope, Command, Options
+	dst = append(dst, cborArray|3, cborTextString|3, 't', 'r', 'c', cborUndefined)
 	// Stream
-	encodeNumberWithType(buf, 6, stream, cborUnsignedInteger)
-	// Done
-	return buf
+	return appendText(dst, stream)
 }
 
 func encodeTruncateByName(stream string) []byte {
-	buf := make([]byte, 5+1+sizeOfBytes([]byte(stream)))
-	_ = buf[5] // bounds check elimination
-	// Envelope
-	buf[0] = cborArray | 3
-	// Command
-	buf[1] = cborTextString | 3
-	buf[2] = 't'
-	buf[3] = 'r'
-	buf[4] = 'c'
-	// Options
-	buf[5] = cborUndefined
-	// Stream
-	encodeBytesWithType(buf, 6, []byte(stream), cborTextString)
-	// Done
-	return buf
+	return appendTruncateByName(make([]byte, 0, 5+1+sizeOfText(stream)), stream)
 }
 
 func sizeOfNumber(n uint64) int {
@@ -294,76 +225,45 @@ func sizeOfNumber(n uint64) int {
 }
 
 func sizeOfBytes(b []byte) int {
-	n := len(b)
-	switch {
-	case n < 24:
-		return n + 1
-	case n < 0x100:
-		return n + 2
-	case n < 0x10000:
-		return n + 3
-	case n < 0x100000000:
-		return n + 5
-	default:
-		return n + 9
-	}
+	return sizeOfNumber(uint64(len(b))) + len(b)
 }
 
-func encodeNumberWithType(buf []byte, off int, n uint64, cborType byte) int {
-	buf = buf[off:]
-	switch {
-	case n < 24:
-		buf[0] = byte(n) | cborType
-		return off + 1
-	case n < 0x100:
-		buf[0] = 24 | cborType
-		buf[1] = byte(n)
-		return off + 2
-	case n < 0x10000:
-		buf[0] = 25 | cborType
-		binary.BigEndian.PutUint16(buf[1:], uint16(n))
-		return off + 3
-	case n < 0x100000000:
-		buf[0] = 26 | cborType
-		binary.BigEndian.PutUint32(buf[1:], uint32(n))
-		return off + 5
-	default:
-		buf[0] = 27 | cborType
-		binary.BigEndian.PutUint64(buf[1:], n)
-		return off + 9
-	}
+func sizeOfText(s string) int {
+	return sizeOfNumber(uint64(len(s))) + len(s)
 }
 
-func encodeBytesWithType(buf []byte, off int, data []byte, cborType byte) int {
-	b := buf[off:]
-	n := len(data)
+func appendNumberWithType(dst []byte, n uint64, cborType byte) []byte {
 	switch {
-	case n == 0:
-		b[0] = cborType
-		return off + 1
 	case n < 24:
-		b[0] = byte(n) | cborType
-		off += 1
+		return append(dst, byte(n)|cborType)
 	case n < 0x100:
-		b[0] = 24 | cborType
-		b[1] = byte(n)
-		off += 2
+		return append(dst, 24|cborType, byte(n))
 	case n < 0x10000:
-		b[0] = 25 | cborType
-		binary.BigEndian.PutUint16(b[1:], uint16(n))
-		off += 3
+		dst = append(dst, 25|cborType, 0, 0)
+		binary.BigEndian.PutUint16(dst[len(dst)-2:], uint16(n))
+		return dst
 	case n < 0x100000000:
-		b[0] = 26 | cborType
-		binary.BigEndian.PutUint32(b[1:], uint32(n))
-		off += 5
+		dst = append(dst, 26|cborType, 0, 0, 0, 0)
+		binary.BigEndian.PutUint32(dst[len(dst)-4:], uint32(n))
+		return dst
 	default:
-		b[0] = 27 | cborType
-		binary.BigEndian.PutUint64(b[1:], uint64(n))
-		off += 9
+		dst = append(dst, 27|cborType, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(dst[len(dst)-8:], n)
+		return dst
 	}
-	return off + copy(buf[off:], data)
 }
 
-func encodeRecordID(buf []byte, off int, recordID RecordID) int {
-	return encodeBytesWithType(buf, off, []byte(recordID), cborByteString)
+func appendBytesWithType(dst []byte, data []byte, cborType byte) []byte {
+	dst = appendNumberWithType(dst, uint64(len(data)), cborType)
+	return append(dst, data...)
+}
+
+// appendText appends a text string, without converting s to a []byte.
+func appendText(dst []byte, s string) []byte {
+	dst = appendNumberWithType(dst, uint64(len(s)), cborTextString)
+	return append(dst, s...)
+}
+
+func appendRecordID(dst []byte, recordID RecordID) []byte {
+	return appendBytesWithType(dst, recordID, cborByteString)
 }
@@ -21,9 +21,12 @@
 package driveline
 
 import (
+	"context"
 	"strconv"
 	"testing"
 	"time"
+
+	ws "github.com/1533-systems/golang-sdk/driveline/websocket"
 )
 
 var payloads = []int{16, 256, 1024, 4096, 65535, 1024 * 1024}
@@ -44,6 +47,51 @@ func BenchmarkEncodeAppendByName(b *testing.B) {
 	})
 }
 
+func BenchmarkAppendAppendByID(b *testing.B) {
+	runWithPayload(b, func(b *testing.B, rec []byte) {
+		b.ReportAllocs()
+		var buf []byte
+		for i := 0; i < b.N; i++ {
+			buf = appendAppendByID(buf[:0], 1533, rec)
+		}
+	})
+}
+
+// discardWebSocket releases buffers as soon as they are written, like the
+// WebSocket writer does.
+type discardWebSocket struct{}
+
+func (discardWebSocket) Write(buf []byte) (int, error) {
+	return len(buf), nil
+}
+
+func (discardWebSocket) WriteBuffer(b ws.Buffer) error {
+	b.Release()
+	return nil
+}
+
+func (discardWebSocket) Close() error {
+	return nil
+}
+
+func BenchmarkStreamAppend(b *testing.B) {
+	c, _ := NewClient(context.Background(), "ws://test", websocketProvider(func(context.Context, string, ...ws.Option) (ws.WebSocket, error) {
+		return discardWebSocket{}, nil
+	}))
+	defer c.Close()
+	s, _ := c.OpenStream("stream-name")
+	runWithPayload(b, func(b *testing.B, rec []byte) {
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				if err := s.Append(rec); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	})
+}
+
 func BenchmarkEncodeCancel(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		encodeCancel(1533)
@@ -135,10 +183,10 @@ func BenchmarkTruncateByName(b *testing.B) {
 	}
 }
 
-func BenchmarkEncodeNumberWithType(b *testing.B) {
+func BenchmarkAppendNumberWithType(b *testing.B) {
 	var buf [20]byte
 	for i := 0; i < b.N; i++ {
-		encodeNumberWithType(buf[0:20], 0, 0x0102030405060708, 0)
+		appendNumberWithType(buf[:0], 0x0102030405060708, 0)
 	}
 }
 
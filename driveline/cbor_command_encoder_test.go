@@ -23,6 +23,7 @@ package driveline
 import (
 	"bytes"
 	"testing"
+	"time"
 )
 
 func TestEncodeAppendByID(t *testing.T) {
@@ -233,6 +234,34 @@ func TestEncodeSync(t *testing.T) {
 	}
 }
 
+func TestEncodeExactSize(t *testing.T) {
+	var storeOptions StoreOptions
+	storeOptions.WithTTL(time.Second)
+	storeOptions.CompareAndSwap(testRecordID)
+	var queryOptions QueryOptions
+	queryOptions.FromRecordID(testRecordID)
+	rec := make([]byte, 300)
+	for i, buf := range [][]byte{
+		encodeAppendByID(1533, rec),
+		encodeAppendByName("stream", rec),
+		encodeCancel(1533),
+		encodeDefine(25, "stream"),
+		encodeList(true, 1533, "pattern"),
+		encodeLoad(1533, "key"),
+		encodeQuery(true, 1533, "SELECT * FROM 'kv/**'", &queryOptions),
+		encodeRemove("key"),
+		encodeRemoveMatches("pattern"),
+		encodeStore("key", rec, &storeOptions),
+		encodeSync(1533),
+		encodeTruncateByID(1533),
+		encodeTruncateByName("stream"),
+	} {
+		if len(buf) != cap(buf) {
+			t.Errorf("command %d: %d bytes in a buffer of %d", i, len(buf), cap(buf))
+		}
+	}
+}
+
 func TestSizeOfNumber(t *testing.T) {
 	t.Run("small number", func(t *testing.T) {
 		if sizeOfNumber(22) != 1 {
@@ -265,11 +294,10 @@ func TestSizeOfNumber(t *testing.T) {
 	})
 }
 
-func TestEncodeNumberWithType(t *testing.T) {
+func TestAppendNumberWithType(t *testing.T) {
 	t.Run("small number", func(t *testing.T) {
-		var data = make([]byte, 16)
-		off := encodeNumberWithType(data, 0, 23, cborUnsignedInteger)
-		if off != 1 {
+		data := appendNumberWithType(nil, 23, cborUnsignedInteger)
+		if len(data) != 1 {
 			t.Fail()
 		}
 		if data[0] != cborUnsignedInteger|23 {
@@ -277,9 +305,8 @@ func TestEncodeNumberWithType(t *testing.T) {
 		}
 	})
 	t.Run("small 8-bit", func(t *testing.T) {
-		var data = make([]byte, 16)
-		off := encodeNumberWithType(data, 0, 200, cborUnsignedInteger)
-		if off != 2 {
+		data := appendNumberWithType(nil, 200, cborUnsignedInteger)
+		if len(data) != 2 {
 			t.Fail()
 		}
 		if data[0] != cborUnsignedInteger|24 && data[1] != 200 {
@@ -288,34 +315,31 @@ func TestEncodeNumberWithType(t *testing.T) {
 	})
 
 	t.Run("16-bit", func(t *testing.T) {
-		var data = make([]byte, 16)
-		off := encodeNumberWithType(data, 0, 0x1234, cborUnsignedInteger)
-		if off != 3 {
+		data := appendNumberWithType(nil, 0x1234, cborUnsignedInteger)
+		if len(data) != 3 {
 			t.Fail()
 		}
-		if bytes.Compare(data[:off], []byte{cborUnsignedInteger | 25, 0x12, 0x34}) != 0 {
+		if bytes.Compare(data, []byte{cborUnsignedInteger | 25, 0x12, 0x34}) != 0 {
 			t.Fail()
 		}
 	})
 
 	t.Run("32-bit", func(t *testing.T) {
-		var data = make([]byte, 16)
-		off := encodeNumberWithType(data, 0, 0x12345678, cborUnsignedInteger)
-		if off != 5 {
+		data := appendNumberWithType(nil, 0x12345678, cborUnsignedInteger)
+		if len(data) != 5 {
 			t.Fail()
 		}
-		if bytes.Compare(data[:off], []byte{cborUnsignedInteger | 26, 0x12, 0x34, 0x56, 0x78}) != 0 {
+		if bytes.Compare(data, []byte{cborUnsignedInteger | 26, 0x12, 0x34, 0x56, 0x78}) != 0 {
 			t.Fail()
 		}
 	})
 
 	t.Run("64-bit", func(t *testing.T) {
-		var data = make([]byte, 16)
-		off := encodeNumberWithType(data, 0, 0x0102030405060708, cborUnsignedInteger)
-		if off != 9 {
+		data := appendNumberWithType(nil, 0x0102030405060708, cborUnsignedInteger)
+		if len(data) != 9 {
 			t.Fail()
 		}
-		if bytes.Compare(data[:off], []byte{cborUnsignedInteger | 27, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08}) != 0 {
+		if bytes.Compare(data, []byte{cborUnsignedInteger | 27, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08}) != 0 {
 			t.Fail()
 		}
 	})
@@ -358,5 +382,23 @@ func TestSizeOfBytes(t *testing.T) {
 	})
 }
 
-func TestEncodeBytesWithType(t *testing.T) {
+func TestAppendBytesWithType(t *testing.T) {
+	t.Run("appends to the buffer", func(t *testing.T) {
+		data := appendBytesWithType([]byte{0x01}, []byte("data"), cborByteString)
+		if bytes.Compare(data, []byte{0x01, cborByteString | 4, 'd', 'a', 't', 'a'}) != 0 {
+			t.Fail()
+		}
+	})
+	t.Run("empty slice", func(t *testing.T) {
+		data := appendBytesWithType(nil, nil, cborTextString)
+		if bytes.Compare(data, []byte{cborTextString}) != 0 {
+			t.Fail()
+		}
+	})
+	t.Run("<64K text", func(t *testing.T) {
+		data := appendText(nil, string(make([]byte, 1200)))
+		if len(data) != sizeOfBytes(make([]byte, 1200)) || data[0] != cborTextString|25 {
+			t.Fail()
+		}
+	})
 }
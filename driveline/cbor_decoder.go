@@ -22,208 +22,348 @@ package driveline
 
 import (
 	"encoding/binary"
-	"errors"
+	"fmt"
+
+	"github.com/1533-systems/golang-sdk/driveline/cbor"
 )
 
+// DecodeError is returned when a server message cannot be decoded. Offset is
+// the position of the invalid item in the message.
+type DecodeError struct {
+	Offset int
+	Reason string
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("%s at offset %d: %s", ErrInvalidServerMessage, e.Offset, e.Reason)
+}
+
+// decodeRecord decodes a record sent by the server itself, such as the lists
+// of keys or streams, reporting malformed records as DecodeError.
+func decodeRecord(data []byte, v interface{}) error {
+	err := cbor.Unmarshal(data, v)
+	switch e := err.(type) {
+	case *cbor.SyntaxError:
+		return &DecodeError{Offset: e.Offset, Reason: e.Reason}
+	case *cbor.UnmarshalTypeError:
+		return &DecodeError{Offset: e.Offset, Reason: "unexpected " + e.Value}
+	}
+	return err
+}
+
 type serverMsg struct {
 	consumerID uint64
 	err        error
 	records    []Record
 }
 
-func decodeServerMessage(buf []byte) (*serverMsg, error) {
-	if !isArray(buf[0]) {
-		return nil, ErrInvalidServerMessage
+// cborReader decodes CBOR items from buf, checking every length against the
+// remaining bytes.
+type cborReader struct {
+	buf []byte
+	pos int
+}
+
+func (r *cborReader) fail(reason string) error {
+	return &DecodeError{Offset: r.pos, Reason: reason}
+}
+
+func (r *cborReader) remaining() int {
+	return len(r.buf) - r.pos
+}
+
+// peek returns the initial byte of the next item.
+func (r *cborReader) peek() (byte, error) {
+	if r.pos >= len(r.buf) {
+		return 0, r.fail("unexpected end of message")
+	}
+	return r.buf[r.pos], nil
+}
+
+// readArgument reads the initial byte of an item and its argument, which is
+// the value of an integer or the length of a string, array or map.
+func (r *cborReader) readArgument() (uint64, error) {
+	d, err := r.peek()
+	if err != nil {
+		return 0, err
+	}
+	size := lenCode(d)
+	var n int
+	switch {
+	case size < 24:
+		r.pos++
+		return size, nil
+	case size == 24:
+		n = 1
+	case size == 25:
+		n = 2
+	case size == 26:
+		n = 4
+	case size == 27:
+		n = 8
+	default:
+		return 0, r.fail(fmt.Sprintf("unsupported argument encoding %d", size))
+	}
+	if r.remaining() < 1+n {
+		return 0, r.fail("truncated argument")
+	}
+	b := r.buf[r.pos+1 : r.pos+1+n]
+	r.pos += 1 + n
+	switch n {
+	case 1:
+		return uint64(b[0]), nil
+	case 2:
+		return uint64(binary.BigEndian.Uint16(b)), nil
+	case 4:
+		return uint64(binary.BigEndian.Uint32(b)), nil
+	default:
+		return binary.BigEndian.Uint64(b), nil
 	}
-	itemCount, buf, err := decodeNumber(buf)
+}
+
+// readUnsigned reads an unsigned integer.
+func (r *cborReader) readUnsigned() (uint64, error) {
+	d, err := r.peek()
+	if err != nil {
+		return 0, err
+	}
+	if !isUnsignedInteger(d) {
+		return 0, r.fail("expected an unsigned integer")
+	}
+	return r.readArgument()
+}
+
+// readArray reads the header of an array of at most max items.
+func (r *cborReader) readArray(max int) (int, error) {
+	d, err := r.peek()
+	if err != nil {
+		return 0, err
+	}
+	if !isArray(d) {
+		return 0, r.fail("expected an array")
+	}
+	start := r.pos
+	count, err := r.readArgument()
+	if err != nil {
+		return 0, err
+	}
+	if count > uint64(max) {
+		r.pos = start
+		return 0, r.fail(fmt.Sprintf("array of %d items larger than the message", count))
+	}
+	return int(count), nil
+}
+
+// readString reads the content of a string of the major type t, without
+// copying it.
+func (r *cborReader) readString(t byte, name string) ([]byte, error) {
+	d, err := r.peek()
 	if err != nil {
 		return nil, err
 	}
-	_ = buf[1] // bounds check elimination
-	if buf[0] == (cborTextString|4) && buf[1] == 'd' {
-		return decodeDataMessage(buf[5:], int(itemCount)-3)
-	} else if buf[0] == (cborTextString | 3) {
-		if buf[1] == 's' {
-			return decodeSyncMessage(buf[4:])
-		} else if buf[1] == 'e' {
-			return decodeErrorMessage(buf[4:])
-		}
+	if d&cborTypeMask != t {
+		return nil, r.fail("expected a " + name)
 	}
-	return nil, ErrInvalidServerMessage
+	start := r.pos
+	size, err := r.readArgument()
+	if err != nil {
+		return nil, err
+	}
+	if size > uint64(r.remaining()) {
+		r.pos = start
+		return nil, r.fail(fmt.Sprintf("%s of %d bytes truncated", name, size))
+	}
+	b := r.buf[r.pos : r.pos+int(size)]
+	r.pos += int(size)
+	return b, nil
 }
 
-func decodeDataMessage(buf []byte, recordCount int) (*serverMsg, error) {
-	if !isUnsignedInteger(buf[0]) {
-		return nil, ErrInvalidServerMessage
+// readBytes reads a byte string, or nil for null and undefined.
+func (r *cborReader) readBytes() ([]byte, error) {
+	d, err := r.peek()
+	if err != nil {
+		return nil, err
+	}
+	if isBlank(d) {
+		r.pos++
+		return nil, nil
 	}
-	consumerID, buf, err := decodeNumber(buf)
+	return r.readString(cborByteString, "byte string")
+}
+
+// readText reads a text string, or "" for null and undefined.
+func (r *cborReader) readText() (string, error) {
+	d, err := r.peek()
 	if err != nil {
+		return "", err
+	}
+	if isBlank(d) {
+		r.pos++
+		return "", nil
+	}
+	b, err := r.readString(cborTextString, "text string")
+	return string(b), err
+}
+
+func decodeServerMessage(buf []byte) (*serverMsg, error) {
+	msg := new(serverMsg)
+	if err := decodeServerMessageInto(msg, buf); err != nil {
 		return nil, err
 	}
-	records := make([]Record, recordCount)
-	d := buf[0]
+	return msg, nil
+}
+
+// decodeServerMessageInto decodes buf into msg, reusing the records of msg.
+// The records alias buf.
+func decodeServerMessageInto(msg *serverMsg, buf []byte) error {
+	*msg = serverMsg{records: msg.records[:0]}
+	r := cborReader{buf: buf}
+	itemCount, err := r.readArray(r.remaining())
+	if err != nil {
+		return err
+	}
+	start := r.pos
+	command, err := r.readString(cborTextString, "text string")
+	if err != nil {
+		return err
+	}
+	switch {
+	case string(command) == "data" && itemCount >= 3:
+		return decodeDataMessage(&r, msg, itemCount-3)
+	case string(command) == "syn" && itemCount >= 2:
+		return decodeSyncMessage(&r, msg)
+	case string(command) == "err" && itemCount >= 3:
+		return decodeErrorMessage(&r, msg)
+	}
+	r.pos = start
+	return r.fail(fmt.Sprintf("unknown message %q of %d items", command, itemCount))
+}
+
+func decodeDataMessage(r *cborReader, msg *serverMsg, recordCount int) error {
+	consumerID, err := r.readUnsigned()
+	if err != nil {
+		return err
+	}
+	// Every record takes at least one byte.
+	if recordCount > r.remaining() {
+		return r.fail(fmt.Sprintf("%d records larger than the message", recordCount))
+	}
+	records := msg.records
+	if cap(records) < recordCount {
+		records = make([]Record, recordCount)
+	}
+	records = records[:recordCount]
+	for i := range records {
+		records[i] = Record{}
+	}
+	d, err := r.peek()
+	if err != nil {
+		return err
+	}
 	switch {
 	case isArray(d):
-		var tagCount uint64
-		tagCount, buf, err = decodeNumber(buf)
+		tagCount, err := r.readArray(r.remaining())
 		if err != nil {
-			return nil, err
+			return err
 		}
 		// Must be an even number of entries
 		if tagCount%2 != 0 {
-			return nil, ErrInvalidServerMessage
+			return r.fail("odd number of options")
 		}
-		for i := 0; i < int(tagCount); i += 2 {
-			d := buf[0]
-			if !isUnsignedInteger(d) {
-				return nil, ErrInvalidServerMessage
+		for i := 0; i < tagCount; i += 2 {
+			d, err := r.peek()
+			if err != nil {
+				return err
 			}
 			if d != encodedMessageIdTag {
-				return nil, ErrInvalidServerMessage
-			}
-			if !isArray(buf[1]) {
-				return nil, ErrInvalidServerMessage
+				return r.fail("unexpected option")
 			}
-			var idCnt uint64
-			idCnt, buf, err = decodeNumber(buf[1:])
+			r.pos++
+			idCount, err := r.readArray(r.remaining())
 			if err != nil {
-				return nil, err
+				return err
 			}
-			if int(idCnt) != recordCount {
-				return nil, ErrInvalidServerMessage
+			if idCount != recordCount {
+				return r.fail(fmt.Sprintf("%d record IDs for %d records", idCount, recordCount))
 			}
 			for i := 0; i < recordCount; i++ {
-				records[i].RecordID, buf, err = decodeRecordID(buf)
+				id, err := r.readBytes()
 				if err != nil {
-					return nil, err
+					return err
 				}
+				records[i].RecordID = RecordID(id)
 			}
 		}
 	case isBlank(d):
-		buf = buf[1:]
+		r.pos++
 	default:
-		return nil, ErrInvalidServerMessage
+		return r.fail("expected options")
 	}
 
-	if recordCount == 1 && isUndefined(buf[0]) {
-		return &serverMsg{consumerID: consumerID}, nil
+	msg.consumerID = consumerID
+	if recordCount == 1 {
+		if d, err := r.peek(); err == nil && isUndefined(d) {
+			msg.records = records[:0]
+			return nil
+		}
 	}
 	for i := 0; i < recordCount; i++ {
-		records[i].Record, buf, err = decodeBytes(buf)
+		if records[i].Record, err = r.readBytes(); err != nil {
+			return err
+		}
 	}
-	return &serverMsg{consumerID: consumerID, records: records}, nil
+	msg.records = records
+	return nil
 }
 
-func decodeErrorMessage(data []byte) (*serverMsg, error) {
-	if !isUnsignedInteger(data[0]) {
-		return nil, ErrInvalidServerMessage
-	}
-	consumerID, data, err := decodeNumber(data)
+func decodeErrorMessage(r *cborReader, msg *serverMsg) error {
+	consumerID, err := r.readUnsigned()
 	if err != nil {
-		return nil, err
+		return err
 	}
-	errorMsg, data, err := decodeString(data)
+	errorMsg, err := r.readText()
 	if err != nil {
-		return nil, err
+		return err
 	}
-	return &serverMsg{consumerID: consumerID, err: errors.New(errorMsg)}, nil
+	msg.consumerID = consumerID
+	msg.err = serverError(errorMsg)
+	return nil
 }
 
-func decodeSyncMessage(buf []byte) (*serverMsg, error) {
-	if !isUnsignedInteger(buf[0]) {
-		return nil, ErrInvalidServerMessage
-	}
-	consumerID, buf, err := decodeNumber(buf)
+func decodeSyncMessage(r *cborReader, msg *serverMsg) error {
+	consumerID, err := r.readUnsigned()
 	if err != nil {
-		return nil, err
+		return err
 	}
-	return &serverMsg{consumerID: consumerID}, nil
+	msg.consumerID = consumerID
+	return nil
 }
 
+// decodeNumber decodes the argument of the first item of buf, and returns the
+// bytes that follow.
 func decodeNumber(buf []byte) (uint64, []byte, error) {
-	size := lenCode(buf[0])
-	switch {
-	case size < 24:
-		return size, buf[1:], nil
-	case size == 24:
-		return uint64(buf[1]), buf[2:], nil
-	case size == 25:
-		result := uint64(binary.BigEndian.Uint16(buf[1:]))
-		return result, buf[3:], nil
-	case size == 26:
-		result := uint64(binary.BigEndian.Uint32(buf[1:]))
-		return result, buf[5:], nil
-	case size == 27:
-		result := uint64(binary.BigEndian.Uint64(buf[1:]))
-		return result, buf[9:], nil
-	default:
-		return 0, nil, ErrInvalidServerMessage
+	r := &cborReader{buf: buf}
+	n, err := r.readArgument()
+	if err != nil {
+		return 0, nil, err
 	}
+	return n, buf[r.pos:], nil
 }
 
 func decodeBytes(buf []byte) ([]byte, []byte, error) {
-	d := buf[0]
-	if isBlank(d) {
-		return nil, buf[1:], nil
-	}
-	if !isByteString(d) {
-		return nil, nil, ErrInvalidServerMessage
-	}
-	buf = buf[1:]
-	size := lenCode(d)
-	switch {
-	case size < 24:
-		// pass
-	case size == 24:
-		size = uint64(buf[0])
-		buf = buf[1:]
-	case size == 25:
-		size = uint64(binary.BigEndian.Uint16(buf))
-		buf = buf[2:]
-	case size == 26:
-		size = uint64(binary.BigEndian.Uint32(buf))
-		buf = buf[4:]
-	case size == 27:
-		size = binary.BigEndian.Uint64(buf)
-		buf = buf[8:]
-	default:
-		return nil, nil, ErrInvalidServerMessage
+	r := &cborReader{buf: buf}
+	b, err := r.readBytes()
+	if err != nil {
+		return nil, nil, err
 	}
-	return buf[:size], buf[size:], nil
+	return b, buf[r.pos:], nil
 }
 
 func decodeString(buf []byte) (string, []byte, error) {
-	d := buf[0]
-	if isBlank(d) {
-		return "", buf, nil
-	}
-	if !isTextString(d) {
-		return "", nil, ErrInvalidServerMessage
-	}
-	buf = buf[1:]
-	size := lenCode(d)
-	switch {
-	case size < 24:
-		// pass
-	case size == 24:
-		size = uint64(buf[0])
-		buf = buf[1:]
-	case size == 25:
-		size = uint64(binary.BigEndian.Uint16(buf))
-		buf = buf[2:]
-	case size == 26:
-		size = uint64(binary.BigEndian.Uint32(buf))
-		buf = buf[4:]
-	case size == 27:
-		size = binary.BigEndian.Uint64(buf)
-		buf = buf[8:]
-	default:
-		return "", nil, ErrInvalidServerMessage
+	r := &cborReader{buf: buf}
+	s, err := r.readText()
+	if err != nil {
+		return "", nil, err
 	}
-	return string(buf[:size]), buf[size:], nil
-}
-
-func decodeRecordID(buf []byte) (RecordID, []byte, error) {
-	id, buf, err := decodeBytes(buf)
-	return RecordID(id), buf, err
+	return s, buf[r.pos:], nil
 }
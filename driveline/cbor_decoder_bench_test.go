@@ -20,7 +20,10 @@
 
 package driveline
 
-import "testing"
+import (
+	"fmt"
+	"testing"
+)
 
 func BenchmarkDecodeNumber(b *testing.B) {
 	b.Run("small number < 24", func(b *testing.B) {
@@ -59,3 +62,65 @@ func BenchmarkDecodeNumber(b *testing.B) {
 		}
 	})
 }
+
+func BenchmarkDecodeServerMessage(b *testing.B) {
+	message := testDataMessage(1533, Record{RecordID: testRecordID, Record: testRecord})
+	b.Run("allocated", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(message)))
+		for i := 0; i < b.N; i++ {
+			if _, err := decodeServerMessage(message); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("reused", func(b *testing.B) {
+		var msg serverMsg
+		b.ReportAllocs()
+		b.SetBytes(int64(len(message)))
+		for i := 0; i < b.N; i++ {
+			if err := decodeServerMessageInto(&msg, message); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// BenchmarkContinuousQuery measures the delivery of the messages of a
+// continuous query to its handler, with records copied or borrowed.
+func BenchmarkContinuousQuery(b *testing.B) {
+	record := make([]byte, 256)
+	for _, count := range []int{1, 16, 128} {
+		records := make([]Record, count)
+		for i := range records {
+			records[i] = Record{RecordID: testRecordID, Record: record}
+		}
+		message := testDataMessage(1533, records...)
+		for _, borrow := range []bool{false, true} {
+			name := fmt.Sprintf("%d records copied", count)
+			if borrow {
+				name = fmt.Sprintf("%d records borrowed", count)
+			}
+			b.Run(name, func(b *testing.B) {
+				client, fws := testClient()
+				var options *QueryOptions
+				if borrow {
+					options = new(QueryOptions).BorrowRecords()
+				}
+				var size int
+				client.registerConsumer(newQueryConsumer(client, 1533, "pattern", true, options, func(r *Record) {
+					size += len(r.Record)
+				}))
+				b.ReportAllocs()
+				b.SetBytes(int64(len(message)))
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					fws.Receive(message)
+				}
+				if size != b.N*count*len(record) {
+					b.Fatal("records not handled")
+				}
+			})
+		}
+	}
+}
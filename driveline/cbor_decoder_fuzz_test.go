@@ -0,0 +1,52 @@
+// Copyright 2019, 1533 Systems, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package driveline
+
+import (
+	"bytes"
+	"testing"
+)
+
+// The seed corpus in testdata/fuzz holds the vectors of cbor_decoder_test.go.
+// Run with: go test -fuzz FuzzDecodeServerMessage
+
+func FuzzDecodeServerMessage(f *testing.F) {
+	f.Fuzz(func(t *testing.T, buf []byte) {
+		msg, err := decodeServerMessage(buf)
+		if err != nil {
+			if _, ok := err.(*DecodeError); !ok {
+				t.Fatalf("unexpected error type %T", err)
+			}
+			if msg != nil {
+				t.Fatal("message decoded with an error")
+			}
+			return
+		}
+		for _, r := range msg.records {
+			if len(r.Record) > len(buf) || len(r.RecordID) > len(buf) {
+				t.Fatal("record larger than the message")
+			}
+			if len(r.Record) > 0 && !bytes.Contains(buf, r.Record) {
+				t.Fatal("record not taken from the message")
+			}
+		}
+	})
+}
@@ -324,6 +324,29 @@ func TestDecodeDataMessage(t *testing.T) {
 	})
 }
 
+func TestDecodeServerMessageInto(t *testing.T) {
+	var msg serverMsg
+	if err := decodeServerMessageInto(&msg, testDataMessage(5, Record{RecordID: testRecordID, Record: testRecord}, Record{Record: testRecord})); err != nil {
+		t.Fatal(err)
+	}
+	records := msg.records
+	if err := decodeServerMessageInto(&msg, testDataMessage(6, Record{Record: []byte("payload")})); err != nil {
+		t.Fatal(err)
+	}
+	if msg.consumerID != 6 || len(msg.records) != 1 || &msg.records[0] != &records[0] {
+		t.Fatal("expected the records to be reused")
+	}
+	if len(msg.records[0].RecordID) != 0 || string(msg.records[0].Record) != "payload" {
+		t.Fail()
+	}
+	if err := decodeServerMessageInto(&msg, []byte{cborArray | 3, cborTextString | 3, 'e', 'r', 'r', cborUnsignedInteger | 7, cborNull}); err != nil {
+		t.Fatal(err)
+	}
+	if msg.consumerID != 7 || msg.err == nil || len(msg.records) != 0 {
+		t.Fail()
+	}
+}
+
 func TestDecodeErrorMessage(t *testing.T) {
 	t.Run("decodes a message", func(t *testing.T) {
 		sample := []byte{
@@ -490,5 +513,129 @@ func TestDecodeNumber(t *testing.T) {
 }
 
 func TestDecodeString(t *testing.T) {
+	t.Run("decodes a string", func(t *testing.T) {
+		data := []byte{cborTextString | 3, 'k', 'e', 'y', cborNull}
+		s, rest, err := decodeString(data)
+		if err != nil || s != "key" || len(rest) != 1 {
+			t.Fail()
+		}
+		if s, rest, err = decodeString(rest); err != nil || s != "" || len(rest) != 0 {
+			t.Fail()
+		}
+	})
+	t.Run("fails to decode a truncated string", func(t *testing.T) {
+		for _, data := range [][]byte{
+			nil,
+			{cborTextString | 3, 'k', 'e'},
+			{cborTextString | 24},
+			{cborTextString | 27, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 'k'},
+			{cborByteString | 1, 'k'},
+		} {
+			if _, _, err := decodeString(data); err == nil {
+				t.Errorf("decoded %v", data)
+			}
+		}
+	})
+}
+
+func TestDecodeBytes(t *testing.T) {
+	data := []byte{cborByteString | 24, 2, 1, 2, cborUndefined}
+	b, rest, err := decodeBytes(data)
+	if err != nil || !bytes.Equal(b, []byte{1, 2}) || len(rest) != 1 {
+		t.Fail()
+	}
+	if b, _, err = decodeBytes(rest); err != nil || b != nil {
+		t.Fail()
+	}
+	if _, _, err := decodeBytes([]byte{cborByteString | 25, 0x01}); err == nil {
+		t.Fail()
+	}
+}
 
+func TestDecodeErrors(t *testing.T) {
+	valid := [][]byte{
+		{
+			cborArray | 5,
+			cborTextString | 4, 'd', 'a', 't', 'a',
+			cborUnsignedInteger | 5,
+			cborArray | 2,
+			cborUnsignedInteger | tagMessageID,
+			cborArray | 2,
+			cborByteString | 8, 1, 2, 3, 4, 5, 6, 7, 8,
+			cborByteString | 8, 1, 2, 3, 4, 5, 6, 7, 9,
+			cborByteString | 7, 'p', 'a', 'y', 'l', 'o', 'a', 'd',
+			cborByteString | 24, 1, 'x',
+		},
+		{
+			cborArray | 3,
+			cborTextString | 3, 'e', 'r', 'r',
+			cborUnsignedInteger | 25, 0x12, 0x34,
+			cborTextString | 4, 'o', 'u', 'c', 'h',
+		},
+		{
+			cborArray | 2,
+			cborTextString | 3, 's', 'y', 'n',
+			cborUnsignedInteger | 27, 0, 0, 0, 0, 0, 0, 0, 5,
+		},
+	}
+
+	t.Run("fails on every truncated message", func(t *testing.T) {
+		for _, message := range valid {
+			if _, err := decodeServerMessage(message); err != nil {
+				t.Fatalf("decoding failed: %s", err)
+			}
+			for i := 0; i < len(message); i++ {
+				_, err := decodeServerMessage(message[:i])
+				if e, ok := err.(*DecodeError); !ok || e.Offset > i {
+					t.Errorf("%v: unexpected error %v", message[:i], err)
+				}
+			}
+		}
+	})
+
+	t.Run("reports the offset and the reason", func(t *testing.T) {
+		samples := []struct {
+			title  string
+			sample []byte
+			offset int
+		}{
+			{"empty message", nil, 0},
+			{"unknown command", []byte{cborArray | 2, cborTextString | 3, 'f', 'o', 'o', 0}, 1},
+			{"truncated record", []byte{
+				cborArray | 4,
+				cborTextString | 4, 'd', 'a', 't', 'a',
+				cborUnsignedInteger | 5,
+				cborUndefined,
+				cborByteString | 7, 'p', 'a', 'y',
+			}, 8},
+			{"invalid record", []byte{
+				cborArray | 4,
+				cborTextString | 4, 'd', 'a', 't', 'a',
+				cborUnsignedInteger | 5,
+				cborUndefined,
+				cborTextString | 7, 'p', 'a', 'y', 'l', 'o', 'a', 'd',
+			}, 8},
+			{"huge record count", []byte{
+				cborArray | 27, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+				cborTextString | 4, 'd', 'a', 't', 'a',
+				cborUnsignedInteger | 5,
+				cborUndefined,
+			}, 0},
+			{"huge string", []byte{
+				cborArray | 3,
+				cborTextString | 3, 'e', 'r', 'r',
+				cborUnsignedInteger | 5,
+				cborTextString | 27, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+			}, 6},
+		}
+		for _, s := range samples {
+			t.Run(s.title, func(t *testing.T) {
+				_, err := decodeServerMessage(s.sample)
+				e, ok := err.(*DecodeError)
+				if !ok || e.Offset != s.offset || e.Reason == "" {
+					t.Errorf("unexpected error %v", err)
+				}
+			})
+		}
+	})
 }
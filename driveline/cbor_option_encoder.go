@@ -33,15 +33,12 @@ func sizeOfQueryOptions(options *QueryOptions) int {
 	return 1 + 1 + recordIDLen
 }
 
-func encodeQueryOptions(buf []byte, off int, options *QueryOptions) int {
+func appendQueryOptions(dst []byte, options *QueryOptions) []byte {
 	if options == nil || options.assigned == 0 {
-		buf[off] = cborUndefined
-		return off + 1
+		return append(dst, cborUndefined)
 	}
-	buf[off] = cborArray | 2
-	buf[off+1] = encodedReadIDTag
-	off = encodeRecordID(buf, off+2, options.fromRecordID)
-	return off
+	dst = append(dst, cborArray|2, encodedReadIDTag)
+	return appendRecordID(dst, options.fromRecordID)
 }
 
 func sizeOfStoreOptions(options *StoreOptions) int {
@@ -58,20 +55,20 @@ func sizeOfStoreOptions(options *StoreOptions) int {
 	return size
 }
 
-func encodeStoreOptions(buf []byte, off int, options *StoreOptions) int {
+func appendStoreOptions(dst []byte, options *StoreOptions) []byte {
 	if options == nil || options.assigned == 0 {
-		buf[off] = cborUndefined
-		return off + 1
+		return append(dst, cborUndefined)
 	}
-	buf[off] = cborArray | byte(bits.OnesCount16(uint16(options.assigned))*2)
-	off++
+	dst = append(dst, cborArray|byte(bits.OnesCount16(uint16(options.assigned))*2))
+	return appendStoreOptionPairs(dst, options)
+}
+
+func appendStoreOptionPairs(dst []byte, options *StoreOptions) []byte {
 	if options.assigned&optStoreCASOption != 0 {
-		buf[off] = encodedStoreCASIDTag
-		off = encodeRecordID(buf, off+1, options.casRecordID)
+		dst = appendRecordID(append(dst, encodedStoreCASIDTag), options.casRecordID)
 	}
 	if options.assigned&optStoreTTLOption != 0 {
-		buf[off] = encodedStoreTTLTag
-		off = encodeNumberWithType(buf, off+1, options.ttl, cborUnsignedInteger)
+		dst = appendNumberWithType(append(dst, encodedStoreTTLTag), options.ttl, cborUnsignedInteger)
 	}
-	return off
+	return dst
 }
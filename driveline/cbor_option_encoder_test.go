@@ -29,10 +29,8 @@ import (
 func TestEncodeQueryOptions(t *testing.T) {
 	t.Run("when nil", func(t *testing.T) {
 		expected := []byte{cborUndefined}
-		actual := make([]byte, 2)
-		length := encodeQueryOptions(actual, 0, nil)
-		actual = actual[:length]
-		if bytes.Compare(expected, actual[:length]) != 0 {
+		actual := appendQueryOptions(nil, nil)
+		if bytes.Compare(expected, actual) != 0 {
 			t.Fail()
 		}
 	})
@@ -46,9 +44,7 @@ func TestEncodeQueryOptions(t *testing.T) {
 		var options QueryOptions
 		options.FromRecordID(RecordID{1, 2, 3, 4, 5, 6, 7, 8,})
 
-		actual := make([]byte, 32)
-		length := encodeQueryOptions(actual, 0, &options)
-		actual = actual[:length]
+		actual := appendQueryOptions(nil, &options)
 
 		if bytes.Compare(expected, actual) != 0 {
 			t.Fail()
@@ -96,20 +92,16 @@ func TestSizeOfQueryOptions(t *testing.T) {
 func TestEncodeStoreOptions(t *testing.T) {
 	t.Run("when nil", func(t *testing.T) {
 		expected := []byte{cborUndefined}
-		actual := make([]byte, 1)
-		length := encodeStoreOptions(actual, 0, nil)
-		actual = actual[:length]
-		if bytes.Compare(expected, actual[:length]) != 0 {
+		actual := appendStoreOptions(nil, nil)
+		if bytes.Compare(expected, actual) != 0 {
 			t.Fail()
 		}
 	})
 	t.Run("when empty", func(t *testing.T) {
 		expected := []byte{cborUndefined}
-		actual := make([]byte, 1)
 		var opts StoreOptions
-		length := encodeStoreOptions(actual, 0, &opts)
-		actual = actual[:length]
-		if bytes.Compare(expected, actual[:length]) != 0 {
+		actual := appendStoreOptions(nil, &opts)
+		if bytes.Compare(expected, actual) != 0 {
 			t.Fail()
 		}
 	})
@@ -124,9 +116,7 @@ func TestEncodeStoreOptions(t *testing.T) {
 		var options StoreOptions
 		options.CompareAndSwap(RecordID([]byte{1, 2, 3, 4, 5, 6, 7, 8}))
 		options.WithTTL(2 * time.Second)
-		actual := make([]byte, 32)
-		length := encodeStoreOptions(actual, 0, &options)
-		actual = actual[:length]
+		actual := appendStoreOptions(nil, &options)
 		if bytes.Compare(expected, actual) != 0 {
 			t.Fail()
 		}
@@ -150,3 +140,4 @@ func TestSizeOfStoreOptions(t *testing.T) {
 		}
 	})
 }
+
@@ -0,0 +1,106 @@
+// Copyright 2019, 1533 Systems, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package driveline
+
+import (
+	"context"
+	"time"
+)
+
+const checkpointCommitTimeout = 5 * time.Second
+
+// CheckpointStore persists the position of continuous queries, so that they
+// can resume where they left off after a restart. c.f. QueryOptions.WithCheckpoint
+type CheckpointStore interface {
+	// Load returns the last RecordID committed under name, or nil when there is none.
+	Load(ctx context.Context, name string) (RecordID, error)
+	// Commit saves id as the position of the query known as name.
+	Commit(ctx context.Context, name string, id RecordID) error
+}
+
+// checkpointedQuery runs a continuous query from its last committed position,
+// committing the RecordID of each handled record after the handler returns,
+// either every time or at most once per checkpoint interval. A record may be
+// handled again after a restart, but never skipped.
+func (c *Client) checkpointedQuery(ctx context.Context, dql string, options *QueryOptions, handler func(*Record)) error {
+	store, name, interval := options.checkpoint, options.checkpointName, options.checkpointInterval
+	from, err := store.Load(ctx, name)
+	if err != nil {
+		return err
+	}
+	opts := *options
+	if from != nil {
+		opts.FromRecordID(from)
+	}
+	cursor, err := openCursor(ctx, newCursorConsumer(c, c.nextConsumerID(), dql, true, &opts))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close()
+
+	var pending RecordID
+	committed := time.Now()
+	commit := func(ctx context.Context) error {
+		if pending == nil {
+			return nil
+		}
+		if err := store.Commit(ctx, name, pending); err != nil {
+			return err
+		}
+		pending = nil
+		committed = time.Now()
+		return nil
+	}
+	for {
+		nextCtx, cancel := ctx, context.CancelFunc(func() {})
+		if interval > 0 && pending != nil {
+			nextCtx, cancel = context.WithDeadline(ctx, committed.Add(interval))
+		}
+		ok := cursor.Next(nextCtx)
+		cancel()
+		if ok {
+			handler(cursor.Record())
+			pending = cursor.Record().RecordID
+			if interval <= 0 || time.Since(committed) >= interval {
+				if err := commit(ctx); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		err := cursor.Err()
+		if err == context.DeadlineExceeded && ctx.Err() == nil {
+			// The commit interval elapsed while waiting for records.
+			if err := commit(ctx); err != nil {
+				return err
+			}
+			continue
+		}
+		if err == ctx.Err() {
+			finalCtx, cancel := context.WithTimeout(context.Background(), checkpointCommitTimeout)
+			if err := commit(finalCtx); err != nil {
+				c.errorHandler(err)
+			}
+			cancel()
+		}
+		return err
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright 2019, 1533 Systems, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package driveline
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+var _ CheckpointStore = (*FileCheckpointStore)(nil)
+
+// FileCheckpointStore is a CheckpointStore that keeps every checkpoint in a
+// single local file, which is atomically replaced on each commit.
+type FileCheckpointStore struct {
+	path        string
+	mu          sync.Mutex
+	checkpoints map[string]string
+}
+
+// OpenFileCheckpointStore opens the checkpoint file at path, which is created
+// on the first commit if it does not exist.
+func OpenFileCheckpointStore(path string) (*FileCheckpointStore, error) {
+	s := &FileCheckpointStore{
+		path:        path,
+		checkpoints: make(map[string]string),
+	}
+	buf, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(buf, &s.checkpoints); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Load implements CheckpointStore.
+func (s *FileCheckpointStore) Load(ctx context.Context, name string) (RecordID, error) {
+	s.mu.Lock()
+	checkpoint, exists := s.checkpoints[name]
+	s.mu.Unlock()
+	if !exists {
+		return nil, nil
+	}
+	return hex.DecodeString(checkpoint)
+}
+
+// Commit implements CheckpointStore.
+func (s *FileCheckpointStore) Commit(ctx context.Context, name string, id RecordID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	previous, existed := s.checkpoints[name]
+	s.checkpoints[name] = id.String()
+	if err := s.write(); err != nil {
+		if existed {
+			s.checkpoints[name] = previous
+		} else {
+			delete(s.checkpoints, name)
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *FileCheckpointStore) write() error {
+	buf, err := json.Marshal(s.checkpoints)
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(buf); err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
@@ -0,0 +1,76 @@
+// Copyright 2019, 1533 Systems, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package driveline
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileCheckpointStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "driveline-checkpoint")
+	if err != nil {
+		t.Fatalf("cannot create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "checkpoints.json")
+	ctx := context.Background()
+
+	t.Run("has no checkpoint initially", func(t *testing.T) {
+		s, err := OpenFileCheckpointStore(path)
+		if err != nil {
+			t.Fatalf("cannot open store: %s", err)
+		}
+		if id, err := s.Load(ctx, "query"); id != nil || err != nil {
+			t.Fail()
+		}
+	})
+
+	t.Run("persists commits", func(t *testing.T) {
+		s, _ := OpenFileCheckpointStore(path)
+		if err := s.Commit(ctx, "query", testRecordID); err != nil {
+			t.Fatalf("cannot commit: %s", err)
+		}
+		s.Commit(ctx, "other", recordIDTail)
+
+		s, err := OpenFileCheckpointStore(path)
+		if err != nil {
+			t.Fatalf("cannot reopen store: %s", err)
+		}
+		if id, err := s.Load(ctx, "query"); bytes.Compare(id, testRecordID) != 0 || err != nil {
+			t.Fail()
+		}
+		if id, _ := s.Load(ctx, "other"); bytes.Compare(id, recordIDTail) != 0 {
+			t.Fail()
+		}
+	})
+
+	t.Run("rejects a corrupt file", func(t *testing.T) {
+		ioutil.WriteFile(path, []byte("{"), 0644)
+		if _, err := OpenFileCheckpointStore(path); err == nil {
+			t.Fail()
+		}
+	})
+}
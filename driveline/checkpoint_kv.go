@@ -0,0 +1,126 @@
+// Copyright 2019, 1533 Systems, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package driveline
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrCheckpointConflict indicates that a checkpoint was committed by another
+// process since it was loaded.
+var ErrCheckpointConflict = errors.New("checkpoint was committed by another process")
+
+var _ CheckpointStore = (*KVCheckpointStore)(nil)
+
+type kvCheckpoint struct {
+	version   RecordID // RecordID of the checkpoint key, nil when it does not exist
+	value     RecordID
+	attempted RecordID // value of a commit whose outcome is unknown
+	synced    bool
+}
+
+// KVCheckpointStore is a CheckpointStore that keeps checkpoints in the Driveline
+// key-value store, under a common key prefix. Commits are compare-and-swap
+// operations, so that two processes committing the same checkpoint cannot
+// overwrite each other: once the checkpoint was changed by another process,
+// commits fail, with ErrCheckpointConflict as soon as the change is known.
+type KVCheckpointStore struct {
+	client      *Client
+	prefix      string
+	mu          sync.Mutex
+	checkpoints map[string]*kvCheckpoint
+}
+
+// NewKVCheckpointStore creates a KVCheckpointStore storing the checkpoint name
+// under the key prefix+name.
+func NewKVCheckpointStore(client *Client, prefix string) *KVCheckpointStore {
+	return &KVCheckpointStore{
+		client:      client,
+		prefix:      prefix,
+		checkpoints: make(map[string]*kvCheckpoint),
+	}
+}
+
+// Load implements CheckpointStore.
+func (s *KVCheckpointStore) Load(ctx context.Context, name string) (RecordID, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	checkpoint, err := s.load(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	s.checkpoints[name] = checkpoint
+	return checkpoint.value, nil
+}
+
+// Commit implements CheckpointStore.
+func (s *KVCheckpointStore) Commit(ctx context.Context, name string, id RecordID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	checkpoint, exists := s.checkpoints[name]
+	if !exists || !checkpoint.synced {
+		// Either the checkpoint was never loaded, or the outcome of the last
+		// commit is unknown: it is only ours if it still holds what we wrote.
+		current, err := s.load(ctx, name)
+		if err != nil {
+			return err
+		}
+		if exists && !bytes.Equal(current.value, checkpoint.value) && !bytes.Equal(current.value, checkpoint.attempted) {
+			return ErrCheckpointConflict
+		}
+		checkpoint = current
+		s.checkpoints[name] = checkpoint
+	}
+	// A missing checkpoint is created without compare-and-swap, which the
+	// server only applies to existing keys: of two processes creating it at
+	// once, the one whose value is overwritten sees a conflict.
+	var options *StoreOptions
+	if checkpoint.version != nil {
+		options = new(StoreOptions).CompareAndSwap(checkpoint.version)
+	}
+	version, err := s.client.StoreContext(ctx, s.prefix+name, id, options)
+	if err == ErrStoreConflict {
+		checkpoint.synced = false
+		return ErrCheckpointConflict
+	}
+	if err != nil {
+		checkpoint.synced = false
+		checkpoint.attempted = id
+		return err
+	}
+	checkpoint.version = version
+	checkpoint.value = id
+	return nil
+}
+
+func (s *KVCheckpointStore) load(ctx context.Context, name string) (*kvCheckpoint, error) {
+	r, err := s.client.Load(ctx, s.prefix+name)
+	if err == ErrKeyNotFound {
+		return &kvCheckpoint{synced: true}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &kvCheckpoint{version: r.RecordID, value: RecordID(r.Record), synced: true}, nil
+}
@@ -0,0 +1,81 @@
+// Copyright 2019, 1533 Systems, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package driveline
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+func TestKVCheckpointStore(t *testing.T) {
+	ctx := context.Background()
+	first := RecordID{0, 0, 0, 0, 0, 0, 0, 1}
+	second := RecordID{0, 0, 0, 0, 0, 0, 0, 2}
+
+	t.Run("has no checkpoint initially", func(t *testing.T) {
+		client, fws := testClient()
+		newTestKV(fws)
+		if id, err := NewKVCheckpointStore(client, "checkpoints/").Load(ctx, "query"); id != nil || err != nil {
+			t.Fatalf("unexpected checkpoint %v (%v)", id, err)
+		}
+	})
+
+	t.Run("stores commits under the prefix", func(t *testing.T) {
+		client, fws := testClient()
+		kv := newTestKV(fws)
+		s := NewKVCheckpointStore(client, "checkpoints/")
+		if err := s.Commit(ctx, "query", first); err != nil {
+			t.Fatalf("cannot commit: %s", err)
+		}
+		if err := s.Commit(ctx, "query", second); err != nil {
+			t.Fatalf("cannot commit: %s", err)
+		}
+		if r, _ := kv.get("checkpoints/query"); !bytes.Equal(r.Record, second) {
+			t.Fatalf("unexpected checkpoint record %v", r.Record)
+		}
+		if id, err := NewKVCheckpointStore(client, "checkpoints/").Load(ctx, "query"); !bytes.Equal(id, second) || err != nil {
+			t.Fatalf("unexpected checkpoint %v (%v)", id, err)
+		}
+	})
+
+	t.Run("fails once another process committed", func(t *testing.T) {
+		client, fws := testClient()
+		kv := newTestKV(fws)
+		s := NewKVCheckpointStore(client, "checkpoints/")
+		if err := s.Commit(ctx, "query", first); err != nil {
+			t.Fatalf("cannot commit: %s", err)
+		}
+		other := NewKVCheckpointStore(client, "checkpoints/")
+		if err := other.Commit(ctx, "query", second); err != nil {
+			t.Fatalf("cannot commit: %s", err)
+		}
+		third := RecordID{0, 0, 0, 0, 0, 0, 0, 3}
+		for i := 0; i < 2; i++ {
+			if err := s.Commit(ctx, "query", third); err != ErrCheckpointConflict {
+				t.Fatalf("expected a conflict, got %v", err)
+			}
+		}
+		if r, _ := kv.get("checkpoints/query"); !bytes.Equal(r.Record, second) {
+			t.Fatalf("expected the checkpoint of the other process to be kept")
+		}
+	})
+}
@@ -0,0 +1,93 @@
+// Copyright 2019, 1533 Systems, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package driveline
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+)
+
+// testCheckpointedQuery runs a checkpointed query over the keys under events/
+// until the record until is handled, and returns the handled records.
+func testCheckpointedQuery(t *testing.T, client *Client, options *QueryOptions, until string) []string {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var received []string
+	err := client.ContinuousQueryOptions(ctx, "SELECT * FROM 'events/*'", options, func(r *Record) {
+		received = append(received, string(r.Record))
+		if string(r.Record) == until {
+			cancel()
+		}
+	})
+	if err != context.Canceled {
+		t.Fatalf("unexpected query result: %v", err)
+	}
+	return received
+}
+
+func TestCheckpointedQuery(t *testing.T) {
+	t.Run("resumes after the committed record", func(t *testing.T) {
+		client, fws := testClient()
+		kv := newTestKV(fws)
+		kv.readAsync()
+		defer kv.close()
+		store := NewKVCheckpointStore(client, "checkpoints/")
+		for _, key := range []string{"a", "b", "c"} {
+			kv.set("events/"+key, []byte(key))
+		}
+		options := new(QueryOptions).FromStreamHead().WithCheckpoint(store, "query")
+		if received := testCheckpointedQuery(t, client, options, "b"); len(received) != 2 {
+			t.Fatalf("unexpected records %v", received)
+		}
+		kv.set("events/d", []byte("d"))
+		received := testCheckpointedQuery(t, client, options, "d")
+		if len(received) != 2 || received[0] != "c" {
+			t.Fatalf("expected to resume after the checkpoint, got %v", received)
+		}
+		last, _ := kv.get("events/d")
+		if id, err := store.Load(context.Background(), "query"); !bytes.Equal(id, last.RecordID) || err != nil {
+			t.Fatalf("unexpected checkpoint %v (%v)", id, err)
+		}
+	})
+
+	t.Run("commits on cancel when committing at intervals", func(t *testing.T) {
+		client, fws := testClient()
+		kv := newTestKV(fws)
+		kv.readAsync()
+		defer kv.close()
+		store := NewKVCheckpointStore(client, "checkpoints/")
+		for _, key := range []string{"a", "b"} {
+			kv.set("events/"+key, []byte(key))
+		}
+		options := new(QueryOptions).WithCheckpoint(store, "query").CheckpointInterval(time.Hour)
+		testCheckpointedQuery(t, client, options, "b")
+		last, _ := kv.get("events/b")
+		if id, err := store.Load(context.Background(), "query"); !bytes.Equal(id, last.RecordID) || err != nil {
+			t.Fatalf("expected the checkpoint to be committed on cancel, got %v (%v)", id, err)
+		}
+		kv.set("events/c", []byte("c"))
+		if received := testCheckpointedQuery(t, client, options, "c"); len(received) != 1 {
+			t.Fatalf("expected to resume after the checkpoint, got %v", received)
+		}
+	})
+}
@@ -24,6 +24,7 @@ import (
 	"context"
 	"fmt"
 	"sync"
+	"time"
 
 	ws "github.com/1533-systems/golang-sdk/driveline/websocket"
 )
@@ -44,21 +45,44 @@ type consumer interface {
 type Client struct {
 	endpoint       string
 	consumers      map[uint64]consumer
+	cancelled      map[uint64]struct{} // consumers whose late messages are dropped
 	consumersLock  sync.Mutex
 	consumerID     uint64
 	consumerIDLock sync.Mutex
 	ws             ws.WebSocket
 	defines        defines
 	errorHandler   func(error)
+	journal        *outboundJournal
+	roundTripLock  sync.Mutex
+	roundTripTime  time.Duration
+	state          *connectionState
+	retryHandler   func(RetryEvent)
+	message        serverMsg // last message received, reused by onMessage
+	closed         chan struct{}
+	closeOnce      sync.Once
 }
 
-// NewClient creates a new Client
+// maxReusedRecords bounds the records kept for the next message, so that a
+// single large message does not pin memory.
+const maxReusedRecords = 4096
+
+// recordBorrower is implemented by consumers that may handle the records of a
+// message without copying them, c.f. QueryOptions.BorrowRecords.
+type recordBorrower interface {
+	borrowsRecords() bool
+}
+
+// NewClient creates a new Client connected to endpoint. c.f. Endpoints and
+// Resolver to fail over to other nodes.
 func NewClient(ctx context.Context, endpoint string, options ...option) (*Client, error) {
 	c := &Client{
 		endpoint:     endpoint,
 		consumerID:   0,
 		consumers:    make(map[uint64]consumer),
+		cancelled:    make(map[uint64]struct{}),
 		errorHandler: func(error) {},
+		state:        newConnectionState(endpoint),
+		closed:       make(chan struct{}),
 	}
 	c.defines.reset()
 	opts := clientOptions{
@@ -68,22 +92,39 @@ func NewClient(ctx context.Context, endpoint string, options ...option) (*Client
 	for _, opt := range options {
 		opt(&opts)
 	}
+	c.retryHandler = opts.retryHandler
+	if c.journal != nil && opts.journalSyncInterval > 0 {
+		c.journal.syncInterval = opts.journalSyncInterval
+	}
 	opts.wsOptions = append(opts.wsOptions,
+		ws.OnHandshake(c.onHandshake),
 		ws.OnConnect(c.onConnect),
 		ws.OnDisconnect(c.onDisconnect),
 		ws.OnFailure(c.onFailure),
 		ws.OnMessage(c.onMessage),
+		ws.BorrowMessages(),
 		ws.ErrorHandler(c.errorHandler),
+		ws.OnRoundTrip(c.onRoundTrip),
+		ws.OnAttempt(c.state.attempt),
+		ws.OnRetry(c.onRetry),
 	)
 	var err error
 	if c.ws, err = opts.newWebSocket(ctx, c.endpoint, opts.wsOptions...); err != nil {
 		return nil, err
 	}
+	if c.journal != nil {
+		go c.journal.run()
+	}
 	return c, nil
 }
 
 // Close the connection to Driveline
 func (c *Client) Close() error {
+	c.closeOnce.Do(func() { close(c.closed) })
+	c.state.set(Closed, nil, 0)
+	if c.journal != nil {
+		c.journal.close()
+	}
 	for _, consumer := range c.snapshotConsumers() {
 		consumer.onFailure(ErrClosed)
 	}
@@ -93,6 +134,14 @@ func (c *Client) Close() error {
 	return nil
 }
 
+// RoundTripTime returns the round-trip time of the last ping, or 0 when no
+// ping completed yet. c.f. PingInterval.
+func (c *Client) RoundTripTime() time.Duration {
+	c.roundTripLock.Lock()
+	defer c.roundTripLock.Unlock()
+	return c.roundTripTime
+}
+
 // OpenStream creates a Stream object that help save data bandwidth when
 // dealing with streams that have a large number of small messages.
 func (c *Client) OpenStream(name string) (*Stream, error) {
@@ -162,6 +211,36 @@ func (c *Client) unregisterConsumer(consumer consumer) bool {
 	return exists
 }
 
+// cancelQuietly cancels the query of consumerID on the server, from another
+// goroutine so that it can be called from the reader loop. The messages the
+// server sent for it before processing the cancel are dropped, instead of
+// being reported as addressed to an unknown consumer, until a sync sent right
+// after the cancel is answered.
+func (c *Client) cancelQuietly(consumerID uint64) {
+	c.consumersLock.Lock()
+	c.cancelled[consumerID] = struct{}{}
+	c.consumersLock.Unlock()
+	sync := newSyncConsumer(c, c.nextConsumerID())
+	c.registerConsumer(sync)
+	go func() {
+		err := c.sendMessage(encodeCancel(consumerID))
+		if err == nil {
+			err = sync.run(context.Background())
+		}
+		if err != nil {
+			c.errorHandler(err)
+		} else {
+			// Answered, or failed on disconnect: nothing more is received
+			// for consumerID either way.
+			<-sync.done()
+		}
+		c.unregisterConsumer(sync)
+		c.consumersLock.Lock()
+		delete(c.cancelled, consumerID)
+		c.consumersLock.Unlock()
+	}()
+}
+
 func (c *Client) sendMessage(message []byte) error {
 	if _, err := c.ws.Write(message); err != nil {
 		return fmt.Errorf("cannot send message: %s", err.Error())
@@ -169,15 +248,53 @@ func (c *Client) sendMessage(message []byte) error {
 	return nil
 }
 
+// sendBuffer sends a command encoded in a pooled buffer, which the WebSocket
+// releases once written. Commands that are journaled must not be pooled.
+func (c *Client) sendBuffer(b *commandBuffer) error {
+	if w, ok := c.ws.(ws.BufferWriter); ok {
+		if err := w.WriteBuffer(b); err != nil {
+			return fmt.Errorf("cannot send message: %s", err.Error())
+		}
+		return nil
+	}
+	return c.sendMessage(b.buf)
+}
+
+// sendCommand sends a command that is not tied to a consumer, through the
+// outbound journal when there is one.
+func (c *Client) sendCommand(message []byte) error {
+	return c.sendCommandAs(message, message)
+}
+
+// sendCommandAs is like sendCommand, but journals durable instead of message.
+// durable must not depend on connection state such as stream aliases.
+func (c *Client) sendCommandAs(message []byte, durable []byte) error {
+	if c.journal != nil {
+		return c.journal.send(message, durable)
+	}
+	return c.sendMessage(message)
+}
+
+// onMessage dispatches a message to its consumer. buf is only valid until
+// onMessage returns: the records are copied, unless the consumer borrows them.
 func (c *Client) onMessage(buf []byte) {
-	msg, err := decodeServerMessage(buf)
-	if err != nil {
+	msg := &c.message
+	defer func() {
+		if cap(msg.records) > maxReusedRecords {
+			msg.records = nil
+		}
+	}()
+	if err := decodeServerMessageInto(msg, buf); err != nil {
 		c.errorHandler(fmt.Errorf("cannot decode server message: %s", err.Error()))
 		return
 	}
 	c.consumersLock.Lock()
 	consumer, ok := c.consumers[msg.consumerID]
+	_, cancelled := c.cancelled[msg.consumerID]
 	c.consumersLock.Unlock()
+	if !ok && cancelled {
+		return
+	}
 	if !ok {
 		c.errorHandler(fmt.Errorf("received message for unknown consumer %d", msg.consumerID))
 		return
@@ -186,32 +303,68 @@ func (c *Client) onMessage(buf []byte) {
 		consumer.onFailure(msg.err)
 		return
 	}
-	consumer.onRecords(msg.records)
+	if borrower, ok := consumer.(recordBorrower); ok && borrower.borrowsRecords() {
+		consumer.onRecords(msg.records)
+		return
+	}
+	consumer.onRecords(copyRecords(msg.records))
 }
 
-func (c *Client) onConnect() {
+// onHandshake restores the stream aliases, then replays the outbound journal,
+// before anything else is sent on a new connection.
+func (c *Client) onHandshake(send func([]byte) error) error {
+	c.defines.mu.Lock()
 	for id, stream := range c.defines.aliasMap {
-		if err := c.define(stream, id); err != nil {
-			c.errorHandler(fmt.Errorf("cannot set alias %d for stream %s", id, stream))
+		if err := send(encodeDefine(id, stream)); err != nil {
+			c.defines.mu.Unlock()
+			return fmt.Errorf("cannot set alias %d for stream %s", id, stream)
 		}
 	}
+	c.defines.mu.Unlock()
+	if c.journal != nil {
+		return c.journal.replay(send)
+	}
+	return nil
+}
+
+func (c *Client) onConnect() {
+	event, _ := c.state.get()
+	c.state.set(Connected, nil, event.Attempt)
 	for _, consumer := range c.snapshotConsumers() {
 		consumer.onReconnect()
 	}
 }
 
 func (c *Client) onDisconnect() {
+	if c.journal != nil {
+		c.journal.disconnected()
+	}
 	for _, consumer := range c.snapshotConsumers() {
 		consumer.onDisconnect()
 	}
 }
 
 func (c *Client) onFailure(err error) {
+	event, _ := c.state.get()
+	c.state.set(Failed, err, event.Attempt)
 	for _, consumer := range c.snapshotConsumers() {
 		consumer.onFailure(err)
 	}
 }
 
+func (c *Client) onRetry(event ws.RetryEvent) {
+	c.state.retry(event.Err, event.Attempt)
+	if c.retryHandler != nil {
+		c.retryHandler(RetryEvent{Attempt: event.Attempt, Err: event.Err, Delay: event.Delay})
+	}
+}
+
+func (c *Client) onRoundTrip(rtt time.Duration) {
+	c.roundTripLock.Lock()
+	c.roundTripTime = rtt
+	c.roundTripLock.Unlock()
+}
+
 func (c *Client) snapshotConsumers() []consumer {
 	c.consumersLock.Lock()
 	consumers := make([]consumer, len(c.consumers))
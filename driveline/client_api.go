@@ -21,8 +21,11 @@
 package driveline
 
 import (
+	"bytes"
 	"context"
 	"encoding/hex"
+
+	"github.com/1533-systems/golang-sdk/driveline/cbor"
 )
 
 var (
@@ -38,15 +41,70 @@ func (id RecordID) String() string {
 	return hex.EncodeToString(id)
 }
 
-// Record is the core data exchange structure
+// Record is the core data exchange structure.
+// The records passed to handlers belong to them, and may be kept once they
+// return, unless the query borrows them. c.f. QueryOptions.BorrowRecords
 type Record struct {
 	RecordID RecordID // RecordID is the identifier of the Record
 	Record   []byte   // Record is the payload of the record
 }
 
+// Decode decodes the CBOR payload of the record into v, c.f. cbor.Unmarshal.
+func (r *Record) Decode(v interface{}) error {
+	return cbor.Unmarshal(r.Record, v)
+}
+
+// Clone returns a copy of the record, that remains valid once the handler that
+// borrowed it returns. c.f. QueryOptions.BorrowRecords
+func (r *Record) Clone() *Record {
+	return &Record{
+		RecordID: append(RecordID(nil), r.RecordID...),
+		Record:   append([]byte(nil), r.Record...),
+	}
+}
+
+// copyRecords copies records out of the message they alias, into a single
+// buffer.
+func copyRecords(records []Record) []Record {
+	size := 0
+	for i := range records {
+		size += len(records[i].RecordID) + len(records[i].Record)
+	}
+	buf := make([]byte, 0, size)
+	copies := make([]Record, len(records))
+	for i := range records {
+		copies[i].RecordID, buf = copyBytes(buf, records[i].RecordID)
+		copies[i].Record, buf = copyBytes(buf, records[i].Record)
+	}
+	return copies
+}
+
+// copyBytes appends b to buf, and returns the copy, nil if b is.
+func copyBytes(buf []byte, b []byte) ([]byte, []byte) {
+	if b == nil {
+		return nil, buf
+	}
+	start := len(buf)
+	buf = append(buf, b...)
+	return buf[start:len(buf):len(buf)], buf
+}
+
 // Append adds a record to a stream
 func (c *Client) Append(stream string, record []byte) error {
-	return c.sendMessage(encodeAppendByName(stream, record))
+	if c.journal != nil {
+		return c.sendCommand(encodeAppendByName(stream, record))
+	}
+	b := getCommandBuffer()
+	b.buf = appendAppendByName(b.buf, stream, record)
+	return c.sendBuffer(b)
+}
+
+// AppendContext adds a record to a stream and waits for the server to process it,
+// with a sync round-trip on the same connection. The server does not report the
+// RecordID of appended records. If the connection drops before the sync is
+// answered, ErrClosed is returned and the record may or may not have been appended.
+func (c *Client) AppendContext(ctx context.Context, stream string, record []byte) error {
+	return c.appendContext(ctx, textualStreamID(stream), record)
 }
 
 // ContinuousQuery runs a streaming query against a stream or the key-value store.
@@ -58,6 +116,12 @@ func (c *Client) ContinuousQuery(ctx context.Context, dql string, handler func(*
 // Use options to configure the behavior of the query, such as the first Record to query.
 // c.f. QueryOptions for a more details.
 func (c *Client) ContinuousQueryOptions(ctx context.Context, dql string, options *QueryOptions, handler func(*Record)) error {
+	if options != nil && options.checkpoint != nil {
+		return c.checkpointedQuery(ctx, dql, options, handler)
+	}
+	if options != nil && options.maxBuffered > 0 {
+		return c.bufferedQuery(ctx, dql, true, options, handler)
+	}
 	return c.runConsumer(ctx, newQueryConsumer(c, c.nextConsumerID(), dql, true, options, handler))
 }
 
@@ -75,6 +139,22 @@ func (c *Client) ListStreams(ctx context.Context, streamPattern string, handler
 	return c.runConsumer(ctx, newListConsumer(c, c.nextConsumerID(), true, streamPattern, handler))
 }
 
+// OpenContinuousQuery starts a streaming query and returns a Cursor to pull its records.
+// The query runs until the Cursor is closed or ctx is done. The Cursor buffers up
+// to 1024 records, unless options set MaxBuffered.
+// c.f. QueryOptions for a more details on options, which may be nil.
+func (c *Client) OpenContinuousQuery(ctx context.Context, dql string, options *QueryOptions) (*Cursor, error) {
+	return openCursor(ctx, newCursorConsumer(c, c.nextConsumerID(), dql, true, options))
+}
+
+// OpenQuery starts a simple -- one shot -- query and returns a Cursor to pull its records.
+// The query runs until all records are read, the Cursor is closed or ctx is done.
+// The Cursor buffers up to 1024 records, unless options set MaxBuffered.
+// c.f. QueryOptions for a more details on options, which may be nil.
+func (c *Client) OpenQuery(ctx context.Context, dql string, options *QueryOptions) (*Cursor, error) {
+	return openCursor(ctx, newCursorConsumer(c, c.nextConsumerID(), dql, false, options))
+}
+
 // Query runs a simple -- one shot -- query against a stream or a subset of the key-value store.
 // Although this operation can generate large amounts of data, it will terminate.
 func (c *Client) Query(ctx context.Context, dql string, handler func(*Record)) error {
@@ -85,28 +165,65 @@ func (c *Client) Query(ctx context.Context, dql string, handler func(*Record)) e
 // Use options to configure the behavior of the query, such as the first Record to query.
 // c.f. QueryOptions for a more details.
 func (c *Client) QueryOptions(ctx context.Context, dql string, options *QueryOptions, handler func(*Record)) error {
+	if options != nil && options.maxBuffered > 0 {
+		return c.bufferedQuery(ctx, dql, false, options, handler)
+	}
 	return c.runConsumer(ctx, newQueryConsumer(c, c.nextConsumerID(), dql, false, options, handler))
 }
 
 // Remove deletes a key from the key-value store.
 func (c *Client) Remove(key string) error {
-	return c.sendMessage(encodeRemove(key))
+	return c.sendCommand(encodeRemove(key))
+}
+
+// RemoveContext deletes a key from the key-value store and waits for the server to
+// process it, with a sync round-trip on the same connection.
+func (c *Client) RemoveContext(ctx context.Context, key string) error {
+	consumerID := c.nextConsumerID()
+	return c.runConsumer(ctx, newAckConsumer(c, consumerID, encodeRemove(key), encodeSync(consumerID)))
 }
 
 // RemoveMatches deletes all keys matching the provided pattern from the key-value store.
 func (c *Client) RemoveMatches(keyPattern string) error {
-	return c.sendMessage(encodeRemoveMatches(keyPattern))
+	return c.sendCommand(encodeRemoveMatches(keyPattern))
 }
 
 // Store writes data to the key-value store.
 func (c *Client) Store(key string, record []byte) error {
-	return c.sendMessage(encodeStore(key, record, nil))
+	return c.StoreOptions(key, record, nil)
 }
 
 // Store writes data to the key-value store.
 // Use options to configure TTL and CAS.
 func (c *Client) StoreOptions(key string, record []byte, options *StoreOptions) error {
-	return c.sendMessage(encodeStore(key, record, options))
+	if c.journal != nil {
+		return c.sendCommand(encodeStore(key, record, options))
+	}
+	b := getCommandBuffer()
+	b.buf = appendStore(b.buf, key, record, options)
+	return c.sendBuffer(b)
+}
+
+// StoreContext writes data to the key-value store and waits for the server to process it.
+// The server does not answer writes, so the key is loaded right after the write, on
+// the same connection, and also right before it with options.CompareAndSwap.
+// StoreContext returns the RecordID of the key when it holds record, and
+// ErrStoreConflict otherwise: options.CompareAndSwap did not match the RecordID of
+// the key, or another client wrote or removed the key in between, or it expired.
+// A compare-and-swap is only reported applied when the key held the expected
+// RecordID before the write and another one after it, so that a key already
+// holding the same bytes does not hide a conflict. If the connection drops before
+// the load is answered, ErrClosed is returned and the record may or may not have
+// been stored.
+func (c *Client) StoreContext(ctx context.Context, key string, record []byte, options *StoreOptions) (RecordID, error) {
+	result, err := c.storeContext(ctx, key, record, options)
+	if err != nil {
+		return nil, err
+	}
+	if !result.applied(record, options.compareAndSwap()) {
+		return nil, ErrStoreConflict
+	}
+	return result.after.RecordID, nil
 }
 
 // Sync execute a sync cycle with the server.
@@ -116,14 +233,96 @@ func (c *Client) Sync(ctx context.Context) error {
 
 // Truncate removes all records of the specified stream.
 func (c *Client) Truncate(stream string) error {
-	return c.sendMessage(encodeTruncateByName(stream))
+	return c.sendCommand(encodeTruncateByName(stream))
 }
 
 func (c *Client) append(streamID streamID, record []byte) error {
+	if c.journal == nil {
+		b := getCommandBuffer()
+		if streamID.isNumeric() {
+			b.buf = appendAppendByID(b.buf, streamID.numericID(), record)
+		} else {
+			b.buf = appendAppendByName(b.buf, streamID.textualID(), record)
+		}
+		return c.sendBuffer(b)
+	}
+	if !streamID.isNumeric() {
+		return c.sendCommand(encodeAppendByName(streamID.textualID(), record))
+	}
+	return c.sendCommandAs(encodeAppendByID(streamID.numericID(), record), encodeAppendByName(c.defines.name(streamID), record))
+}
+
+func (c *Client) appendContext(ctx context.Context, streamID streamID, record []byte) error {
+	var command []byte
 	if streamID.isNumeric() {
-		return c.sendMessage(encodeAppendByID(streamID.numericID(), record))
+		command = encodeAppendByID(streamID.numericID(), record)
+	} else {
+		command = encodeAppendByName(streamID.textualID(), record)
+	}
+	consumerID := c.nextConsumerID()
+	return c.runConsumer(ctx, newAckConsumer(c, consumerID, command, encodeSync(consumerID)))
+}
+
+// storeResult holds the records of a key loaded around a store, nil when the
+// key does not exist. before is only loaded for a compare-and-swap.
+type storeResult struct {
+	before *Record
+	after  *Record
+}
+
+// applied reports whether the store of record, with the compare-and-swap of
+// cas if not nil, was applied. The other writes of the same bytes in between
+// the load before the store and the store itself cannot be told apart.
+func (r storeResult) applied(record []byte, cas RecordID) bool {
+	if r.after == nil || !bytes.Equal(r.after.Record, record) {
+		return false
+	}
+	if cas == nil {
+		return true
+	}
+	return r.before != nil && bytes.Equal(r.before.RecordID, cas) && !bytes.Equal(r.after.RecordID, cas)
+}
+
+// storeContext stores record, and returns the records of the key loaded around
+// the store once it is processed.
+func (c *Client) storeContext(ctx context.Context, key string, record []byte, options *StoreOptions) (storeResult, error) {
+	consumerID := c.nextConsumerID()
+	consumer := newAckConsumer(c, consumerID, encodeStore(key, record, options), encodeLoad(consumerID, key))
+	if options.compareAndSwap() != nil {
+		consumer.before = encodeLoad(consumerID, key)
+	}
+	if err := c.runConsumer(ctx, consumer); err != nil {
+		return storeResult{}, err
+	}
+	return storeResult{before: consumer.previous, after: consumer.record}, nil
+}
+
+// ack sends the write of consumer, then the command that confirms it. Both go
+// through the same queue, so the server receives them in order.
+func (c *Client) ack(consumer *ackConsumer) error {
+	if consumer.before != nil {
+		if err := c.sendMessage(consumer.before); err != nil {
+			return err
+		}
 	}
-	return c.sendMessage(encodeAppendByName(streamID.textualID(), record))
+	if err := c.sendMessage(consumer.command); err != nil {
+		return err
+	}
+	return c.sendMessage(consumer.confirm)
+}
+
+// bufferedQuery runs handler on the calling goroutine, pulling records through
+// a Cursor bounded by options.MaxBuffered.
+func (c *Client) bufferedQuery(ctx context.Context, dql string, isContinuous bool, options *QueryOptions, handler func(*Record)) error {
+	cursor, err := openCursor(ctx, newCursorConsumer(c, c.nextConsumerID(), dql, isContinuous, options))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close()
+	for cursor.Next(ctx) {
+		handler(cursor.Record())
+	}
+	return cursor.Err()
 }
 
 func (c *Client) query(consumer *queryConsumer) error {
@@ -131,10 +330,14 @@ func (c *Client) query(consumer *queryConsumer) error {
 }
 
 func (c *Client) truncate(streamID streamID) error {
-	if streamID.isNumeric() {
-		return c.sendMessage(encodeTruncateByID(streamID.numericID()))
+	if !streamID.isNumeric() {
+		return c.sendCommand(encodeTruncateByName(streamID.textualID()))
+	}
+	message := encodeTruncateByID(streamID.numericID())
+	if c.journal == nil {
+		return c.sendMessage(message)
 	}
-	return c.sendMessage(encodeTruncateByName(streamID.textualID()))
+	return c.sendCommandAs(message, encodeTruncateByName(c.defines.name(streamID)))
 }
 
 func (c *Client) list(consumer *listConsumer) error {
@@ -142,6 +345,9 @@ func (c *Client) list(consumer *listConsumer) error {
 }
 
 // Load reads data from the key-value store.
+// It returns ErrKeyNotFound when the key does not exist, which the server
+// answers with a single undefined record. Earlier versions returned
+// ErrInvalidServerMessage in that case.
 func (c *Client) Load(ctx context.Context, key string) (*Record, error) {
 	consumer := newLoadConsumer(c, c.nextConsumerID(), key)
 	if err := c.runConsumer(ctx, consumer); err != nil {
@@ -0,0 +1,80 @@
+// Copyright 2019, 1533 Systems, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package driveline
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/1533-systems/golang-sdk/driveline/cbor"
+)
+
+func TestRecord_Decode(t *testing.T) {
+	type order struct {
+		ID     string  `cbor:"id"`
+		Amount float64 `cbor:"amount"`
+	}
+	data, _ := cbor.Marshal(order{ID: "o-1", Amount: 12.5})
+	var o order
+	if err := (&Record{Record: data}).Decode(&o); err != nil || o.ID != "o-1" || o.Amount != 12.5 {
+		t.Fatalf("unexpected order %v (%v)", o, err)
+	}
+	if err := (&Record{Record: []byte{cborTextString | 4, 'a'}}).Decode(&o); err == nil {
+		t.Fatalf("decoded a truncated record")
+	}
+}
+
+func TestBufferedQuery(t *testing.T) {
+	client, fws := testClient()
+	kv := newTestKV(fws)
+	kv.readAsync()
+	defer kv.close()
+	const count = 20
+	for i := 0; i < count; i++ {
+		kv.set(string('a'+rune(i)), []byte{byte(i)})
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var received []byte
+	options := new(QueryOptions).FromStreamHead().MaxBuffered(4)
+	err := client.ContinuousQueryOptions(ctx, "SELECT * FROM '*'", options, func(r *Record) {
+		received = append(received, r.Record[0])
+		switch len(received) {
+		case count / 2:
+			fws.Disconnect()
+			fws.Reconnect()
+		case count:
+			cancel()
+		}
+	})
+	if err != context.Canceled {
+		t.Fatalf("unexpected query result: %v", err)
+	}
+	if len(received) != count {
+		t.Fatalf("expected %d records, got %v", count, received)
+	}
+	for i, r := range received {
+		if r != byte(i) {
+			t.Fatalf("unexpected record %d at %d", r, i)
+		}
+	}
+}
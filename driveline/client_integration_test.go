@@ -25,9 +25,10 @@ package driveline
 import (
 	"bytes"
 	"context"
-	"fmt"
 	"testing"
 	"time"
+
+	"github.com/1533-systems/golang-sdk/driveline/dql"
 )
 
 func TestConnectionContextTimeout(t *testing.T) {
@@ -80,7 +81,7 @@ func TestWriteLoad(t *testing.T) {
 
 func TestClient_ContinuousQuery(t *testing.T) {
 	key := "/dl/infra/infra-manager/create-task/094134ab-97a6-4480-b8ab-ecc51e478b6d/ba9b63ea-15f2-4fb5-9f40-b6a84dadfe76/response"
-	dql := fmt.Sprintf("SELECT * FROM '%s'", key)
+	query := dql.Select().From(key).String()
 	c, err := NewClient(context.Background(), "ws://127.0.0.1:8080")
 	if err != nil {
 		t.Fatalf("client cannot connect")
@@ -93,7 +94,7 @@ func TestClient_ContinuousQuery(t *testing.T) {
 
 	var qErr error
 	go func() {
-		qErr = c.ContinuousQuery(ctx, dql, func(rec *Record) {
+		qErr = c.ContinuousQuery(ctx, query, func(rec *Record) {
 			actual := rec.Record
 			if bytes.Compare(expected, actual) != 0 {
 				t.Fatalf("records don't match!")
@@ -0,0 +1,155 @@
+// Copyright 2019, 1533 Systems, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package driveline
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultJournalSyncInterval = 100 * time.Millisecond
+	journalSyncTimeout         = 10 * time.Second
+)
+
+// outboundJournal records fire-and-forget commands in a Journal before they are
+// sent. A sync round-trip periodically confirms that the server processed
+// them, at which point they are acknowledged in the Journal. Whatever is left
+// is replayed, in order, when the client reconnects.
+type outboundJournal struct {
+	client       *Client
+	journal      Journal
+	syncInterval time.Duration
+	connected    int32
+	mu           sync.Mutex
+	lastSeq      uint64
+	syncedSeq    uint64
+	stop         chan struct{}
+	stopped      chan struct{}
+	closeOnce    sync.Once
+}
+
+func newOutboundJournal(client *Client, journal Journal) *outboundJournal {
+	return &outboundJournal{
+		client:       client,
+		journal:      journal,
+		syncInterval: defaultJournalSyncInterval,
+		stop:         make(chan struct{}),
+		stopped:      make(chan struct{}),
+	}
+}
+
+// send journals durable, which must be replayable on any connection, and
+// sends message, its possibly more compact equivalent, when connected.
+func (o *outboundJournal) send(message []byte, durable []byte) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	seq, err := o.journal.Append(durable)
+	if err != nil {
+		return err
+	}
+	o.lastSeq = seq
+	if atomic.LoadInt32(&o.connected) == 0 {
+		return nil
+	}
+	return o.client.sendMessage(message)
+}
+
+// replay sends every command that was not acknowledged, ahead of the commands
+// queued on the new connection.
+func (o *outboundJournal) replay(send func([]byte) error) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	err := o.journal.Replay(func(seq uint64, command []byte) error {
+		if seq > o.lastSeq {
+			o.lastSeq = seq
+		}
+		return send(command)
+	})
+	if err != nil {
+		return err
+	}
+	atomic.StoreInt32(&o.connected, 1)
+	return nil
+}
+
+func (o *outboundJournal) disconnected() {
+	atomic.StoreInt32(&o.connected, 0)
+}
+
+func (o *outboundJournal) run() {
+	defer close(o.stopped)
+	ticker := time.NewTicker(o.syncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-o.stop:
+			return
+		case <-ticker.C:
+			if err := o.sync(); err != nil && err != ErrClosed {
+				o.client.errorHandler(err)
+			}
+		}
+	}
+}
+
+func (o *outboundJournal) close() {
+	o.closeOnce.Do(func() { close(o.stop) })
+	<-o.stopped
+}
+
+func (o *outboundJournal) sync() error {
+	consumer := newSyncConsumer(o.client, o.client.nextConsumerID())
+	o.client.registerConsumer(consumer)
+	defer o.client.unregisterConsumer(consumer)
+
+	// The sync command is queued under the lock, right behind the last
+	// journaled command: once it is answered, every command up to seq has
+	// been processed by the server.
+	o.mu.Lock()
+	seq := o.lastSeq
+	if seq == o.syncedSeq || atomic.LoadInt32(&o.connected) == 0 {
+		o.mu.Unlock()
+		return nil
+	}
+	err := consumer.run(context.Background())
+	o.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	timeout := time.NewTimer(journalSyncTimeout)
+	defer timeout.Stop()
+	select {
+	case <-consumer.done():
+		if err := consumer.err(); err != nil {
+			return err
+		}
+	case <-timeout.C:
+		return context.DeadlineExceeded
+	case <-o.stop:
+		return nil
+	}
+	o.syncedSeq = seq
+	return o.journal.Ack(seq)
+}
@@ -0,0 +1,121 @@
+// Copyright 2019, 1533 Systems, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package driveline
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	ws "github.com/1533-systems/golang-sdk/driveline/websocket"
+)
+
+func testJournalClient(journal Journal) (*Client, *ws.FakeWebSocket) {
+	fake := ws.NewFakeWebsocket()
+	c, _ := NewClient(context.Background(), "ws://test", websocketProvider(fake.Provide),
+		OutboundJournal(journal), JournalSyncInterval(time.Hour))
+	fake.Reconnect()
+	return c, fake
+}
+
+func TestOutboundJournalSync(t *testing.T) {
+	t.Run("acknowledges the commands once the sync is answered", func(t *testing.T) {
+		journal := NewMemoryJournal()
+		c, fake := testJournalClient(journal)
+		defer c.Close()
+		fake.WriteHandler = func(buf []byte) (int, error) {
+			if name, args := testCommand(buf); name == "syn" {
+				consumerID, _ := args[0].(uint64)
+				fake.Receive(testSyncMessage(consumerID))
+			}
+			return len(buf), nil
+		}
+		c.Append("stream", testRecord)
+		if err := c.journal.sync(); err != nil {
+			t.Fatalf("cannot sync: %s", err)
+		}
+		if journal.Len() != 0 {
+			t.Fatalf("expected the journal to be acknowledged, %d commands left", journal.Len())
+		}
+	})
+
+	t.Run("fails when the connection is lost", func(t *testing.T) {
+		journal := NewMemoryJournal()
+		c, fake := testJournalClient(journal)
+		defer c.Close()
+		fake.WriteHandler = func(buf []byte) (int, error) {
+			if name, _ := testCommand(buf); name == "syn" {
+				fake.Disconnect()
+			}
+			return len(buf), nil
+		}
+		c.Append("stream", testRecord)
+		result := make(chan error, 1)
+		go func() { result <- c.journal.sync() }()
+		select {
+		case err := <-result:
+			if err != ErrClosed {
+				t.Fatalf("expected ErrClosed, got %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("sync did not fail on disconnect")
+		}
+		if journal.Len() != 1 {
+			t.Fatalf("expected the command to stay in the journal, got %d", journal.Len())
+		}
+	})
+
+	t.Run("replays the pending commands after a reconnection", func(t *testing.T) {
+		journal := NewMemoryJournal()
+		c, fake := testJournalClient(journal)
+		defer c.Close()
+		kv := newTestKV(fake)
+		var appended []string
+		fake.WriteHandler = func(buf []byte) (int, error) {
+			if name, args := testCommand(buf); name == "app" {
+				record, _ := args[2].([]byte)
+				appended = append(appended, string(record))
+			}
+			return kv.write(buf)
+		}
+		c.Append("stream", []byte("a"))
+		fake.Disconnect()
+		c.Append("stream", []byte("b"))
+		fake.Reconnect()
+		if len(appended) != 3 || appended[1] != "a" || appended[2] != "b" {
+			t.Fatalf("expected a, then a and b to be replayed, got %v", appended)
+		}
+		if err := c.journal.sync(); err != nil {
+			t.Fatalf("cannot sync: %s", err)
+		}
+		if journal.Len() != 0 {
+			t.Fatalf("expected the journal to be acknowledged, %d commands left", journal.Len())
+		}
+	})
+
+	t.Run("can be closed twice", func(t *testing.T) {
+		c, _ := testJournalClient(NewMemoryJournal())
+		c.Close()
+		if err := c.Close(); err != nil {
+			t.Fatalf("cannot close twice: %s", err)
+		}
+	})
+}
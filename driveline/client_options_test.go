@@ -21,7 +21,11 @@
 package driveline
 
 import (
+	"context"
+	"crypto/tls"
 	"errors"
+	"net"
+	"net/url"
 	"testing"
 	"time"
 )
@@ -97,6 +101,16 @@ func TestClientOptions(t *testing.T) {
 		}
 	})
 
+	t.Run("configures the retry policy", func(t *testing.T) {
+		opts := newClientOptions()
+		MaxReconnectWait(time.Minute)(&opts)
+		Retry(DecorrelatedJitter(time.Second, time.Minute))(&opts)
+		OnRetry(func(RetryEvent) {})(&opts)
+		if len(opts.wsOptions) != 2 || opts.retryHandler == nil {
+			t.Fail()
+		}
+	})
+
 	t.Run("configures the WebSocket maxInFlight", func(t *testing.T) {
 		opts := newClientOptions()
 		if len(opts.wsOptions) != 0 {
@@ -108,6 +122,79 @@ func TestClientOptions(t *testing.T) {
 		}
 	})
 
+	t.Run("configures WebSocket pings", func(t *testing.T) {
+		opts := newClientOptions()
+		PingInterval(time.Second)(&opts)
+		PongTimeout(time.Second)(&opts)
+		if len(opts.wsOptions) != 2 {
+			t.Fail()
+		}
+	})
+
+	t.Run("configures WebSocket compression", func(t *testing.T) {
+		opts := newClientOptions()
+		Compression()(&opts)
+		CompressionNoContextTakeover()(&opts)
+		CompressionThreshold(64)(&opts)
+		if len(opts.wsOptions) != 3 {
+			t.Fail()
+		}
+	})
+
+	t.Run("configures endpoints", func(t *testing.T) {
+		opts := newClientOptions()
+		Endpoints("ws://node-2:8080", "ws://node-3:8080")(&opts)
+		Resolver(FileEndpoints("endpoints"))(&opts)
+		if len(opts.wsOptions) != 2 {
+			t.Fail()
+		}
+	})
+
+	t.Run("configures TLS", func(t *testing.T) {
+		opts := newClientOptions()
+		TLSConfig(&tls.Config{ServerName: "driveline"})(&opts)
+		ClientCertificate("client.pem", "client-key.pem")(&opts)
+		RootCAs("ca.pem")(&opts)
+		if len(opts.wsOptions) != 3 {
+			t.Fail()
+		}
+	})
+
+	t.Run("configures the dialer and the proxy", func(t *testing.T) {
+		opts := newClientOptions()
+		Dialer(&net.Dialer{KeepAlive: time.Minute})(&opts)
+		ProxyURL(&url.URL{Scheme: "socks5", Host: "proxy:1080"})(&opts)
+		Proxy(nil)(&opts)
+		if len(opts.wsOptions) != 3 {
+			t.Fail()
+		}
+	})
+
+	t.Run("configures an authenticator", func(t *testing.T) {
+		opts := newClientOptions()
+		Authentication(BearerToken(func(context.Context, bool) (string, error) {
+			return "token", nil
+		}))(&opts)
+		if len(opts.wsOptions) != 1 {
+			t.Fail()
+		}
+	})
+
+	t.Run("configures an outbound journal", func(t *testing.T) {
+		opts := newClientOptions()
+		OutboundJournal(NewMemoryJournal())(&opts)
+		JournalSyncInterval(time.Second)(&opts)
+		if opts.client.journal == nil {
+			t.Fail()
+		}
+		if len(opts.wsOptions) != 1 {
+			t.Fail()
+		}
+		if opts.journalSyncInterval != time.Second {
+			t.Fail()
+		}
+	})
+
 }
 
 func newClientOptions(options ...option) clientOptions {
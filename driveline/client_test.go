@@ -72,4 +72,24 @@ func TestClient_Append(t *testing.T) {
 	}
 }
 
-
+func TestCopyRecords(t *testing.T) {
+	buf := []byte("idrecord")
+	records := []Record{
+		{RecordID: RecordID(buf[:2]), Record: buf[2:]},
+		{Record: buf[2:2]},
+	}
+	copies := copyRecords(records)
+	for i := range buf {
+		buf[i] = 0
+	}
+	if string(copies[0].RecordID) != "id" || string(copies[0].Record) != "record" {
+		t.Fail()
+	}
+	if copies[1].RecordID != nil || copies[1].Record == nil || len(copies[1].Record) != 0 {
+		t.Fail()
+	}
+	// The copies must not overwrite each other when appended to.
+	if cap(copies[0].RecordID) != 2 {
+		t.Fail()
+	}
+}
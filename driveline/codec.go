@@ -0,0 +1,136 @@
+// Copyright 2019, 1533 Systems, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package driveline
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/1533-systems/golang-sdk/driveline/cbor"
+)
+
+// Codec converts values to record payloads and back.
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// Built-in codecs. For protobuf messages, c.f. ProtobufCodec.
+var (
+	JSON Codec = CodecFuncs(json.Marshal, json.Unmarshal)
+	CBOR Codec = CodecFuncs(cbor.Marshal, cbor.Unmarshal)
+)
+
+type codecFuncs struct {
+	marshal   func(v interface{}) ([]byte, error)
+	unmarshal func(data []byte, v interface{}) error
+}
+
+// CodecFuncs returns a Codec using the given functions, for instance
+// msgpack.Marshal and msgpack.Unmarshal.
+func CodecFuncs(marshal func(v interface{}) ([]byte, error), unmarshal func(data []byte, v interface{}) error) Codec {
+	return codecFuncs{marshal: marshal, unmarshal: unmarshal}
+}
+
+func (c codecFuncs) Marshal(v interface{}) ([]byte, error) {
+	return c.marshal(v)
+}
+
+func (c codecFuncs) Unmarshal(data []byte, v interface{}) error {
+	return c.unmarshal(data, v)
+}
+
+// ProtobufCodec returns a Codec for the protobuf messages of type M, using the
+// functions of a protobuf library, for instance with google.golang.org/protobuf:
+//
+//	codec := driveline.ProtobufCodec(proto.Marshal, proto.Unmarshal)
+//
+// where M is proto.Message. Values that are not an M cannot be encoded nor
+// decoded.
+func ProtobufCodec[M any](marshal func(M) ([]byte, error), unmarshal func([]byte, M) error) Codec {
+	return CodecFuncs(func(v interface{}) ([]byte, error) {
+		m, ok := v.(M)
+		if !ok {
+			return nil, fmt.Errorf("%T is not a protobuf message", v)
+		}
+		return marshal(m)
+	}, func(data []byte, v interface{}) error {
+		m, ok := v.(M)
+		if !ok {
+			return fmt.Errorf("%T is not a protobuf message", v)
+		}
+		return unmarshal(data, m)
+	})
+}
+
+// RecordDecodeError is reported when the payload of a record cannot be decoded.
+type RecordDecodeError struct {
+	RecordID RecordID
+	Err      error
+}
+
+func (e *RecordDecodeError) Error() string {
+	return fmt.Sprintf("cannot decode record %s: %s", e.RecordID, e.Err)
+}
+
+var recordType = reflect.TypeOf((*Record)(nil))
+
+// DecodeHandler returns a record handler for Query, ContinuousQuery and the
+// like, that decodes records with codec before calling handler. handler is a
+// func(T) or a func(T, *Record), where T is the type of the decoded values, or
+// a pointer to it. Records that cannot be decoded are passed to onError with a
+// *RecordDecodeError instead.
+//
+// DecodeHandler panics when handler has another signature, or onError is nil.
+func DecodeHandler(codec Codec, handler interface{}, onError func(*Record, error)) func(*Record) {
+	h := reflect.ValueOf(handler)
+	if h.Kind() != reflect.Func {
+		panic(fmt.Sprintf("driveline: DecodeHandler needs a func(T) or func(T, *Record), not %T", handler))
+	}
+	t := h.Type()
+	if t.NumOut() != 0 || t.NumIn() < 1 || t.NumIn() > 2 || t.NumIn() == 2 && t.In(1) != recordType {
+		panic(fmt.Sprintf("driveline: DecodeHandler needs a func(T) or func(T, *Record), not %s", t))
+	}
+	if onError == nil {
+		panic("driveline: DecodeHandler needs an error handler")
+	}
+	valueType := t.In(0)
+	isPtr := valueType.Kind() == reflect.Ptr
+	if isPtr {
+		valueType = valueType.Elem()
+	}
+	return func(r *Record) {
+		v := reflect.New(valueType)
+		if err := codec.Unmarshal(r.Record, v.Interface()); err != nil {
+			onError(r, &RecordDecodeError{RecordID: r.RecordID, Err: err})
+			return
+		}
+		if !isPtr {
+			v = v.Elem()
+		}
+		if t.NumIn() == 2 {
+			h.Call([]reflect.Value{v, reflect.ValueOf(r)})
+		} else {
+			h.Call([]reflect.Value{v})
+		}
+	}
+}
@@ -0,0 +1,166 @@
+// Copyright 2019, 1533 Systems, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package driveline
+
+import (
+	"errors"
+	"testing"
+)
+
+// testProtoMessage, testProtoMarshal and testProtoUnmarshal mimic the message
+// interface and the functions of a protobuf library.
+type testProtoMessage interface {
+	Reset()
+	ProtoMessage()
+}
+
+type testMessage struct {
+	Text string
+}
+
+func (m *testMessage) Reset() {
+	*m = testMessage{}
+}
+
+func (m *testMessage) ProtoMessage() {}
+
+func testProtoMarshal(m testProtoMessage) ([]byte, error) {
+	return []byte(m.(*testMessage).Text), nil
+}
+
+func testProtoUnmarshal(data []byte, m testProtoMessage) error {
+	if len(data) == 0 {
+		return errors.New("empty message")
+	}
+	m.Reset()
+	m.(*testMessage).Text = string(data)
+	return nil
+}
+
+var testProto = ProtobufCodec(testProtoMarshal, testProtoUnmarshal)
+
+type testEvent struct {
+	Name  string `json:"name" cbor:"name"`
+	Count int    `json:"count" cbor:"count"`
+}
+
+func TestCodecs(t *testing.T) {
+	t.Run("round trips values", func(t *testing.T) {
+		for _, codec := range []Codec{JSON, CBOR} {
+			data, err := codec.Marshal(testEvent{Name: "a", Count: 2})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var e testEvent
+			if err := codec.Unmarshal(data, &e); err != nil || e.Name != "a" || e.Count != 2 {
+				t.Fail()
+			}
+		}
+	})
+
+	t.Run("uses the functions of a protobuf library", func(t *testing.T) {
+		data, err := testProto.Marshal(&testMessage{Text: "hello"})
+		if err != nil || string(data) != "hello" {
+			t.Fail()
+		}
+		m := &testMessage{Text: "previous"}
+		if err := testProto.Unmarshal(data, m); err != nil || m.Text != "hello" {
+			t.Fail()
+		}
+		if _, err := testProto.Marshal(testEvent{}); err == nil {
+			t.Fail()
+		}
+		if err := testProto.Unmarshal(data, &testEvent{}); err == nil {
+			t.Fail()
+		}
+	})
+}
+
+func TestDecodeHandler(t *testing.T) {
+	record := func(s string) *Record {
+		data, _ := JSON.Marshal(testEvent{Name: s})
+		return &Record{RecordID: RecordID{1}, Record: data}
+	}
+	noError := func(r *Record, err error) {
+		t.Errorf("unexpected error %s", err)
+	}
+
+	t.Run("decodes values", func(t *testing.T) {
+		var names []string
+		h := DecodeHandler(JSON, func(e testEvent) {
+			names = append(names, e.Name)
+		}, noError)
+		h(record("a"))
+		h(record("b"))
+		if len(names) != 2 || names[0] != "a" || names[1] != "b" {
+			t.Fail()
+		}
+	})
+
+	t.Run("decodes pointers and passes the record", func(t *testing.T) {
+		var calls int
+		h := DecodeHandler(testProto, func(m *testMessage, r *Record) {
+			calls++
+			if m.Text != "hello" || r.RecordID[0] != 2 {
+				t.Fail()
+			}
+		}, noError)
+		h(&Record{RecordID: RecordID{2}, Record: []byte("hello")})
+		if calls != 1 {
+			t.Fail()
+		}
+	})
+
+	t.Run("reports decode errors", func(t *testing.T) {
+		var errs []error
+		h := DecodeHandler(JSON, func(testEvent) {
+			t.Fail()
+		}, func(r *Record, err error) {
+			errs = append(errs, err)
+		})
+		h(&Record{RecordID: RecordID{3}, Record: []byte("{")})
+		if len(errs) != 1 {
+			t.FailNow()
+		}
+		if err, ok := errs[0].(*RecordDecodeError); !ok || err.RecordID[0] != 3 || err.Err == nil {
+			t.Fail()
+		}
+	})
+
+	t.Run("panics with an invalid handler", func(t *testing.T) {
+		for _, handler := range []interface{}{
+			nil,
+			"handler",
+			func() {},
+			func(testEvent, string) {},
+			func(testEvent) error { return nil },
+		} {
+			func() {
+				defer func() {
+					if recover() == nil {
+						t.Errorf("no panic with %T", handler)
+					}
+				}()
+				DecodeHandler(JSON, handler, noError)
+			}()
+		}
+	})
+}
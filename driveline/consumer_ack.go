@@ -0,0 +1,79 @@
+// Copyright 2019, 1533 Systems, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package driveline
+
+import (
+	"context"
+)
+
+// ackConsumer waits for the server to process a single write command. The
+// server does not answer writes: the command is followed, on the same
+// connection, by a command that is answered once the write is processed.
+type ackConsumer struct {
+	baseConsumer
+	before   []byte // load answered to this consumer before the write, if any
+	command  []byte
+	confirm  []byte // sync or load, answered to this consumer
+	previous *Record
+	record   *Record
+	answered bool // whether before was answered
+}
+
+var _ consumer = (*ackConsumer)(nil)
+
+func newAckConsumer(client *Client, consumerID uint64, command []byte, confirm []byte) *ackConsumer {
+	return &ackConsumer{
+		baseConsumer: newBaseConsumer(client, consumerID),
+		command:      command,
+		confirm:      confirm,
+	}
+}
+
+func (c *ackConsumer) run(ctx context.Context) error {
+	return c.Client.ack(c)
+}
+
+// onRecords receives the record loaded before the write, if any, then the
+// answer of the sync, or the record loaded once the write is processed. Loaded
+// records are nil when the key does not exist.
+func (c *ackConsumer) onRecords(records []Record) {
+	var record *Record
+	if len(records) > 0 {
+		record = &records[0]
+	}
+	if c.before != nil && !c.answered {
+		c.previous = record
+		c.answered = true
+		return
+	}
+	c.record = record
+	c.quit()
+}
+
+// onDisconnect fails the write: the server may or may not have applied it,
+// and sending it again could apply it twice.
+func (c *ackConsumer) onDisconnect() {
+	select {
+	case <-c.done():
+	default:
+		c.onFailure(ErrClosed)
+	}
+}
@@ -0,0 +1,214 @@
+// Copyright 2019, 1533 Systems, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package driveline
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestAckConsumer(t *testing.T) {
+	command := encodeStore("key", []byte("data"), nil)
+
+	t.Run("sends the command then the confirmation on run", func(t *testing.T) {
+		client, fws := testClient()
+		var written [][]byte
+		fws.WriteHandler = func(buf []byte) (int, error) {
+			written = append(written, buf)
+			return len(buf), nil
+		}
+		c := newAckConsumer(client, 1533, command, encodeSync(1533))
+		if err := c.run(context.Background()); err != nil {
+			t.Fail()
+		}
+		if len(written) != 2 || !bytes.Equal(written[0], command) || !bytes.Equal(written[1], encodeSync(1533)) {
+			t.Fail()
+		}
+	})
+
+	t.Run("receives the loaded record", func(t *testing.T) {
+		client, _ := testClient()
+		c := newAckConsumer(client, 1533, command, encodeLoad(1533, "key"))
+		c.onRecords([]Record{{RecordID: testRecordID, Record: []byte("data")}})
+		if c.err() != nil || c.record == nil || bytes.Compare(c.record.RecordID, testRecordID) != 0 {
+			t.Fail()
+		}
+		if c.ctx.Err() != context.Canceled {
+			t.Fail()
+		}
+	})
+
+	t.Run("receives the sync", func(t *testing.T) {
+		client, _ := testClient()
+		c := newAckConsumer(client, 1533, command, encodeSync(1533))
+		c.onRecords(nil)
+		if c.err() != nil || c.record != nil {
+			t.Fail()
+		}
+		if c.ctx.Err() != context.Canceled {
+			t.Fail()
+		}
+	})
+
+	t.Run("fails with the server error", func(t *testing.T) {
+		client, _ := testClient()
+		c := newAckConsumer(client, 1533, command, encodeSync(1533))
+		c.onFailure(errors.New("failed"))
+		if c.err() == nil || c.err().Error() != "failed" {
+			t.Fail()
+		}
+	})
+
+	t.Run("fails when the client disconnects", func(t *testing.T) {
+		client, _ := testClient()
+		c := newAckConsumer(client, 1533, command, encodeSync(1533))
+		c.onDisconnect()
+		if c.err() != ErrClosed {
+			t.Fail()
+		}
+		if c.ctx.Err() != context.Canceled {
+			t.Fail()
+		}
+	})
+
+	t.Run("keeps the acknowledgement when the client disconnects afterwards", func(t *testing.T) {
+		client, _ := testClient()
+		c := newAckConsumer(client, 1533, command, encodeSync(1533))
+		c.onRecords(nil)
+		c.onDisconnect()
+		if c.err() != nil {
+			t.Fail()
+		}
+	})
+}
+
+func TestAcknowledgedWrites(t *testing.T) {
+	// reply answers the confirmation of a write, as the server would once it
+	// processed the write, with the record of the key for a load.
+	reply := func(stored *Record) (*Client, *[][]byte) {
+		client, fws := testClient()
+		var written [][]byte
+		fws.WriteHandler = func(buf []byte) (int, error) {
+			written = append(written, buf)
+			name, args := testCommand(buf)
+			switch name {
+			case "syn":
+				fws.Receive(testSyncMessage(args[0].(uint64)))
+			case "ld":
+				if stored == nil {
+					fws.Receive(testUndefinedMessage(args[0].(uint64)))
+				} else {
+					fws.Receive(testDataMessage(args[0].(uint64), *stored))
+				}
+			}
+			return len(buf), nil
+		}
+		return client, &written
+	}
+
+	t.Run("stores and returns the RecordID of the key", func(t *testing.T) {
+		client, written := reply(&Record{RecordID: testRecordID, Record: []byte("data")})
+		id, err := client.StoreContext(context.Background(), "key", []byte("data"), nil)
+		if err != nil || !bytes.Equal(id, testRecordID) {
+			t.Fatal(id, err)
+		}
+		if len(*written) != 2 || !bytes.Equal((*written)[0], encodeStore("key", []byte("data"), nil)) {
+			t.Fail()
+		}
+		if name, _ := testCommand((*written)[1]); name != "ld" {
+			t.Fail()
+		}
+	})
+
+	t.Run("loads the key around a compare-and-swap", func(t *testing.T) {
+		client, fws := testClient()
+		kv := newTestKV(fws)
+		var commands []string
+		fws.WriteHandler = func(buf []byte) (int, error) {
+			name, _ := testCommand(buf)
+			commands = append(commands, name)
+			return kv.write(buf)
+		}
+		kv.set("key", []byte("old"))
+		version, _ := kv.get("key")
+		id, err := client.StoreContext(context.Background(), "key", []byte("data"), new(StoreOptions).CompareAndSwap(version.RecordID))
+		if current, _ := kv.get("key"); err != nil || !bytes.Equal(id, current.RecordID) {
+			t.Fatal(id, err)
+		}
+		if len(commands) != 3 || commands[0] != "ld" || commands[1] != "st" || commands[2] != "ld" {
+			t.Fatalf("unexpected commands %v", commands)
+		}
+	})
+
+	t.Run("fails a compare-and-swap when the key holds the same bytes under another RecordID", func(t *testing.T) {
+		client, fws := testClient()
+		kv := newTestKV(fws)
+		kv.set("key", []byte("data"))
+		stale, _ := kv.get("key")
+		kv.set("key", []byte("data"))
+		if _, err := client.StoreContext(context.Background(), "key", []byte("data"), new(StoreOptions).CompareAndSwap(stale.RecordID)); err != ErrStoreConflict {
+			t.Fatalf("expected ErrStoreConflict, got %v", err)
+		}
+	})
+
+	t.Run("fails a compare-and-swap when the key keeps the expected RecordID", func(t *testing.T) {
+		client, _ := reply(&Record{RecordID: testRecordID, Record: []byte("data")})
+		if _, err := client.StoreContext(context.Background(), "key", []byte("data"), new(StoreOptions).CompareAndSwap(testRecordID)); err != ErrStoreConflict {
+			t.Fatalf("expected ErrStoreConflict, got %v", err)
+		}
+	})
+
+	t.Run("fails to store when the key holds another record", func(t *testing.T) {
+		client, _ := reply(&Record{RecordID: testRecordID, Record: []byte("other")})
+		if _, err := client.StoreContext(context.Background(), "key", []byte("data"), nil); err != ErrStoreConflict {
+			t.Fail()
+		}
+	})
+
+	t.Run("fails to store when the key does not exist", func(t *testing.T) {
+		client, _ := reply(nil)
+		if _, err := client.StoreContext(context.Background(), "key", []byte("data"), nil); err != ErrStoreConflict {
+			t.Fail()
+		}
+	})
+
+	t.Run("appends and syncs", func(t *testing.T) {
+		client, written := reply(nil)
+		if err := client.AppendContext(context.Background(), "stream", []byte("data")); err != nil {
+			t.Fatal(err)
+		}
+		if len(*written) != 2 || !bytes.Equal((*written)[0], encodeAppendByName("stream", []byte("data"))) {
+			t.Fail()
+		}
+	})
+
+	t.Run("removes and syncs", func(t *testing.T) {
+		client, written := reply(nil)
+		if err := client.RemoveContext(context.Background(), "key"); err != nil {
+			t.Fatal(err)
+		}
+		if len(*written) != 2 || !bytes.Equal((*written)[0], encodeRemove("key")) {
+			t.Fail()
+		}
+	})
+}
@@ -0,0 +1,190 @@
+// Copyright 2019, 1533 Systems, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package driveline
+
+import (
+	"context"
+	"sync"
+)
+
+const defaultCursorBufferSize = 1024
+
+var _ consumer = (*cursorConsumer)(nil)
+
+// cursorConsumer buffers the records of a query until a Cursor pulls them.
+// When the buffer is full, the query is canceled on the server and records are
+// dropped; the query is issued again, under a new consumer ID and from the last
+// buffered RecordID, once the Cursor has drained half of the buffer.
+//
+// ConsumerID is guarded by mu, since it changes every time the query resumes.
+type cursorConsumer struct {
+	baseConsumer
+	dql          string
+	isContinuous bool
+	capacity     int
+
+	mu       sync.Mutex
+	options  QueryOptions
+	buffer   []Record
+	paused   bool
+	finished bool
+	closed   bool
+	notify   chan struct{}
+}
+
+func newCursorConsumer(client *Client, consumerID uint64, dql string, isContinuous bool, options *QueryOptions) *cursorConsumer {
+	c := &cursorConsumer{
+		baseConsumer: newBaseConsumer(client, consumerID),
+		dql:          dql,
+		isContinuous: isContinuous,
+		capacity:     defaultCursorBufferSize,
+		notify:       make(chan struct{}, 1),
+	}
+	if options != nil {
+		c.options = *options
+		if options.maxBuffered > 0 {
+			c.capacity = options.maxBuffered
+		}
+	}
+	return c
+}
+
+func (c *cursorConsumer) run(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Client.registerConsumer(c)
+	if err := c.Client.sendMessage(c.encodeQuery()); err != nil {
+		c.Client.unregisterConsumer(c)
+		return err
+	}
+	return nil
+}
+
+func (c *cursorConsumer) onRecords(records []Record) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.paused || c.closed {
+		return
+	}
+	if len(records) == 0 {
+		if !c.isContinuous {
+			c.finished = true
+			c.Client.unregisterConsumer(c)
+			c.quit()
+		}
+		return
+	}
+	if room := c.capacity - len(c.buffer); len(records) >= room {
+		records = records[:room]
+		c.pause()
+	}
+	if len(records) > 0 {
+		c.buffer = append(c.buffer, records...)
+		c.options.FromRecordID(records[len(records)-1].RecordID)
+		c.signal()
+	}
+}
+
+func (c *cursorConsumer) onReconnect() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.paused || c.finished || c.closed {
+		return
+	}
+	if err := c.Client.sendMessage(c.encodeQuery()); err != nil {
+		c.onFailure(err)
+	}
+}
+
+// next pops the first buffered record. It returns false when the buffer is
+// empty.
+func (c *cursorConsumer) next(record *Record) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.buffer) == 0 {
+		if c.paused && !c.closed {
+			c.resume()
+		}
+		return false
+	}
+	*record = c.buffer[0]
+	c.buffer[0] = Record{}
+	c.buffer = c.buffer[1:]
+	if len(c.buffer) == 0 {
+		c.buffer = nil
+	}
+	if c.paused && !c.closed && len(c.buffer) <= c.capacity/2 {
+		c.resume()
+	}
+	return true
+}
+
+// close stops the query. err, when set, is reported as the result of the query.
+func (c *cursorConsumer) close(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+	c.closed = true
+	c.buffer = nil
+	if !c.finished && !c.paused {
+		if err := c.Client.cancel(c); err != nil {
+			c.Client.errorHandler(err)
+		}
+	}
+	c.Client.unregisterConsumer(c)
+	if err != nil && c.ctx.Err() == nil {
+		c.result = err
+	}
+	c.quit()
+}
+
+// pause cancels the query on the server. The records the server sent before
+// the cancel are dropped, even once the query has resumed under a new
+// consumer ID.
+func (c *cursorConsumer) pause() {
+	c.paused = true
+	c.Client.cancelQuietly(c.ConsumerID)
+}
+
+// resume issues the query again under a new consumer ID, so that records the
+// server sent before the cancel are not mistaken for the new ones.
+func (c *cursorConsumer) resume() {
+	c.Client.unregisterConsumer(c)
+	c.ConsumerID = c.Client.nextConsumerID()
+	c.paused = false
+	c.Client.registerConsumer(c)
+	if err := c.Client.sendMessage(c.encodeQuery()); err != nil {
+		c.onFailure(err)
+	}
+}
+
+func (c *cursorConsumer) signal() {
+	select {
+	case c.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (c *cursorConsumer) encodeQuery() []byte {
+	return encodeQuery(c.isContinuous, c.ConsumerID, c.dql, &c.options)
+}
@@ -0,0 +1,221 @@
+// Copyright 2019, 1533 Systems, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package driveline
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func cursorRecords(ids ...byte) []Record {
+	records := make([]Record, len(ids))
+	for i, id := range ids {
+		records[i] = Record{RecordID: RecordID{0, 0, 0, 0, 0, 0, 0, id}, Record: []byte{id}}
+	}
+	return records
+}
+
+func TestCursorConsumer(t *testing.T) {
+	t.Run("sends command on run", func(t *testing.T) {
+		client, fws := testClient()
+		fws.WriteHandler = func(buf []byte) (int, error) {
+			if bytes.Compare(buf, encodeQuery(false, 1533, "pattern", nil)) != 0 {
+				t.Fail()
+			}
+			return len(buf), nil
+		}
+		c := newCursorConsumer(client, 1533, "pattern", false, new(QueryOptions).MaxBuffered(4))
+		if err := c.run(context.Background()); err != nil {
+			t.Fail()
+		}
+	})
+
+	t.Run("buffers records", func(t *testing.T) {
+		client, _ := testClient()
+		c := newCursorConsumer(client, 1533, "pattern", true, new(QueryOptions).MaxBuffered(4))
+		c.onRecords(cursorRecords(1, 2))
+		var r Record
+		if !c.next(&r) || r.Record[0] != 1 {
+			t.Fail()
+		}
+		if !c.next(&r) || r.Record[0] != 2 {
+			t.Fail()
+		}
+		if c.next(&r) {
+			t.Fail()
+		}
+	})
+
+	t.Run("pauses when the buffer is full", func(t *testing.T) {
+		client, fws := testClient()
+		var mu sync.Mutex
+		var written [][]byte
+		fws.WriteHandler = func(buf []byte) (int, error) {
+			mu.Lock()
+			written = append(written, buf)
+			mu.Unlock()
+			return len(buf), nil
+		}
+		c := newCursorConsumer(client, 1533, "pattern", true, new(QueryOptions).MaxBuffered(4))
+		c.onRecords(cursorRecords(1, 2, 3))
+		c.onRecords(cursorRecords(4, 5, 6))
+		c.onRecords(cursorRecords(7))
+		if len(c.buffer) != 4 || !c.paused {
+			t.Fatalf("unexpected buffer %v", c.buffer)
+		}
+		deadline := time.Now().Add(time.Second)
+		for {
+			mu.Lock()
+			n := len(written)
+			mu.Unlock()
+			if n == 2 || time.Now().After(deadline) {
+				break
+			}
+		}
+		if len(written) != 2 || bytes.Compare(written[0], encodeCancel(1533)) != 0 {
+			t.Fatalf("expected a cancel command")
+		}
+		if name, _ := testCommand(written[1]); name != "syn" {
+			t.Fatalf("expected the cancel to be followed by a sync")
+		}
+
+		var r Record
+		c.next(&r)
+		if len(written) != 2 {
+			t.Fatalf("resumed above the low watermark")
+		}
+		c.next(&r)
+		if len(written) != 3 || c.paused {
+			t.Fatalf("expected the query to resume")
+		}
+		resumed := encodeQuery(true, c.ConsumerID, "pattern", new(QueryOptions).FromRecordID(cursorRecords(4)[0].RecordID))
+		if c.ConsumerID == 1533 || bytes.Compare(written[2], resumed) != 0 {
+			t.Fatalf("unexpected resume command")
+		}
+	})
+
+	t.Run("drops the records sent before the cancel once resumed", func(t *testing.T) {
+		client, fws := testClient()
+		var mu sync.Mutex
+		var errs []error
+		client.errorHandler = func(err error) {
+			mu.Lock()
+			errs = append(errs, err)
+			mu.Unlock()
+		}
+		synced := make(chan uint64, 1)
+		fws.WriteHandler = func(buf []byte) (int, error) {
+			if name, args := testCommand(buf); name == "syn" {
+				consumerID, _ := args[0].(uint64)
+				synced <- consumerID
+			}
+			return len(buf), nil
+		}
+		c := newCursorConsumer(client, client.nextConsumerID(), "pattern", true, new(QueryOptions).MaxBuffered(1))
+		c.run(context.Background())
+		paused := c.ConsumerID
+		fws.Receive(testDataMessage(paused, cursorRecords(1)...))
+		syncID := <-synced
+		var r Record
+		c.next(&r)
+		if c.ConsumerID == paused {
+			t.Fatalf("expected the query to resume under a new consumer ID")
+		}
+		fws.Receive(testDataMessage(paused, cursorRecords(2)...))
+		mu.Lock()
+		if len(errs) != 0 {
+			t.Fatalf("unexpected error %v", errs[0])
+		}
+		mu.Unlock()
+		if c.next(&r) {
+			t.Fatalf("unexpected record %v", r)
+		}
+
+		fws.Receive(testSyncMessage(syncID))
+		deadline := time.Now().Add(time.Second)
+		for {
+			fws.Receive(testDataMessage(paused, cursorRecords(3)...))
+			mu.Lock()
+			n := len(errs)
+			mu.Unlock()
+			if n > 0 || time.Now().After(deadline) {
+				break
+			}
+		}
+		if len(errs) == 0 {
+			t.Fatalf("expected messages to be reported once the cancel is synced")
+		}
+	})
+
+	t.Run("re-issues the query from the last record on reconnect", func(t *testing.T) {
+		client, fws := testClient()
+		c := newCursorConsumer(client, 1533, "pattern", true, new(QueryOptions).MaxBuffered(4))
+		c.onRecords(cursorRecords(1))
+		var commandWritten bool
+		fws.WriteHandler = func(buf []byte) (int, error) {
+			expected := encodeQuery(true, 1533, "pattern", new(QueryOptions).FromRecordID(cursorRecords(1)[0].RecordID))
+			if bytes.Compare(buf, expected) != 0 {
+				t.Fail()
+			}
+			commandWritten = true
+			return len(buf), nil
+		}
+		c.onDisconnect()
+		c.onReconnect()
+		if !commandWritten {
+			t.Fail()
+		}
+	})
+
+	t.Run("stops when one-shot query terminates", func(t *testing.T) {
+		client, _ := testClient()
+		c := newCursorConsumer(client, 1533, "pattern", false, new(QueryOptions).MaxBuffered(4))
+		c.onRecords(cursorRecords(1))
+		c.onRecords(nil)
+		if c.ctx.Err() != context.Canceled || c.err() != nil {
+			t.Fail()
+		}
+		var r Record
+		if !c.next(&r) {
+			t.Fail()
+		}
+	})
+
+	t.Run("cancels the query on close", func(t *testing.T) {
+		client, fws := testClient()
+		c := newCursorConsumer(client, 1533, "pattern", true, new(QueryOptions).MaxBuffered(4))
+		var commandWritten bool
+		fws.WriteHandler = func(buf []byte) (int, error) {
+			if bytes.Compare(buf, encodeCancel(1533)) != 0 {
+				t.Fail()
+			}
+			commandWritten = true
+			return len(buf), nil
+		}
+		c.close(nil)
+		if !commandWritten || c.ctx.Err() != context.Canceled || c.err() != nil {
+			t.Fail()
+		}
+	})
+}
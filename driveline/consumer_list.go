@@ -51,28 +51,16 @@ func (c *listConsumer) onRecords(records []Record) {
 		c.onFailure(ErrInvalidServerMessage)
 		return
 	}
-	encoded := records[0].Record
-	code := encoded[0]
-	if !isArray(code) {
-		c.onFailure(ErrInvalidServerMessage)
+	var entries []string
+	if err := decodeRecord(records[0].Record, &entries); err != nil {
+		c.onFailure(err)
 		return
 	}
-	if lenCode(code) == 0 {
+	if len(entries) == 0 {
 		c.quit()
 		return
 	}
-	entryCount, encoded, err := decodeNumber(encoded)
-	if err != nil {
-		c.onFailure(err)
-		return
-	}
-	var entry string
-	for i := uint64(0); i < entryCount; i++ {
-		entry, encoded, err = decodeString(encoded)
-		if err != nil {
-			c.onFailure(err)
-			return
-		}
+	for _, entry := range entries {
 		c.handler(entry)
 	}
 }
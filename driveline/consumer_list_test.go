@@ -142,6 +142,19 @@ func TestListConsumer(t *testing.T) {
 			t.Fail()
 		}
 	})
+	t.Run("fails with an empty or truncated payload", func(t *testing.T) {
+		for _, record := range [][]byte{
+			nil,
+			{cborArray | 2, cborTextString | 5, 'h', 'e', 'l', 'l', 'o', cborTextString | 5, 'w'},
+		} {
+			client, _ := testClient()
+			c := newListConsumer(client, 1533, true, "pattern", func(string) {})
+			c.onRecords([]Record{{Record: record}})
+			if _, ok := c.err().(*DecodeError); !ok {
+				t.Fail()
+			}
+		}
+	})
 	t.Run("fails with a payload where the number of items is incorrectly encoded", func(t *testing.T) {
 		client, _ := testClient()
 		var result []string
@@ -43,7 +43,13 @@ func (c *loadConsumer) run(ctx context.Context) error {
 	return c.Client.load(c)
 }
 
+// onRecords receives the loaded record. The server answers the load of a
+// missing key with a single undefined record, which decodes to no record.
 func (c *loadConsumer) onRecords(records []Record) {
+	if len(records) == 0 {
+		c.onFailure(ErrKeyNotFound)
+		return
+	}
 	if len(records) != 1 {
 		c.onFailure(ErrInvalidServerMessage)
 		return
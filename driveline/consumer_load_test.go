@@ -60,20 +60,42 @@ func TestLoadConsumer(t *testing.T) {
 			t.Fail()
 		}
 	})
-	t.Run("fails when there are no entries in the payload", func(t *testing.T) {
+	t.Run("reports a missing key when there are no entries in the payload", func(t *testing.T) {
 		client, _ := testClient()
 		c := newLoadConsumer(client, 1533, "key")
 		c.onRecords([]Record{})
 		if c.record != nil {
 			t.Fail()
 		}
-		if c.err() == nil {
+		if c.err() != ErrKeyNotFound {
 			t.Fail()
 		}
 		if c.ctx.Err() != context.Canceled {
 			t.Fail()
 		}
+	})
+
+	t.Run("fails when there are several entries in the payload", func(t *testing.T) {
+		client, _ := testClient()
+		c := newLoadConsumer(client, 1533, "key")
+		c.onRecords(make([]Record, 2))
+		if c.record != nil || c.err() != ErrInvalidServerMessage {
+			t.Fail()
+		}
+	})
 
+	t.Run("loads a missing key", func(t *testing.T) {
+		client, fws := testClient()
+		fws.WriteHandler = func(buf []byte) (int, error) {
+			if name, args := testCommand(buf); name == "ld" {
+				consumerID, _ := args[0].(uint64)
+				fws.Receive(testUndefinedMessage(consumerID))
+			}
+			return len(buf), nil
+		}
+		if _, err := client.Load(context.Background(), "key"); err != ErrKeyNotFound {
+			t.Fatalf("expected ErrKeyNotFound, got %v", err)
+		}
 	})
 
 	t.Run("does not fail when the client disconnects", func(t *testing.T) {
@@ -32,16 +32,19 @@ type queryConsumer struct {
 	isContinuous bool
 	options      *QueryOptions
 	handler      func(*Record)
+	lastID       RecordID // copy of the last borrowed RecordID
 }
 
 func newQueryConsumer(client *Client, consumerID uint64, dql string, isContinuous bool, options *QueryOptions, handler func(*Record)) *queryConsumer {
-	if options == nil {
-		options = new(QueryOptions)
+	// The options are copied since the query position is tracked in them.
+	opts := new(QueryOptions)
+	if options != nil {
+		*opts = *options
 	}
 	return &queryConsumer{
 		baseConsumer: newBaseConsumer(client, consumerID),
 		dql:          dql,
-		options:      options,
+		options:      opts,
 		handler:      handler,
 		isContinuous: isContinuous,
 	}
@@ -57,12 +60,26 @@ func (c *queryConsumer) onRecords(records []Record) {
 		c.quit()
 		return
 	}
+	if c.options.borrow {
+		for i := 0; i < cnt; i++ {
+			c.handler(&records[i])
+		}
+		if cnt > 0 {
+			c.lastID = append(c.lastID[:0], records[cnt-1].RecordID...)
+			c.options.FromRecordID(c.lastID)
+		}
+		return
+	}
 	for i := 0; i < cnt; i++ {
-		c.options.fromRecordID = records[i].RecordID
+		c.options.FromRecordID(records[i].RecordID)
 		c.handler(&records[i])
 	}
 }
 
+func (c *queryConsumer) borrowsRecords() bool {
+	return c.options.borrow
+}
+
 func (c *queryConsumer) onReconnect() {
 	if err := c.Client.query(c); err != nil {
 		c.onFailure(err)
@@ -87,6 +87,79 @@ func TestQueryConsumer(t *testing.T) {
 		}
 	})
 
+	t.Run("resumes from the last record on reconnect", func(t *testing.T) {
+		client, fws := testClient()
+		var commandWritten bool
+		fws.WriteHandler = func(buf []byte) (int, error) {
+			if bytes.Compare(buf, encodeQuery(true, 1533, "pattern", new(QueryOptions).FromRecordID(testRecordID))) != 0 {
+				t.Fail()
+			}
+			commandWritten = true
+			return len(buf), nil
+		}
+		options := new(QueryOptions)
+		c := newQueryConsumer(client, 1533, "pattern", true, options, func(r *Record) {})
+		c.onRecords([]Record{{RecordID: testRecordID, Record: testRecord}})
+		c.onDisconnect()
+		c.onReconnect()
+		if !commandWritten {
+			t.Fail()
+		}
+		if options.assigned != 0 {
+			t.Fail()
+		}
+	})
+
+	t.Run("copies the records of the message by default", func(t *testing.T) {
+		client, fws := testClient()
+		var result *Record
+		c := newQueryConsumer(client, 1533, "pattern", true, nil, func(r *Record) {
+			result = r
+		})
+		client.registerConsumer(c)
+		message := testDataMessage(1533, Record{RecordID: testRecordID, Record: testRecord})
+		fws.Receive(message)
+		for i := range message {
+			message[i] = 0
+		}
+		if result == nil || !bytes.Equal(result.RecordID, testRecordID) || !bytes.Equal(result.Record, testRecord) {
+			t.Fail()
+		}
+	})
+
+	t.Run("lends the records of the message when borrowing", func(t *testing.T) {
+		client, fws := testClient()
+		var borrowed, cloned *Record
+		c := newQueryConsumer(client, 1533, "pattern", true, new(QueryOptions).BorrowRecords(), func(r *Record) {
+			borrowed = r
+			cloned = r.Clone()
+		})
+		client.registerConsumer(c)
+		message := testDataMessage(1533, Record{RecordID: testRecordID, Record: testRecord})
+		fws.Receive(message)
+		for i := range message {
+			message[i] = 0
+		}
+		if borrowed == nil || bytes.Equal(borrowed.Record, testRecord) {
+			t.Fatal("expected the record to alias the message")
+		}
+		if !bytes.Equal(cloned.RecordID, testRecordID) || !bytes.Equal(cloned.Record, testRecord) {
+			t.Fail()
+		}
+		var commandWritten bool
+		fws.WriteHandler = func(buf []byte) (int, error) {
+			if bytes.Compare(buf, encodeQuery(true, 1533, "pattern", new(QueryOptions).FromRecordID(testRecordID))) != 0 {
+				t.Fail()
+			}
+			commandWritten = true
+			return len(buf), nil
+		}
+		c.onReconnect()
+		if !commandWritten {
+			t.Fail()
+		}
+	})
+
 	t.Run("stops when one-shot query terminates", func(t *testing.T) {
 		client, _ := testClient()
 		c := newQueryConsumer(client, 1533, "pattern", false, nil, func(r *Record) {
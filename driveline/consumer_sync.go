@@ -44,6 +44,12 @@ func (c *syncConsumer) onRecords(records []Record) {
 	c.quit()
 }
 
+// onDisconnect fails the sync, unless it was answered before the connection
+// was lost.
 func (c *syncConsumer) onDisconnect() {
-	c.onFailure(ErrClosed)
+	select {
+	case <-c.done():
+	default:
+		c.onFailure(ErrClosed)
+	}
 }
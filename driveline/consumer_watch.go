@@ -0,0 +1,121 @@
+// Copyright 2019, 1533 Systems, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package driveline
+
+import (
+	"context"
+	"sync"
+
+	"github.com/1533-systems/golang-sdk/driveline/dql"
+)
+
+var _ consumer = (*watchConsumer)(nil)
+
+// watchConsumer runs the continuous query behind Watch. The records of a query
+// on the key-value store do not tell which key they belong to, and removals are
+// not reported at all: every record, like every reconnection, only signals
+// that the keys must be compared with a new snapshot.
+type watchConsumer struct {
+	baseConsumer
+	pattern string
+	changed chan struct{}
+
+	mu      sync.Mutex
+	options QueryOptions // position of the query, c.f. queryConsumer
+	closed  bool
+}
+
+func newWatchConsumer(client *Client, consumerID uint64, pattern string, from RecordID) *watchConsumer {
+	c := &watchConsumer{
+		baseConsumer: newBaseConsumer(client, consumerID),
+		pattern:      pattern,
+		changed:      make(chan struct{}, 1),
+	}
+	if from != nil {
+		c.options.FromRecordID(from)
+	} else {
+		c.options.FromStreamHead()
+	}
+	return c
+}
+
+func (c *watchConsumer) run(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Client.registerConsumer(c)
+	if err := c.Client.sendMessage(c.encodeQuery()); err != nil {
+		c.Client.unregisterConsumer(c)
+		return err
+	}
+	return nil
+}
+
+func (c *watchConsumer) onRecords(records []Record) {
+	if len(records) == 0 {
+		return
+	}
+	c.mu.Lock()
+	c.options.FromRecordID(records[len(records)-1].RecordID)
+	c.mu.Unlock()
+	c.signal()
+}
+
+// onReconnect issues the query again from the last record received. Removals
+// that happened while disconnected are found by the next snapshot.
+func (c *watchConsumer) onReconnect() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+	if err := c.Client.sendMessage(c.encodeQuery()); err != nil {
+		c.onFailure(err)
+		return
+	}
+	c.signal()
+}
+
+func (c *watchConsumer) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+	c.closed = true
+	if c.ctx.Err() == nil {
+		if err := c.Client.cancel(c); err != nil {
+			c.Client.errorHandler(err)
+		}
+	}
+	c.Client.unregisterConsumer(c)
+	c.quit()
+}
+
+func (c *watchConsumer) signal() {
+	select {
+	case c.changed <- struct{}{}:
+	default:
+	}
+}
+
+func (c *watchConsumer) encodeQuery() []byte {
+	return encodeQuery(true, c.ConsumerID, dql.Select().From(c.pattern).String(), &c.options)
+}
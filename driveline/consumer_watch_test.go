@@ -0,0 +1,219 @@
+// Copyright 2019, 1533 Systems, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package driveline
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/1533-systems/golang-sdk/driveline/dql"
+)
+
+func TestWatchConsumer(t *testing.T) {
+	t.Run("sends command on run", func(t *testing.T) {
+		client, fws := testClient()
+		fws.WriteHandler = func(buf []byte) (int, error) {
+			if bytes.Compare(buf, encodeQuery(true, 1533, dql.Select().From("kv/*").String(), new(QueryOptions).FromRecordID(testRecordID))) != 0 {
+				t.Fail()
+			}
+			return len(buf), nil
+		}
+		c := newWatchConsumer(client, 1533, "kv/*", testRecordID)
+		if err := c.run(context.Background()); err != nil {
+			t.Fail()
+		}
+	})
+
+	t.Run("starts from the head without position", func(t *testing.T) {
+		client, fws := testClient()
+		fws.WriteHandler = func(buf []byte) (int, error) {
+			if bytes.Compare(buf, encodeQuery(true, 1533, dql.Select().From("kv/*").String(), new(QueryOptions).FromStreamHead())) != 0 {
+				t.Fail()
+			}
+			return len(buf), nil
+		}
+		c := newWatchConsumer(client, 1533, "kv/*", nil)
+		c.run(context.Background())
+	})
+
+	t.Run("signals records", func(t *testing.T) {
+		client, _ := testClient()
+		c := newWatchConsumer(client, 1533, "kv/*", nil)
+		c.onRecords(nil)
+		select {
+		case <-c.changed:
+			t.Fatalf("unexpected change")
+		default:
+		}
+		c.onRecords(cursorRecords(1, 2))
+		c.onRecords(cursorRecords(3))
+		select {
+		case <-c.changed:
+		default:
+			t.Fatalf("expected a change")
+		}
+		select {
+		case <-c.changed:
+			t.Fatalf("expected changes to be coalesced")
+		default:
+		}
+	})
+
+	t.Run("resumes from the last record on reconnect", func(t *testing.T) {
+		client, fws := testClient()
+		c := newWatchConsumer(client, 1533, "kv/*", nil)
+		c.onRecords(cursorRecords(1, 2))
+		<-c.changed
+		var commandWritten bool
+		fws.WriteHandler = func(buf []byte) (int, error) {
+			expected := encodeQuery(true, 1533, dql.Select().From("kv/*").String(), new(QueryOptions).FromRecordID(cursorRecords(2)[0].RecordID))
+			if bytes.Compare(buf, expected) != 0 {
+				t.Fail()
+			}
+			commandWritten = true
+			return len(buf), nil
+		}
+		c.onDisconnect()
+		c.onReconnect()
+		if !commandWritten {
+			t.Fail()
+		}
+		select {
+		case <-c.changed:
+		default:
+			t.Fatalf("expected a reconnection to signal a change")
+		}
+	})
+
+	t.Run("cancels the query on close", func(t *testing.T) {
+		client, fws := testClient()
+		c := newWatchConsumer(client, 1533, "kv/*", nil)
+		var commandWritten bool
+		fws.WriteHandler = func(buf []byte) (int, error) {
+			if bytes.Compare(buf, encodeCancel(1533)) != 0 {
+				t.Fail()
+			}
+			commandWritten = true
+			return len(buf), nil
+		}
+		c.close()
+		if !commandWritten || c.ctx.Err() != context.Canceled {
+			t.Fail()
+		}
+	})
+}
+
+func nextKeyEvent(t *testing.T, events <-chan KeyEvent) KeyEvent {
+	select {
+	case event, ok := <-events:
+		if !ok {
+			t.Fatalf("events closed")
+		}
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no event")
+	}
+	return KeyEvent{}
+}
+
+func TestWatch(t *testing.T) {
+	t.Run("reports existing keys, then changes", func(t *testing.T) {
+		client, fws := testClient()
+		kv := newTestKV(fws)
+		kv.set("kv/a", []byte("a1"))
+		kv.set("other", []byte("x"))
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		events, err := client.Watch(ctx, "kv/*", nil)
+		if err != nil {
+			t.Fatalf("cannot watch: %s", err)
+		}
+		if e := nextKeyEvent(t, events); e.Kind != KeyPut || e.Key != "kv/a" || string(e.Value) != "a1" || e.RecordID == nil {
+			t.Fatalf("unexpected event %v", e)
+		}
+
+		kv.set("kv/b", []byte("b1"))
+		if e := nextKeyEvent(t, events); e.Kind != KeyPut || e.Key != "kv/b" || string(e.Value) != "b1" {
+			t.Fatalf("unexpected event %v", e)
+		}
+
+		// Removals are noticed with the next change.
+		kv.remove("kv/a")
+		kv.set("kv/b", []byte("b2"))
+		if e := nextKeyEvent(t, events); e.Kind != KeyPut || e.Key != "kv/b" || string(e.Value) != "b2" {
+			t.Fatalf("unexpected event %v", e)
+		}
+		if e := nextKeyEvent(t, events); e.Kind != KeyDelete || e.Key != "kv/a" || e.RecordID != nil {
+			t.Fatalf("unexpected event %v", e)
+		}
+	})
+
+	t.Run("reports expired keys after a reconnection", func(t *testing.T) {
+		client, fws := testClient()
+		kv := newTestKV(fws)
+		ctx := context.Background()
+		client.StoreContext(ctx, "kv/a", []byte("a1"), new(StoreOptions).WithTTL(time.Minute))
+		wctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		events, _ := client.Watch(wctx, "kv/*", nil)
+		nextKeyEvent(t, events)
+
+		fws.Disconnect()
+		kv.advance(time.Minute)
+		fws.Reconnect()
+		if e := nextKeyEvent(t, events); e.Kind != KeyDelete || e.Key != "kv/a" {
+			t.Fatalf("unexpected event %v", e)
+		}
+	})
+
+	t.Run("reports removed keys after the snapshot interval", func(t *testing.T) {
+		client, fws := testClient()
+		kv := newTestKV(fws)
+		kv.set("kv/a", []byte("a1"))
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		events, _ := client.Watch(ctx, "kv/*", new(WatchOptions).SnapshotInterval(10*time.Millisecond))
+		nextKeyEvent(t, events)
+
+		kv.remove("kv/a")
+		if e := nextKeyEvent(t, events); e.Kind != KeyDelete || e.Key != "kv/a" {
+			t.Fatalf("unexpected event %v", e)
+		}
+	})
+
+	t.Run("stops when ctx is done", func(t *testing.T) {
+		client, fws := testClient()
+		kv := newTestKV(fws)
+		ctx, cancel := context.WithCancel(context.Background())
+		events, _ := client.Watch(ctx, "kv/*", nil)
+		cancel()
+		for range events {
+		}
+		kv.mu.Lock()
+		queries := len(kv.queries)
+		kv.mu.Unlock()
+		if queries != 0 {
+			t.Fatalf("expected the query to be cancelled")
+		}
+	})
+}
@@ -0,0 +1,100 @@
+// Copyright 2019, 1533 Systems, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package driveline
+
+import (
+	"context"
+)
+
+// Cursor iterates over the records of a query. Records are buffered as they
+// arrive, up to a bounded number per Cursor, so a slow reader only delays its
+// own query rather than every consumer of the connection.
+//
+//	cursor, err := client.OpenQuery(ctx, "SELECT * FROM 'events'", nil)
+//	if err != nil {
+//		return err
+//	}
+//	defer cursor.Close()
+//	for cursor.Next(ctx) {
+//		process(cursor.Record())
+//	}
+//	return cursor.Err()
+type Cursor struct {
+	consumer *cursorConsumer
+	record   Record
+	err      error
+}
+
+func openCursor(ctx context.Context, consumer *cursorConsumer) (*Cursor, error) {
+	if err := consumer.run(ctx); err != nil {
+		return nil, err
+	}
+	go func() {
+		select {
+		case <-ctx.Done():
+			consumer.close(ctx.Err())
+		case <-consumer.done():
+		}
+	}()
+	return &Cursor{consumer: consumer}, nil
+}
+
+// Next waits for the next record and reports whether there is one. It returns
+// false when a one-shot query has no more records, when the query fails or is
+// closed, or when ctx is done; Err tells these cases apart.
+func (c *Cursor) Next(ctx context.Context) bool {
+	c.err = nil
+	for {
+		if c.consumer.next(&c.record) {
+			return true
+		}
+		select {
+		case <-c.consumer.notify:
+		case <-c.consumer.done():
+			// Records may have been buffered right before the query ended.
+			if c.consumer.next(&c.record) {
+				return true
+			}
+			c.err = c.consumer.err()
+			return false
+		case <-ctx.Done():
+			c.err = ctx.Err()
+			return false
+		}
+	}
+}
+
+// Record returns the current record. It is only valid until the next call to Next.
+func (c *Cursor) Record() *Record {
+	return &c.record
+}
+
+// Err returns the error that made Next return false, if any. It is nil once a
+// one-shot query has returned all of its records, or after Close.
+func (c *Cursor) Err() error {
+	return c.err
+}
+
+// Close cancels the query and releases the buffered records.
+func (c *Cursor) Close() error {
+	c.consumer.close(nil)
+	return nil
+}
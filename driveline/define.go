@@ -67,3 +67,14 @@ func (d *defines) release(id streamID) {
 		d.mu.Unlock()
 	}
 }
+
+// name returns the name of the stream identified by id.
+func (d *defines) name(id streamID) string {
+	if !id.isNumeric() {
+		return id.textualID()
+	}
+	d.mu.Lock()
+	name := d.aliasMap[uint8(id.numericID())]
+	d.mu.Unlock()
+	return name
+}
@@ -0,0 +1,367 @@
+// Copyright 2019, 1533 Systems, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+/*
+Package dql builds DQL queries for the driveline package.
+
+Names, patterns and values are quoted and escaped, so that the rendered query is
+safe to pass to Client.Query, Client.ContinuousQuery and their variants:
+
+	q, err := dql.Select().From("orders/*").Where(dql.Eq("status", "it's shipped")).Build()
+	// SELECT * FROM 'orders/*' WHERE status = 'it''s shipped'
+	if err == nil {
+		err = client.Query(ctx, q, handler)
+	}
+*/
+package dql
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var identifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)*$`)
+
+// Query is a SELECT query.
+type Query struct {
+	fields []string
+	from   string
+	where  Condition
+}
+
+// Select starts a query returning fields, or whole records when none is given.
+func Select(fields ...string) *Query {
+	return &Query{fields: fields}
+}
+
+// From sets the stream name, key or pattern the query reads. Patterns use
+// * to match within a path segment, ** to match across segments and ? to
+// match a single character.
+func (q *Query) From(pattern string) *Query {
+	q.from = pattern
+	return q
+}
+
+// Where filters the records with condition. Calling Where again adds a
+// condition that must also hold.
+func (q *Query) Where(condition Condition) *Query {
+	if q.where != nil {
+		condition = And(q.where, condition)
+	}
+	q.where = condition
+	return q
+}
+
+// Build renders the query. It fails when a condition cannot be expressed in
+// DQL: an Or without conditions, the negation of an And without conditions, a
+// comparison with a NaN or infinite number, or with a value of an unsupported
+// type.
+func (q *Query) Build() (string, error) {
+	var b writer
+	b.WriteString("SELECT ")
+	if len(q.fields) == 0 {
+		b.WriteString("*")
+	}
+	for i, field := range q.fields {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		writeField(&b, field)
+	}
+	b.WriteString(" FROM ")
+	b.WriteString(Quote(q.from))
+	if q.where != nil && !q.where.always() {
+		b.WriteString(" WHERE ")
+		q.where.writeTo(&b)
+	}
+	if b.err != nil {
+		return "", b.err
+	}
+	return b.String(), nil
+}
+
+// String renders the query like Build, and panics when the query cannot be
+// rendered. It suits queries whose conditions are known to be valid, such as
+// the ones without conditions.
+func (q *Query) String() string {
+	s, err := q.Build()
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// Quote returns s as a DQL string literal.
+func Quote(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
+
+// writer renders a query, keeping the first error.
+type writer struct {
+	strings.Builder
+	err error
+}
+
+func (b *writer) fail(err error) {
+	if b.err == nil {
+		b.err = err
+	}
+}
+
+// Condition is a boolean expression of a WHERE clause.
+type Condition interface {
+	// always tells whether the condition holds for every record, in which
+	// case it is left out.
+	always() bool
+	writeTo(b *writer)
+}
+
+type comparison struct {
+	field    string
+	operator string
+	value    interface{}
+}
+
+func (c comparison) always() bool {
+	return false
+}
+
+func (c comparison) writeTo(b *writer) {
+	writeField(b, c.field)
+	if c.value == nil && (c.operator == "=" || c.operator == "<>") {
+		if c.operator == "=" {
+			b.WriteString(" IS NULL")
+		} else {
+			b.WriteString(" IS NOT NULL")
+		}
+		return
+	}
+	b.WriteString(" ")
+	b.WriteString(c.operator)
+	b.WriteString(" ")
+	writeValue(b, c.value)
+}
+
+// Eq is true when field equals value. Values may be strings, booleans,
+// numbers, types based on them, or nil; other values cannot be rendered. c.f.
+// Query.Build. With nil, Eq is true when field IS NULL.
+func Eq(field string, value interface{}) Condition {
+	return comparison{field, "=", value}
+}
+
+// Ne is true when field differs from value. With nil, Ne is true when field
+// IS NOT NULL.
+func Ne(field string, value interface{}) Condition {
+	return comparison{field, "<>", value}
+}
+
+// Lt is true when field is less than value.
+func Lt(field string, value interface{}) Condition {
+	return comparison{field, "<", value}
+}
+
+// Le is true when field is less than or equal to value.
+func Le(field string, value interface{}) Condition {
+	return comparison{field, "<=", value}
+}
+
+// Gt is true when field is greater than value.
+func Gt(field string, value interface{}) Condition {
+	return comparison{field, ">", value}
+}
+
+// Ge is true when field is greater than or equal to value.
+func Ge(field string, value interface{}) Condition {
+	return comparison{field, ">=", value}
+}
+
+type junction struct {
+	operator   string
+	conditions []Condition
+}
+
+// always is true for an And whose conditions all always hold, and for an Or
+// one of whose conditions does.
+func (j junction) always() bool {
+	if j.operator == "OR" {
+		for _, c := range j.conditions {
+			if c.always() {
+				return true
+			}
+		}
+		return false
+	}
+	for _, c := range j.conditions {
+		if !c.always() {
+			return false
+		}
+	}
+	return true
+}
+
+func (j junction) writeTo(b *writer) {
+	if len(j.conditions) == 0 {
+		// An Or without conditions never holds.
+		b.fail(errors.New("dql: Or without conditions"))
+		return
+	}
+	i := 0
+	for _, c := range j.conditions {
+		if c.always() {
+			continue
+		}
+		if i++; i > 1 {
+			b.WriteString(" ")
+			b.WriteString(j.operator)
+			b.WriteString(" ")
+		}
+		if _, nested := c.(junction); nested {
+			b.WriteString("(")
+			c.writeTo(b)
+			b.WriteString(")")
+		} else {
+			c.writeTo(b)
+		}
+	}
+}
+
+// And is true when all conditions are. An And without conditions is always
+// true, and left out of the query.
+func And(conditions ...Condition) Condition {
+	return junction{"AND", conditions}
+}
+
+// Or is true when any of conditions is. An Or without conditions is never
+// true, which cannot be rendered. c.f. Query.Build
+func Or(conditions ...Condition) Condition {
+	return junction{"OR", conditions}
+}
+
+type negation struct {
+	condition Condition
+}
+
+func (n negation) always() bool {
+	return false
+}
+
+func (n negation) writeTo(b *writer) {
+	if n.condition.always() {
+		b.fail(errors.New("dql: Not of a condition that always holds"))
+		return
+	}
+	b.WriteString("NOT (")
+	n.condition.writeTo(b)
+	b.WriteString(")")
+}
+
+// Not is true when condition is not.
+func Not(condition Condition) Condition {
+	return negation{condition}
+}
+
+// writeField writes a field name, quoted as an identifier unless it is a
+// plain, possibly dotted, name.
+func writeField(b *writer, field string) {
+	if identifier.MatchString(field) {
+		b.WriteString(field)
+		return
+	}
+	b.WriteString(`"`)
+	b.WriteString(strings.Replace(field, `"`, `""`, -1))
+	b.WriteString(`"`)
+}
+
+func writeValue(b *writer, value interface{}) {
+	switch v := value.(type) {
+	case nil:
+		b.WriteString("NULL")
+	case string:
+		b.WriteString(Quote(v))
+	case bool:
+		if v {
+			b.WriteString("TRUE")
+		} else {
+			b.WriteString("FALSE")
+		}
+	case int:
+		b.WriteString(strconv.FormatInt(int64(v), 10))
+	case int8:
+		b.WriteString(strconv.FormatInt(int64(v), 10))
+	case int16:
+		b.WriteString(strconv.FormatInt(int64(v), 10))
+	case int32:
+		b.WriteString(strconv.FormatInt(int64(v), 10))
+	case int64:
+		b.WriteString(strconv.FormatInt(v, 10))
+	case uint:
+		b.WriteString(strconv.FormatUint(uint64(v), 10))
+	case uint8:
+		b.WriteString(strconv.FormatUint(uint64(v), 10))
+	case uint16:
+		b.WriteString(strconv.FormatUint(uint64(v), 10))
+	case uint32:
+		b.WriteString(strconv.FormatUint(uint64(v), 10))
+	case uint64:
+		b.WriteString(strconv.FormatUint(v, 10))
+	case float32:
+		writeFloat(b, float64(v), 32)
+	case float64:
+		writeFloat(b, v, 64)
+	default:
+		writeBasicValue(b, value)
+	}
+}
+
+// writeBasicValue writes a value whose type is based on a string, boolean or
+// number type, such as an enumeration.
+func writeBasicValue(b *writer, value interface{}) {
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.String:
+		writeValue(b, v.String())
+	case reflect.Bool:
+		writeValue(b, v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		writeValue(b, v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		writeValue(b, v.Uint())
+	case reflect.Float32:
+		writeValue(b, float32(v.Float()))
+	case reflect.Float64:
+		writeValue(b, v.Float())
+	default:
+		b.fail(fmt.Errorf("dql: cannot compare with a value of type %T", value))
+	}
+}
+
+// writeFloat writes a finite number; DQL has no literal for NaN and infinities.
+func writeFloat(b *writer, v float64, bitSize int) {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		b.fail(fmt.Errorf("dql: cannot compare with %v", v))
+		return
+	}
+	b.WriteString(strconv.FormatFloat(v, 'g', -1, bitSize))
+}
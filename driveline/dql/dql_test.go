@@ -0,0 +1,145 @@
+// Copyright 2019, 1533 Systems, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package dql
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSelect(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		query    *Query
+		expected string
+	}{
+		{"whole records", Select().From("events"), "SELECT * FROM 'events'"},
+		{"fields", Select("a", "b.c").From("events"), "SELECT a, b.c FROM 'events'"},
+		{"quoted fields", Select(`odd "name"`, "1st").From("events"), `SELECT "odd ""name""", "1st" FROM 'events'`},
+		{"quotes", Select().From("it's"), "SELECT * FROM 'it''s'"},
+		{"injection", Select().From("x' OR '1'='1"), "SELECT * FROM 'x'' OR ''1''=''1'"},
+		{"wildcards", Select().From("orders/*/lines/?"), "SELECT * FROM 'orders/*/lines/?'"},
+		{"key patterns", Select().From("kv/**"), "SELECT * FROM 'kv/**'"},
+		{"empty", Select().From(""), "SELECT * FROM ''"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if actual := tc.query.String(); actual != tc.expected {
+				t.Fatalf("expected %s, got %s", tc.expected, actual)
+			}
+		})
+	}
+}
+
+type (
+	testStatus string
+	testCount  int
+)
+
+func TestWhere(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		condition Condition
+		expected  string
+	}{
+		{"string", Eq("status", "it's done"), "status = 'it''s done'"},
+		{"integer", Ne("count", -3), "count <> -3"},
+		{"unsigned", Lt("count", uint64(18446744073709551615)), "count < 18446744073709551615"},
+		{"float", Le("ratio", 0.5), "ratio <= 0.5"},
+		{"boolean", Gt("flag", true), "flag > TRUE"},
+		{"null", Ge("value", nil), "value >= NULL"},
+		{"is null", Eq("value", nil), "value IS NULL"},
+		{"is not null", Ne("value", nil), "value IS NOT NULL"},
+		{"string type", Eq("status", testStatus("done")), "status = 'done'"},
+		{"integer type", Eq("count", testCount(3)), "count = 3"},
+		{"and", And(Eq("a", 1), Eq("b", 2)), "a = 1 AND b = 2"},
+		{"nested", Or(Eq("a", 1), And(Eq("b", 2), Eq("c", 3))), "a = 1 OR (b = 2 AND c = 3)"},
+		{"not", Not(Or(Eq("a", 1), Eq("b", 2))), "NOT (a = 1 OR b = 2)"},
+		{"empty and", And(Eq("a", 1), And()), "a = 1"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			expected := "SELECT * FROM 's' WHERE " + tc.expected
+			if actual := Select().From("s").Where(tc.condition).String(); actual != expected {
+				t.Fatalf("expected %s, got %s", expected, actual)
+			}
+		})
+	}
+
+	t.Run("combines conditions", func(t *testing.T) {
+		expected := "SELECT * FROM 's' WHERE a = 1 AND b = 2"
+		if actual := Select().From("s").Where(Eq("a", 1)).Where(Eq("b", 2)).String(); actual != expected {
+			t.Fatalf("expected %s, got %s", expected, actual)
+		}
+	})
+}
+
+func TestEmptyConditions(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		query *Query
+	}{
+		{"and", Select().From("s").Where(And())},
+		{"nested and", Select().From("s").Where(And(And(), And()))},
+		{"or with an empty and", Select().From("s").Where(Or(Eq("a", 1), And()))},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if actual, err := tc.query.Build(); err != nil || actual != "SELECT * FROM 's'" {
+				t.Fatalf("expected no WHERE clause, got %s (%v)", actual, err)
+			}
+		})
+	}
+}
+
+func TestInvalidQueries(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		condition Condition
+	}{
+		{"empty or", Or()},
+		{"nested empty or", And(Eq("a", 1), Or())},
+		{"negated empty and", Not(And())},
+		{"NaN", Eq("ratio", math.NaN())},
+		{"infinity", Gt("ratio", math.Inf(1))},
+		{"negative infinity", Lt("ratio", float32(math.Inf(-1)))},
+		{"bytes", Eq("value", []byte("x'"))},
+		{"struct", Eq("value", struct{ A int }{1})},
+		{"pointer", Eq("value", new(string))},
+		{"map", Eq("value", map[string]int{})},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			q := Select().From("s").Where(tc.condition)
+			if actual, err := q.Build(); err == nil {
+				t.Fatalf("expected an error, got %s", actual)
+			}
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("expected String to panic")
+				}
+			}()
+			_ = q.String()
+		})
+	}
+}
+
+func TestQuote(t *testing.T) {
+	if Quote("a'b''c") != "'a''b''''c'" {
+		t.Fail()
+	}
+}
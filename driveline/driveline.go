@@ -24,3 +24,17 @@ var ErrClosed = errors.New("connection closed")
 
 // ErrInvalidServerMessage indicates that the client cannot decode server messages.
 var ErrInvalidServerMessage = errors.New("invalid server message")
+
+// ErrKeyNotFound indicates that a key does not exist in the key-value store.
+var ErrKeyNotFound = errors.New("key not found")
+
+// ErrStoreConflict indicates that a key does not hold the record just stored,
+// c.f. Client.StoreContext.
+var ErrStoreConflict = errors.New("key does not hold the stored record")
+
+// serverError is an error reported by the server in response to a command.
+type serverError string
+
+func (e serverError) Error() string {
+	return string(e)
+}
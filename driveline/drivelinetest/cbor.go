@@ -0,0 +1,178 @@
+// Copyright 2019, 1533 Systems, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package drivelinetest
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	cborUnsignedInteger = byte(0 << 5)
+	cborSignedInteger   = byte(1 << 5)
+	cborByteString      = byte(2 << 5)
+	cborTextString      = byte(3 << 5)
+	cborArray           = byte(4 << 5)
+	cborMap             = byte(5 << 5)
+	cborTag             = byte(6 << 5)
+	cborMulti           = byte(7 << 5)
+
+	cborFalse     = cborMulti | 20
+	cborTrue      = cborMulti | 21
+	cborNull      = cborMulti | 22
+	cborUndefined = cborMulti | 23
+
+	cborTypeMask   = 0x07 << 5
+	cborLengthMask = 0x1f
+
+	tagMessageID  = 1
+	tagReadID     = 2
+	tagStoreCASID = 3
+	tagStoreTTL   = 4
+)
+
+var errMalformed = errors.New("malformed CBOR command")
+
+// decode reads a single CBOR item from buf. Unsigned integers are returned as
+// uint64, negative integers as int64, byte strings as []byte, text strings as
+// string, arrays as []interface{} and null/undefined as nil.
+func decode(buf []byte) (interface{}, []byte, error) {
+	if len(buf) == 0 {
+		return nil, nil, errMalformed
+	}
+	d := buf[0]
+	switch d & cborTypeMask {
+	case cborUnsignedInteger:
+		return decodeHead(buf)
+	case cborSignedInteger:
+		n, rest, err := decodeHead(buf)
+		if err != nil {
+			return nil, nil, err
+		}
+		return -1 - int64(n), rest, nil
+	case cborByteString, cborTextString:
+		n, rest, err := decodeHead(buf)
+		if err != nil {
+			return nil, nil, err
+		}
+		if uint64(len(rest)) < n {
+			return nil, nil, errMalformed
+		}
+		if d&cborTypeMask == cborTextString {
+			return string(rest[:n]), rest[n:], nil
+		}
+		return rest[:n:n], rest[n:], nil
+	case cborArray:
+		n, rest, err := decodeHead(buf)
+		if err != nil {
+			return nil, nil, err
+		}
+		if uint64(len(rest)) < n {
+			return nil, nil, errMalformed
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], rest, err = decode(rest); err != nil {
+				return nil, nil, err
+			}
+		}
+		return items, rest, nil
+	case cborMap:
+		n, rest, err := decodeHead(buf)
+		if err != nil {
+			return nil, nil, err
+		}
+		for i := uint64(0); i < 2*n; i++ {
+			if _, rest, err = decode(rest); err != nil {
+				return nil, nil, err
+			}
+		}
+		return nil, rest, nil
+	case cborTag:
+		_, rest, err := decodeHead(buf)
+		if err != nil {
+			return nil, nil, err
+		}
+		return decode(rest)
+	default:
+		switch d {
+		case cborFalse:
+			return false, buf[1:], nil
+		case cborTrue:
+			return true, buf[1:], nil
+		case cborNull, cborUndefined:
+			return nil, buf[1:], nil
+		}
+		return nil, nil, errMalformed
+	}
+}
+
+func decodeHead(buf []byte) (uint64, []byte, error) {
+	size := uint64(buf[0] & cborLengthMask)
+	buf = buf[1:]
+	switch {
+	case size < 24:
+		return size, buf, nil
+	case size == 24 && len(buf) >= 1:
+		return uint64(buf[0]), buf[1:], nil
+	case size == 25 && len(buf) >= 2:
+		return uint64(binary.BigEndian.Uint16(buf)), buf[2:], nil
+	case size == 26 && len(buf) >= 4:
+		return uint64(binary.BigEndian.Uint32(buf)), buf[4:], nil
+	case size == 27 && len(buf) >= 8:
+		return binary.BigEndian.Uint64(buf), buf[8:], nil
+	}
+	return 0, nil, errMalformed
+}
+
+func appendHead(dst []byte, cborType byte, n uint64) []byte {
+	switch {
+	case n < 24:
+		return append(dst, cborType|byte(n))
+	case n < 0x100:
+		return append(dst, cborType|24, byte(n))
+	case n < 0x10000:
+		return append(dst, cborType|25, byte(n>>8), byte(n))
+	case n < 0x100000000:
+		return append(dst, cborType|26, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	default:
+		dst = append(dst, cborType|27)
+		var b [8]byte
+		binary.BigEndian.PutUint64(b[:], n)
+		return append(dst, b[:]...)
+	}
+}
+
+func appendUint(dst []byte, n uint64) []byte {
+	return appendHead(dst, cborUnsignedInteger, n)
+}
+
+func appendBytes(dst []byte, b []byte) []byte {
+	return append(appendHead(dst, cborByteString, uint64(len(b))), b...)
+}
+
+func appendText(dst []byte, s string) []byte {
+	return append(appendHead(dst, cborTextString, uint64(len(s))), s...)
+}
+
+func appendArray(dst []byte, n int) []byte {
+	return appendHead(dst, cborArray, uint64(n))
+}
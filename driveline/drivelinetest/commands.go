@@ -0,0 +1,279 @@
+// Copyright 2019, 1533 Systems, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package drivelinetest
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var selectAll = regexp.MustCompile(`(?is)^\s*SELECT\s+\*\s+FROM\s+'((?:[^']|'')*)'\s*;?\s*$`)
+
+// handle decodes and executes a single client command. An error means the
+// command could not be decoded, in which case the connection is dropped.
+func (c *conn) handle(message []byte) error {
+	v, _, err := decode(message)
+	if err != nil {
+		return err
+	}
+	cmd, ok := v.([]interface{})
+	if !ok || len(cmd) < 2 {
+		return errMalformed
+	}
+	name, _ := cmd[0].(string)
+	st := c.server.state
+	switch {
+	case name == "app" && len(cmd) == 4:
+		stream, ok := c.streamName(cmd[1])
+		data, isBytes := cmd[3].([]byte)
+		if !ok || !isBytes {
+			return errMalformed
+		}
+		st.append(stream, data)
+	case name == "def" && len(cmd) == 3:
+		id, isID := cmd[1].(uint64)
+		stream, isName := cmd[2].(string)
+		if !isID || !isName {
+			return errMalformed
+		}
+		c.aliases[id] = stream
+	case name == "st" && len(cmd) == 4:
+		key, isKey := cmd[1].(string)
+		data, isBytes := cmd[3].([]byte)
+		opts, err := decodeOptions(cmd[2])
+		if !isKey || !isBytes || err != nil {
+			return errMalformed
+		}
+		cas, _ := opts[tagStoreCASID].([]byte)
+		ttl, _ := opts[tagStoreTTL].(uint64)
+		st.store(key, data, cas, ttl)
+	case name == "ld" && len(cmd) == 4:
+		consumerID, isID := cmd[1].(uint64)
+		key, isKey := cmd[3].(string)
+		if !isID || !isKey {
+			return errMalformed
+		}
+		rec, exists := st.load(key)
+		if !exists {
+			// A missing key reads as a single undefined record.
+			c.send(endMessage(consumerID))
+			return nil
+		}
+		c.send(dataMessage(consumerID, []record{rec}))
+	case name == "rm" && len(cmd) == 3:
+		key, isKey := cmd[2].(string)
+		if !isKey {
+			return errMalformed
+		}
+		st.remove(key)
+	case name == "rmk" && len(cmd) == 3:
+		pattern, isPattern := cmd[2].(string)
+		if !isPattern {
+			return errMalformed
+		}
+		st.removeMatches(pattern)
+	case (name == "qq" || name == "sq") && len(cmd) == 4:
+		consumerID, isID := cmd[1].(uint64)
+		dql, isDQL := cmd[3].(string)
+		opts, err := decodeOptions(cmd[2])
+		if !isID || !isDQL || err != nil {
+			return errMalformed
+		}
+		pattern, err := parseDQL(dql)
+		if err != nil {
+			c.send(errorMessage(consumerID, err.Error()))
+			return nil
+		}
+		var from uint64
+		if id, ok := opts[tagReadID].([]byte); ok {
+			from = decodeRecordID(id)
+		}
+		st.query(c, consumerID, pattern, from, name == "sq")
+	case (name == "lst" || name == "sls") && len(cmd) == 4:
+		consumerID, isID := cmd[1].(uint64)
+		pattern, isPattern := cmd[3].(string)
+		if !isID || !isPattern {
+			return errMalformed
+		}
+		names := st.list(name == "sls", pattern)
+		for len(names) > 0 {
+			n := len(names)
+			if n > maxBatchSize {
+				n = maxBatchSize
+			}
+			c.send(listMessage(consumerID, names[:n]))
+			names = names[n:]
+		}
+		c.send(listMessage(consumerID, nil))
+	case name == "trc" && len(cmd) == 3:
+		stream, ok := c.streamName(cmd[2])
+		if !ok {
+			return errMalformed
+		}
+		st.truncate(stream)
+	case name == "syn" && len(cmd) == 2:
+		consumerID, isID := cmd[1].(uint64)
+		if !isID {
+			return errMalformed
+		}
+		c.send(syncMessage(consumerID))
+	case name == "can" && len(cmd) >= 2:
+		consumerID, isID := cmd[1].(uint64)
+		if !isID {
+			return errMalformed
+		}
+		st.unsubscribe(c, consumerID)
+	default:
+		return errMalformed
+	}
+	return nil
+}
+
+// streamName resolves a stream that is either sent by name or by an alias
+// previously declared with the def command.
+func (c *conn) streamName(v interface{}) (string, bool) {
+	switch s := v.(type) {
+	case string:
+		return s, true
+	case uint64:
+		name, ok := c.aliases[s]
+		return name, ok
+	}
+	return "", false
+}
+
+func decodeOptions(v interface{}) (map[uint64]interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	items, ok := v.([]interface{})
+	if !ok || len(items)%2 != 0 {
+		return nil, errMalformed
+	}
+	opts := make(map[uint64]interface{}, len(items)/2)
+	for i := 0; i < len(items); i += 2 {
+		tag, ok := items[i].(uint64)
+		if !ok {
+			return nil, errMalformed
+		}
+		opts[tag] = items[i+1]
+	}
+	return opts, nil
+}
+
+// parseDQL extracts the stream or key pattern of a SELECT * FROM 'pattern'
+// query, which is the only form the emulator understands.
+func parseDQL(dql string) (string, error) {
+	m := selectAll.FindStringSubmatch(dql)
+	if m == nil {
+		return "", fmt.Errorf("unsupported query: %s", dql)
+	}
+	return strings.Replace(m[1], "''", "'", -1), nil
+}
+
+// MatchPattern reports whether the key or stream name matches pattern as the
+// server matches it, where ** matches any sequence of characters, * matches any
+// sequence not containing a '/' and ? matches a single character other than '/'.
+func MatchPattern(pattern, name string) bool {
+	for len(pattern) > 0 {
+		switch {
+		case strings.HasPrefix(pattern, "**"):
+			rest := strings.TrimLeft(pattern, "*")
+			for i := len(name); i >= 0; i-- {
+				if MatchPattern(rest, name[i:]) {
+					return true
+				}
+			}
+			return false
+		case pattern[0] == '*':
+			rest := pattern[1:]
+			for i := 0; i <= len(name); i++ {
+				if MatchPattern(rest, name[i:]) {
+					return true
+				}
+				if i < len(name) && name[i] == '/' {
+					return false
+				}
+			}
+			return false
+		case pattern[0] == '?':
+			if len(name) == 0 || name[0] == '/' {
+				return false
+			}
+		default:
+			if len(name) == 0 || pattern[0] != name[0] {
+				return false
+			}
+		}
+		pattern = pattern[1:]
+		name = name[1:]
+	}
+	return len(name) == 0
+}
+
+func dataMessage(consumerID uint64, records []record) []byte {
+	buf := appendArray(nil, 3+len(records))
+	buf = appendText(buf, "data")
+	buf = appendUint(buf, consumerID)
+	buf = appendArray(buf, 2)
+	buf = appendUint(buf, tagMessageID)
+	buf = appendArray(buf, len(records))
+	for _, rec := range records {
+		buf = appendBytes(buf, encodeRecordID(rec.id))
+	}
+	for _, rec := range records {
+		buf = appendBytes(buf, rec.data)
+	}
+	return buf
+}
+
+func endMessage(consumerID uint64) []byte {
+	buf := appendArray(nil, 4)
+	buf = appendText(buf, "data")
+	buf = appendUint(buf, consumerID)
+	return append(buf, cborUndefined, cborUndefined)
+}
+
+func listMessage(consumerID uint64, names []string) []byte {
+	buf := appendArray(nil, 4)
+	buf = appendText(buf, "data")
+	buf = appendUint(buf, consumerID)
+	buf = append(buf, cborUndefined)
+	payload := appendArray(nil, len(names))
+	for _, name := range names {
+		payload = appendText(payload, name)
+	}
+	return appendBytes(buf, payload)
+}
+
+func errorMessage(consumerID uint64, msg string) []byte {
+	buf := appendArray(nil, 3)
+	buf = appendText(buf, "err")
+	buf = appendUint(buf, consumerID)
+	return appendText(buf, msg)
+}
+
+func syncMessage(consumerID uint64) []byte {
+	buf := appendArray(nil, 2)
+	buf = appendText(buf, "syn")
+	return appendUint(buf, consumerID)
+}
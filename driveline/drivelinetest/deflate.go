@@ -0,0 +1,126 @@
+// Copyright 2019, 1533 Systems, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package drivelinetest
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+const (
+	compressionThreshold = 32
+	maxWindowSize        = 1 << 15
+	rsv1                 = 0x40
+)
+
+var deflateTail = []byte{0x00, 0x00, 0xff, 0xff, 0x01, 0x00, 0x00, 0xff, 0xff}
+
+// deflate is the permessage-deflate state of a connection, c.f. RFC 7692.
+type deflate struct {
+	clientNoContextTakeover bool
+	serverNoContextTakeover bool
+
+	w    *flate.Writer
+	buf  bytes.Buffer
+	r    io.ReadCloser
+	dict []byte
+}
+
+// acceptDeflate accepts the first permessage-deflate offer of the client that
+// the server supports. It returns nil when there is none.
+func acceptDeflate(h http.Header) *deflate {
+	for _, value := range h[http.CanonicalHeaderKey("Sec-WebSocket-Extensions")] {
+	offers:
+		for _, offer := range strings.Split(value, ",") {
+			params := strings.Split(offer, ";")
+			if strings.TrimSpace(params[0]) != "permessage-deflate" {
+				continue
+			}
+			d := new(deflate)
+			for _, param := range params[1:] {
+				switch name := strings.TrimSpace(param); {
+				case name == "client_no_context_takeover":
+					d.clientNoContextTakeover = true
+				case name == "server_no_context_takeover":
+					d.serverNoContextTakeover = true
+				case strings.HasPrefix(name, "client_max_window_bits"):
+					// The client may use a smaller window than the server.
+				default:
+					continue offers
+				}
+			}
+			d.w, _ = flate.NewWriter(&d.buf, flate.BestSpeed)
+			return d
+		}
+	}
+	return nil
+}
+
+// response returns the Sec-WebSocket-Extensions header accepting the offer.
+func (d *deflate) response() string {
+	response := "permessage-deflate"
+	if d.clientNoContextTakeover {
+		response += "; client_no_context_takeover"
+	}
+	if d.serverNoContextTakeover {
+		response += "; server_no_context_takeover"
+	}
+	return response
+}
+
+func (d *deflate) compress(payload []byte) []byte {
+	d.buf.Reset()
+	if d.serverNoContextTakeover {
+		d.w.Reset(&d.buf)
+	}
+	d.w.Write(payload)
+	d.w.Flush()
+	compressed := d.buf.Bytes()
+	return compressed[:len(compressed)-4]
+}
+
+func (d *deflate) decompress(payload []byte) ([]byte, error) {
+	in := io.MultiReader(bytes.NewReader(payload), bytes.NewReader(deflateTail))
+	if d.r == nil {
+		d.r = flate.NewReaderDict(in, d.dict)
+	} else if err := d.r.(flate.Resetter).Reset(in, d.dict); err != nil {
+		return nil, err
+	}
+	message, err := ioutil.ReadAll(io.LimitReader(d.r, maxMessageSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(message) > maxMessageSize {
+		return nil, errFrameTooLarge
+	}
+	if !d.clientNoContextTakeover {
+		window := append(d.dict, message...)
+		if len(window) > maxWindowSize {
+			window = append([]byte(nil), window[len(window)-maxWindowSize:]...)
+		}
+		d.dict = window
+	}
+	return message, nil
+}
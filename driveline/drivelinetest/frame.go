@@ -0,0 +1,136 @@
+// Copyright 2019, 1533 Systems, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package drivelinetest
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+type frameOpCode byte
+
+const (
+	continuationFrame = frameOpCode(0x00)
+	textFrame         = frameOpCode(0x01)
+	binaryFrame       = frameOpCode(0x02)
+	closeFrame        = frameOpCode(0x08)
+	pingFrame         = frameOpCode(0x09)
+	pongFrame         = frameOpCode(0x0A)
+
+	maxMessageSize = 64 * 1024 * 1024
+)
+
+var (
+	errFrameTooLarge         = errors.New("WebSocket message too large")
+	errUnexpectedCompression = errors.New("compressed message without permessage-deflate")
+)
+
+// readMessage reads a complete message from the client, reassembling
+// fragmented frames. Control frames are returned as they arrive. It also tells
+// whether the message is compressed.
+func readMessage(in io.Reader) (frameOpCode, []byte, bool, error) {
+	var (
+		message    []byte
+		msgCode    frameOpCode
+		compressed bool
+	)
+	for {
+		fin, rsv1, opCode, frame, err := readFrame(in)
+		if err != nil {
+			return 0, nil, false, err
+		}
+		if opCode >= closeFrame {
+			return opCode, frame, false, nil
+		}
+		if opCode != continuationFrame {
+			msgCode = opCode
+			message = message[:0]
+			compressed = rsv1
+		}
+		if len(message)+len(frame) > maxMessageSize {
+			return 0, nil, false, errFrameTooLarge
+		}
+		message = append(message, frame...)
+		if fin {
+			return msgCode, message, compressed, nil
+		}
+	}
+}
+
+func readFrame(in io.Reader) (bool, bool, frameOpCode, []byte, error) {
+	var hdr [8]byte
+	if _, err := io.ReadFull(in, hdr[:2]); err != nil {
+		return false, false, 0, nil, err
+	}
+	fin := hdr[0]&0x80 != 0
+	compressed := hdr[0]&rsv1 != 0
+	opCode := frameOpCode(hdr[0] & 0x0F)
+	isMasked := hdr[1]&0x80 != 0
+	frameLen := uint64(hdr[1] & 0x7F)
+	switch frameLen {
+	case 126:
+		if _, err := io.ReadFull(in, hdr[:2]); err != nil {
+			return false, false, 0, nil, err
+		}
+		frameLen = uint64(binary.BigEndian.Uint16(hdr[:2]))
+	case 127:
+		if _, err := io.ReadFull(in, hdr[:8]); err != nil {
+			return false, false, 0, nil, err
+		}
+		frameLen = binary.BigEndian.Uint64(hdr[:8])
+	}
+	if frameLen > maxMessageSize {
+		return false, false, 0, nil, errFrameTooLarge
+	}
+	var mask [4]byte
+	if isMasked {
+		if _, err := io.ReadFull(in, mask[:]); err != nil {
+			return false, false, 0, nil, err
+		}
+	}
+	frame := make([]byte, frameLen)
+	if _, err := io.ReadFull(in, frame); err != nil {
+		return false, false, 0, nil, err
+	}
+	if isMasked {
+		for i := range frame {
+			frame[i] ^= mask[i%4]
+		}
+	}
+	return fin, compressed, opCode, frame, nil
+}
+
+func appendFrame(dst []byte, flags byte, opCode frameOpCode, frame []byte) []byte {
+	l := uint64(len(frame))
+	dst = append(dst, 0x80|flags|byte(opCode))
+	switch {
+	case l < 126:
+		dst = append(dst, byte(l))
+	case l < 0x10000:
+		dst = append(dst, 126, byte(l>>8), byte(l))
+	default:
+		var b [8]byte
+		binary.BigEndian.PutUint64(b[:], l)
+		dst = append(append(dst, 127), b[:]...)
+	}
+	return append(dst, frame...)
+}
@@ -0,0 +1,296 @@
+// Copyright 2019, 1533 Systems, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+/*
+Package drivelinetest provides an in-process Driveline server for hermetic tests.
+
+The server speaks the WebSocket handshake and the CBOR command set used by the
+driveline package, and keeps streams and the key-value store in memory. Like
+the server, it answers the load of a missing key with a single undefined
+record, and applies a compare-and-swap store only to an existing key whose
+RecordID matches.
+
+	srv := drivelinetest.NewServer()
+	defer srv.Close()
+
+	client, err := driveline.NewClient(ctx, srv.URL)
+*/
+package drivelinetest
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+)
+
+const webSocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// Server is an in-memory Driveline server listening on a local loopback port.
+type Server struct {
+	// URL is the ws:// endpoint of the server, suitable for driveline.NewClient.
+	URL string
+
+	http  *httptest.Server
+	state *state
+	mu    sync.Mutex
+	conns map[*conn]struct{}
+}
+
+// NewServer starts and returns a new Server. The caller should call Close when finished.
+func NewServer() *Server {
+	s := &Server{
+		state: newState(),
+		conns: make(map[*conn]struct{}),
+	}
+	s.http = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	s.URL = "ws" + strings.TrimPrefix(s.http.URL, "http")
+	return s
+}
+
+// Close drops every client connection and shuts down the server.
+func (s *Server) Close() {
+	s.DropConnections()
+	s.http.Close()
+	s.state.close()
+}
+
+// DropConnections abruptly closes every client connection, without a WebSocket
+// close handshake, to simulate a network failure. The server keeps its data and
+// accepts new connections.
+func (s *Server) DropConnections() {
+	s.mu.Lock()
+	conns := make([]*conn, 0, len(s.conns))
+	for c := range s.conns {
+		conns = append(conns, c)
+	}
+	s.mu.Unlock()
+	for _, c := range conns {
+		c.close()
+	}
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet ||
+		!headerContains(r.Header, "Connection", "upgrade") ||
+		!headerContains(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "WebSocket upgrade required", http.StatusUpgradeRequired)
+		return
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported WebSocket version", http.StatusBadRequest)
+		return
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		http.Error(w, "missing Sec-WebSocket-Key", http.StatusBadRequest)
+		return
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "cannot hijack connection", http.StatusInternalServerError)
+		return
+	}
+	netConn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return
+	}
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	rw.WriteString("Connection: Upgrade\r\n")
+	rw.WriteString("Upgrade: websocket\r\n")
+	rw.WriteString("Sec-WebSocket-Accept: " + acceptKey(key) + "\r\n")
+	if headerContains(r.Header, "Sec-WebSocket-Protocol", "driveline") {
+		rw.WriteString("Sec-WebSocket-Protocol: driveline\r\n")
+	}
+	deflate := acceptDeflate(r.Header)
+	if deflate != nil {
+		rw.WriteString("Sec-WebSocket-Extensions: " + deflate.response() + "\r\n")
+	}
+	rw.WriteString("\r\n")
+	if err := rw.Flush(); err != nil {
+		netConn.Close()
+		return
+	}
+	c := newConn(s, netConn, rw.Reader, deflate)
+	s.mu.Lock()
+	s.conns[c] = struct{}{}
+	s.mu.Unlock()
+	go c.run()
+}
+
+func (s *Server) forget(c *conn) {
+	s.mu.Lock()
+	delete(s.conns, c)
+	s.mu.Unlock()
+}
+
+func acceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + webSocketGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+func headerContains(h http.Header, name, token string) bool {
+	for _, value := range h[http.CanonicalHeaderKey(name)] {
+		for _, v := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(v), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// conn is a single client session. Commands are processed in order by run, while
+// outbound frames are queued and written by writeLoop so that a slow client
+// never blocks the server state.
+type conn struct {
+	server  *Server
+	netConn net.Conn
+	in      *bufio.Reader
+	aliases map[uint64]string
+	deflate *deflate // nil unless permessage-deflate was negotiated
+
+	mu         sync.Mutex
+	cond       *sync.Cond
+	queue      [][]byte
+	closed     bool
+	writerDone chan struct{}
+}
+
+func newConn(s *Server, netConn net.Conn, in *bufio.Reader, deflate *deflate) *conn {
+	c := &conn{
+		server:     s,
+		netConn:    netConn,
+		in:         in,
+		deflate:    deflate,
+		aliases:    make(map[uint64]string),
+		writerDone: make(chan struct{}),
+	}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+func (c *conn) run() {
+	go c.writeLoop()
+	defer func() {
+		c.server.state.unsubscribeAll(c)
+		c.server.forget(c)
+	}()
+	for {
+		opCode, message, compressed, err := readMessage(c.in)
+		if err == nil && compressed {
+			if c.deflate == nil {
+				err = errUnexpectedCompression
+			} else {
+				message, err = c.deflate.decompress(message)
+			}
+		}
+		if err != nil {
+			c.close()
+			return
+		}
+		switch opCode {
+		case binaryFrame, textFrame:
+			if err := c.handle(message); err != nil {
+				c.close()
+				return
+			}
+		case pingFrame:
+			c.sendFrame(pongFrame, message)
+		case closeFrame:
+			c.sendFrame(closeFrame, message)
+			c.shutdown()
+			<-c.writerDone
+			c.netConn.Close()
+			return
+		}
+	}
+}
+
+func (c *conn) send(message []byte) {
+	c.sendFrame(binaryFrame, message)
+}
+
+func (c *conn) sendFrame(opCode frameOpCode, frame []byte) {
+	c.mu.Lock()
+	if !c.closed {
+		var flags byte
+		// Frames are compressed in the order they are queued, which is the
+		// order of the compression context.
+		if opCode == binaryFrame && c.deflate != nil && len(frame) >= compressionThreshold {
+			frame = c.deflate.compress(frame)
+			flags = rsv1
+		}
+		c.queue = append(c.queue, appendFrame(nil, flags, opCode, frame))
+		c.cond.Signal()
+	}
+	c.mu.Unlock()
+}
+
+func (c *conn) writeLoop() {
+	defer close(c.writerDone)
+	var buf []byte
+	for {
+		c.mu.Lock()
+		for len(c.queue) == 0 && !c.closed {
+			c.cond.Wait()
+		}
+		queue := c.queue
+		c.queue = nil
+		closed := c.closed
+		c.mu.Unlock()
+		buf = buf[:0]
+		for _, frame := range queue {
+			buf = append(buf, frame...)
+		}
+		if len(buf) > 0 {
+			if _, err := c.netConn.Write(buf); err != nil {
+				c.close()
+				return
+			}
+		}
+		if closed {
+			return
+		}
+	}
+}
+
+// shutdown stops accepting new frames and lets writeLoop flush pending ones.
+func (c *conn) shutdown() {
+	c.mu.Lock()
+	c.closed = true
+	c.cond.Signal()
+	c.mu.Unlock()
+}
+
+func (c *conn) close() {
+	c.mu.Lock()
+	c.closed = true
+	c.queue = nil
+	c.cond.Signal()
+	c.mu.Unlock()
+	c.netConn.Close()
+}
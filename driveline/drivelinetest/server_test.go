@@ -0,0 +1,350 @@
+// Copyright 2019, 1533 Systems, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package drivelinetest_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/1533-systems/golang-sdk/driveline"
+	"github.com/1533-systems/golang-sdk/driveline/drivelinetest"
+)
+
+func testClient(t *testing.T) (*driveline.Client, *drivelinetest.Server) {
+	srv := drivelinetest.NewServer()
+	c, err := driveline.NewClient(context.Background(), srv.URL, driveline.MaxReconnect(3), driveline.ReconnectWait(10*time.Millisecond))
+	if err != nil {
+		srv.Close()
+		t.Fatalf("client cannot connect: %s", err)
+	}
+	return c, srv
+}
+
+// waitReconnect blocks until a sync round-trip succeeds, which can only
+// happen once the client is connected again.
+func waitReconnect(t *testing.T, c *driveline.Client) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		err := c.Sync(ctx)
+		cancel()
+		if err == nil {
+			return
+		}
+	}
+	t.Fatalf("client did not reconnect")
+}
+
+func TestStoreLoad(t *testing.T) {
+	c, srv := testClient(t)
+	defer srv.Close()
+	defer c.Close()
+
+	if err := c.Store("/test", []byte{1, 2, 4}); err != nil {
+		t.Fatalf("cannot store data: %s", err)
+	}
+	r, err := c.Load(context.Background(), "/test")
+	if err != nil {
+		t.Fatalf("cannot load data: %s", err)
+	}
+	if bytes.Compare([]byte{1, 2, 4}, r.Record) != 0 {
+		t.Fatalf("loaded different data than was previously stored")
+	}
+	if len(r.RecordID) != 8 {
+		t.Fatalf("expected a RecordID, got %v", r.RecordID)
+	}
+
+	if err := c.Remove("/test"); err != nil {
+		t.Fatalf("cannot remove key: %s", err)
+	}
+	if _, err := c.Load(context.Background(), "/test"); err == nil {
+		t.Fatalf("loaded a removed key")
+	}
+}
+
+func TestQuery(t *testing.T) {
+	c, srv := testClient(t)
+	defer srv.Close()
+	defer c.Close()
+
+	s, err := c.OpenStream("events")
+	if err != nil {
+		t.Fatalf("cannot open stream: %s", err)
+	}
+	for _, rec := range []string{"a", "b", "c"} {
+		if err := s.Append([]byte(rec)); err != nil {
+			t.Fatalf("cannot append: %s", err)
+		}
+	}
+	if err := c.Append("other", []byte("x")); err != nil {
+		t.Fatalf("cannot append: %s", err)
+	}
+
+	var actual []string
+	err = c.Query(context.Background(), "SELECT * FROM 'events'", func(r *driveline.Record) {
+		actual = append(actual, string(r.Record))
+	})
+	if err != nil {
+		t.Fatalf("query failed: %s", err)
+	}
+	if len(actual) != 3 || actual[0] != "a" || actual[2] != "c" {
+		t.Fatalf("unexpected records %v", actual)
+	}
+
+	if err := s.Truncate(); err != nil {
+		t.Fatalf("cannot truncate: %s", err)
+	}
+	actual = nil
+	err = c.Query(context.Background(), "SELECT * FROM 'events'", func(r *driveline.Record) {
+		actual = append(actual, string(r.Record))
+	})
+	if err != nil || len(actual) != 0 {
+		t.Fatalf("expected an empty stream, got %v (%v)", actual, err)
+	}
+}
+
+func TestContinuousQuery(t *testing.T) {
+	c, srv := testClient(t)
+	defer srv.Close()
+	defer c.Close()
+
+	if err := c.Append("events", []byte("old")); err != nil {
+		t.Fatalf("cannot append: %s", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	received := make(chan string, 10)
+	done := make(chan error, 1)
+	go func() {
+		done <- c.ContinuousQuery(ctx, "SELECT * FROM 'ev*'", func(r *driveline.Record) {
+			received <- string(r.Record)
+		})
+	}()
+	if r := <-received; r != "old" {
+		t.Fatalf("expected existing record, got %s", r)
+	}
+	if err := c.Append("events", []byte("new")); err != nil {
+		t.Fatalf("cannot append: %s", err)
+	}
+	if r := <-received; r != "new" {
+		t.Fatalf("expected new record, got %s", r)
+	}
+
+	srv.DropConnections()
+	waitReconnect(t, c)
+	if err := c.Append("events", []byte("after-reconnect")); err != nil {
+		t.Fatalf("cannot append: %s", err)
+	}
+	if r := <-received; r != "after-reconnect" {
+		t.Fatalf("expected the query to resume after the last record, got %s", r)
+	}
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Fatalf("unexpected query result: %v", err)
+	}
+}
+
+func TestListKeys(t *testing.T) {
+	c, srv := testClient(t)
+	defer srv.Close()
+	defer c.Close()
+
+	for _, key := range []string{"kv/a", "kv/b", "kv/sub/c", "other"} {
+		if err := c.Store(key, []byte(key)); err != nil {
+			t.Fatalf("cannot store: %s", err)
+		}
+	}
+	var keys []string
+	if err := c.ListKeys(context.Background(), "kv/*", func(k string) { keys = append(keys, k) }); err != nil {
+		t.Fatalf("cannot list keys: %s", err)
+	}
+	if len(keys) != 2 || keys[0] != "kv/a" || keys[1] != "kv/b" {
+		t.Fatalf("unexpected keys %v", keys)
+	}
+
+	if err := c.RemoveMatches("kv/**"); err != nil {
+		t.Fatalf("cannot remove keys: %s", err)
+	}
+	keys = nil
+	if err := c.ListKeys(context.Background(), "**", func(k string) { keys = append(keys, k) }); err != nil {
+		t.Fatalf("cannot list keys: %s", err)
+	}
+	if len(keys) != 1 || keys[0] != "other" {
+		t.Fatalf("unexpected keys %v", keys)
+	}
+
+	var streams []string
+	c.Append("s1", []byte{1})
+	if err := c.ListStreams(context.Background(), "*", func(s string) { streams = append(streams, s) }); err != nil {
+		t.Fatalf("cannot list streams: %s", err)
+	}
+	if len(streams) != 1 || streams[0] != "s1" {
+		t.Fatalf("unexpected streams %v", streams)
+	}
+}
+
+func TestStoreTTL(t *testing.T) {
+	c, srv := testClient(t)
+	defer srv.Close()
+	defer c.Close()
+
+	if err := c.StoreOptions("ttl", []byte{1}, new(driveline.StoreOptions).WithTTL(10*time.Millisecond)); err != nil {
+		t.Fatalf("cannot store: %s", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if _, err := c.Load(context.Background(), "ttl"); err == nil {
+		t.Fatalf("key should have expired")
+	}
+}
+
+func TestSync(t *testing.T) {
+	c, srv := testClient(t)
+	defer srv.Close()
+	defer c.Close()
+
+	if err := c.Sync(context.Background()); err != nil {
+		t.Fatalf("sync failed: %s", err)
+	}
+}
+
+func TestPing(t *testing.T) {
+	srv := drivelinetest.NewServer()
+	defer srv.Close()
+	c, err := driveline.NewClient(context.Background(), srv.URL, driveline.PingInterval(10*time.Millisecond))
+	if err != nil {
+		t.Fatalf("client cannot connect: %s", err)
+	}
+	defer c.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for c.RoundTripTime() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("no round-trip time measured")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCompression(t *testing.T) {
+	for name, noContextTakeover := range map[string]bool{
+		"with context takeover":    false,
+		"without context takeover": true,
+	} {
+		t.Run(name, func(t *testing.T) {
+			srv := drivelinetest.NewServer()
+			defer srv.Close()
+			var (
+				c   *driveline.Client
+				err error
+			)
+			if noContextTakeover {
+				c, err = driveline.NewClient(context.Background(), srv.URL,
+					driveline.Compression(), driveline.CompressionNoContextTakeover())
+			} else {
+				c, err = driveline.NewClient(context.Background(), srv.URL, driveline.Compression())
+			}
+			if err != nil {
+				t.Fatalf("client cannot connect: %s", err)
+			}
+			defer c.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			var expected [][]byte
+			for i := 0; i < 20; i++ {
+				record := bytes.Repeat([]byte{'a' + byte(i)}, 100*i)
+				expected = append(expected, record)
+				if err := c.AppendContext(ctx, "compressed", record); err != nil {
+					t.Fatal(err)
+				}
+			}
+			var actual [][]byte
+			err = c.Query(ctx, "SELECT * FROM 'compressed'", func(r *driveline.Record) {
+				actual = append(actual, r.Record)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(actual) != len(expected) {
+				t.Fatalf("expected %d records, got %d", len(expected), len(actual))
+			}
+			for i := range expected {
+				if !bytes.Equal(actual[i], expected[i]) {
+					t.Fatalf("unexpected record %d", i)
+				}
+			}
+		})
+	}
+}
+
+func TestAcknowledgedWrites(t *testing.T) {
+	c, srv := testClient(t)
+	defer srv.Close()
+	defer c.Close()
+	ctx := context.Background()
+
+	first, err := c.StoreContext(ctx, "cas", []byte("v1"), nil)
+	if err != nil || len(first) != 8 {
+		t.Fatalf("cannot store: %v %v", first, err)
+	}
+	second, err := c.StoreContext(ctx, "cas", []byte("v2"), new(driveline.StoreOptions).CompareAndSwap(first))
+	if err != nil {
+		t.Fatalf("CAS store failed: %s", err)
+	}
+	if _, err := c.StoreContext(ctx, "cas", []byte("v3"), new(driveline.StoreOptions).CompareAndSwap(first)); err != driveline.ErrStoreConflict {
+		t.Fatalf("CAS store with a stale RecordID should fail: %v", err)
+	}
+	if _, err := c.StoreContext(ctx, "missing", []byte("v1"), new(driveline.StoreOptions).CompareAndSwap(first)); err != driveline.ErrStoreConflict {
+		t.Fatalf("CAS store on a missing key should fail: %v", err)
+	}
+	if _, err := c.Load(ctx, "missing"); err != driveline.ErrKeyNotFound {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+	r, err := c.Load(ctx, "cas")
+	if err != nil || string(r.Record) != "v2" || bytes.Compare(r.RecordID, second) != 0 {
+		t.Fatalf("unexpected record %v (%v)", r, err)
+	}
+
+	if err := c.AppendContext(ctx, "events", []byte("a")); err != nil {
+		t.Fatalf("cannot append: %s", err)
+	}
+	s, _ := c.OpenStream("events")
+	if err := s.AppendContext(ctx, []byte("b")); err != nil {
+		t.Fatalf("cannot append: %s", err)
+	}
+	var records []string
+	if err := c.Query(ctx, "SELECT * FROM 'events'", func(r *driveline.Record) {
+		records = append(records, string(r.Record))
+	}); err != nil || len(records) != 2 || records[0] != "a" || records[1] != "b" {
+		t.Fatalf("unexpected records %v (%v)", records, err)
+	}
+
+	if err := c.RemoveContext(ctx, "cas"); err != nil {
+		t.Fatalf("cannot remove: %s", err)
+	}
+	if _, err := c.Load(ctx, "cas"); err == nil {
+		t.Fatalf("loaded a removed key")
+	}
+}
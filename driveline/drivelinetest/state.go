@@ -0,0 +1,261 @@
+// Copyright 2019, 1533 Systems, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package drivelinetest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+const maxBatchSize = 64
+
+var errCASMismatch = errors.New("compare and swap failed")
+
+type record struct {
+	id   uint64
+	data []byte
+}
+
+type entry struct {
+	record
+	timer *time.Timer
+}
+
+type subscription struct {
+	conn       *conn
+	consumerID uint64
+	pattern    string
+}
+
+// state holds the streams, the key-value store and the continuous queries
+// shared by every connection of a Server.
+type state struct {
+	mu      sync.Mutex
+	lastID  uint64
+	streams map[string][]record
+	kv      map[string]*entry
+	subs    map[*conn]map[uint64]*subscription
+}
+
+func newState() *state {
+	return &state{
+		streams: make(map[string][]record),
+		kv:      make(map[string]*entry),
+		subs:    make(map[*conn]map[uint64]*subscription),
+	}
+}
+
+func (s *state) close() {
+	s.mu.Lock()
+	for _, e := range s.kv {
+		if e.timer != nil {
+			e.timer.Stop()
+		}
+	}
+	s.mu.Unlock()
+}
+
+func (s *state) nextID() uint64 {
+	s.lastID++
+	return s.lastID
+}
+
+func (s *state) append(stream string, data []byte) {
+	s.mu.Lock()
+	rec := record{id: s.nextID(), data: data}
+	s.streams[stream] = append(s.streams[stream], rec)
+	s.publish(stream, rec)
+	s.mu.Unlock()
+}
+
+func (s *state) truncate(stream string) {
+	s.mu.Lock()
+	delete(s.streams, stream)
+	s.mu.Unlock()
+}
+
+// store sets key to data. A compare-and-swap store fails unless the key exists
+// with the cas RecordID: as with the server, it cannot create a key.
+func (s *state) store(key string, data []byte, cas []byte, ttl uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	current, exists := s.kv[key]
+	if cas != nil && (!exists || !bytes.Equal(cas, encodeRecordID(current.id))) {
+		return errCASMismatch
+	}
+	if exists && current.timer != nil {
+		current.timer.Stop()
+	}
+	e := &entry{record: record{id: s.nextID(), data: data}}
+	if ttl > 0 {
+		id := e.id
+		e.timer = time.AfterFunc(time.Duration(ttl)*time.Millisecond, func() {
+			s.expire(key, id)
+		})
+	}
+	s.kv[key] = e
+	s.publish(key, e.record)
+	return nil
+}
+
+func (s *state) expire(key string, id uint64) {
+	s.mu.Lock()
+	if e, exists := s.kv[key]; exists && e.id == id {
+		delete(s.kv, key)
+	}
+	s.mu.Unlock()
+}
+
+func (s *state) load(key string) (record, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, exists := s.kv[key]
+	if !exists {
+		return record{}, false
+	}
+	return e.record, true
+}
+
+func (s *state) remove(key string) {
+	s.mu.Lock()
+	s.removeLocked(key)
+	s.mu.Unlock()
+}
+
+func (s *state) removeMatches(pattern string) {
+	s.mu.Lock()
+	for key := range s.kv {
+		if MatchPattern(pattern, key) {
+			s.removeLocked(key)
+		}
+	}
+	s.mu.Unlock()
+}
+
+func (s *state) removeLocked(key string) {
+	if e, exists := s.kv[key]; exists {
+		if e.timer != nil {
+			e.timer.Stop()
+		}
+		delete(s.kv, key)
+	}
+}
+
+// list returns the sorted names of the streams or keys matching pattern.
+func (s *state) list(isStream bool, pattern string) []string {
+	s.mu.Lock()
+	var names []string
+	if isStream {
+		for name := range s.streams {
+			if MatchPattern(pattern, name) {
+				names = append(names, name)
+			}
+		}
+	} else {
+		for key := range s.kv {
+			if MatchPattern(pattern, key) {
+				names = append(names, key)
+			}
+		}
+	}
+	s.mu.Unlock()
+	sort.Strings(names)
+	return names
+}
+
+// query sends every record matching pattern stored after from. When
+// continuous is set, the consumer is also subscribed to new records; both
+// happen under the lock so that no record can slip in between.
+func (s *state) query(c *conn, consumerID uint64, pattern string, from uint64, continuous bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var records []record
+	for name, stream := range s.streams {
+		if !MatchPattern(pattern, name) {
+			continue
+		}
+		i := sort.Search(len(stream), func(i int) bool { return stream[i].id > from })
+		records = append(records, stream[i:]...)
+	}
+	for key, e := range s.kv {
+		if e.id > from && MatchPattern(pattern, key) {
+			records = append(records, e.record)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].id < records[j].id })
+	for len(records) > 0 {
+		n := len(records)
+		if n > maxBatchSize {
+			n = maxBatchSize
+		}
+		c.send(dataMessage(consumerID, records[:n]))
+		records = records[n:]
+	}
+	if !continuous {
+		c.send(endMessage(consumerID))
+		return
+	}
+	subs, exists := s.subs[c]
+	if !exists {
+		subs = make(map[uint64]*subscription)
+		s.subs[c] = subs
+	}
+	subs[consumerID] = &subscription{conn: c, consumerID: consumerID, pattern: pattern}
+}
+
+func (s *state) unsubscribe(c *conn, consumerID uint64) {
+	s.mu.Lock()
+	delete(s.subs[c], consumerID)
+	s.mu.Unlock()
+}
+
+func (s *state) unsubscribeAll(c *conn) {
+	s.mu.Lock()
+	delete(s.subs, c)
+	s.mu.Unlock()
+}
+
+func (s *state) publish(name string, rec record) {
+	for _, subs := range s.subs {
+		for _, sub := range subs {
+			if MatchPattern(sub.pattern, name) {
+				sub.conn.send(dataMessage(sub.consumerID, []record{rec}))
+			}
+		}
+	}
+}
+
+func encodeRecordID(id uint64) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], id)
+	return buf[:]
+}
+
+func decodeRecordID(buf []byte) uint64 {
+	if len(buf) != 8 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(buf)
+}
@@ -0,0 +1,55 @@
+// Copyright 2019, 1533 Systems, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package driveline
+
+import (
+	"context"
+
+	ws "github.com/1533-systems/golang-sdk/driveline/websocket"
+)
+
+// EndpointResolver returns the endpoints of the Driveline nodes. Resolve is
+// called before each connection attempt, so that nodes can be added or removed
+// while the client runs. c.f. Resolver.
+type EndpointResolver interface {
+	Resolve(ctx context.Context) ([]string, error)
+}
+
+// EndpointResolverFunc is a function used as an EndpointResolver.
+type EndpointResolverFunc func(ctx context.Context) ([]string, error)
+
+// Resolve calls f.
+func (f EndpointResolverFunc) Resolve(ctx context.Context) ([]string, error) {
+	return f(ctx)
+}
+
+// SRVEndpoints returns an EndpointResolver looking up the DNS SRV records of
+// _service._proto.name, for instance SRVEndpoints("wss", "driveline", "tcp",
+// "example.com"). Endpoints use the given URL scheme, ws or wss.
+func SRVEndpoints(scheme string, service string, proto string, name string) EndpointResolver {
+	return ws.SRVResolver(scheme, service, proto, name)
+}
+
+// FileEndpoints returns an EndpointResolver reading the endpoints from a file,
+// one per line. Blank lines and lines starting with # are ignored.
+func FileEndpoints(path string) EndpointResolver {
+	return ws.FileResolver(path)
+}
@@ -0,0 +1,347 @@
+// Copyright 2019, 1533 Systems, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package driveline
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/1533-systems/golang-sdk/driveline/dql"
+)
+
+const defaultGroupSessionTTL = 10 * time.Second
+
+// groupWildcards are the characters of stream patterns. Workers query their
+// stream by name, which would also match other streams if it held them.
+const groupWildcards = "*?"
+
+// ConsumeGroup joins the consumer group named group and handles the records of
+// the streams matching streamPattern that are assigned to this member, until
+// ctx is done.
+//
+// Every stream is assigned to a single live member of the group. Members keep
+// a session in the key-value store under group+"/members/"; one of them is
+// elected leader and publishes the assignment under group+"/assignment",
+// rebalancing the streams whenever members join or leave or new streams appear.
+// The position of each stream is shared by the group under
+// group+"/checkpoints/", so that a stream handed over to another member resumes
+// where it was left. Records are handled at least once: a few records may be
+// handled again when a stream moves, and twice if a member loses its session
+// but not its connection, until it notices the new assignment.
+//
+// Streams whose names contain the wildcard characters * or ? cannot be queried
+// on their own: they are left out of the assignment and reported to the error
+// handler of the client.
+//
+// handler is called concurrently for different streams, and sequentially for
+// the records of a given stream.
+func (c *Client) ConsumeGroup(ctx context.Context, group string, streamPattern string, options *GroupOptions, handler func(stream string, record *Record)) error {
+	m, err := newGroupMember(c, group, streamPattern, options, handler)
+	if err != nil {
+		return err
+	}
+	return m.run(ctx)
+}
+
+type groupWorker struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// groupMember is the state of a consumer group member. It is only accessed by
+// the goroutine running the member.
+type groupMember struct {
+	client             *Client
+	group              string
+	pattern            string
+	memberID           string
+	sessionTTL         time.Duration
+	checkpointInterval time.Duration
+	handler            func(string, *Record)
+
+	leaderVersion     RecordID // RecordID of the leader key, while leader
+	assignment        []byte
+	assignmentVersion RecordID
+	workers           map[string]*groupWorker
+	skipped           map[string]bool // streams reported for their wildcards
+}
+
+func newGroupMember(client *Client, group string, pattern string, options *GroupOptions, handler func(string, *Record)) (*groupMember, error) {
+	if options == nil {
+		options = new(GroupOptions)
+	}
+	m := &groupMember{
+		client:             client,
+		group:              group,
+		pattern:            pattern,
+		memberID:           options.memberID,
+		sessionTTL:         options.sessionTTL,
+		checkpointInterval: options.checkpointInterval,
+		handler:            handler,
+		workers:            make(map[string]*groupWorker),
+		skipped:            make(map[string]bool),
+	}
+	if m.sessionTTL <= 0 {
+		m.sessionTTL = defaultGroupSessionTTL
+	}
+	if m.memberID == "" {
+		var id [8]byte
+		if _, err := rand.Read(id[:]); err != nil {
+			return nil, err
+		}
+		m.memberID = hex.EncodeToString(id[:])
+	}
+	if strings.Contains(m.memberID, "/") {
+		return nil, fmt.Errorf("invalid member ID %s", m.memberID)
+	}
+	return m, nil
+}
+
+func (m *groupMember) run(ctx context.Context) error {
+	defer m.leave()
+	ticker := time.NewTicker(m.sessionTTL / 3)
+	defer ticker.Stop()
+	for {
+		if err := m.heartbeat(ctx); err != nil && ctx.Err() == nil {
+			m.client.errorHandler(fmt.Errorf("consumer group %s: %s", m.group, err))
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// heartbeat renews the session of the member, takes over or keeps the
+// leadership, and applies the current assignment.
+func (m *groupMember) heartbeat(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, m.sessionTTL/3)
+	defer cancel()
+	_, err := m.client.StoreContext(ctx, m.memberKey(), []byte(m.memberID), new(StoreOptions).WithTTL(m.sessionTTL))
+	if err != nil {
+		return err
+	}
+	if err := m.elect(ctx); err != nil {
+		return err
+	}
+	if err := m.follow(ctx); err != nil {
+		return err
+	}
+	if m.leaderVersion != nil {
+		return m.rebalance(ctx)
+	}
+	return nil
+}
+
+// elect renews the leader key while this member holds it, and claims it like
+// a Lease when it does not exist: the claim is confirmed with compare-and-swap,
+// so that of two members claiming the key at once, only one leads. The
+// assignment is written with compare-and-swap as well, so that a former leader
+// that has not noticed yet cannot overwrite the assignment of the new one.
+func (m *groupMember) elect(ctx context.Context) error {
+	key := m.group + "/leader"
+	var version RecordID
+	var err error
+	if m.leaderVersion != nil {
+		options := new(StoreOptions).CompareAndSwap(m.leaderVersion).WithTTL(m.sessionTTL)
+		version, err = m.client.StoreContext(ctx, key, []byte(m.memberID), options)
+	} else {
+		version, err = m.client.claimLease(ctx, key, []byte(m.memberID), m.sessionTTL)
+	}
+	if err != nil {
+		m.leaderVersion = nil
+		if err != ErrStoreConflict {
+			return err
+		}
+		// Another member leads the group.
+		return nil
+	}
+	m.leaderVersion = version
+	return nil
+}
+
+// follow loads the assignment and starts or stops the workers accordingly.
+func (m *groupMember) follow(ctx context.Context) error {
+	r, err := m.client.Load(ctx, m.group+"/assignment")
+	if err == ErrKeyNotFound {
+		m.assignmentVersion = nil
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	m.assignmentVersion = r.RecordID
+	if bytes.Equal(r.Record, m.assignment) {
+		return nil
+	}
+	var assignment map[string][]string
+	if err := json.Unmarshal(r.Record, &assignment); err != nil {
+		return fmt.Errorf("invalid assignment: %s", err)
+	}
+	m.assignment = r.Record
+	m.assign(assignment[m.memberID])
+	return nil
+}
+
+// rebalance publishes a new assignment when the members or the streams changed.
+func (m *groupMember) rebalance(ctx context.Context) error {
+	prefix := m.group + "/members/"
+	var members, streams []string
+	err := m.client.ListKeys(ctx, prefix+"*", func(key string) {
+		members = append(members, strings.TrimPrefix(key, prefix))
+	})
+	if err != nil {
+		return err
+	}
+	err = m.client.ListStreams(ctx, m.pattern, func(stream string) {
+		if !strings.ContainsAny(stream, groupWildcards) {
+			streams = append(streams, stream)
+		} else if !m.skipped[stream] {
+			m.skipped[stream] = true
+			m.client.errorHandler(fmt.Errorf("consumer group %s: stream %q is not assigned, its name contains wildcards", m.group, stream))
+		}
+	})
+	if err != nil {
+		return err
+	}
+	assignment := assignStreams(members, streams)
+	buf, err := json.Marshal(assignment)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(buf, m.assignment) {
+		return nil
+	}
+	var options *StoreOptions
+	if m.assignmentVersion != nil {
+		options = new(StoreOptions).CompareAndSwap(m.assignmentVersion)
+	}
+	version, err := m.client.StoreContext(ctx, m.group+"/assignment", buf, options)
+	if err != nil {
+		return err
+	}
+	m.assignment = buf
+	m.assignmentVersion = version
+	m.assign(assignment[m.memberID])
+	return nil
+}
+
+// assign stops the workers of the streams that are no longer assigned to the
+// member, then starts the workers of the newly assigned ones.
+func (m *groupMember) assign(streams []string) {
+	assigned := make(map[string]bool, len(streams))
+	for _, stream := range streams {
+		assigned[stream] = true
+	}
+	for stream, w := range m.workers {
+		if !assigned[stream] {
+			w.cancel()
+			<-w.done
+			delete(m.workers, stream)
+		}
+	}
+	for _, stream := range streams {
+		if _, exists := m.workers[stream]; !exists {
+			ctx, cancel := context.WithCancel(context.Background())
+			w := &groupWorker{cancel: cancel, done: make(chan struct{})}
+			m.workers[stream] = w
+			go m.work(ctx, stream, w.done)
+		}
+	}
+}
+
+// work runs a checkpointed query on stream, and runs it again after failures
+// such as checkpoint conflicts, until ctx is done.
+func (m *groupMember) work(ctx context.Context, stream string, done chan struct{}) {
+	defer close(done)
+	query := dql.Select().From(stream).String()
+	handler := func(r *Record) {
+		m.handler(stream, r)
+	}
+	for {
+		checkpoints := NewKVCheckpointStore(m.client, m.group+"/checkpoints/")
+		options := new(QueryOptions).FromStreamHead().
+			WithCheckpoint(checkpoints, stream).
+			CheckpointInterval(m.checkpointInterval)
+		err := m.client.ContinuousQueryOptions(ctx, query, options, handler)
+		if ctx.Err() != nil {
+			return
+		}
+		m.client.errorHandler(fmt.Errorf("consumer group %s: stream %s: %s", m.group, stream, err))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(m.sessionTTL / 3):
+		}
+	}
+}
+
+// leave stops every worker and ends the session, so that the streams of the
+// member are reassigned right away. Failures are reported to the error handler
+// of the client.
+func (m *groupMember) leave() {
+	m.assign(nil)
+	ctx, cancel := context.WithTimeout(context.Background(), m.sessionTTL/3)
+	defer cancel()
+	if err := m.client.RemoveContext(ctx, m.memberKey()); err != nil {
+		m.client.errorHandler(fmt.Errorf("consumer group %s: %s", m.group, err))
+	}
+	if m.leaderVersion != nil {
+		// Let the leader key expire right away, unless another member took
+		// over in the meantime, in which case the store is not applied.
+		_, err := m.client.storeContext(ctx, m.group+"/leader", []byte(m.memberID),
+			new(StoreOptions).CompareAndSwap(m.leaderVersion).WithTTL(time.Millisecond))
+		if err != nil {
+			m.client.errorHandler(fmt.Errorf("consumer group %s: %s", m.group, err))
+		}
+		m.leaderVersion = nil
+	}
+}
+
+func (m *groupMember) memberKey() string {
+	return m.group + "/members/" + m.memberID
+}
+
+// assignStreams assigns the sorted streams to the sorted members, round-robin.
+func assignStreams(members []string, streams []string) map[string][]string {
+	sort.Strings(members)
+	sort.Strings(streams)
+	assignment := make(map[string][]string, len(members))
+	for _, member := range members {
+		assignment[member] = []string{}
+	}
+	if len(members) == 0 {
+		return assignment
+	}
+	for i, stream := range streams {
+		member := members[i%len(members)]
+		assignment[member] = append(assignment[member], stream)
+	}
+	return assignment
+}
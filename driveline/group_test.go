@@ -0,0 +1,228 @@
+// Copyright 2019, 1533 Systems, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package driveline
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAssignStreams(t *testing.T) {
+	t.Run("assigns streams round-robin", func(t *testing.T) {
+		assignment := assignStreams([]string{"m2", "m1"}, []string{"s3", "s1", "s2"})
+		expected := map[string][]string{
+			"m1": {"s1", "s3"},
+			"m2": {"s2"},
+		}
+		if !reflect.DeepEqual(assignment, expected) {
+			t.Fatalf("unexpected assignment %v", assignment)
+		}
+	})
+
+	t.Run("lists idle members", func(t *testing.T) {
+		assignment := assignStreams([]string{"m1", "m2"}, []string{"s1"})
+		if len(assignment["m2"]) != 0 || assignment["m2"] == nil {
+			t.Fail()
+		}
+	})
+
+	t.Run("handles an empty group", func(t *testing.T) {
+		assignment := assignStreams(nil, []string{"s1"})
+		if len(assignment) != 0 {
+			t.Fail()
+		}
+	})
+}
+
+func TestGroupOptions(t *testing.T) {
+	t.Run("rejects invalid member IDs", func(t *testing.T) {
+		client, _ := testClient()
+		if _, err := newGroupMember(client, "group", "*", new(GroupOptions).MemberID("a/b"), nil); err == nil {
+			t.Fail()
+		}
+	})
+
+	t.Run("generates member IDs", func(t *testing.T) {
+		client, _ := testClient()
+		m1, _ := newGroupMember(client, "group", "*", nil, nil)
+		m2, _ := newGroupMember(client, "group", "*", nil, nil)
+		if m1.memberID == "" || m1.memberID == m2.memberID {
+			t.Fail()
+		}
+		if m1.sessionTTL != defaultGroupSessionTTL {
+			t.Fail()
+		}
+	})
+}
+
+func testGroupMember(t *testing.T, client *Client, memberID string) *groupMember {
+	m, err := newGroupMember(client, "group", "s*", new(GroupOptions).MemberID(memberID).SessionTTL(time.Minute), func(string, *Record) {})
+	if err != nil {
+		t.Fatalf("cannot create member: %s", err)
+	}
+	return m
+}
+
+func testAssignment(t *testing.T, kv *testKV) map[string][]string {
+	r, exists := kv.get("group/assignment")
+	if !exists {
+		t.Fatalf("no assignment")
+	}
+	var assignment map[string][]string
+	if err := json.Unmarshal(r.Record, &assignment); err != nil {
+		t.Fatalf("invalid assignment: %s", err)
+	}
+	return assignment
+}
+
+func TestGroupMember(t *testing.T) {
+	t.Run("keeps a session", func(t *testing.T) {
+		client, fws := testClient()
+		kv := newTestKV(fws)
+		m := testGroupMember(t, client, "m1")
+		if err := m.heartbeat(context.Background()); err != nil {
+			t.Fatalf("heartbeat failed: %s", err)
+		}
+		r, exists := kv.get("group/members/m1")
+		if !exists || string(r.Record) != "m1" {
+			t.Fatalf("expected a session")
+		}
+		kv.advance(time.Minute)
+		if _, exists := kv.get("group/members/m1"); exists {
+			t.Fatalf("expected the session to expire")
+		}
+		m.heartbeat(context.Background())
+		if _, exists := kv.get("group/members/m1"); !exists {
+			t.Fatalf("expected the session to be renewed")
+		}
+	})
+
+	t.Run("elects a single leader", func(t *testing.T) {
+		client, fws := testClient()
+		kv := newTestKV(fws)
+		m1 := testGroupMember(t, client, "m1")
+		m2 := testGroupMember(t, client, "m2")
+		m1.heartbeat(context.Background())
+		m2.heartbeat(context.Background())
+		if m1.leaderVersion == nil || m2.leaderVersion != nil {
+			t.Fatalf("expected m1 to lead")
+		}
+		m1.heartbeat(context.Background())
+		if m1.leaderVersion == nil {
+			t.Fatalf("expected m1 to keep the leadership")
+		}
+
+		// m1 stops renewing its session, and m2 takes over.
+		kv.advance(time.Minute)
+		m2.heartbeat(context.Background())
+		if r, _ := kv.get("group/leader"); m2.leaderVersion == nil || string(r.Record) != "m2" {
+			t.Fatalf("expected m2 to take over")
+		}
+		m1.heartbeat(context.Background())
+		if m1.leaderVersion != nil {
+			t.Fatalf("expected m1 to step down")
+		}
+	})
+
+	t.Run("rebalances streams when a member leaves", func(t *testing.T) {
+		client, fws := testClient()
+		var errs []error
+		client.errorHandler = func(err error) { errs = append(errs, err) }
+		kv := newTestKV(fws)
+		kv.streams = []string{"s1", "s2"}
+		m1 := testGroupMember(t, client, "m1")
+		m2 := testGroupMember(t, client, "m2")
+		defer m1.leave()
+
+		m1.heartbeat(context.Background())
+		m2.heartbeat(context.Background())
+		m1.heartbeat(context.Background())
+		m2.heartbeat(context.Background())
+		expected := map[string][]string{"m1": {"s1"}, "m2": {"s2"}}
+		if assignment := testAssignment(t, kv); !reflect.DeepEqual(assignment, expected) {
+			t.Fatalf("unexpected assignment %v", assignment)
+		}
+		if len(m1.workers) != 1 || m1.workers["s1"] == nil || len(m2.workers) != 1 || m2.workers["s2"] == nil {
+			t.Fatalf("unexpected workers")
+		}
+
+		m2.leave()
+		if len(m2.workers) != 0 {
+			t.Fatalf("expected the workers of m2 to stop")
+		}
+		if _, exists := kv.get("group/members/m2"); exists {
+			t.Fatalf("expected the session of m2 to end")
+		}
+		m1.heartbeat(context.Background())
+		expected = map[string][]string{"m1": {"s1", "s2"}}
+		if assignment := testAssignment(t, kv); !reflect.DeepEqual(assignment, expected) {
+			t.Fatalf("unexpected assignment %v", assignment)
+		}
+		if len(m1.workers) != 2 {
+			t.Fatalf("expected m1 to handle every stream")
+		}
+		if len(errs) != 0 {
+			t.Fatalf("unexpected error %v", errs[0])
+		}
+	})
+
+	t.Run("leaves out streams named with wildcards", func(t *testing.T) {
+		client, fws := testClient()
+		var errs []error
+		client.errorHandler = func(err error) { errs = append(errs, err) }
+		kv := newTestKV(fws)
+		kv.streams = []string{"s1", "s*"}
+		m := testGroupMember(t, client, "m1")
+		defer m.leave()
+
+		m.heartbeat(context.Background())
+		m.heartbeat(context.Background())
+		expected := map[string][]string{"m1": {"s1"}}
+		if assignment := testAssignment(t, kv); !reflect.DeepEqual(assignment, expected) {
+			t.Fatalf("unexpected assignment %v", assignment)
+		}
+		if len(m.workers) != 1 || m.workers["s1"] == nil {
+			t.Fatalf("unexpected workers")
+		}
+		if len(errs) != 1 {
+			t.Fatalf("expected the stream to be reported once, got %v", errs)
+		}
+	})
+
+	t.Run("reports a failure to leave", func(t *testing.T) {
+		client, fws := testClient()
+		newTestKV(fws)
+		m := testGroupMember(t, client, "m1")
+		m.heartbeat(context.Background())
+		var errs []error
+		client.errorHandler = func(err error) { errs = append(errs, err) }
+		fws.WriteHandler = func(buf []byte) (int, error) {
+			return 0, ErrClosed
+		}
+		m.leave()
+		if len(errs) != 2 {
+			t.Fatalf("expected the session and the leadership failures, got %v", errs)
+		}
+	})
+}
@@ -0,0 +1,109 @@
+// Copyright 2019, 1533 Systems, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package driveline
+
+import (
+	"sync"
+)
+
+// Journal keeps outbound commands until the server has processed them, so that
+// they can be sent again after a reconnection or, for durable implementations,
+// after a restart of the process. c.f. OutboundJournal.
+type Journal interface {
+	// Append stores a command and returns its sequence number. Sequence numbers
+	// must be strictly increasing. The journal must not retain command.
+	Append(command []byte) (uint64, error)
+	// Ack discards every command up to and including seq.
+	Ack(seq uint64) error
+	// Replay calls fn, in order, for every command that has not been acknowledged.
+	Replay(fn func(seq uint64, command []byte) error) error
+	// Close releases the resources held by the journal.
+	Close() error
+}
+
+type journalEntry struct {
+	seq     uint64
+	command []byte
+}
+
+// MemoryJournal is a Journal that keeps commands in memory. It survives
+// reconnections but not a restart of the process.
+type MemoryJournal struct {
+	mu      sync.Mutex
+	lastSeq uint64
+	entries []journalEntry
+}
+
+var _ Journal = (*MemoryJournal)(nil)
+
+// NewMemoryJournal creates an empty MemoryJournal.
+func NewMemoryJournal() *MemoryJournal {
+	return &MemoryJournal{}
+}
+
+// Append implements Journal.
+func (j *MemoryJournal) Append(command []byte) (uint64, error) {
+	j.mu.Lock()
+	j.lastSeq++
+	seq := j.lastSeq
+	j.entries = append(j.entries, journalEntry{seq: seq, command: append([]byte(nil), command...)})
+	j.mu.Unlock()
+	return seq, nil
+}
+
+// Ack implements Journal.
+func (j *MemoryJournal) Ack(seq uint64) error {
+	j.mu.Lock()
+	i := 0
+	for i < len(j.entries) && j.entries[i].seq <= seq {
+		j.entries[i].command = nil
+		i++
+	}
+	j.entries = j.entries[i:]
+	j.mu.Unlock()
+	return nil
+}
+
+// Replay implements Journal.
+func (j *MemoryJournal) Replay(fn func(seq uint64, command []byte) error) error {
+	j.mu.Lock()
+	entries := make([]journalEntry, len(j.entries))
+	copy(entries, j.entries)
+	j.mu.Unlock()
+	for _, e := range entries {
+		if err := fn(e.seq, e.command); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Len returns the number of commands that have not been acknowledged.
+func (j *MemoryJournal) Len() int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return len(j.entries)
+}
+
+// Close implements Journal.
+func (j *MemoryJournal) Close() error {
+	return nil
+}
@@ -0,0 +1,306 @@
+// Copyright 2019, 1533 Systems, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package driveline
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"sort"
+	"sync"
+)
+
+const (
+	fileJournalCommand = byte('C')
+	fileJournalAck     = byte('A')
+
+	// kind, sequence number, payload length, checksum
+	fileJournalHeaderSize = 1 + 8 + 4 + 4
+
+	fileJournalCompactSize = 4 * 1024 * 1024
+)
+
+// ErrJournalClosed indicates that the journal has been closed.
+var ErrJournalClosed = errors.New("journal closed")
+
+type fileJournalEntry struct {
+	seq    uint64
+	offset int64
+	size   int
+}
+
+// FileJournal is a Journal backed by a write-ahead log on the local file system.
+// Commands that have not been acknowledged by the server are replayed after a
+// restart of the process.
+//
+// Commands are handed to the operating system as soon as they are appended, so
+// they survive a crash of the process. The log is synced to disk whenever
+// commands are acknowledged and when the journal is closed.
+type FileJournal struct {
+	mu           sync.Mutex
+	path         string
+	f            *os.File
+	size         int64
+	lastSeq      uint64
+	pending      []fileJournalEntry
+	pendingBytes int64
+}
+
+var _ Journal = (*FileJournal)(nil)
+
+// OpenFileJournal opens the write-ahead log at path, creating it if needed.
+// A truncated or corrupted tail, left by a crash, is discarded.
+func OpenFileJournal(path string) (*FileJournal, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	j := &FileJournal{path: path, f: f}
+	if err := j.load(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return j, nil
+}
+
+func (j *FileJournal) load() error {
+	in := bufio.NewReader(j.f)
+	var (
+		hdr    [fileJournalHeaderSize]byte
+		offset int64
+	)
+	for {
+		if _, err := io.ReadFull(in, hdr[:]); err != nil {
+			break
+		}
+		kind := hdr[0]
+		seq := binary.BigEndian.Uint64(hdr[1:])
+		size := binary.BigEndian.Uint32(hdr[9:])
+		if (kind != fileJournalCommand && kind != fileJournalAck) || size > maxJournalCommandSize {
+			break
+		}
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(in, payload); err != nil {
+			break
+		}
+		if checksum(hdr[:13], payload) != binary.BigEndian.Uint32(hdr[13:]) {
+			break
+		}
+		switch kind {
+		case fileJournalCommand:
+			j.pending = append(j.pending, fileJournalEntry{
+				seq:    seq,
+				offset: offset + fileJournalHeaderSize,
+				size:   int(size),
+			})
+			j.pendingBytes += int64(size)
+		case fileJournalAck:
+			j.discard(seq)
+		}
+		if seq > j.lastSeq {
+			j.lastSeq = seq
+		}
+		offset += fileJournalHeaderSize + int64(size)
+	}
+	j.size = offset
+	return j.f.Truncate(offset)
+}
+
+// maxJournalCommandSize guards against allocating absurd buffers when a
+// corrupted length is read back.
+const maxJournalCommandSize = 1 << 30
+
+// Append implements Journal.
+func (j *FileJournal) Append(command []byte) (uint64, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.f == nil {
+		return 0, ErrJournalClosed
+	}
+	seq := j.lastSeq + 1
+	if err := j.write(fileJournalCommand, seq, command); err != nil {
+		return 0, err
+	}
+	j.lastSeq = seq
+	j.pending = append(j.pending, fileJournalEntry{
+		seq:    seq,
+		offset: j.size - int64(len(command)),
+		size:   len(command),
+	})
+	j.pendingBytes += int64(len(command))
+	return seq, nil
+}
+
+// Ack implements Journal.
+func (j *FileJournal) Ack(seq uint64) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.f == nil {
+		return ErrJournalClosed
+	}
+	if len(j.pending) == 0 || j.pending[0].seq > seq {
+		return nil
+	}
+	j.discard(seq)
+	if len(j.pending) == 0 {
+		// Nothing left to replay: start over with an empty log.
+		j.size = 0
+		if err := j.f.Truncate(0); err != nil {
+			return err
+		}
+		return j.f.Sync()
+	}
+	if err := j.write(fileJournalAck, seq, nil); err != nil {
+		return err
+	}
+	if j.size > fileJournalCompactSize && j.pendingBytes < j.size/2 {
+		return j.compact()
+	}
+	return j.f.Sync()
+}
+
+// Replay implements Journal. Commands are read one at a time under the lock,
+// so fn may run concurrently with Ack, which truncates or compacts the log.
+func (j *FileJournal) Replay(fn func(seq uint64, command []byte) error) error {
+	var seq uint64
+	for {
+		next, command, err := j.next(seq)
+		if err != nil || command == nil {
+			return err
+		}
+		if err := fn(next, command); err != nil {
+			return err
+		}
+		seq = next
+	}
+}
+
+// next reads the first pending command following seq, if any.
+func (j *FileJournal) next(seq uint64) (uint64, []byte, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.f == nil {
+		return 0, nil, ErrJournalClosed
+	}
+	i := sort.Search(len(j.pending), func(i int) bool { return j.pending[i].seq > seq })
+	if i == len(j.pending) {
+		return 0, nil, nil
+	}
+	e := j.pending[i]
+	command := make([]byte, e.size)
+	if _, err := j.f.ReadAt(command, e.offset); err != nil {
+		return 0, nil, err
+	}
+	return e.seq, command, nil
+}
+
+// Len returns the number of commands that have not been acknowledged.
+func (j *FileJournal) Len() int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return len(j.pending)
+}
+
+// Close syncs and closes the log file.
+func (j *FileJournal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.f == nil {
+		return nil
+	}
+	err := j.f.Sync()
+	if cerr := j.f.Close(); err == nil {
+		err = cerr
+	}
+	j.f = nil
+	return err
+}
+
+func (j *FileJournal) discard(seq uint64) {
+	i := 0
+	for i < len(j.pending) && j.pending[i].seq <= seq {
+		j.pendingBytes -= int64(j.pending[i].size)
+		i++
+	}
+	j.pending = j.pending[i:]
+}
+
+func (j *FileJournal) write(kind byte, seq uint64, payload []byte) error {
+	buf := make([]byte, fileJournalHeaderSize+len(payload))
+	buf[0] = kind
+	binary.BigEndian.PutUint64(buf[1:], seq)
+	binary.BigEndian.PutUint32(buf[9:], uint32(len(payload)))
+	copy(buf[fileJournalHeaderSize:], payload)
+	binary.BigEndian.PutUint32(buf[13:], checksum(buf[:13], payload))
+	if _, err := j.f.WriteAt(buf, j.size); err != nil {
+		return err
+	}
+	j.size += int64(len(buf))
+	return nil
+}
+
+// compact rewrites the pending commands into a new log, which then atomically
+// replaces the current one.
+func (j *FileJournal) compact() error {
+	tmp, err := os.OpenFile(j.path+".tmp", os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	compacted := &FileJournal{path: j.path, f: tmp, lastSeq: j.lastSeq}
+	for _, e := range j.pending {
+		command := make([]byte, e.size)
+		if _, err = j.f.ReadAt(command, e.offset); err != nil {
+			break
+		}
+		if err = compacted.write(fileJournalCommand, e.seq, command); err != nil {
+			break
+		}
+		compacted.pending = append(compacted.pending, fileJournalEntry{
+			seq:    e.seq,
+			offset: compacted.size - int64(e.size),
+			size:   e.size,
+		})
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err == nil {
+		err = os.Rename(j.path+".tmp", j.path)
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(j.path + ".tmp")
+		return err
+	}
+	j.f.Close()
+	j.f = tmp
+	j.size = compacted.size
+	j.pending = compacted.pending
+	return nil
+}
+
+func checksum(header []byte, payload []byte) uint32 {
+	crc := crc32.ChecksumIEEE(header)
+	return crc32.Update(crc, crc32.IEEETable, payload)
+}
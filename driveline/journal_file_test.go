@@ -0,0 +1,179 @@
+// Copyright 2019, 1533 Systems, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package driveline
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func tempJournalPath(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "driveline-journal")
+	if err != nil {
+		t.Fatalf("cannot create temporary directory: %s", err)
+	}
+	return filepath.Join(dir, "journal.wal"), func() { os.RemoveAll(dir) }
+}
+
+func TestFileJournal(t *testing.T) {
+	t.Run("replays unacknowledged commands", func(t *testing.T) {
+		path, cleanup := tempJournalPath(t)
+		defer cleanup()
+		j, err := OpenFileJournal(path)
+		if err != nil {
+			t.Fatalf("cannot open journal: %s", err)
+		}
+		defer j.Close()
+		testJournal(t, j)
+	})
+
+	t.Run("survives a restart", func(t *testing.T) {
+		path, cleanup := tempJournalPath(t)
+		defer cleanup()
+		j, _ := OpenFileJournal(path)
+		j.Append([]byte("a"))
+		j.Append([]byte("b"))
+		j.Append([]byte("c"))
+		j.Ack(1)
+		j.Close()
+
+		j, err := OpenFileJournal(path)
+		if err != nil {
+			t.Fatalf("cannot reopen journal: %s", err)
+		}
+		defer j.Close()
+		var replayed []string
+		j.Replay(func(seq uint64, command []byte) error {
+			replayed = append(replayed, string(command))
+			return nil
+		})
+		if len(replayed) != 2 || replayed[0] != "b" || replayed[1] != "c" {
+			t.Fatalf("unexpected commands %v", replayed)
+		}
+		if seq, _ := j.Append([]byte("d")); seq != 4 {
+			t.Fatalf("unexpected sequence number %d", seq)
+		}
+	})
+
+	t.Run("discards a torn tail", func(t *testing.T) {
+		path, cleanup := tempJournalPath(t)
+		defer cleanup()
+		j, _ := OpenFileJournal(path)
+		j.Append([]byte("complete"))
+		j.Append([]byte("torn"))
+		j.Close()
+		info, _ := os.Stat(path)
+		os.Truncate(path, info.Size()-2)
+
+		j, err := OpenFileJournal(path)
+		if err != nil {
+			t.Fatalf("cannot reopen journal: %s", err)
+		}
+		defer j.Close()
+		if j.Len() != 1 {
+			t.Fatalf("expected a single command, got %d", j.Len())
+		}
+		j.Append([]byte("next"))
+		var replayed []string
+		j.Replay(func(seq uint64, command []byte) error {
+			replayed = append(replayed, string(command))
+			return nil
+		})
+		if len(replayed) != 2 || replayed[0] != "complete" || replayed[1] != "next" {
+			t.Fatalf("unexpected commands %v", replayed)
+		}
+	})
+
+	t.Run("empties the log once everything is acknowledged", func(t *testing.T) {
+		path, cleanup := tempJournalPath(t)
+		defer cleanup()
+		j, _ := OpenFileJournal(path)
+		defer j.Close()
+		j.Append([]byte("a"))
+		j.Append([]byte("b"))
+		j.Ack(2)
+		if info, _ := os.Stat(path); info.Size() != 0 {
+			t.Fatalf("expected an empty log, got %d bytes", info.Size())
+		}
+	})
+
+	t.Run("compacts the log", func(t *testing.T) {
+		path, cleanup := tempJournalPath(t)
+		defer cleanup()
+		j, _ := OpenFileJournal(path)
+		defer j.Close()
+		command := make([]byte, 64*1024)
+		var seq uint64
+		for i := 0; i < 80; i++ {
+			seq, _ = j.Append(command)
+		}
+		if err := j.Ack(seq - 1); err != nil {
+			t.Fatalf("cannot ack: %s", err)
+		}
+		if info, _ := os.Stat(path); info.Size() > 2*int64(len(command)) {
+			t.Fatalf("log was not compacted: %d bytes", info.Size())
+		}
+		var replayed []uint64
+		j.Replay(func(seq uint64, command []byte) error {
+			replayed = append(replayed, seq)
+			return nil
+		})
+		if len(replayed) != 1 || replayed[0] != seq {
+			t.Fatalf("unexpected commands %v", replayed)
+		}
+	})
+
+	t.Run("replays while commands are acknowledged", func(t *testing.T) {
+		path, cleanup := tempJournalPath(t)
+		defer cleanup()
+		j, _ := OpenFileJournal(path)
+		defer j.Close()
+		command := make([]byte, fileJournalCompactSize/3)
+		for i := 0; i < 4; i++ {
+			j.Append(command)
+		}
+		// Acknowledging the second command compacts the log, and the last one
+		// empties it, while the remaining commands are being replayed.
+		var replayed []uint64
+		err := j.Replay(func(seq uint64, command []byte) error {
+			replayed = append(replayed, seq)
+			return j.Ack(seq)
+		})
+		if err != nil {
+			t.Fatalf("cannot replay: %s", err)
+		}
+		if len(replayed) != 4 || replayed[3] != 4 {
+			t.Fatalf("unexpected commands %v", replayed)
+		}
+	})
+
+	t.Run("fails once closed", func(t *testing.T) {
+		path, cleanup := tempJournalPath(t)
+		defer cleanup()
+		j, _ := OpenFileJournal(path)
+		j.Close()
+		if _, err := j.Append([]byte("a")); err != ErrJournalClosed {
+			t.Fail()
+		}
+	})
+}
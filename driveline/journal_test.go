@@ -0,0 +1,81 @@
+// Copyright 2019, 1533 Systems, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package driveline
+
+import (
+	"bytes"
+	"testing"
+)
+
+func testJournal(t *testing.T, j Journal) {
+	for _, command := range []string{"a", "b", "c"} {
+		if _, err := j.Append([]byte(command)); err != nil {
+			t.Fatalf("cannot append: %s", err)
+		}
+	}
+	if err := j.Ack(2); err != nil {
+		t.Fatalf("cannot ack: %s", err)
+	}
+	seq, err := j.Append([]byte("d"))
+	if err != nil || seq != 4 {
+		t.Fatalf("unexpected sequence %d (%v)", seq, err)
+	}
+	var replayed []string
+	err = j.Replay(func(seq uint64, command []byte) error {
+		replayed = append(replayed, string(command))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("cannot replay: %s", err)
+	}
+	if len(replayed) != 2 || replayed[0] != "c" || replayed[1] != "d" {
+		t.Fatalf("unexpected commands %v", replayed)
+	}
+}
+
+func TestMemoryJournal(t *testing.T) {
+	t.Run("replays unacknowledged commands", func(t *testing.T) {
+		testJournal(t, NewMemoryJournal())
+	})
+
+	t.Run("does not retain the command buffer", func(t *testing.T) {
+		j := NewMemoryJournal()
+		command := []byte("abc")
+		j.Append(command)
+		command[0] = 'x'
+		j.Replay(func(seq uint64, replayed []byte) error {
+			if bytes.Compare(replayed, []byte("abc")) != 0 {
+				t.Fail()
+			}
+			return nil
+		})
+	})
+
+	t.Run("acknowledges everything", func(t *testing.T) {
+		j := NewMemoryJournal()
+		j.Append([]byte("a"))
+		j.Append([]byte("b"))
+		j.Ack(10)
+		if j.Len() != 0 {
+			t.Fail()
+		}
+	})
+}
@@ -0,0 +1,224 @@
+// Copyright 2019, 1533 Systems, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package driveline
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"sync"
+	"time"
+)
+
+const maxLeaseRetryInterval = time.Second
+
+// ErrLeaseLost indicates that a lease expired or was taken over before it was released.
+var ErrLeaseLost = errors.New("lease lost")
+
+// Lease is an exclusive claim on a key of the key-value store, held for a
+// limited time and renewed automatically until it is released. A Lease is lost
+// when it cannot be renewed before its TTL elapses, for instance because the
+// connection is down; another client may then acquire it.
+//
+// Since a holder may not notice right away that its Lease was lost, the
+// resources it protects should check the fencing token: tokens of successive
+// holders of a key compare in increasing order. The server only applies
+// compare-and-swap to existing keys, so a free key is claimed with a plain
+// store, which AcquireLease then confirms with a compare-and-swap: of two
+// clients claiming the key at once, only the one whose store was kept gets
+// the Lease. A client whose claim spans the whole claim and confirmation of
+// another may still overwrite it; the overwritten holder then loses its Lease
+// at its next renewal.
+//
+// A Lease is no longer renewed once its Client is closed, and is then lost.
+type Lease struct {
+	client *Client
+	key    string
+	ttl    time.Duration
+	token  RecordID
+	holder []byte // value of the key, unique to the lease
+
+	mu      sync.Mutex
+	version RecordID
+	expires time.Time // renewal deadline, only used by keepAlive
+	lost    chan struct{}
+	release chan struct{}
+	done    chan struct{}
+	once    sync.Once
+}
+
+// AcquireLease waits until the key is free, then acquires a lease on it for ttl.
+// The lease is renewed every third of ttl until it is released or lost.
+func (c *Client) AcquireLease(ctx context.Context, key string, ttl time.Duration) (*Lease, error) {
+	retry := ttl / 4
+	if retry > maxLeaseRetryInterval {
+		retry = maxLeaseRetryInterval
+	}
+	holder := make([]byte, 16)
+	if _, err := rand.Read(holder); err != nil {
+		return nil, err
+	}
+	for {
+		acquired := time.Now()
+		version, err := c.claimLease(ctx, key, holder, ttl)
+		if err == nil {
+			l := &Lease{
+				client:  c,
+				key:     key,
+				ttl:     ttl,
+				token:   version,
+				holder:  holder,
+				version: version,
+				expires: acquired.Add(ttl),
+				lost:    make(chan struct{}),
+				release: make(chan struct{}),
+				done:    make(chan struct{}),
+			}
+			go l.keepAlive()
+			return l, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err != ErrStoreConflict {
+			c.errorHandler(err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(retry):
+		}
+	}
+}
+
+// claimLease stores holder under key if the key does not exist, then confirms
+// the claim with a compare-and-swap on the stored RecordID, which it returns.
+// It returns ErrStoreConflict if the key exists or another holder took it.
+func (c *Client) claimLease(ctx context.Context, key string, holder []byte, ttl time.Duration) (RecordID, error) {
+	if _, err := c.Load(ctx, key); err != ErrKeyNotFound {
+		if err == nil {
+			err = ErrStoreConflict
+		}
+		return nil, err
+	}
+	version, err := c.StoreContext(ctx, key, holder, new(StoreOptions).WithTTL(ttl))
+	if err != nil {
+		return nil, err
+	}
+	return c.StoreContext(ctx, key, holder, new(StoreOptions).CompareAndSwap(version).WithTTL(ttl))
+}
+
+// Key returns the key of the lease.
+func (l *Lease) Key() string {
+	return l.key
+}
+
+// Token returns the fencing token of the lease, the RecordID of the key when
+// the lease was acquired.
+func (l *Lease) Token() RecordID {
+	return l.token
+}
+
+// Lost returns a channel that is closed when the lease is lost.
+func (l *Lease) Lost() <-chan struct{} {
+	return l.lost
+}
+
+// Release stops renewing the lease and frees the key, unless the lease was
+// already lost, in which case ErrLeaseLost is returned.
+func (l *Lease) Release(ctx context.Context) error {
+	l.once.Do(func() { close(l.release) })
+	<-l.done
+	select {
+	case <-l.lost:
+		return ErrLeaseLost
+	default:
+	}
+	// The key is expired right away rather than removed, so that a lease
+	// acquired by someone else in the meantime is left untouched.
+	l.mu.Lock()
+	version := l.version
+	l.mu.Unlock()
+	result, err := l.client.storeContext(ctx, l.key, l.holder, new(StoreOptions).CompareAndSwap(version).WithTTL(time.Millisecond))
+	if err != nil {
+		return err
+	}
+	// The key may already have expired, but must not hold another lease.
+	if result.after != nil && !bytes.Equal(result.after.Record, l.holder) {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+// keepAlive renews the lease until it is released or lost, or the client is
+// closed.
+func (l *Lease) keepAlive() {
+	defer close(l.done)
+	timer := time.NewTimer(l.ttl / 3)
+	defer timer.Stop()
+	for {
+		select {
+		case <-l.release:
+			return
+		case <-l.client.closed:
+			close(l.lost)
+			return
+		case <-timer.C:
+		}
+		next, ok := l.renew()
+		if !ok {
+			return
+		}
+		timer.Reset(next)
+	}
+}
+
+// renew renews the lease once, and returns when to renew it next. A lease
+// whose renewal is rejected is lost right away, and one that cannot be
+// renewed, when its TTL elapses; renew then closes Lost and returns false.
+func (l *Lease) renew() (time.Duration, bool) {
+	interval := l.ttl / 3
+	l.mu.Lock()
+	version := l.version
+	l.mu.Unlock()
+	renewed := time.Now()
+	ctx, cancel := context.WithDeadline(context.Background(), l.expires)
+	version, err := l.client.StoreContext(ctx, l.key, l.holder, new(StoreOptions).CompareAndSwap(version).WithTTL(l.ttl))
+	cancel()
+	switch {
+	case err == nil:
+		l.mu.Lock()
+		l.version = version
+		l.mu.Unlock()
+		l.expires = renewed.Add(l.ttl)
+		return interval, true
+	case err == ErrStoreConflict || !time.Now().Before(l.expires):
+		close(l.lost)
+		return 0, false
+	default:
+		retry := interval / 4
+		if remaining := time.Until(l.expires); remaining < retry {
+			retry = remaining
+		}
+		return retry, true
+	}
+}
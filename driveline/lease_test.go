@@ -0,0 +1,184 @@
+// Copyright 2019, 1533 Systems, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package driveline
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+)
+
+func testLease(t *testing.T, client *Client) *Lease {
+	l, err := client.AcquireLease(context.Background(), "lease", time.Hour)
+	if err != nil {
+		t.Fatalf("cannot acquire lease: %s", err)
+	}
+	return l
+}
+
+func TestLease(t *testing.T) {
+	t.Run("increments the fencing token", func(t *testing.T) {
+		client, fws := testClient()
+		kv := newTestKV(fws)
+		l1 := testLease(t, client)
+		if next, ok := l1.renew(); !ok || next != time.Hour/3 {
+			t.Fatalf("cannot renew lease")
+		}
+		if err := l1.Release(context.Background()); err != nil {
+			t.Fatalf("cannot release lease: %s", err)
+		}
+		kv.advance(time.Millisecond)
+		l2 := testLease(t, client)
+		defer l2.Release(context.Background())
+		if bytes.Compare(l2.Token(), l1.Token()) <= 0 {
+			t.Fatalf("expected token %v to follow %v", l2.Token(), l1.Token())
+		}
+	})
+
+	t.Run("waits while the key is held", func(t *testing.T) {
+		client, fws := testClient()
+		newTestKV(fws)
+		l := testLease(t, client)
+		defer l.Release(context.Background())
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		if _, err := client.AcquireLease(ctx, "lease", time.Hour); err != context.DeadlineExceeded {
+			t.Fatalf("expected the key to be held, got %v", err)
+		}
+	})
+
+	t.Run("is lost when a renewal is rejected", func(t *testing.T) {
+		client, fws := testClient()
+		kv := newTestKV(fws)
+		l := testLease(t, client)
+		kv.set("lease", []byte("other"))
+		if _, ok := l.renew(); ok {
+			t.Fatalf("expected the renewal to be rejected")
+		}
+		select {
+		case <-l.Lost():
+		default:
+			t.Fatalf("expected the lease to be lost")
+		}
+		if err := l.Release(context.Background()); err != ErrLeaseLost {
+			t.Fatalf("expected ErrLeaseLost, got %v", err)
+		}
+		if r, _ := kv.get("lease"); string(r.Record) != "other" {
+			t.Fatalf("expected the key to be left untouched")
+		}
+	})
+
+	t.Run("stops renewing once released", func(t *testing.T) {
+		client, fws := testClient()
+		kv := newTestKV(fws)
+		l := testLease(t, client)
+		stores := kv.storeCount()
+		if err := l.Release(context.Background()); err != nil {
+			t.Fatalf("cannot release lease: %s", err)
+		}
+		select {
+		case <-l.done:
+		default:
+			t.Fatalf("expected keepAlive to stop")
+		}
+		select {
+		case <-l.Lost():
+			t.Fatalf("unexpected loss")
+		default:
+		}
+		if kv.storeCount() != stores+1 {
+			t.Fatalf("expected a single store to release the key")
+		}
+		kv.advance(time.Millisecond)
+		if _, exists := kv.get("lease"); exists {
+			t.Fatalf("expected the key to expire")
+		}
+	})
+
+	t.Run("releases an expired lease", func(t *testing.T) {
+		client, fws := testClient()
+		kv := newTestKV(fws)
+		l := testLease(t, client)
+		kv.advance(time.Hour)
+		if err := l.Release(context.Background()); err != nil {
+			t.Fatalf("cannot release lease: %s", err)
+		}
+		if _, exists := kv.get("lease"); exists {
+			t.Fatalf("expected the key to stay free")
+		}
+	})
+
+	t.Run("does not release a lease taken over after expiry", func(t *testing.T) {
+		client, fws := testClient()
+		kv := newTestKV(fws)
+		l1 := testLease(t, client)
+		kv.advance(time.Hour)
+		l2 := testLease(t, client)
+		defer l2.Release(context.Background())
+		if err := l1.Release(context.Background()); err != ErrLeaseLost {
+			t.Fatalf("expected ErrLeaseLost, got %v", err)
+		}
+		if r, _ := kv.get("lease"); !bytes.Equal(r.RecordID, l2.Token()) {
+			t.Fatalf("expected the key to be left untouched")
+		}
+	})
+
+	t.Run("is not acquired when the claim is overwritten before it is confirmed", func(t *testing.T) {
+		client, fws := testClient()
+		kv := newTestKV(fws)
+		loads := 0
+		fws.WriteHandler = func(buf []byte) (int, error) {
+			if name, _ := testCommand(buf); name == "ld" {
+				// The fourth load precedes the confirmation of the claim.
+				if loads++; loads == 4 {
+					kv.set("lease", []byte("other"))
+				}
+			}
+			return kv.write(buf)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		if _, err := client.AcquireLease(ctx, "lease", time.Hour); err != context.DeadlineExceeded {
+			t.Fatalf("expected the claim to be lost, got %v", err)
+		}
+		if r, _ := kv.get("lease"); string(r.Record) != "other" {
+			t.Fatalf("expected the key to be left untouched")
+		}
+	})
+
+	t.Run("is lost once the client is closed", func(t *testing.T) {
+		client, fws := testClient()
+		newTestKV(fws)
+		l := testLease(t, client)
+		client.Close()
+		select {
+		case <-l.Lost():
+		case <-time.After(time.Second):
+			t.Fatalf("expected the lease to be lost")
+		}
+		select {
+		case <-l.done:
+		case <-time.After(time.Second):
+			t.Fatalf("expected keepAlive to stop")
+		}
+	})
+}
@@ -0,0 +1,69 @@
+// Copyright 2019, 1533 Systems, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package driveline
+
+import (
+	"time"
+)
+
+// GroupOptions configures ConsumeGroup operations.
+type GroupOptions struct {
+	memberID           string
+	sessionTTL         time.Duration
+	checkpointInterval time.Duration
+}
+
+// MemberID sets the identifier of the member within its group, which defaults
+// to a random one. It must be unique within the group and must not contain '/'.
+func (o *GroupOptions) MemberID(id string) *GroupOptions {
+	if o != nil {
+		o.memberID = id
+		return o
+	}
+	return &GroupOptions{
+		memberID: id,
+	}
+}
+
+// SessionTTL sets how long a member that stopped responding keeps its
+// streams before they are assigned to other members. Members renew their
+// session three times per TTL. The default is 10 seconds.
+func (o *GroupOptions) SessionTTL(d time.Duration) *GroupOptions {
+	if o != nil {
+		o.sessionTTL = d
+		return o
+	}
+	return &GroupOptions{
+		sessionTTL: d,
+	}
+}
+
+// CheckpointInterval commits the position of each stream at most once per
+// interval rather than after each record. c.f. QueryOptions.CheckpointInterval
+func (o *GroupOptions) CheckpointInterval(d time.Duration) *GroupOptions {
+	if o != nil {
+		o.checkpointInterval = d
+		return o
+	}
+	return &GroupOptions{
+		checkpointInterval: d,
+	}
+}
@@ -20,6 +20,10 @@
 
 package driveline
 
+import (
+	"time"
+)
+
 type optQueryOption uint16
 
 const (
@@ -30,6 +34,12 @@ const (
 type QueryOptions struct {
 	assigned     optQueryOption
 	fromRecordID RecordID
+	maxBuffered  int
+	borrow       bool
+
+	checkpoint         CheckpointStore
+	checkpointName     string
+	checkpointInterval time.Duration
 }
 
 // FromStreamHead indicates that the query operation should start as far back
@@ -74,3 +84,67 @@ func (o *QueryOptions) FromRecordID(id RecordID) *QueryOptions {
 		fromRecordID: id,
 	}
 }
+
+// MaxBuffered bounds the number of records received but not yet handled.
+// When the handler falls behind, the client stops pulling records from the
+// server and resumes from the last received RecordID once the handler has
+// caught up, instead of buffering without limit or blocking the connection.
+// The handler then runs on the goroutine calling the query, not on the one
+// reading from the connection.
+// A non-positive count restores the default, unbuffered, behavior.
+func (o *QueryOptions) MaxBuffered(count int) *QueryOptions {
+	if o != nil {
+		o.maxBuffered = count
+		return o
+	}
+	return &QueryOptions{
+		maxBuffered: count,
+	}
+}
+
+// BorrowRecords lends the records to the handler instead of copying them out
+// of the message they were received in: a record, its RecordID and its payload
+// are then only valid until the handler returns, and must be cloned to be kept.
+// This saves allocations when handling many records. It has no effect with
+// MaxBuffered or WithCheckpoint, whose records are buffered before the
+// handler runs.
+// c.f. Record.Clone
+func (o *QueryOptions) BorrowRecords() *QueryOptions {
+	if o != nil {
+		o.borrow = true
+		return o
+	}
+	return &QueryOptions{
+		borrow: true,
+	}
+}
+
+// WithCheckpoint makes a continuous query resume from the last RecordID
+// committed to store under name, and commit the RecordID of each record once
+// the handler returns. Records are handled at least once: after a restart, the
+// records handled since the last commit are handled again.
+// Without a checkpoint, the query starts as configured by the other options.
+// The handler runs on the goroutine calling the query. c.f. CheckpointInterval
+func (o *QueryOptions) WithCheckpoint(store CheckpointStore, name string) *QueryOptions {
+	if o != nil {
+		o.checkpoint = store
+		o.checkpointName = name
+		return o
+	}
+	return &QueryOptions{
+		checkpoint:     store,
+		checkpointName: name,
+	}
+}
+
+// CheckpointInterval commits the checkpoint at most once per interval rather
+// than after each record. c.f. WithCheckpoint
+func (o *QueryOptions) CheckpointInterval(d time.Duration) *QueryOptions {
+	if o != nil {
+		o.checkpointInterval = d
+		return o
+	}
+	return &QueryOptions{
+		checkpointInterval: d,
+	}
+}
@@ -23,6 +23,7 @@ package driveline
 import (
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestQueryOptions_FromRecordID(t *testing.T) {
@@ -57,3 +58,29 @@ func TestQueryOptions_FromStreamTail(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestQueryOptions_MaxBuffered(t *testing.T) {
+	var o QueryOptions
+	o.MaxBuffered(10)
+	if o.maxBuffered != 10 {
+		t.Fail()
+	}
+	if o.assigned != 0 {
+		t.Fail()
+	}
+}
+
+func TestQueryOptions_WithCheckpoint(t *testing.T) {
+	var o QueryOptions
+	store := new(FileCheckpointStore)
+	o.WithCheckpoint(store, "name").CheckpointInterval(time.Second)
+	if o.checkpoint != store || o.checkpointName != "name" {
+		t.Fail()
+	}
+	if o.checkpointInterval != time.Second {
+		t.Fail()
+	}
+	if o.assigned != 0 {
+		t.Fail()
+	}
+}
@@ -57,3 +57,11 @@ func (o *StoreOptions) CompareAndSwap(recordID RecordID) *StoreOptions {
 	}
 	return o
 }
+
+// compareAndSwap returns the RecordID set by CompareAndSwap, nil when unset.
+func (o *StoreOptions) compareAndSwap() RecordID {
+	if o == nil || o.assigned&optStoreCASOption == 0 {
+		return nil
+	}
+	return o.casRecordID
+}
@@ -0,0 +1,61 @@
+// Copyright 2019, 1533 Systems, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package driveline
+
+import (
+	"time"
+
+	ws "github.com/1533-systems/golang-sdk/driveline/websocket"
+)
+
+// RetryPolicy returns the delay before a connection attempt. attempt counts
+// the consecutive attempts since the client was last connected, starting at 1;
+// err is the error that ended the previous attempt or connection. c.f. Retry.
+type RetryPolicy interface {
+	Delay(attempt int, err error) time.Duration
+}
+
+// RetryEvent describes a connection attempt about to be made, c.f. OnRetry.
+type RetryEvent struct {
+	Attempt int
+	Err     error
+	Delay   time.Duration
+}
+
+// ConstantBackoff returns a RetryPolicy waiting delay before every attempt.
+func ConstantBackoff(delay time.Duration) RetryPolicy {
+	return ws.ConstantBackoff(delay)
+}
+
+// ExponentialBackoff returns a RetryPolicy doubling the delay from base at
+// every attempt, up to max, and randomized between half and all of it. It is
+// the default policy, with ReconnectWait and MaxReconnectWait.
+func ExponentialBackoff(base time.Duration, max time.Duration) RetryPolicy {
+	return ws.ExponentialBackoff(base, max)
+}
+
+// DecorrelatedJitter returns a RetryPolicy picking a random delay between base
+// and three times the previous delay, up to max. It spreads the reconnects of
+// many clients after a server restart better than ExponentialBackoff. A policy
+// must not be shared between clients.
+func DecorrelatedJitter(base time.Duration, max time.Duration) RetryPolicy {
+	return ws.DecorrelatedJitter(base, max)
+}
@@ -0,0 +1,187 @@
+// Copyright 2019, 1533 Systems, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package driveline
+
+import (
+	"context"
+	"sync"
+)
+
+// State is the state of the connection of a Client to Driveline.
+type State int
+
+const (
+	// Connecting is the state until the client connects for the first time.
+	Connecting State = iota
+	// Connected is the state while the client is connected.
+	Connected
+	// Reconnecting is the state after the connection was lost, until the
+	// client connects again.
+	Reconnecting
+	// Failed is the state once the client gave up reconnecting, c.f.
+	// MaxReconnect.
+	Failed
+	// Closed is the state once the client is closed.
+	Closed
+)
+
+var stateNames = []string{"connecting", "connected", "reconnecting", "failed", "closed"}
+
+func (s State) String() string {
+	if s < 0 || int(s) >= len(stateNames) {
+		return "unknown"
+	}
+	return stateNames[s]
+}
+
+// StateEvent is a change of the connection state of a Client. Endpoint is the
+// endpoint of the last connection attempt. Err is the error that caused the
+// change, if any: the error that ended the previous connection or attempt
+// when reconnecting, or the reason of the failure. Attempt is the number of
+// the connection attempt since the client started or was last connected,
+// starting at 0.
+type StateEvent struct {
+	State    State
+	Endpoint string
+	Err      error
+	Attempt  int
+}
+
+// stateChangesBuffer is the capacity of the channels of StateChanges. When a
+// subscriber falls behind, its oldest events are dropped.
+const stateChangesBuffer = 16
+
+type connectionState struct {
+	mu          sync.Mutex
+	event       StateEvent
+	changed     chan struct{} // closed and replaced on every change
+	subscribers []chan StateEvent
+}
+
+func newConnectionState(endpoint string) *connectionState {
+	return &connectionState{
+		event:   StateEvent{State: Connecting, Endpoint: endpoint},
+		changed: make(chan struct{}),
+	}
+}
+
+func (s *connectionState) get() (StateEvent, <-chan struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.event, s.changed
+}
+
+// attempt records the endpoint and the number of a connection attempt.
+func (s *connectionState) attempt(endpoint string, attempt int) {
+	s.mu.Lock()
+	s.event.Endpoint = endpoint
+	s.event.Attempt = attempt
+	s.mu.Unlock()
+}
+
+// set changes the state, unless the client is closed, and notifies the
+// subscribers. The channels of the subscribers are closed with Closed.
+func (s *connectionState) set(state State, err error, attempt int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.event.State == Closed {
+		return
+	}
+	s.event.State = state
+	s.event.Err = err
+	s.event.Attempt = attempt
+	close(s.changed)
+	s.changed = make(chan struct{})
+	for _, ch := range s.subscribers {
+		select {
+		case ch <- s.event:
+		default:
+			select {
+			case <-ch:
+			default:
+			}
+			ch <- s.event
+		}
+		if state == Closed {
+			close(ch)
+		}
+	}
+	if state == Closed {
+		s.subscribers = nil
+	}
+}
+
+// retry moves to Reconnecting before a new connection attempt, unless the
+// client never connected.
+func (s *connectionState) retry(err error, attempt int) {
+	state := Reconnecting
+	if event, _ := s.get(); event.State == Connecting {
+		state = Connecting
+	}
+	s.set(state, err, attempt)
+}
+
+func (s *connectionState) subscribe() <-chan StateEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ch := make(chan StateEvent, stateChangesBuffer)
+	if s.event.State == Closed {
+		close(ch)
+	} else {
+		s.subscribers = append(s.subscribers, ch)
+	}
+	return ch
+}
+
+// State returns the current state of the connection to Driveline.
+func (c *Client) State() State {
+	event, _ := c.state.get()
+	return event.State
+}
+
+// StateChanges returns a channel receiving every change of the connection
+// state, from now on. When the receiver falls behind, the oldest events are
+// dropped. The channel is closed once the client is closed.
+func (c *Client) StateChanges() <-chan StateEvent {
+	return c.state.subscribe()
+}
+
+// WaitConnected blocks until the client is connected. It returns the cause of
+// the failure once the client gave up reconnecting, ErrClosed once it is
+// closed, or the error of ctx.
+func (c *Client) WaitConnected(ctx context.Context) error {
+	for {
+		event, changed := c.state.get()
+		switch event.State {
+		case Connected:
+			return nil
+		case Failed:
+			return event.Err
+		case Closed:
+			return ErrClosed
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright 2019, 1533 Systems, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package driveline
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	ws "github.com/1533-systems/golang-sdk/driveline/websocket"
+)
+
+func TestConnectionState(t *testing.T) {
+	t.Run("follows the connection", func(t *testing.T) {
+		c, _ := testClient()
+		changes := c.StateChanges()
+		errLost := errors.New("connection lost")
+
+		c.state.attempt("ws://node-1", 0)
+		c.onConnect()
+		c.onRetry(ws.RetryEvent{Attempt: 1, Err: errLost})
+		c.state.attempt("ws://node-2", 1)
+		c.onConnect()
+		c.onFailure(ws.ErrMaxReconnect)
+		c.Close()
+
+		expected := []StateEvent{
+			{State: Connected, Endpoint: "ws://node-1"},
+			{State: Reconnecting, Endpoint: "ws://node-1", Err: errLost, Attempt: 1},
+			{State: Connected, Endpoint: "ws://node-2", Attempt: 1},
+			{State: Failed, Endpoint: "ws://node-2", Err: ws.ErrMaxReconnect, Attempt: 1},
+			{State: Closed, Endpoint: "ws://node-2"},
+		}
+		for _, e := range expected {
+			if event := <-changes; event != e {
+				t.Errorf("expected %+v, got %+v", e, event)
+			}
+		}
+		if _, ok := <-changes; ok {
+			t.Error("expected the channel to be closed")
+		}
+		if c.State() != Closed {
+			t.Fail()
+		}
+	})
+	t.Run("stays connecting until the first connection", func(t *testing.T) {
+		c, _ := testClient()
+		defer c.Close()
+		c.onRetry(ws.RetryEvent{Attempt: 1, Err: errors.New("refused")})
+		if c.State() != Connecting {
+			t.Fail()
+		}
+	})
+	t.Run("drops the oldest events of slow subscribers", func(t *testing.T) {
+		c, _ := testClient()
+		defer c.Close()
+		changes := c.StateChanges()
+		for i := 0; i < stateChangesBuffer+2; i++ {
+			c.onRetry(ws.RetryEvent{Attempt: i + 1})
+		}
+		c.onConnect()
+		var last StateEvent
+		for i := 0; i < stateChangesBuffer; i++ {
+			last = <-changes
+		}
+		if last.State != Connected || len(changes) != 0 {
+			t.Fail()
+		}
+	})
+	t.Run("closes subscriptions of a closed client", func(t *testing.T) {
+		c, _ := testClient()
+		c.Close()
+		if _, ok := <-c.StateChanges(); ok {
+			t.Fail()
+		}
+	})
+	t.Run("waits until connected", func(t *testing.T) {
+		c, _ := testClient()
+		defer c.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		if err := c.WaitConnected(ctx); err != context.DeadlineExceeded {
+			t.Fail()
+		}
+		go c.onConnect()
+		if err := c.WaitConnected(context.Background()); err != nil {
+			t.Fail()
+		}
+	})
+	t.Run("stops waiting on failures", func(t *testing.T) {
+		c, _ := testClient()
+		go c.onFailure(ws.ErrMaxReconnect)
+		if err := c.WaitConnected(context.Background()); err != ws.ErrMaxReconnect {
+			t.Fail()
+		}
+		c.Close()
+		if err := c.WaitConnected(context.Background()); err != ErrClosed {
+			t.Fail()
+		}
+	})
+	t.Run("names states", func(t *testing.T) {
+		if Reconnecting.String() != "reconnecting" || State(42).String() != "unknown" {
+			t.Fail()
+		}
+	})
+}
@@ -20,6 +20,10 @@
 
 package driveline
 
+import (
+	"context"
+)
+
 // Stream is a proxy structure, that makes wire-encoding more compact, and also
 // provide a DRY-er interface for Append and Truncate operations.
 type Stream struct {
@@ -32,6 +36,12 @@ func (s *Stream) Append(data []byte) error {
 	return s.client.append(s.streamID, data)
 }
 
+// AppendContext adds a Record to the stream and waits for the server to process it.
+// c.f. Client.AppendContext for more details.
+func (s *Stream) AppendContext(ctx context.Context, data []byte) error {
+	return s.client.appendContext(ctx, s.streamID, data)
+}
+
 // Truncate all records .
 func (s *Stream) Truncate() error {
 	return s.client.truncate(s.streamID)
@@ -0,0 +1,326 @@
+// Copyright 2019, 1533 Systems, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package driveline
+
+import (
+	"bytes"
+	"encoding/binary"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/1533-systems/golang-sdk/driveline/cbor"
+	"github.com/1533-systems/golang-sdk/driveline/drivelinetest"
+	ws "github.com/1533-systems/golang-sdk/driveline/websocket"
+)
+
+// testDataMessage encodes the data message of the server for records.
+func testDataMessage(consumerID uint64, records ...Record) []byte {
+	buf := appendNumberWithType(nil, uint64(3+len(records)), cborArray)
+	buf = appendText(buf, "data")
+	buf = appendNumberWithType(buf, consumerID, cborUnsignedInteger)
+	buf = appendNumberWithType(buf, 2, cborArray)
+	buf = append(buf, encodedMessageIdTag)
+	buf = appendNumberWithType(buf, uint64(len(records)), cborArray)
+	for _, r := range records {
+		buf = appendBytesWithType(buf, r.RecordID, cborByteString)
+	}
+	for _, r := range records {
+		buf = appendBytesWithType(buf, r.Record, cborByteString)
+	}
+	return buf
+}
+
+// testCommand decodes a command sent by the client into its name and its
+// arguments, or returns "" when it cannot be decoded.
+func testCommand(buf []byte) (string, []interface{}) {
+	var command []interface{}
+	if err := cbor.Unmarshal(buf, &command); err != nil || len(command) == 0 {
+		return "", nil
+	}
+	name, _ := command[0].(string)
+	return name, command[1:]
+}
+
+// testSyncMessage encodes the answer of the server to a sync.
+func testSyncMessage(consumerID uint64) []byte {
+	buf := appendNumberWithType(nil, 2, cborArray)
+	buf = appendText(buf, "syn")
+	return appendNumberWithType(buf, consumerID, cborUnsignedInteger)
+}
+
+// testUndefinedMessage encodes the data message of a single undefined record,
+// which ends queries and answers the load of a missing key.
+func testUndefinedMessage(consumerID uint64) []byte {
+	buf := appendNumberWithType(nil, 4, cborArray)
+	buf = appendText(buf, "data")
+	buf = appendNumberWithType(buf, consumerID, cborUnsignedInteger)
+	return append(buf, cborUndefined, cborUndefined)
+}
+
+// testListMessage encodes the data message of the server listing names.
+func testListMessage(consumerID uint64, names []string) []byte {
+	payload, _ := cbor.Marshal(names)
+	buf := appendNumberWithType(nil, 4, cborArray)
+	buf = appendText(buf, "data")
+	buf = appendNumberWithType(buf, consumerID, cborUnsignedInteger)
+	buf = append(buf, cborUndefined)
+	return appendBytesWithType(buf, payload, cborByteString)
+}
+
+type testEntry struct {
+	record  Record
+	expires time.Duration
+}
+
+var testSelectAll = regexp.MustCompile(`^SELECT \* FROM '((?:[^']|'')*)'$`)
+
+// testKV answers the key-value commands sent to a FakeWebSocket as the server
+// would: writes are not answered, compare-and-swap fails on a missing key and
+// the load of a missing key is answered with a single undefined record.
+// Patterns are matched as drivelinetest.Server matches them. Queries select
+// every key matching a pattern; continuous ones then receive the records of
+// the keys stored later. Time only passes, and keys only expire, when advance
+// is called.
+type testKV struct {
+	mu      sync.Mutex
+	receive sync.Mutex // replies are delivered one at a time, like the reader loop
+	fake    *ws.FakeWebSocket
+	lastID  uint64
+	now     time.Duration
+	entries map[string]testEntry
+	streams []string
+	queries map[uint64]string // patterns of the continuous queries
+	stores  int
+	pending chan [][]byte // replies delivered by another goroutine, c.f. readAsync
+	stopped chan struct{}
+}
+
+func newTestKV(fake *ws.FakeWebSocket) *testKV {
+	kv := &testKV{fake: fake, entries: make(map[string]testEntry), queries: make(map[uint64]string)}
+	fake.WriteHandler = kv.write
+	return kv
+}
+
+func (kv *testKV) write(buf []byte) (int, error) {
+	kv.mu.Lock()
+	replies := kv.handle(buf)
+	kv.mu.Unlock()
+	kv.deliver(replies)
+	return len(buf), nil
+}
+
+func (kv *testKV) deliver(messages [][]byte) {
+	if kv.pending != nil {
+		select {
+		case kv.pending <- messages:
+		case <-kv.stopped:
+		}
+		return
+	}
+	kv.receive.Lock()
+	for _, message := range messages {
+		kv.fake.Receive(message)
+	}
+	kv.receive.Unlock()
+}
+
+// readAsync makes replies be delivered by another goroutine, like the reader
+// loop of the connection, for consumers sending commands under a lock that
+// their message handler takes too. close stops the goroutine.
+func (kv *testKV) readAsync() {
+	kv.pending = make(chan [][]byte, 1024)
+	kv.stopped = make(chan struct{})
+	go func() {
+		for {
+			select {
+			case messages := <-kv.pending:
+				for _, message := range messages {
+					kv.fake.Receive(message)
+				}
+			case <-kv.stopped:
+				return
+			}
+		}
+	}()
+}
+
+func (kv *testKV) close() {
+	if kv.stopped != nil {
+		close(kv.stopped)
+	}
+}
+
+// handle applies a command and returns the messages answering it.
+func (kv *testKV) handle(buf []byte) [][]byte {
+	name, args := testCommand(buf)
+	switch {
+	case name == "st" && len(args) == 3:
+		key, _ := args[0].(string)
+		data, _ := args[2].([]byte)
+		var cas []byte
+		var ttl uint64
+		options, _ := args[1].([]interface{})
+		for i := 0; i+1 < len(options); i += 2 {
+			switch tag, _ := options[i].(uint64); tag {
+			case tagStoreCASID:
+				cas, _ = options[i+1].([]byte)
+			case tagStoreTTL:
+				ttl, _ = options[i+1].(uint64)
+			}
+		}
+		kv.stores++
+		current, exists := kv.entries[key]
+		if cas != nil && (!exists || !bytes.Equal(cas, current.record.RecordID)) {
+			return nil
+		}
+		return kv.setLocked(key, data, time.Duration(ttl)*time.Millisecond)
+	case name == "rm" && len(args) == 2:
+		key, _ := args[1].(string)
+		delete(kv.entries, key)
+	case name == "ld" && len(args) == 3:
+		consumerID, _ := args[0].(uint64)
+		key, _ := args[2].(string)
+		if e, exists := kv.entries[key]; exists {
+			return [][]byte{testDataMessage(consumerID, e.record)}
+		}
+		return [][]byte{testUndefinedMessage(consumerID)}
+	case (name == "lst" || name == "sls") && len(args) == 3:
+		consumerID, _ := args[0].(uint64)
+		pattern, _ := args[2].(string)
+		names := kv.streams
+		if name == "lst" {
+			names = nil
+			for key := range kv.entries {
+				names = append(names, key)
+			}
+		}
+		var matches []string
+		for _, name := range names {
+			if drivelinetest.MatchPattern(pattern, name) {
+				matches = append(matches, name)
+			}
+		}
+		if len(matches) == 0 {
+			return [][]byte{testListMessage(consumerID, nil)}
+		}
+		return [][]byte{testListMessage(consumerID, matches), testListMessage(consumerID, nil)}
+	case (name == "qq" || name == "sq") && len(args) == 3:
+		consumerID, _ := args[0].(uint64)
+		query, _ := args[2].(string)
+		m := testSelectAll.FindStringSubmatch(query)
+		if m == nil {
+			return nil
+		}
+		pattern := strings.Replace(m[1], "''", "'", -1)
+		var from RecordID
+		options, _ := args[1].([]interface{})
+		if len(options) == 2 && options[0] == uint64(tagReadID) {
+			from, _ = options[1].([]byte)
+		}
+		var records []Record
+		for key, e := range kv.entries {
+			if drivelinetest.MatchPattern(pattern, key) && bytes.Compare(e.record.RecordID, from) > 0 {
+				records = append(records, e.record)
+			}
+		}
+		sort.Slice(records, func(i, j int) bool { return bytes.Compare(records[i].RecordID, records[j].RecordID) < 0 })
+		var replies [][]byte
+		if len(records) > 0 {
+			replies = append(replies, testDataMessage(consumerID, records...))
+		}
+		if name == "sq" {
+			kv.queries[consumerID] = pattern
+			return replies
+		}
+		return append(replies, testUndefinedMessage(consumerID))
+	case name == "can" && len(args) >= 1:
+		consumerID, _ := args[0].(uint64)
+		delete(kv.queries, consumerID)
+	case name == "syn" && len(args) == 1:
+		consumerID, _ := args[0].(uint64)
+		return [][]byte{testSyncMessage(consumerID)}
+	}
+	return nil
+}
+
+// set stores data under key, as another client would.
+func (kv *testKV) set(key string, data []byte) {
+	kv.mu.Lock()
+	notifications := kv.setLocked(key, data, 0)
+	kv.mu.Unlock()
+	kv.deliver(notifications)
+}
+
+// remove removes key, as another client would.
+func (kv *testKV) remove(key string) {
+	kv.mu.Lock()
+	delete(kv.entries, key)
+	kv.mu.Unlock()
+}
+
+// setLocked stores data under key, and returns the messages notifying the
+// continuous queries matching key.
+func (kv *testKV) setLocked(key string, data []byte, ttl time.Duration) [][]byte {
+	kv.lastID++
+	id := make(RecordID, recordIDLen)
+	binary.BigEndian.PutUint64(id, kv.lastID)
+	e := testEntry{record: Record{RecordID: id, Record: data}}
+	if ttl > 0 {
+		e.expires = kv.now + ttl
+	}
+	kv.entries[key] = e
+	var notifications [][]byte
+	for consumerID, pattern := range kv.queries {
+		if drivelinetest.MatchPattern(pattern, key) {
+			notifications = append(notifications, testDataMessage(consumerID, e.record))
+		}
+	}
+	return notifications
+}
+
+func (kv *testKV) get(key string) (Record, bool) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	e, exists := kv.entries[key]
+	return e.record, exists
+}
+
+// advance moves the clock of the server forward, expiring keys whose TTL elapsed.
+func (kv *testKV) advance(d time.Duration) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	kv.now += d
+	for key, e := range kv.entries {
+		if e.expires > 0 && e.expires <= kv.now {
+			delete(kv.entries, key)
+		}
+	}
+}
+
+// storeCount returns the number of store commands received.
+func (kv *testKV) storeCount() int {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	return kv.stores
+}
@@ -0,0 +1,110 @@
+// Copyright 2019, 1533 Systems, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package driveline
+
+import (
+	"context"
+)
+
+// TypedStream appends values encoded with a Codec to a stream.
+type TypedStream struct {
+	stream *Stream
+	codec  Codec
+}
+
+// NewTypedStream returns a TypedStream appending to stream.
+func NewTypedStream(stream *Stream, codec Codec) *TypedStream {
+	return &TypedStream{stream: stream, codec: codec}
+}
+
+// Append encodes v and adds it to the stream.
+func (s *TypedStream) Append(v interface{}) error {
+	data, err := s.codec.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return s.stream.Append(data)
+}
+
+// AppendContext encodes v, adds it to the stream and waits for the server to
+// process it. c.f. Client.AppendContext for more details.
+func (s *TypedStream) AppendContext(ctx context.Context, v interface{}) error {
+	data, err := s.codec.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return s.stream.AppendContext(ctx, data)
+}
+
+// Truncate removes all records of the stream.
+func (s *TypedStream) Truncate() error {
+	return s.stream.Truncate()
+}
+
+// TypedKV stores and loads values encoded with a Codec in the key-value store.
+type TypedKV struct {
+	client *Client
+	codec  Codec
+}
+
+// NewTypedKV returns a TypedKV using the key-value store of client.
+func NewTypedKV(client *Client, codec Codec) *TypedKV {
+	return &TypedKV{client: client, codec: codec}
+}
+
+// Store encodes v and writes it to the key-value store.
+func (kv *TypedKV) Store(key string, v interface{}) error {
+	return kv.StoreOptions(key, v, nil)
+}
+
+// StoreOptions encodes v and writes it to the key-value store.
+// Use options to configure TTL and CAS.
+func (kv *TypedKV) StoreOptions(key string, v interface{}, options *StoreOptions) error {
+	data, err := kv.codec.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return kv.client.StoreOptions(key, data, options)
+}
+
+// StoreContext encodes v, writes it to the key-value store and waits for the
+// server to process it. c.f. Client.StoreContext for more details.
+func (kv *TypedKV) StoreContext(ctx context.Context, key string, v interface{}, options *StoreOptions) (RecordID, error) {
+	data, err := kv.codec.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return kv.client.StoreContext(ctx, key, data, options)
+}
+
+// Load reads a value from the key-value store and decodes it into v, which
+// must be a pointer. It returns the RecordID of the value, ErrKeyNotFound when
+// the key does not exist, or a *RecordDecodeError when it cannot be decoded.
+func (kv *TypedKV) Load(ctx context.Context, key string, v interface{}) (RecordID, error) {
+	record, err := kv.client.Load(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if err := kv.codec.Unmarshal(record.Record, v); err != nil {
+		return record.RecordID, &RecordDecodeError{RecordID: record.RecordID, Err: err}
+	}
+	return record.RecordID, nil
+}
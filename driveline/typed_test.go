@@ -0,0 +1,101 @@
+// Copyright 2019, 1533 Systems, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package driveline
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+func TestTypedStream(t *testing.T) {
+	t.Run("appends encoded values", func(t *testing.T) {
+		client, fws := testClient()
+		var appended [][]byte
+		fws.WriteHandler = func(buf []byte) (int, error) {
+			if name, args := testCommand(buf); name == "app" {
+				record, _ := args[2].([]byte)
+				appended = append(appended, record)
+			}
+			return len(buf), nil
+		}
+		stream, _ := client.OpenStream("events")
+		s := NewTypedStream(stream, JSON)
+		if err := s.Append(testEvent{Name: "a", Count: 1}); err != nil {
+			t.Fatalf("cannot append: %s", err)
+		}
+		expected, _ := JSON.Marshal(testEvent{Name: "a", Count: 1})
+		if len(appended) != 1 || !bytes.Equal(appended[0], expected) {
+			t.Fatalf("unexpected records %q", appended)
+		}
+	})
+
+	t.Run("does not append values that cannot be encoded", func(t *testing.T) {
+		client, fws := testClient()
+		var writes int
+		fws.WriteHandler = func(buf []byte) (int, error) {
+			writes++
+			return len(buf), nil
+		}
+		stream, _ := client.OpenStream("events")
+		writes = 0
+		if err := NewTypedStream(stream, testProto).Append(testEvent{}); err == nil {
+			t.Fatalf("encoded an event as a protobuf message")
+		}
+		if writes != 0 {
+			t.Fatalf("unexpected write")
+		}
+	})
+}
+
+func TestTypedKV(t *testing.T) {
+	t.Run("stores and loads values", func(t *testing.T) {
+		client, fws := testClient()
+		newTestKV(fws)
+		kv := NewTypedKV(client, CBOR)
+		id, err := kv.StoreContext(context.Background(), "last", testEvent{Name: "b", Count: 2}, nil)
+		if err != nil {
+			t.Fatalf("cannot store: %s", err)
+		}
+		var e testEvent
+		loaded, err := kv.Load(context.Background(), "last", &e)
+		if err != nil || e.Name != "b" || e.Count != 2 || !bytes.Equal(loaded, id) {
+			t.Fatalf("unexpected value %v %v (%v)", e, loaded, err)
+		}
+	})
+
+	t.Run("reports values that cannot be decoded", func(t *testing.T) {
+		client, fws := testClient()
+		newTestKV(fws).set("last", []byte("not cbor"))
+		_, err := NewTypedKV(client, CBOR).Load(context.Background(), "last", new(testEvent))
+		if decodeErr, ok := err.(*RecordDecodeError); !ok || decodeErr.RecordID == nil {
+			t.Fatalf("expected a RecordDecodeError, got %v", err)
+		}
+	})
+
+	t.Run("reports missing keys", func(t *testing.T) {
+		client, fws := testClient()
+		newTestKV(fws)
+		if _, err := NewTypedKV(client, CBOR).Load(context.Background(), "last", new(testEvent)); err != ErrKeyNotFound {
+			t.Fatalf("expected ErrKeyNotFound, got %v", err)
+		}
+	})
+}
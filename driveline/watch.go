@@ -0,0 +1,216 @@
+// Copyright 2019, 1533 Systems, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package driveline
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"time"
+
+	"github.com/1533-systems/golang-sdk/driveline/dql"
+)
+
+const (
+	watchRetryInterval           = time.Second
+	defaultWatchSnapshotInterval = 30 * time.Second
+)
+
+// KeyEventKind tells what happened to a key. c.f. Watch
+type KeyEventKind int
+
+const (
+	// KeyPut indicates that a key was stored.
+	KeyPut KeyEventKind = iota
+	// KeyDelete indicates that a key was removed, or that its TTL elapsed:
+	// the server reports neither, so Watch only finds that the key is gone
+	// and cannot tell them apart.
+	KeyDelete
+)
+
+// String implements Stringer interface.
+func (k KeyEventKind) String() string {
+	switch k {
+	case KeyPut:
+		return "put"
+	case KeyDelete:
+		return "delete"
+	}
+	return "unknown"
+}
+
+// KeyEvent is a change of a key of the key-value store.
+type KeyEvent struct {
+	Kind     KeyEventKind
+	Key      string
+	RecordID RecordID // RecordID is the identifier of a KeyPut event
+	Value    []byte   // Value is the stored data of a KeyPut event
+}
+
+// Watch reports the changes of the keys matching keyPattern, starting with a
+// KeyPut event for every existing key, until ctx is done or the client is
+// closed, at which point the channel is closed.
+//
+// Watch takes a snapshot of the keys, with a Query for the current position
+// followed by ListKeys and Load, then runs a ContinuousQuery from that
+// position. The server does not report removals, nor which key a record of
+// the query belongs to: each record received, each reconnection and each
+// snapshot interval (c.f. WatchOptions.SnapshotInterval) leads to a new
+// snapshot, compared with the previous one. As a result:
+//   - a key removed or expired is reported as KeyDelete, without RecordID,
+//     once the next snapshot is taken, i.e. up to a snapshot interval late
+//     when no other key matching keyPattern is stored meanwhile;
+//   - a key removed and stored again between snapshots is reported as
+//     KeyPut only;
+//   - when the watcher lags behind, or a key changes several times between
+//     snapshots, only its latest value is reported.
+//
+// Every snapshot loads all the matching keys, so Watch suits patterns matching
+// a moderate number of keys, such as configuration.
+func (c *Client) Watch(ctx context.Context, keyPattern string, options *WatchOptions) (<-chan KeyEvent, error) {
+	if options == nil {
+		options = new(WatchOptions)
+	}
+	w := &watcher{client: c, pattern: keyPattern, interval: options.snapshotInterval}
+	if w.interval <= 0 {
+		w.interval = defaultWatchSnapshotInterval
+	}
+	var from RecordID
+	err := c.Query(ctx, dql.Select().From(keyPattern).String(), func(r *Record) {
+		from = r.RecordID
+	})
+	if err != nil {
+		return nil, err
+	}
+	events, err := w.snapshot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	consumer := newWatchConsumer(c, c.nextConsumerID(), keyPattern, from)
+	if err := consumer.run(ctx); err != nil {
+		return nil, err
+	}
+	out := make(chan KeyEvent)
+	go w.run(ctx, consumer, events, out)
+	return out, nil
+}
+
+// watcher compares snapshots of the keys matching a pattern. It is only
+// accessed by the goroutine running Watch.
+type watcher struct {
+	client   *Client
+	pattern  string
+	interval time.Duration
+	known    map[string]RecordID
+}
+
+// run sends events to out, then a new snapshot every time the query signals a
+// change or the snapshot interval elapses, until ctx is done or the query
+// fails.
+func (w *watcher) run(ctx context.Context, consumer *watchConsumer, events []KeyEvent, out chan<- KeyEvent) {
+	defer close(out)
+	defer consumer.close()
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		for _, event := range events {
+			select {
+			case out <- event:
+			case <-ctx.Done():
+				return
+			case <-consumer.done():
+				w.fail(consumer)
+				return
+			}
+		}
+		select {
+		case <-consumer.changed:
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		case <-consumer.done():
+			w.fail(consumer)
+			return
+		}
+		var err error
+		if events, err = w.snapshot(ctx); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			if err != ErrClosed {
+				w.client.errorHandler(err)
+			}
+			// Try again later, unless a reconnection signals it first.
+			go func() {
+				select {
+				case <-time.After(watchRetryInterval):
+					consumer.signal()
+				case <-consumer.done():
+				}
+			}()
+		}
+	}
+}
+
+func (w *watcher) fail(consumer *watchConsumer) {
+	if err := consumer.err(); err != nil {
+		w.client.errorHandler(err)
+	}
+}
+
+// snapshot loads the keys matching the pattern, and returns the events that
+// tell them apart from the previous snapshot: the stored keys by RecordID,
+// then the removed ones by key.
+func (w *watcher) snapshot(ctx context.Context) ([]KeyEvent, error) {
+	var keys []string
+	if err := w.client.ListKeys(ctx, w.pattern, func(key string) { keys = append(keys, key) }); err != nil {
+		return nil, err
+	}
+	current := make(map[string]RecordID, len(keys))
+	var events []KeyEvent
+	for _, key := range keys {
+		r, err := w.client.Load(ctx, key)
+		if err == ErrKeyNotFound {
+			// Removed since it was listed.
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		current[key] = r.RecordID
+		if id, exists := w.known[key]; !exists || !bytes.Equal(id, r.RecordID) {
+			events = append(events, KeyEvent{Kind: KeyPut, Key: key, RecordID: r.RecordID, Value: r.Record})
+		}
+	}
+	sort.Slice(events, func(i, j int) bool { return bytes.Compare(events[i].RecordID, events[j].RecordID) < 0 })
+	var removed []string
+	for key := range w.known {
+		if _, exists := current[key]; !exists {
+			removed = append(removed, key)
+		}
+	}
+	sort.Strings(removed)
+	for _, key := range removed {
+		events = append(events, KeyEvent{Kind: KeyDelete, Key: key})
+	}
+	w.known = current
+	return events, nil
+}
@@ -0,0 +1,177 @@
+// Copyright 2019, 1533 Systems, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package websocket
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultCompressionThreshold = 128
+	maxWindowSize               = 1 << 15
+	rsv1                        = 0x40
+)
+
+// deflateTail terminates a compressed message: the empty stored block that
+// the sender stripped from the message, followed by a final empty block.
+var deflateTail = []byte{0x00, 0x00, 0xff, 0xff, 0x01, 0x00, 0x00, 0xff, 0xff}
+
+// deflateParams are the permessage-deflate parameters negotiated with the
+// server, c.f. RFC 7692 section 7.
+type deflateParams struct {
+	clientNoContextTakeover bool
+	serverNoContextTakeover bool
+}
+
+// deflateOffer returns the Sec-WebSocket-Extensions header offering
+// permessage-deflate. The client_max_window_bits parameter is not offered,
+// since compress/flate always uses the largest window.
+func deflateOffer(noContextTakeover bool) string {
+	if noContextTakeover {
+		return "permessage-deflate; client_no_context_takeover; server_no_context_takeover"
+	}
+	return "permessage-deflate"
+}
+
+// negotiateDeflate returns the parameters accepted by the server, or nil when
+// it declined the offer.
+func negotiateDeflate(offered bool, response *http.Response) (*deflateParams, error) {
+	var params *deflateParams
+	for _, value := range response.Header[http.CanonicalHeaderKey("Sec-WebSocket-Extensions")] {
+		for _, extension := range strings.Split(value, ",") {
+			if strings.TrimSpace(extension) == "" {
+				continue
+			}
+			parts := strings.Split(extension, ";")
+			if !offered || params != nil || strings.TrimSpace(parts[0]) != "permessage-deflate" {
+				return nil, invalidExtensions(response)
+			}
+			params = new(deflateParams)
+			for _, param := range parts[1:] {
+				name, value := strings.TrimSpace(param), ""
+				if i := strings.IndexByte(name, '='); i >= 0 {
+					name, value = strings.TrimSpace(name[:i]), strings.Trim(strings.TrimSpace(name[i+1:]), `"`)
+				}
+				switch {
+				case name == "client_no_context_takeover" && value == "":
+					params.clientNoContextTakeover = true
+				case name == "server_no_context_takeover" && value == "":
+					params.serverNoContextTakeover = true
+				case name == "server_max_window_bits" && isWindowBits(value):
+					// Decompression supports any window size.
+				default:
+					return nil, invalidExtensions(response)
+				}
+			}
+		}
+	}
+	return params, nil
+}
+
+func isWindowBits(value string) bool {
+	bits, err := strconv.Atoi(value)
+	return err == nil && bits >= 8 && bits <= 15
+}
+
+func invalidExtensions(response *http.Response) error {
+	return &HandshakeError{
+		StatusCode: response.StatusCode,
+		Status:     response.Status,
+		Reason:     "invalid Sec-WebSocket-Extensions",
+	}
+}
+
+// messageCompressor compresses the messages sent on a connection. Unless
+// noContextTakeover, messages share a single compression context.
+type messageCompressor struct {
+	w                 *flate.Writer
+	buf               bytes.Buffer
+	noContextTakeover bool
+	threshold         int
+}
+
+func newMessageCompressor(noContextTakeover bool, threshold int) *messageCompressor {
+	c := &messageCompressor{noContextTakeover: noContextTakeover, threshold: threshold}
+	c.w, _ = flate.NewWriter(&c.buf, flate.BestSpeed)
+	return c
+}
+
+// compress returns the compressed payload, which is only valid until the next
+// call.
+func (c *messageCompressor) compress(payload []byte) ([]byte, error) {
+	c.buf.Reset()
+	if c.noContextTakeover {
+		c.w.Reset(&c.buf)
+	}
+	if _, err := c.w.Write(payload); err != nil {
+		return nil, err
+	}
+	if err := c.w.Flush(); err != nil {
+		return nil, err
+	}
+	// Flush ends with an empty stored block, which the receiver adds back.
+	compressed := c.buf.Bytes()
+	return compressed[:len(compressed)-4], nil
+}
+
+// messageDecompressor decompresses the messages received on a connection.
+// Unless noContextTakeover, the last 32 KiB of decompressed data are the
+// dictionary of the next message.
+type messageDecompressor struct {
+	r                 io.ReadCloser
+	dict              []byte
+	noContextTakeover bool
+	maxMessageSize    int
+}
+
+func newMessageDecompressor(noContextTakeover bool, maxMessageSize int) *messageDecompressor {
+	return &messageDecompressor{noContextTakeover: noContextTakeover, maxMessageSize: maxMessageSize}
+}
+
+func (d *messageDecompressor) decompress(payload []byte) ([]byte, error) {
+	in := io.MultiReader(bytes.NewReader(payload), bytes.NewReader(deflateTail))
+	if d.r == nil {
+		d.r = flate.NewReaderDict(in, d.dict)
+	} else if err := d.r.(flate.Resetter).Reset(in, d.dict); err != nil {
+		return nil, err
+	}
+	message, err := ioutil.ReadAll(io.LimitReader(d.r, int64(d.maxMessageSize)+1))
+	if err != nil {
+		return nil, ErrInvalidWebSocketFrame
+	}
+	if len(message) > d.maxMessageSize {
+		return nil, ErrMessageTooLarge
+	}
+	if !d.noContextTakeover {
+		window := append(d.dict, message...)
+		if len(window) > maxWindowSize {
+			window = append([]byte(nil), window[len(window)-maxWindowSize:]...)
+		}
+		d.dict = window
+	}
+	return message, nil
+}
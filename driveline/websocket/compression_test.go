@@ -0,0 +1,145 @@
+// Copyright 2019, 1533 Systems, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package websocket
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+)
+
+func TestNegotiateDeflate(t *testing.T) {
+	response := func(extensions ...string) *http.Response {
+		r := &http.Response{StatusCode: http.StatusSwitchingProtocols, Header: make(http.Header)}
+		for _, extension := range extensions {
+			r.Header.Add("Sec-WebSocket-Extensions", extension)
+		}
+		return r
+	}
+	t.Run("when declined", func(t *testing.T) {
+		params, err := negotiateDeflate(true, response())
+		if err != nil || params != nil {
+			t.Fail()
+		}
+	})
+	t.Run("when accepted", func(t *testing.T) {
+		params, err := negotiateDeflate(true, response("permessage-deflate; client_no_context_takeover; server_max_window_bits=10"))
+		if err != nil || params == nil || !params.clientNoContextTakeover || params.serverNoContextTakeover {
+			t.Fail()
+		}
+	})
+	invalid := map[string]struct {
+		offered    bool
+		extensions []string
+	}{
+		"not offered":         {false, []string{"permessage-deflate"}},
+		"unknown extension":   {true, []string{"x-webkit-deflate-frame"}},
+		"accepted twice":      {true, []string{"permessage-deflate", "permessage-deflate"}},
+		"unknown parameter":   {true, []string{"permessage-deflate; level=9"}},
+		"client window size":  {true, []string{"permessage-deflate; client_max_window_bits=10"}},
+		"invalid window size": {true, []string{"permessage-deflate; server_max_window_bits=16"}},
+	}
+	for name, c := range invalid {
+		t.Run("rejects "+name, func(t *testing.T) {
+			_, err := negotiateDeflate(c.offered, response(c.extensions...))
+			if _, ok := err.(*HandshakeError); !ok {
+				t.Fail()
+			}
+		})
+	}
+}
+
+func TestMessageCompression(t *testing.T) {
+	messages := [][]byte{
+		bytes.Repeat([]byte("driveline "), 100),
+		bytes.Repeat([]byte("driveline "), 100),
+		[]byte("short"),
+		bytes.Repeat([]byte{0, 1, 2, 3}, 20000),
+	}
+	for _, noContextTakeover := range []bool{false, true} {
+		compressor := newMessageCompressor(noContextTakeover, 0)
+		decompressor := newMessageDecompressor(noContextTakeover, 1<<20)
+		var sizes []int
+		for _, message := range messages {
+			compressed, err := compressor.compress(message)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sizes = append(sizes, len(compressed))
+			actual, err := decompressor.decompress(compressed)
+			if err != nil || !bytes.Equal(actual, message) {
+				t.Fatalf("no context takeover %v: invalid message", noContextTakeover)
+			}
+		}
+		// The second message repeats the first one: it refers to the context.
+		if (sizes[1] < sizes[0]) == noContextTakeover {
+			t.Errorf("no context takeover %v: unexpected sizes %v", noContextTakeover, sizes)
+		}
+	}
+	t.Run("limits the size of messages", func(t *testing.T) {
+		compressed, _ := newMessageCompressor(false, 0).compress(make([]byte, 1024))
+		if _, err := newMessageDecompressor(false, 1023).decompress(compressed); err != ErrMessageTooLarge {
+			t.Fail()
+		}
+	})
+	t.Run("rejects invalid data", func(t *testing.T) {
+		if _, err := newMessageDecompressor(false, 1024).decompress([]byte{0xff, 0xff}); err != ErrInvalidWebSocketFrame {
+			t.Fail()
+		}
+	})
+}
+
+func TestCompressedFrames(t *testing.T) {
+	message := bytes.Repeat([]byte("compressed "), 50)
+	var buf bytes.Buffer
+	out := newFrameWriter(&buf, 64)
+	out.compressor = newMessageCompressor(false, 100)
+	out.writeMessage([]byte("small"))
+	out.writeMessage(message)
+	out.Flush()
+
+	fin, opCode, _, payload, _ := readTestFrame(&buf)
+	if !fin || opCode != binaryFrame || string(payload) != "small" {
+		t.Fatal("expected an uncompressed frame")
+	}
+	hdr := buf.Bytes()[0]
+	_, _, _, compressed, _ := readTestFrame(&buf)
+	if hdr&rsv1 == 0 || len(compressed) >= len(message) {
+		t.Fatal("expected a compressed frame")
+	}
+
+	// The server may fragment compressed messages.
+	first := serverFrame(false, binaryFrame, compressed[:3])
+	first[0] |= rsv1
+	r := newFrameReader(bytes.NewReader(concat(first, serverFrame(true, continuationFrame, compressed[3:]))), 64, 1024)
+	r.decompressor = newMessageDecompressor(false, 1024)
+	opCode, actual, err := r.readMessage()
+	if err != nil || opCode != binaryFrame || !bytes.Equal(actual, message) {
+		t.Fatal("expected the decompressed message")
+	}
+
+	t.Run("rejects compressed frames unless negotiated", func(t *testing.T) {
+		r := newFrameReader(bytes.NewReader(first), 64, 1024)
+		if _, _, err := r.readMessage(); err != ErrInvalidWebSocketFrame {
+			t.Fail()
+		}
+	})
+}
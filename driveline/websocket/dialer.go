@@ -0,0 +1,423 @@
+// Copyright 2019, 1533 Systems, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package websocket
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// ErrUnsupportedProxy is returned when the proxy URL has a scheme other than
+// http, https, socks5 or socks5h.
+var ErrUnsupportedProxy = errors.New("proxy URL scheme must be http, https, socks5 or socks5h")
+
+// ProxyError is returned when a proxy refuses to open a tunnel to the endpoint.
+type ProxyError struct {
+	Proxy  string
+	Reason string
+}
+
+func (e *ProxyError) Error() string {
+	return fmt.Sprintf("proxy %s: %s", e.Proxy, e.Reason)
+}
+
+// ProxyFromEnvironment returns the proxy of u from the HTTP_PROXY, HTTPS_PROXY
+// and NO_PROXY environment variables, c.f. http.ProxyFromEnvironment. It is the
+// default proxy function.
+func ProxyFromEnvironment(u *url.URL) (*url.URL, error) {
+	return http.ProxyFromEnvironment(&http.Request{URL: u})
+}
+
+// ProxyURL returns a proxy function that always returns proxy.
+func ProxyURL(proxy *url.URL) func(*url.URL) (*url.URL, error) {
+	return func(*url.URL) (*url.URL, error) {
+		return proxy, nil
+	}
+}
+
+// Dialer sets the dialer of the TCP connections, for instance to set the
+// keep-alive period or the local address. The connect timeout applies on top
+// of the timeout of the dialer. It is not used with HTTPClient.
+func Dialer(dialer *net.Dialer) Option {
+	return func(ws *webSocketOptions) {
+		ws.dialer = dialer
+	}
+}
+
+// Proxy sets the function returning the proxy of an endpoint, given as an
+// http:// or https:// URL. A nil proxy function or URL connects directly.
+// http:// and https:// proxies open a tunnel with CONNECT, socks5:// and
+// socks5h:// proxies use SOCKS5; credentials are taken from the user info of
+// the proxy URL. With socks5://, the host name of the endpoint is resolved
+// locally, with the resolver of the Dialer if any, and the proxy is given its
+// IP address. With socks5h://, the host name is given to the proxy, which
+// resolves it. It is not used with HTTPClient.
+func Proxy(proxy func(endpoint *url.URL) (*url.URL, error)) Option {
+	return func(ws *webSocketOptions) {
+		ws.proxy = proxy
+	}
+}
+
+// resolve returns address with its host name replaced by its first IP address.
+func (ws *webSocket) resolve(ctx context.Context, address string) (string, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil || net.ParseIP(host) != nil {
+		return address, err
+	}
+	resolver := net.DefaultResolver
+	if ws.dialer != nil && ws.dialer.Resolver != nil {
+		resolver = ws.dialer.Resolver
+	}
+	addrs, err := resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(addrs[0].IP.String(), port), nil
+}
+
+// connBody is the body of a handshake response read from conn. It reads from
+// r, which holds the bytes buffered after the response, and closes conn.
+type connBody struct {
+	io.Reader
+	conn net.Conn
+}
+
+func (b *connBody) Write(p []byte) (int, error) {
+	return b.conn.Write(p)
+}
+
+func (b *connBody) Close() error {
+	return b.conn.Close()
+}
+
+// roundTrip sends the handshake request on a new connection, opened through
+// the proxy if any. The body of the response is a connBody.
+func (ws *webSocket) roundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	conn, err := ws.dialEndpoint(ctx, req.URL)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	in := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(in, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusSwitchingProtocols {
+		conn.SetDeadline(time.Time{})
+		resp.Body = &connBody{Reader: in, conn: conn}
+	} else {
+		resp.Body = &connBody{Reader: resp.Body, conn: conn}
+	}
+	return resp, nil
+}
+
+// dialEndpoint opens a connection to the host of u, and runs the TLS handshake
+// for https:// URLs.
+func (ws *webSocket) dialEndpoint(ctx context.Context, u *url.URL) (net.Conn, error) {
+	var proxy *url.URL
+	if ws.proxy != nil {
+		var err error
+		if proxy, err = ws.proxy(u); err != nil {
+			return nil, err
+		}
+	}
+	address := hostPort(u)
+	var conn net.Conn
+	var err error
+	if proxy == nil {
+		conn, err = ws.dialContext(ctx, address)
+	} else {
+		conn, err = ws.dialProxy(ctx, proxy, address)
+	}
+	if err != nil || u.Scheme != "https" {
+		return conn, err
+	}
+	config, err := ws.tls.load()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsClient(ctx, conn, config, u.Hostname())
+}
+
+func (ws *webSocket) dialContext(ctx context.Context, address string) (net.Conn, error) {
+	dialer := ws.dialer
+	if dialer == nil {
+		dialer = new(net.Dialer)
+	}
+	return dialer.DialContext(ctx, "tcp", address)
+}
+
+// dialProxy opens a tunnel to address through proxy.
+func (ws *webSocket) dialProxy(ctx context.Context, proxy *url.URL, address string) (net.Conn, error) {
+	switch proxy.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, ErrUnsupportedProxy
+	}
+	if proxy.Scheme == "socks5" {
+		var err error
+		if address, err = ws.resolve(ctx, address); err != nil {
+			return nil, err
+		}
+	}
+	conn, err := ws.dialContext(ctx, hostPort(proxy))
+	if err != nil {
+		return nil, err
+	}
+	if proxy.Scheme == "https" {
+		// The proxy is verified with the system roots, not with the TLS
+		// options of the endpoint.
+		if conn, err = tlsClient(ctx, conn, new(tls.Config), proxy.Hostname()); err != nil {
+			return nil, err
+		}
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if proxy.Scheme == "http" || proxy.Scheme == "https" {
+		err = connectTunnel(conn, proxy, address)
+	} else {
+		err = socks5Tunnel(conn, proxy, address)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// connectTunnel asks an HTTP proxy to open a tunnel to address with CONNECT.
+func connectTunnel(conn net.Conn, proxy *url.URL, address string) error {
+	req := &http.Request{
+		Method: "CONNECT",
+		URL:    &url.URL{Opaque: address},
+		Host:   address,
+		Header: make(http.Header),
+	}
+	if proxy.User != nil {
+		password, _ := proxy.User.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(proxy.User.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+	if err := req.Write(conn); err != nil {
+		return err
+	}
+	// The server does not send anything before the TLS or WebSocket handshake,
+	// so nothing is lost with the buffer.
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return &ProxyError{Proxy: proxy.Host, Reason: "CONNECT failed: " + resp.Status}
+	}
+	return nil
+}
+
+// SOCKS5 constants, c.f. RFC 1928 and RFC 1929.
+const (
+	socks5Version          = 5
+	socks5NoAuth           = 0
+	socks5PasswordAuth     = 2
+	socks5NoAcceptable     = 0xff
+	socks5PasswordVersion  = 1
+	socks5Connect          = 1
+	socks5IPv4             = 1
+	socks5DomainName       = 3
+	socks5IPv6             = 4
+	socks5Succeeded        = 0
+	socks5MaxCredentialLen = 255
+)
+
+var socks5Replies = []string{
+	"succeeded",
+	"general SOCKS server failure",
+	"connection not allowed by ruleset",
+	"network unreachable",
+	"host unreachable",
+	"connection refused",
+	"TTL expired",
+	"command not supported",
+	"address type not supported",
+}
+
+// socks5Tunnel asks a SOCKS5 proxy to connect to address. Host names are
+// resolved by the proxy, c.f. Proxy for socks5:// proxies.
+func socks5Tunnel(conn net.Conn, proxy *url.URL, address string) error {
+	host, portString, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.ParseUint(portString, 10, 16)
+	if err != nil {
+		return err
+	}
+	fail := func(reason string) error {
+		return &ProxyError{Proxy: proxy.Host, Reason: reason}
+	}
+
+	method := byte(socks5NoAuth)
+	if proxy.User != nil {
+		method = socks5PasswordAuth
+	}
+	if _, err := conn.Write([]byte{socks5Version, 1, method}); err != nil {
+		return err
+	}
+	var reply [2]byte
+	if _, err := io.ReadFull(conn, reply[:]); err != nil {
+		return err
+	}
+	if reply[0] != socks5Version {
+		return fail("not a SOCKS5 proxy")
+	}
+	if reply[1] != method {
+		return fail("authentication method not accepted")
+	}
+	if method == socks5PasswordAuth {
+		username := proxy.User.Username()
+		password, _ := proxy.User.Password()
+		if len(username) > socks5MaxCredentialLen || len(password) > socks5MaxCredentialLen {
+			return fail("credentials too long")
+		}
+		auth := []byte{socks5PasswordVersion, byte(len(username))}
+		auth = append(auth, username...)
+		auth = append(auth, byte(len(password)))
+		auth = append(auth, password...)
+		if _, err := conn.Write(auth); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, reply[:]); err != nil {
+			return err
+		}
+		if reply[0] != socks5PasswordVersion {
+			return fail("invalid authentication reply")
+		}
+		if reply[1] != socks5Succeeded {
+			return fail("authentication failed")
+		}
+	}
+
+	request := []byte{socks5Version, socks5Connect, 0}
+	if ip := net.ParseIP(host); ip == nil {
+		if len(host) > 255 {
+			return fail("host name too long")
+		}
+		request = append(request, socks5DomainName, byte(len(host)))
+		request = append(request, host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		request = append(request, socks5IPv4)
+		request = append(request, ip4...)
+	} else {
+		request = append(request, socks5IPv6)
+		request = append(request, ip...)
+	}
+	request = append(request, byte(port>>8), byte(port))
+	if _, err := conn.Write(request); err != nil {
+		return err
+	}
+
+	var header [4]byte
+	if _, err := io.ReadFull(conn, header[:]); err != nil {
+		return err
+	}
+	if header[0] != socks5Version {
+		return fail("not a SOCKS5 proxy")
+	}
+	if header[1] != socks5Succeeded {
+		if int(header[1]) < len(socks5Replies) {
+			return fail(socks5Replies[header[1]])
+		}
+		return fail(fmt.Sprintf("unknown reply %d", header[1]))
+	}
+	// Skip the bound address and port.
+	var skip int
+	switch header[3] {
+	case socks5IPv4:
+		skip = net.IPv4len + 2
+	case socks5IPv6:
+		skip = net.IPv6len + 2
+	case socks5DomainName:
+		var length [1]byte
+		if _, err := io.ReadFull(conn, length[:]); err != nil {
+			return err
+		}
+		skip = int(length[0]) + 2
+	default:
+		return fail("invalid bound address")
+	}
+	_, err = io.CopyN(ioutil.Discard, conn, int64(skip))
+	return err
+}
+
+// tlsClient runs the TLS handshake on conn within the deadline of ctx.
+func tlsClient(ctx context.Context, conn net.Conn, config *tls.Config, serverName string) (net.Conn, error) {
+	if config.ServerName == "" {
+		config = config.Clone()
+		config.ServerName = serverName
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	tlsConn := tls.Client(conn, config)
+	if err := tlsConn.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return tlsConn, nil
+}
+
+// hostPort returns the host and port of u, with the default port of its scheme.
+func hostPort(u *url.URL) string {
+	if port := u.Port(); port != "" {
+		return net.JoinHostPort(u.Hostname(), port)
+	}
+	switch u.Scheme {
+	case "https":
+		return net.JoinHostPort(u.Hostname(), "443")
+	case "socks5", "socks5h":
+		return net.JoinHostPort(u.Hostname(), "1080")
+	}
+	return net.JoinHostPort(u.Hostname(), "80")
+}
@@ -0,0 +1,362 @@
+// Copyright 2019, 1533 Systems, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package websocket
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newConnectProxy returns an HTTP proxy opening CONNECT tunnels, which reports
+// the address of every tunnel. When authorization is set, it must match the
+// Proxy-Authorization header.
+func newConnectProxy(t *testing.T, authorization string) (*httptest.Server, <-chan string) {
+	tunnels := make(chan string, 4)
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "CONNECT" {
+			http.Error(w, "CONNECT required", http.StatusMethodNotAllowed)
+			return
+		}
+		if authorization != "" && r.Header.Get("Proxy-Authorization") != authorization {
+			http.Error(w, "invalid credentials", http.StatusProxyAuthRequired)
+			return
+		}
+		target, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			target.Close()
+			return
+		}
+		rw.WriteString("HTTP/1.1 200 Connection established\r\n\r\n")
+		rw.Flush()
+		tunnels <- r.Host
+		pipe(conn, target)
+	}))
+	return proxy, tunnels
+}
+
+// newSOCKS5Proxy returns a SOCKS5 proxy, which reports the address of every
+// tunnel. When username is set, the proxy requires password authentication.
+func newSOCKS5Proxy(t *testing.T, username string, password string) (net.Listener, <-chan string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tunnels := make(chan string, 4)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				address, err := acceptSOCKS5(conn, username, password)
+				if err != nil {
+					conn.Close()
+					return
+				}
+				target, err := net.Dial("tcp", address)
+				if err != nil {
+					conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+					conn.Close()
+					return
+				}
+				conn.Write([]byte{5, 0, 0, 1, 127, 0, 0, 1, 0, 0})
+				tunnels <- address
+				pipe(conn, target)
+			}()
+		}
+	}()
+	return listener, tunnels
+}
+
+func acceptSOCKS5(conn net.Conn, username string, password string) (string, error) {
+	read := func(n int) ([]byte, error) {
+		b := make([]byte, n)
+		_, err := io.ReadFull(conn, b)
+		return b, err
+	}
+	greeting, err := read(2)
+	if err != nil {
+		return "", err
+	}
+	methods, err := read(int(greeting[1]))
+	if err != nil {
+		return "", err
+	}
+	method := byte(socks5NoAuth)
+	if username != "" {
+		method = socks5PasswordAuth
+	}
+	if !strings.Contains(string(methods), string([]byte{method})) {
+		conn.Write([]byte{5, socks5NoAcceptable})
+		return "", io.EOF
+	}
+	conn.Write([]byte{5, method})
+	if method == socks5PasswordAuth {
+		header, err := read(2)
+		if err != nil {
+			return "", err
+		}
+		user, err := read(int(header[1]))
+		if err != nil {
+			return "", err
+		}
+		length, err := read(1)
+		if err != nil {
+			return "", err
+		}
+		pass, err := read(int(length[0]))
+		if err != nil {
+			return "", err
+		}
+		if string(user) != username || string(pass) != password {
+			conn.Write([]byte{1, 1})
+			return "", io.EOF
+		}
+		conn.Write([]byte{1, 0})
+	}
+	request, err := read(4)
+	if err != nil {
+		return "", err
+	}
+	var host string
+	switch request[3] {
+	case socks5IPv4:
+		ip, err := read(net.IPv4len)
+		if err != nil {
+			return "", err
+		}
+		host = net.IP(ip).String()
+	case socks5IPv6:
+		ip, err := read(net.IPv6len)
+		if err != nil {
+			return "", err
+		}
+		host = net.IP(ip).String()
+	case socks5DomainName:
+		length, err := read(1)
+		if err != nil {
+			return "", err
+		}
+		name, err := read(int(length[0]))
+		if err != nil {
+			return "", err
+		}
+		host = string(name)
+	default:
+		return "", io.EOF
+	}
+	port, err := read(2)
+	if err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
+
+func pipe(a net.Conn, b net.Conn) {
+	go func() {
+		io.Copy(a, b)
+		a.Close()
+	}()
+	io.Copy(b, a)
+	b.Close()
+}
+
+func TestDialer(t *testing.T) {
+	server, conns := newTestServer(t)
+	defer server.Close()
+	serverAddress := strings.TrimPrefix(server.URL, "http://")
+
+	connectTo := func(t *testing.T, options ...Option) {
+		ws := dialTestServer(t, server, options...)
+		defer ws.Close()
+		conn := <-conns
+		defer conn.Close()
+		conn.writeFrame(pingFrame, []byte("hello"))
+		if opCode, payload, err := conn.readFrame(); err != nil || opCode != pongFrame || string(payload) != "hello" {
+			t.Fail()
+		}
+	}
+	failWith := func(t *testing.T, options ...Option) error {
+		errs := make(chan error, 1)
+		options = append(options,
+			MaxReconnect(1),
+			ReconnectWait(time.Millisecond),
+			ErrorHandler(func(err error) { errs <- err }))
+		if _, err := New(context.Background(), "ws://"+serverAddress, options...); err != ErrMaxReconnect {
+			t.Fatal(err)
+		}
+		return <-errs
+	}
+
+	t.Run("tunnels through an HTTP proxy with CONNECT", func(t *testing.T) {
+		proxy, tunnels := newConnectProxy(t, "Basic dXNlcjpzZWNyZXQ=")
+		defer proxy.Close()
+		proxyURL, _ := url.Parse(proxy.URL)
+		proxyURL.User = url.UserPassword("user", "secret")
+		connectTo(t, Proxy(ProxyURL(proxyURL)))
+		if <-tunnels != serverAddress {
+			t.Fail()
+		}
+	})
+	t.Run("fails when the HTTP proxy refuses the tunnel", func(t *testing.T) {
+		proxy, _ := newConnectProxy(t, "Basic dXNlcjpzZWNyZXQ=")
+		defer proxy.Close()
+		proxyURL, _ := url.Parse(proxy.URL)
+		err := failWith(t, Proxy(ProxyURL(proxyURL)))
+		if proxyErr, ok := err.(*ProxyError); !ok || !strings.Contains(proxyErr.Reason, "407") {
+			t.Fail()
+		}
+	})
+	t.Run("tunnels through a SOCKS5 proxy", func(t *testing.T) {
+		proxy, tunnels := newSOCKS5Proxy(t, "", "")
+		defer proxy.Close()
+		connectTo(t, Proxy(ProxyURL(&url.URL{Scheme: "socks5", Host: proxy.Addr().String()})))
+		if <-tunnels != serverAddress {
+			t.Fail()
+		}
+	})
+	t.Run("authenticates with a SOCKS5 proxy", func(t *testing.T) {
+		proxy, tunnels := newSOCKS5Proxy(t, "user", "secret")
+		defer proxy.Close()
+		proxyURL := &url.URL{Scheme: "socks5h", Host: proxy.Addr().String(), User: url.UserPassword("user", "secret")}
+		connectTo(t, Proxy(ProxyURL(proxyURL)))
+		if <-tunnels != serverAddress {
+			t.Fail()
+		}
+		proxyURL.User = url.UserPassword("user", "wrong")
+		if _, ok := failWith(t, Proxy(ProxyURL(proxyURL))).(*ProxyError); !ok {
+			t.Fail()
+		}
+	})
+	t.Run("resolves host names locally with socks5 and at the proxy with socks5h", func(t *testing.T) {
+		proxy, tunnels := newSOCKS5Proxy(t, "", "")
+		defer proxy.Close()
+		_, port, _ := net.SplitHostPort(serverAddress)
+		for scheme, resolved := range map[string]func(string) bool{
+			"socks5":  func(host string) bool { ip := net.ParseIP(host); return ip != nil && ip.IsLoopback() },
+			"socks5h": func(host string) bool { return host == "localhost" },
+		} {
+			proxyURL := &url.URL{Scheme: scheme, Host: proxy.Addr().String()}
+			ws, err := New(context.Background(), "ws://localhost:"+port, Proxy(ProxyURL(proxyURL)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			(<-conns).Close()
+			ws.Close()
+			host, _, _ := net.SplitHostPort(<-tunnels)
+			if !resolved(host) {
+				t.Fatalf("unexpected tunnel host %s with %s", host, scheme)
+			}
+		}
+	})
+	t.Run("checks the version of the SOCKS5 authentication reply", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer listener.Close()
+		go func() {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			// Greeting, then the credentials of user:secret.
+			io.ReadFull(conn, make([]byte, 3))
+			conn.Write([]byte{5, socks5PasswordAuth})
+			io.ReadFull(conn, make([]byte, 3+len("user")+len("secret")))
+			conn.Write([]byte{5, socks5Succeeded})
+			io.Copy(ioutil.Discard, conn)
+		}()
+		proxyURL := &url.URL{Scheme: "socks5h", Host: listener.Addr().String(), User: url.UserPassword("user", "secret")}
+		err = failWith(t, Proxy(ProxyURL(proxyURL)))
+		if proxyErr, ok := err.(*ProxyError); !ok || proxyErr.Reason != "invalid authentication reply" {
+			t.Fatalf("unexpected error %v", err)
+		}
+	})
+	t.Run("rejects unsupported proxies", func(t *testing.T) {
+		proxyURL := &url.URL{Scheme: "ftp", Host: "127.0.0.1:21"}
+		if failWith(t, Proxy(ProxyURL(proxyURL))) != ErrUnsupportedProxy {
+			t.Fail()
+		}
+	})
+	t.Run("uses the dialer", func(t *testing.T) {
+		dialer := &net.Dialer{
+			KeepAlive: time.Minute,
+			LocalAddr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)},
+		}
+		dialed := make(chan string, 1)
+		proxy := func(endpoint *url.URL) (*url.URL, error) {
+			dialed <- endpoint.Host
+			return nil, nil
+		}
+		connectTo(t, Dialer(dialer), Proxy(proxy))
+		if <-dialed != serverAddress {
+			t.Fail()
+		}
+	})
+	t.Run("fails when the connect timeout expires", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer listener.Close()
+		// The proxy accepts the connection but never answers.
+		proxyURL := &url.URL{Scheme: "socks5", Host: listener.Addr().String()}
+		err = failWith(t, Proxy(ProxyURL(proxyURL)), ConnectTimeout(50*time.Millisecond))
+		if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+			t.Fail()
+		}
+	})
+}
+
+func TestHostPort(t *testing.T) {
+	for rawURL, expected := range map[string]string{
+		"http://example.com":        "example.com:80",
+		"https://example.com":       "example.com:443",
+		"socks5://example.com":      "example.com:1080",
+		"http://example.com:8080":   "example.com:8080",
+		"https://[::1]/path":        "[::1]:443",
+		"socks5h://user:pw@host:99": "host:99",
+	} {
+		u, _ := url.Parse(rawURL)
+		if hostPort(u) != expected {
+			t.Errorf("%s: %s", rawURL, hostPort(u))
+		}
+	}
+}
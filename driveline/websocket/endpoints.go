@@ -0,0 +1,195 @@
+// Copyright 2019, 1533 Systems, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package websocket
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ErrNoEndpoint is returned when there is no endpoint to connect to.
+var ErrNoEndpoint = errors.New("no endpoint to connect to")
+
+// EndpointResolver returns the endpoints of the servers. It is called before
+// every connection attempt, so that the list of servers can change over time.
+type EndpointResolver interface {
+	Resolve(ctx context.Context) ([]string, error)
+}
+
+// EndpointResolverFunc is an EndpointResolver function.
+type EndpointResolverFunc func(ctx context.Context) ([]string, error)
+
+func (f EndpointResolverFunc) Resolve(ctx context.Context) ([]string, error) {
+	return f(ctx)
+}
+
+// SRVResolver returns the endpoints of the DNS SRV records of
+// _service._proto.name, with the given URL scheme, such as ws or wss. They are
+// ordered by priority and randomized by weight.
+func SRVResolver(scheme string, service string, proto string, name string) EndpointResolver {
+	return EndpointResolverFunc(func(ctx context.Context) ([]string, error) {
+		_, records, err := net.DefaultResolver.LookupSRV(ctx, service, proto, name)
+		if err != nil {
+			return nil, err
+		}
+		endpoints := make([]string, len(records))
+		for i, record := range records {
+			host := strings.TrimSuffix(record.Target, ".")
+			endpoints[i] = scheme + "://" + net.JoinHostPort(host, strconv.Itoa(int(record.Port)))
+		}
+		return endpoints, nil
+	})
+}
+
+// FileResolver returns the endpoints listed in a file, one per line. Blank
+// lines and lines starting with # are ignored. The file is read again at every
+// connection attempt.
+func FileResolver(path string) EndpointResolver {
+	return EndpointResolverFunc(func(context.Context) ([]string, error) {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		var endpoints []string
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line != "" && !strings.HasPrefix(line, "#") {
+				endpoints = append(endpoints, line)
+			}
+		}
+		return endpoints, scanner.Err()
+	})
+}
+
+// Endpoints adds endpoints to fail over to, after the one given to New.
+func Endpoints(endpoints ...string) Option {
+	return func(ws *webSocketOptions) {
+		ws.extraEndpoints = append(ws.extraEndpoints, endpoints...)
+	}
+}
+
+// Resolver sets the resolver of the endpoints to fail over to, after the ones
+// given to New and Endpoints. When it fails, the endpoints it returned last
+// are used.
+func Resolver(resolver EndpointResolver) Option {
+	return func(ws *webSocketOptions) {
+		ws.resolver = resolver
+	}
+}
+
+type endpointHealth struct {
+	failures    int    // consecutive failed connection attempts
+	lastAttempt uint64 // sequence number of the last attempt, 0 if none
+}
+
+// endpointSet orders the endpoints for connection attempts: the ones with the
+// fewest consecutive failures first, then the ones tried least recently, so
+// that reconnecting rotates through healthy endpoints.
+type endpointSet struct {
+	static    []string
+	resolved  []string
+	endpoints []string
+	health    map[string]*endpointHealth
+	sequence  uint64
+}
+
+func newEndpointSet(static []string) *endpointSet {
+	s := &endpointSet{
+		static: static,
+		health: make(map[string]*endpointHealth),
+	}
+	s.update(nil)
+	return s
+}
+
+// update sets the resolved endpoints. The health of known endpoints is kept.
+func (s *endpointSet) update(resolved []string) {
+	s.resolved = resolved
+	s.endpoints = s.endpoints[:0]
+	health := make(map[string]*endpointHealth, len(s.static)+len(resolved))
+	for _, endpoints := range [][]string{s.static, resolved} {
+		for _, endpoint := range endpoints {
+			if endpoint == "" || health[endpoint] != nil {
+				continue
+			}
+			h := s.health[endpoint]
+			if h == nil {
+				h = new(endpointHealth)
+			}
+			health[endpoint] = h
+			s.endpoints = append(s.endpoints, endpoint)
+		}
+	}
+	s.health = health
+}
+
+// next returns the endpoint of the next connection attempt.
+func (s *endpointSet) next() (string, error) {
+	if len(s.endpoints) == 0 {
+		return "", ErrNoEndpoint
+	}
+	best := s.endpoints[0]
+	for _, endpoint := range s.endpoints[1:] {
+		h, b := s.health[endpoint], s.health[best]
+		if h.failures < b.failures || (h.failures == b.failures && h.lastAttempt < b.lastAttempt) {
+			best = endpoint
+		}
+	}
+	s.sequence++
+	s.health[best].lastAttempt = s.sequence
+	return best, nil
+}
+
+func (s *endpointSet) failed(endpoint string) {
+	if h := s.health[endpoint]; h != nil {
+		h.failures++
+	}
+}
+
+func (s *endpointSet) succeeded(endpoint string) {
+	if h := s.health[endpoint]; h != nil {
+		h.failures = 0
+	}
+}
+
+// nextEndpoint resolves the endpoints when there is a resolver, and returns
+// the endpoint of the next connection attempt. Resolution errors are reported
+// to the error handler.
+func (ws *webSocket) nextEndpoint(ctx context.Context) (string, error) {
+	if ws.resolver != nil {
+		ctx, cancel := context.WithTimeout(ctx, ws.connectTimeout)
+		resolved, err := ws.resolver.Resolve(ctx)
+		cancel()
+		if err != nil {
+			ws.errorHandler(err)
+		} else {
+			ws.endpoints.update(resolved)
+		}
+	}
+	return ws.endpoints.next()
+}
@@ -0,0 +1,171 @@
+// Copyright 2019, 1533 Systems, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package websocket
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestEndpointSet(t *testing.T) {
+	attempts := func(s *endpointSet, n int) []string {
+		var endpoints []string
+		for i := 0; i < n; i++ {
+			endpoint, err := s.next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			endpoints = append(endpoints, endpoint)
+		}
+		return endpoints
+	}
+
+	t.Run("rotates through healthy endpoints", func(t *testing.T) {
+		s := newEndpointSet([]string{"ws://a", "ws://b", "ws://c"})
+		if !reflect.DeepEqual(attempts(s, 4), []string{"ws://a", "ws://b", "ws://c", "ws://a"}) {
+			t.Fail()
+		}
+	})
+	t.Run("prefers endpoints with fewer failures", func(t *testing.T) {
+		s := newEndpointSet([]string{"ws://a", "ws://b", "ws://c"})
+		s.failed("ws://a")
+		s.failed("ws://a")
+		s.failed("ws://b")
+		if !reflect.DeepEqual(attempts(s, 2), []string{"ws://c", "ws://c"}) {
+			t.Fail()
+		}
+		s.failed("ws://c")
+		s.failed("ws://c")
+		if !reflect.DeepEqual(attempts(s, 1), []string{"ws://b"}) {
+			t.Fail()
+		}
+		s.succeeded("ws://a")
+		if !reflect.DeepEqual(attempts(s, 1), []string{"ws://a"}) {
+			t.Fail()
+		}
+	})
+	t.Run("keeps the health of resolved endpoints", func(t *testing.T) {
+		s := newEndpointSet([]string{"ws://a"})
+		s.update([]string{"ws://b", "ws://a", "ws://c"})
+		if !reflect.DeepEqual(s.endpoints, []string{"ws://a", "ws://b", "ws://c"}) {
+			t.Fail()
+		}
+		s.failed("ws://b")
+		s.update([]string{"ws://c", "ws://b"})
+		if s.health["ws://b"].failures != 1 {
+			t.Fail()
+		}
+		if !reflect.DeepEqual(attempts(s, 2), []string{"ws://a", "ws://c"}) {
+			t.Fail()
+		}
+	})
+	t.Run("fails without endpoints", func(t *testing.T) {
+		s := newEndpointSet([]string{""})
+		if _, err := s.next(); err != ErrNoEndpoint {
+			t.Fail()
+		}
+	})
+}
+
+func TestFileResolver(t *testing.T) {
+	dir, err := ioutil.TempDir("", "endpoints")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "endpoints")
+	content := "# Driveline nodes\nws://a:8080\n\n  wss://b:8443  \n"
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	endpoints, err := FileResolver(path).Resolve(context.Background())
+	if err != nil || !reflect.DeepEqual(endpoints, []string{"ws://a:8080", "wss://b:8443"}) {
+		t.Fail()
+	}
+	if _, err := FileResolver(filepath.Join(dir, "missing")).Resolve(context.Background()); !os.IsNotExist(err) {
+		t.Fail()
+	}
+}
+
+func TestFailover(t *testing.T) {
+	down := httptest.NewServer(nil)
+	down.Close()
+
+	t.Run("fails over to the next endpoint", func(t *testing.T) {
+		server, conns := newTestServer(t)
+		defer server.Close()
+		ws := dialTestServer(t, down, Endpoints("ws"+strings.TrimPrefix(server.URL, "http")))
+		defer ws.Close()
+		conn := <-conns
+		conn.Close()
+		// The connection is lost: the client reconnects to the healthy endpoint.
+		conn = <-conns
+		conn.Close()
+	})
+	t.Run("rotates endpoints on reconnect", func(t *testing.T) {
+		first, firstConns := newTestServer(t)
+		defer first.Close()
+		second, secondConns := newTestServer(t)
+		defer second.Close()
+		secondEndpoint := "ws" + strings.TrimPrefix(second.URL, "http")
+		attempts := make(chan string, 8)
+		ws := dialTestServer(t, first, Endpoints(secondEndpoint), OnAttempt(func(endpoint string, attempt int) {
+			attempts <- endpoint + "#" + strconv.Itoa(attempt)
+		}))
+		defer ws.Close()
+		(<-firstConns).Close()
+		(<-secondConns).Close()
+		(<-firstConns).Close()
+		firstEndpoint := "ws" + strings.TrimPrefix(first.URL, "http")
+		for _, expected := range []string{firstEndpoint + "#0", secondEndpoint + "#1", firstEndpoint + "#1"} {
+			if attempt := <-attempts; attempt != expected {
+				t.Errorf("expected attempt %s, got %s", expected, attempt)
+			}
+		}
+	})
+	t.Run("uses the resolver", func(t *testing.T) {
+		server, conns := newTestServer(t)
+		defer server.Close()
+		errs := make(chan error, 8)
+		resolved := make(chan struct{}, 8)
+		resolver := EndpointResolverFunc(func(context.Context) ([]string, error) {
+			resolved <- struct{}{}
+			if len(resolved) == 1 {
+				return nil, errors.New("resolver failure")
+			}
+			return []string{"ws" + strings.TrimPrefix(server.URL, "http")}, nil
+		})
+		ws := dialTestServer(t, down, Resolver(resolver), ErrorHandler(func(err error) { errs <- err }))
+		defer ws.Close()
+		(<-conns).Close()
+		if err := <-errs; err.Error() != "resolver failure" {
+			t.Fail()
+		}
+	})
+}
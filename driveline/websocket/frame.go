@@ -0,0 +1,393 @@
+// Copyright 2019, 1533 Systems, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"unicode/utf8"
+)
+
+var ErrMessageTooLarge = errors.New("WebSocket message too large")
+
+// Status codes of Close frames, c.f. RFC 6455 section 7.4.1.
+const (
+	CloseNormalClosure      = 1000
+	CloseGoingAway          = 1001
+	CloseProtocolError      = 1002
+	CloseUnsupportedData    = 1003
+	CloseNoStatusReceived   = 1005
+	CloseAbnormalClosure    = 1006
+	CloseInvalidPayload     = 1007
+	ClosePolicyViolation    = 1008
+	CloseMessageTooBig      = 1009
+	CloseMandatoryExtension = 1010
+	CloseInternalError      = 1011
+)
+
+const (
+	maxControlFrameSize = 125
+	maskChunkSize       = 4096
+)
+
+// CloseError is returned when the server closes the connection with a Close
+// frame. Code is CloseNoStatusReceived when the frame carries no status code.
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("WebSocket closed with status %d", e.Code)
+	}
+	return fmt.Sprintf("WebSocket closed with status %d: %s", e.Code, e.Reason)
+}
+
+// maxPooledFrameSize is the capacity above which frame buffers are not pooled,
+// so that a few large messages do not pin memory.
+const maxPooledFrameSize = 1024 * 1024
+
+// frameBuffers holds the buffers of borrowed messages, shared by connections.
+var frameBuffers = sync.Pool{
+	New: func() interface{} { return new(frameBuffer) },
+}
+
+type frameBuffer struct {
+	buf []byte
+}
+
+// frameReader reads the frames sent by the server, reassembling fragmented
+// messages up to maxMessageSize bytes.
+type frameReader struct {
+	in             *bufio.Reader
+	header         [8]byte
+	maxMessageSize int
+	message        []byte
+	messageOpCode  frameOpCode
+	fragmented     bool
+	compressed     bool
+	decompressor   *messageDecompressor // nil unless permessage-deflate was negotiated
+	borrow         bool                 // read data frames into pooled buffers
+	buffer         *frameBuffer         // pooled buffer of the last data frame, if any
+}
+
+func newFrameReader(in io.Reader, bufferSize int, maxMessageSize int) *frameReader {
+	return &frameReader{
+		in:             bufio.NewReaderSize(in, bufferSize),
+		maxMessageSize: maxMessageSize,
+	}
+}
+
+// readMessage returns the next complete data message. Control frames may be
+// interleaved with the fragments of a message, and are returned as they arrive.
+func (r *frameReader) readMessage() (frameOpCode, []byte, error) {
+	for {
+		fin, compressed, opCode, payload, err := r.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		if opCode >= closeFrame {
+			return opCode, payload, nil
+		}
+		if (opCode == continuationFrame) != r.fragmented {
+			return 0, nil, ErrInvalidWebSocketFrame
+		}
+		if opCode != continuationFrame {
+			if fin {
+				return r.decompress(opCode, compressed, payload)
+			}
+			if r.borrow {
+				// The pooled buffer is reused by the next fragment.
+				payload = append([]byte(nil), payload...)
+			}
+			r.messageOpCode = opCode
+			r.message = payload
+			r.fragmented = true
+			r.compressed = compressed
+			continue
+		}
+		r.message = append(r.message, payload...)
+		if fin {
+			message := r.message
+			r.message = nil
+			r.fragmented = false
+			return r.decompress(r.messageOpCode, r.compressed, message)
+		}
+	}
+}
+
+func (r *frameReader) decompress(opCode frameOpCode, compressed bool, message []byte) (frameOpCode, []byte, error) {
+	if !compressed {
+		return opCode, message, nil
+	}
+	message, err := r.decompressor.decompress(message)
+	if err != nil {
+		return 0, nil, err
+	}
+	return opCode, message, nil
+}
+
+// readFrame returns the FIN and RSV1 bits, the op code and the payload of the
+// next frame.
+func (r *frameReader) readFrame() (bool, bool, frameOpCode, []byte, error) {
+	hdr := &r.header
+	if _, err := io.ReadFull(r.in, hdr[:2]); err != nil {
+		return false, false, 0, nil, err
+	}
+	fin := hdr[0]&0x80 != 0
+	compressed := hdr[0]&rsv1 != 0
+	opCode := frameOpCode(hdr[0] & 0x0F)
+	isMasked := hdr[1]&0x80 != 0
+	// Servers must not mask frames. RSV1 marks the first frame of compressed
+	// messages, when permessage-deflate was negotiated.
+	if isMasked || hdr[0]&0x30 != 0 {
+		return false, false, 0, nil, ErrInvalidWebSocketFrame
+	}
+	if compressed && (r.decompressor == nil || (opCode != textFrame && opCode != binaryFrame)) {
+		return false, false, 0, nil, ErrInvalidWebSocketFrame
+	}
+
+	frameLen := uint64(hdr[1] & 0x7F)
+	switch frameLen {
+	case 126:
+		if _, err := io.ReadFull(r.in, hdr[:2]); err != nil {
+			return false, false, 0, nil, err
+		}
+		frameLen = uint64(binary.BigEndian.Uint16(hdr[:2]))
+	case 127:
+		if _, err := io.ReadFull(r.in, hdr[:8]); err != nil {
+			return false, false, 0, nil, err
+		}
+		frameLen = binary.BigEndian.Uint64(hdr[:8])
+	}
+
+	switch opCode {
+	case continuationFrame, textFrame, binaryFrame:
+		if frameLen > uint64(r.maxMessageSize-len(r.message)) {
+			return false, false, 0, nil, ErrMessageTooLarge
+		}
+	case closeFrame, pingFrame, pongFrame:
+		if !fin || frameLen > maxControlFrameSize {
+			return false, false, 0, nil, ErrInvalidWebSocketFrame
+		}
+	default:
+		return false, false, 0, nil, ErrInvalidWebSocketFrame
+	}
+
+	var payload []byte
+	if r.borrow && opCode < closeFrame {
+		payload = r.borrowPayload(int(frameLen))
+	} else {
+		payload = make([]byte, frameLen)
+	}
+	if _, err := io.ReadFull(r.in, payload); err != nil {
+		return false, false, 0, nil, err
+	}
+	return fin, compressed, opCode, payload, nil
+}
+
+// borrowPayload returns size bytes of a pooled buffer, valid until release.
+func (r *frameReader) borrowPayload(size int) []byte {
+	if r.buffer == nil {
+		r.buffer = frameBuffers.Get().(*frameBuffer)
+	}
+	if cap(r.buffer.buf) < size {
+		r.buffer.buf = make([]byte, size)
+	}
+	return r.buffer.buf[:size]
+}
+
+// release returns the pooled buffer of the last message, once handled.
+func (r *frameReader) release() {
+	if r.buffer == nil {
+		return
+	}
+	if cap(r.buffer.buf) > maxPooledFrameSize {
+		r.buffer.buf = nil
+	}
+	frameBuffers.Put(r.buffer)
+	r.buffer = nil
+}
+
+// frameWriter writes client frames, masked with a random key.
+type frameWriter struct {
+	*bufio.Writer
+	header     [14]byte
+	scratch    [maskChunkSize]byte
+	compressor *messageCompressor // nil unless permessage-deflate was negotiated
+}
+
+func newFrameWriter(out io.Writer, bufferSize int) *frameWriter {
+	return &frameWriter{Writer: bufio.NewWriterSize(out, bufferSize)}
+}
+
+// writeMessage writes a binary message, compressed when permessage-deflate was
+// negotiated and the message is not smaller than the compression threshold.
+func (w *frameWriter) writeMessage(payload []byte) error {
+	if w.compressor == nil || len(payload) < w.compressor.threshold {
+		return w.writeFrame(binaryFrame, payload)
+	}
+	compressed, err := w.compressor.compress(payload)
+	if err != nil {
+		return err
+	}
+	return w.writeFrameFlags(rsv1, binaryFrame, compressed)
+}
+
+func (w *frameWriter) writeFrame(opCode frameOpCode, payload []byte) error {
+	return w.writeFrameFlags(0, opCode, payload)
+}
+
+func (w *frameWriter) writeFrameFlags(flags byte, opCode frameOpCode, payload []byte) error {
+	key, err := w.writeHeader(flags, opCode, len(payload))
+	if err != nil {
+		return err
+	}
+	// The payload belongs to the caller: it is masked chunk by chunk in scratch.
+	for pos := 0; pos < len(payload); {
+		chunk := w.scratch[:copy(w.scratch[:], payload[pos:])]
+		maskBytes(key, pos, chunk)
+		if _, err := w.Write(chunk); err != nil {
+			return err
+		}
+		pos += len(chunk)
+	}
+	return nil
+}
+
+// writeOwnedMessage is like writeMessage, but masks payload in place instead
+// of copying it, as the caller gives it up.
+func (w *frameWriter) writeOwnedMessage(payload []byte) error {
+	if w.compressor != nil && len(payload) >= w.compressor.threshold {
+		return w.writeMessage(payload)
+	}
+	key, err := w.writeHeader(0, binaryFrame, len(payload))
+	if err != nil {
+		return err
+	}
+	maskBytes(key, 0, payload)
+	_, err = w.Write(payload)
+	return err
+}
+
+// writeHeader writes the header of a masked frame, and returns its key.
+func (w *frameWriter) writeHeader(flags byte, opCode frameOpCode, length int) ([4]byte, error) {
+	hdr := w.header[:]
+	l := uint64(length)
+
+	hdr[0] = 0x80 | flags | byte(opCode)
+	n := 2
+	switch {
+	case l < 126:
+		hdr[1] = byte(l)
+	case l < 0x10000:
+		hdr[1] = 126
+		binary.BigEndian.PutUint16(hdr[2:], uint16(l))
+		n = 4
+	default:
+		hdr[1] = 127
+		binary.BigEndian.PutUint64(hdr[2:], l)
+		n = 10
+	}
+	hdr[1] |= 0x80
+	key, err := newMaskKey()
+	if err != nil {
+		return key, err
+	}
+	n += copy(hdr[n:], key[:])
+	_, err = w.Write(hdr[:n])
+	return key, err
+}
+
+var maskKeys = struct {
+	sync.Mutex
+	source *bufio.Reader
+	key    [4]byte // read under the lock, so that it does not escape
+}{source: bufio.NewReaderSize(rand.Reader, 4096)}
+
+// newMaskKey returns a masking key from a cryptographically strong source, as
+// required by RFC 6455 section 5.3.
+func newMaskKey() ([4]byte, error) {
+	maskKeys.Lock()
+	_, err := io.ReadFull(maskKeys.source, maskKeys.key[:])
+	key := maskKeys.key
+	maskKeys.Unlock()
+	return key, err
+}
+
+// maskBytes masks b in place, b starting at offset pos of the payload.
+func maskBytes(key [4]byte, pos int, b []byte) {
+	for i := range b {
+		b[i] ^= key[(pos+i)&3]
+	}
+}
+
+// encodeClose returns the payload of a Close frame. A zero code returns an
+// empty payload.
+func encodeClose(code int, reason string) []byte {
+	if code == 0 {
+		return nil
+	}
+	if len(reason) > maxControlFrameSize-2 {
+		reason = reason[:maxControlFrameSize-2]
+	}
+	payload := make([]byte, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	copy(payload[2:], reason)
+	return payload
+}
+
+// decodeClose decodes the payload of a Close frame received from the server.
+// Invalid payloads are reported as a protocol error.
+func decodeClose(payload []byte) *CloseError {
+	switch {
+	case len(payload) == 0:
+		return &CloseError{Code: CloseNoStatusReceived}
+	case len(payload) == 1:
+		return &CloseError{Code: CloseProtocolError, Reason: "invalid Close frame"}
+	}
+	code := int(binary.BigEndian.Uint16(payload))
+	if !isValidCloseCode(code) {
+		return &CloseError{Code: CloseProtocolError, Reason: fmt.Sprintf("invalid status code %d", code)}
+	}
+	if !utf8.Valid(payload[2:]) {
+		return &CloseError{Code: CloseInvalidPayload, Reason: "invalid Close reason"}
+	}
+	return &CloseError{Code: code, Reason: string(payload[2:])}
+}
+
+// isValidCloseCode tells whether a status code may be sent in a Close frame.
+func isValidCloseCode(code int) bool {
+	switch {
+	case code >= CloseNormalClosure && code <= CloseUnsupportedData:
+		return true
+	case code >= CloseInvalidPayload && code <= CloseInternalError:
+		return true
+	default:
+		return code >= 3000 && code <= 4999
+	}
+}
@@ -0,0 +1,210 @@
+// Copyright 2019, 1533 Systems, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package websocket
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestFrameWriter(t *testing.T) {
+	for _, size := range []int{0, 125, 126, 0xFFFF, 0x10000, maskChunkSize*2 + 3} {
+		payload := bytes.Repeat([]byte{0xA5}, size)
+		var buf bytes.Buffer
+		out := newFrameWriter(&buf, 16)
+		if err := out.writeFrame(binaryFrame, payload); err != nil || out.Flush() != nil {
+			t.Fatal(err)
+		}
+		fin, opCode, masked, actual, err := readTestFrame(&buf)
+		if err != nil || !fin || opCode != binaryFrame || !masked {
+			t.Fatalf("size %d: invalid frame", size)
+		}
+		if !bytes.Equal(actual, payload) {
+			t.Fatalf("size %d: invalid payload", size)
+		}
+		if !bytes.Equal(payload, bytes.Repeat([]byte{0xA5}, size)) {
+			t.Fatalf("size %d: payload was modified", size)
+		}
+	}
+	t.Run("masks owned messages in place", func(t *testing.T) {
+		payload := bytes.Repeat([]byte{0xA5}, maskChunkSize*2+3)
+		var buf bytes.Buffer
+		out := newFrameWriter(&buf, 16)
+		if err := out.writeOwnedMessage(payload); err != nil || out.Flush() != nil {
+			t.Fatal(err)
+		}
+		fin, opCode, masked, actual, err := readTestFrame(&buf)
+		if err != nil || !fin || opCode != binaryFrame || !masked {
+			t.Fatal("invalid frame")
+		}
+		if !bytes.Equal(actual, bytes.Repeat([]byte{0xA5}, len(payload))) {
+			t.Fail()
+		}
+	})
+	t.Run("uses a new key per frame", func(t *testing.T) {
+		var buf bytes.Buffer
+		out := newFrameWriter(&buf, 64)
+		out.writeFrame(binaryFrame, nil)
+		out.writeFrame(binaryFrame, nil)
+		out.Flush()
+		frames := buf.Bytes()
+		if len(frames) != 12 || bytes.Equal(frames[2:6], frames[8:12]) {
+			t.Fail()
+		}
+	})
+}
+
+func TestFrameReader(t *testing.T) {
+	t.Run("reassembles fragmented messages", func(t *testing.T) {
+		in := bytes.NewReader(concat(
+			serverFrame(false, binaryFrame, []byte("hel")),
+			serverFrame(true, pingFrame, []byte("ping")),
+			serverFrame(false, continuationFrame, []byte("lo ")),
+			serverFrame(true, continuationFrame, []byte("world")),
+			serverFrame(true, binaryFrame, []byte("next")),
+		))
+		r := newFrameReader(in, 64, 1024)
+		expected := []struct {
+			opCode  frameOpCode
+			message string
+		}{
+			{pingFrame, "ping"},
+			{binaryFrame, "hello world"},
+			{binaryFrame, "next"},
+		}
+		for _, e := range expected {
+			opCode, message, err := r.readMessage()
+			if err != nil || opCode != e.opCode || string(message) != e.message {
+				t.Fatalf("expected %q, got %q (%v)", e.message, message, err)
+			}
+		}
+	})
+	t.Run("reuses pooled buffers when borrowing", func(t *testing.T) {
+		in := bytes.NewReader(concat(
+			serverFrame(true, binaryFrame, []byte("first")),
+			serverFrame(false, binaryFrame, []byte("frag")),
+			serverFrame(true, continuationFrame, []byte("mented")),
+			serverFrame(true, binaryFrame, []byte("third")),
+		))
+		r := newFrameReader(in, 64, 1024)
+		r.borrow = true
+		_, first, err := r.readMessage()
+		if err != nil || string(first) != "first" {
+			t.Fatal(err)
+		}
+		r.release()
+		if r.buffer != nil {
+			t.Fail()
+		}
+		_, message, err := r.readMessage()
+		if err != nil || string(message) != "fragmented" {
+			t.Fatalf("got %q (%v)", message, err)
+		}
+		r.release()
+		_, message, err = r.readMessage()
+		if err != nil || string(message) != "third" {
+			t.Fatal(err)
+		}
+		if r.buffer == nil || &r.buffer.buf[0] != &message[0] {
+			t.Error("expected a pooled buffer")
+		}
+		r.release()
+	})
+	t.Run("limits the size of messages", func(t *testing.T) {
+		in := bytes.NewReader(concat(
+			serverFrame(false, binaryFrame, make([]byte, 6)),
+			serverFrame(true, continuationFrame, make([]byte, 6)),
+		))
+		if _, _, err := newFrameReader(in, 64, 10).readMessage(); err != ErrMessageTooLarge {
+			t.Fail()
+		}
+	})
+	invalid := map[string][]byte{
+		"masked frame":             maskedFrame(serverFrame(true, binaryFrame, []byte("x"))),
+		"unexpected continuation":  serverFrame(true, continuationFrame, []byte("x")),
+		"interrupted message":      concat(serverFrame(false, binaryFrame, []byte("x")), serverFrame(true, binaryFrame, []byte("y"))),
+		"fragmented control frame": serverFrame(false, pingFrame, []byte("x")),
+		"large control frame":      serverFrame(true, pingFrame, make([]byte, 126)),
+		"reserved bits":            {0xC2, 0},
+		"reserved op code":         serverFrame(true, frameOpCode(0x03), nil),
+	}
+	for name, frames := range invalid {
+		t.Run("rejects "+name, func(t *testing.T) {
+			_, _, err := newFrameReader(bytes.NewReader(frames), 64, 1024).readMessage()
+			if err != ErrInvalidWebSocketFrame {
+				t.Fail()
+			}
+		})
+	}
+}
+
+func TestDecodeClose(t *testing.T) {
+	cases := []struct {
+		payload  []byte
+		expected CloseError
+	}{
+		{nil, CloseError{Code: CloseNoStatusReceived}},
+		{encodeClose(CloseGoingAway, "bye"), CloseError{Code: CloseGoingAway, Reason: "bye"}},
+		{encodeClose(4000, ""), CloseError{Code: 4000}},
+		{[]byte{3}, CloseError{Code: CloseProtocolError, Reason: "invalid Close frame"}},
+		{encodeClose(CloseAbnormalClosure, ""), CloseError{Code: CloseProtocolError, Reason: "invalid status code 1006"}},
+		{encodeClose(CloseNormalClosure, "\xff"), CloseError{Code: CloseInvalidPayload, Reason: "invalid Close reason"}},
+	}
+	for _, c := range cases {
+		if actual := decodeClose(c.payload); *actual != c.expected {
+			t.Errorf("expected %v, got %v", c.expected, *actual)
+		}
+	}
+}
+
+// serverFrame encodes an unmasked frame, as sent by a server.
+func serverFrame(fin bool, opCode frameOpCode, payload []byte) []byte {
+	var hdr [10]byte
+	hdr[0] = byte(opCode)
+	if fin {
+		hdr[0] |= 0x80
+	}
+	n := 2
+	switch l := len(payload); {
+	case l < 126:
+		hdr[1] = byte(l)
+	case l < 0x10000:
+		hdr[1] = 126
+		binary.BigEndian.PutUint16(hdr[2:], uint16(l))
+		n = 4
+	default:
+		hdr[1] = 127
+		binary.BigEndian.PutUint64(hdr[2:], uint64(l))
+		n = 10
+	}
+	return append(hdr[:n:n], payload...)
+}
+
+// maskedFrame sets the mask bit of a short frame, with a zero key.
+func maskedFrame(frame []byte) []byte {
+	masked := append([]byte{frame[0], frame[1] | 0x80, 0, 0, 0, 0}, frame[2:]...)
+	return masked
+}
+
+func concat(frames ...[]byte) []byte {
+	return bytes.Join(frames, nil)
+}
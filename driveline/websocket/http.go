@@ -22,8 +22,13 @@ package websocket
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
 	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"net/url"
 	"strings"
@@ -37,19 +42,55 @@ var (
 	ErrInvalidProtocolScheme = errors.New("URL scheme must be ws or wss")
 )
 
+const (
+	webSocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	subprotocol   = "driveline"
+
+	maxHandshakeErrorBody = 4096
+)
+
+// HandshakeError is returned when the server does not accept the WebSocket
+// handshake. StatusCode, Status and Body are those of the HTTP response; Body
+// is truncated to 4 KiB.
+type HandshakeError struct {
+	StatusCode int
+	Status     string
+	Body       []byte
+	Reason     string
+}
+
+func (e *HandshakeError) Error() string {
+	if len(e.Body) == 0 {
+		return fmt.Sprintf("WebSocket handshake failed: %s (%s)", e.Reason, e.Status)
+	}
+	return fmt.Sprintf("WebSocket handshake failed: %s (%s): %s", e.Reason, e.Status, e.Body)
+}
+
 func (o *webSocketOptions) configureHTTP() {
-	o.httpClient = http.DefaultClient
+	o.proxy = ProxyFromEnvironment
 	o.httpHeaders = make(http.Header)
 	o.httpHeaders.Set("Connection", "Upgrade")
 	o.httpHeaders.Set("Upgrade", "websocket")
-	o.httpHeaders.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
-	o.httpHeaders.Set("Sec-WebSocket-Protocol", "driveline")
+	o.httpHeaders.Set("Sec-WebSocket-Protocol", subprotocol)
 	o.httpHeaders.Set("Sec-WebSocket-Version", "13")
 	o.httpHeaders.Set("User-Agent", "driveline/"+bininfo.VERSION+" go")
 }
 
+// connect runs the HTTP handshake. When the server rejects the credentials of
+// the authenticator, they are refreshed and the handshake is retried once.
 func (ws *webSocket) connect(endpoint string) error {
-	u, err := url.Parse(ws.endpoint)
+	err := ws.dial(endpoint, false)
+	if handshakeErr, ok := err.(*HandshakeError); ok && ws.authenticate != nil {
+		switch handshakeErr.StatusCode {
+		case http.StatusUnauthorized, http.StatusForbidden:
+			err = ws.dial(endpoint, true)
+		}
+	}
+	return err
+}
+
+func (ws *webSocket) dial(endpoint string, refresh bool) error {
+	u, err := url.Parse(endpoint)
 	if err != nil {
 		return err
 	}
@@ -67,38 +108,124 @@ func (ws *webSocket) connect(endpoint string) error {
 	if err != nil {
 		return err
 	}
-	req.Header = ws.httpHeaders
-	ctx, _ := context.WithTimeout(context.Background(), ws.connectTimeout)
+	key, err := newHandshakeKey()
+	if err != nil {
+		return err
+	}
+	req.Header = make(http.Header, len(ws.httpHeaders)+1)
+	for k, v := range ws.httpHeaders {
+		req.Header[k] = append([]string(nil), v...)
+	}
+	req.Header.Set("Sec-WebSocket-Key", key)
+	if ws.compression {
+		req.Header.Set("Sec-WebSocket-Extensions", deflateOffer(ws.compressionNoContextTakeover))
+	}
+	if ws.authenticate != nil {
+		if err := ws.authenticateRequest(req, refresh); err != nil {
+			return err
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), ws.connectTimeout)
+	defer cancel()
 	req = req.WithContext(ctx)
-	resp, err := ws.httpClient.Do(req)
+	var resp *http.Response
+	if ws.httpClient != nil {
+		resp, err = ws.httpClient.Do(req)
+	} else {
+		resp, err = ws.roundTrip(req)
+	}
 	if err != nil {
 		return err
 	}
 
 	if err := verifyHandshake(req, resp); err != nil {
+		resp.Body.Close()
+		return err
+	}
+	if ws.deflate, err = negotiateDeflate(ws.compression, resp); err != nil {
+		resp.Body.Close()
 		return err
 	}
 	ok := true
 	ws.cnx, ok = resp.Body.(io.ReadWriteCloser)
 	if !ok {
+		resp.Body.Close()
 		return ErrHandshake
 	}
 	return nil
 }
 
-func verifyHandshake(_ *http.Request, response *http.Response) error {
+// authenticateRequest lets the authenticator add its credentials to req, within
+// the connect timeout.
+func (ws *webSocket) authenticateRequest(req *http.Request, refresh bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), ws.connectTimeout)
+	defer cancel()
+	return ws.authenticate(ctx, req, refresh)
+}
+
+func verifyHandshake(request *http.Request, response *http.Response) error {
+	fail := func(reason string) error {
+		err := &HandshakeError{
+			StatusCode: response.StatusCode,
+			Status:     response.Status,
+			Reason:     reason,
+		}
+		if response.StatusCode != http.StatusSwitchingProtocols {
+			err.Body, _ = ioutil.ReadAll(io.LimitReader(response.Body, maxHandshakeErrorBody))
+		}
+		return err
+	}
 	if response.StatusCode != http.StatusSwitchingProtocols {
-		return ErrHandshake
+		return fail("unexpected HTTP status")
 	}
-	if strings.ToLower(response.Header.Get("Connection")) != "upgrade" {
-		return ErrHandshake
+	if !headerContains(response.Header, "Connection", "upgrade") {
+		return fail("missing Connection: Upgrade")
 	}
-	if strings.ToLower(response.Header.Get("Upgrade")) != "websocket" {
-		return ErrHandshake
+	if !headerContains(response.Header, "Upgrade", "websocket") {
+		return fail("missing Upgrade: websocket")
+	}
+	if response.Header.Get("Sec-WebSocket-Accept") != acceptKey(request.Header.Get("Sec-WebSocket-Key")) {
+		return fail("invalid Sec-WebSocket-Accept")
+	}
+	protocol := response.Header.Get("Sec-WebSocket-Protocol")
+	if protocol == "" || !headerContains(request.Header, "Sec-WebSocket-Protocol", protocol) {
+		return fail("subprotocol not negotiated")
 	}
 	return nil
 }
 
+// newHandshakeKey returns a random Sec-WebSocket-Key, c.f. RFC 6455 section 4.1.
+func newHandshakeKey() (string, error) {
+	var nonce [16]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(nonce[:]), nil
+}
+
+// acceptKey returns the Sec-WebSocket-Accept value expected for key.
+func acceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + webSocketGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// headerContains tells whether the comma-separated values of a header contain
+// token, ignoring case.
+func headerContains(h http.Header, name string, token string) bool {
+	for _, value := range h[http.CanonicalHeaderKey(name)] {
+		for _, v := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(v), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// HTTPClient sends the handshake with c instead of dialing the connection
+// directly. The Dialer and Proxy options are then not used, and c must return
+// a writable body for the upgraded connection.
 func HTTPClient(c *http.Client) Option {
 	return func(o *webSocketOptions) {
 		o.httpClient = c
@@ -115,6 +242,16 @@ func HTTPHeaders(header http.Header) Option {
 	}
 }
 
+// Authenticate sets a function adding credentials, such as an Authorization
+// header or query parameters, to the request of every handshake. When the
+// server answers 401 or 403, it is called again with refresh set, and the
+// handshake is retried once.
+func Authenticate(authenticate func(ctx context.Context, request *http.Request, refresh bool) error) Option {
+	return func(o *webSocketOptions) {
+		o.authenticate = authenticate
+	}
+}
+
 func ConnectTimeout(d time.Duration) Option {
 	return func(ws *webSocketOptions) {
 		ws.connectTimeout = d
@@ -0,0 +1,163 @@
+// Copyright 2019, 1533 Systems, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package websocket
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestAcceptKey(t *testing.T) {
+	// Example of RFC 6455 section 1.3.
+	if acceptKey("dGhlIHNhbXBsZSBub25jZQ==") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fail()
+	}
+}
+
+func TestConnect(t *testing.T) {
+	connect := func(handler http.HandlerFunc, options ...Option) error {
+		server := httptest.NewServer(handler)
+		defer server.Close()
+		ws := new(webSocket)
+		ws.configure(options)
+		err := ws.connect("ws" + strings.TrimPrefix(server.URL, "http"))
+		if err == nil {
+			ws.cnx.Close()
+		}
+		return err
+	}
+	upgrade := func(w http.ResponseWriter, r *http.Request, accept string, protocol string) {
+		w.Header().Set("Connection", "Upgrade")
+		w.Header().Set("Upgrade", "websocket")
+		w.Header().Set("Sec-WebSocket-Accept", accept)
+		if protocol != "" {
+			w.Header().Set("Sec-WebSocket-Protocol", protocol)
+		}
+		w.WriteHeader(http.StatusSwitchingProtocols)
+	}
+
+	t.Run("sends a new key per connection", func(t *testing.T) {
+		keys := make(map[string]bool)
+		for i := 0; i < 3; i++ {
+			err := connect(func(w http.ResponseWriter, r *http.Request) {
+				key := r.Header.Get("Sec-WebSocket-Key")
+				keys[key] = true
+				upgrade(w, r, acceptKey(key), "driveline")
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if len(keys) != 3 {
+			t.Fail()
+		}
+	})
+	t.Run("reports the status and body of rejections", func(t *testing.T) {
+		err := connect(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "invalid credentials", http.StatusUnauthorized)
+		})
+		handshakeErr, ok := err.(*HandshakeError)
+		if !ok || handshakeErr.StatusCode != http.StatusUnauthorized || string(handshakeErr.Body) != "invalid credentials\n" {
+			t.Fail()
+		}
+	})
+	t.Run("rejects an invalid accept key", func(t *testing.T) {
+		err := connect(func(w http.ResponseWriter, r *http.Request) {
+			upgrade(w, r, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", "driveline")
+		})
+		handshakeErr, ok := err.(*HandshakeError)
+		if !ok || handshakeErr.Reason != "invalid Sec-WebSocket-Accept" {
+			t.Fail()
+		}
+	})
+	t.Run("rejects a missing subprotocol", func(t *testing.T) {
+		err := connect(func(w http.ResponseWriter, r *http.Request) {
+			upgrade(w, r, acceptKey(r.Header.Get("Sec-WebSocket-Key")), "")
+		})
+		handshakeErr, ok := err.(*HandshakeError)
+		if !ok || handshakeErr.StatusCode != http.StatusSwitchingProtocols || handshakeErr.Reason != "subprotocol not negotiated" {
+			t.Fail()
+		}
+	})
+	t.Run("rejects another subprotocol", func(t *testing.T) {
+		err := connect(func(w http.ResponseWriter, r *http.Request) {
+			upgrade(w, r, acceptKey(r.Header.Get("Sec-WebSocket-Key")), "chat")
+		})
+		if _, ok := err.(*HandshakeError); !ok {
+			t.Fail()
+		}
+	})
+	t.Run("refreshes rejected credentials once", func(t *testing.T) {
+		var refreshes []bool
+		token := 0
+		authenticate := Authenticate(func(ctx context.Context, r *http.Request, refresh bool) error {
+			refreshes = append(refreshes, refresh)
+			if refresh {
+				token++
+			}
+			r.Header.Set("Authorization", "Bearer "+strconv.Itoa(token))
+			q := r.URL.Query()
+			q.Set("tenant", "acme")
+			r.URL.RawQuery = q.Encode()
+			return nil
+		})
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer 1" || r.URL.Query().Get("tenant") != "acme" {
+				http.Error(w, "expired token", http.StatusUnauthorized)
+				return
+			}
+			upgrade(w, r, acceptKey(r.Header.Get("Sec-WebSocket-Key")), "driveline")
+		}
+		if err := connect(handler, authenticate); err != nil {
+			t.Fatal(err)
+		}
+		if len(refreshes) != 2 || refreshes[0] || !refreshes[1] {
+			t.Fatalf("unexpected calls %v", refreshes)
+		}
+
+		refreshes = nil
+		err := connect(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+		}, authenticate)
+		if handshakeErr, ok := err.(*HandshakeError); !ok || handshakeErr.StatusCode != http.StatusForbidden {
+			t.Fail()
+		}
+		if len(refreshes) != 2 {
+			t.Fatalf("expected a single retry, got %v", refreshes)
+		}
+	})
+	t.Run("reports authentication errors", func(t *testing.T) {
+		failure := errors.New("no token")
+		err := connect(func(w http.ResponseWriter, r *http.Request) {
+			t.Error("unexpected request")
+		}, Authenticate(func(context.Context, *http.Request, bool) error {
+			return failure
+		}))
+		if err != failure {
+			t.Fail()
+		}
+	})
+}
@@ -0,0 +1,154 @@
+// Copyright 2019, 1533 Systems, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package websocket
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// RetryPolicy returns the delay before a connection attempt. attempt counts
+// the consecutive attempts since the last successful connection, starting at
+// 1; err is the error that ended the previous attempt or connection. The first
+// connection attempt is not delayed.
+type RetryPolicy interface {
+	Delay(attempt int, err error) time.Duration
+}
+
+// RetryEvent describes a connection attempt about to be made, c.f. OnRetry.
+type RetryEvent struct {
+	Attempt int
+	Err     error
+	Delay   time.Duration
+}
+
+// Retry sets the retry policy. It defaults to ExponentialBackoff with
+// ReconnectWait and MaxReconnectWait.
+func Retry(policy RetryPolicy) Option {
+	return func(ws *webSocketOptions) {
+		ws.retryPolicy = policy
+	}
+}
+
+// OnRetry sets a handler called before waiting for every connection attempt
+// after the first.
+func OnRetry(handler func(RetryEvent)) Option {
+	return func(ws *webSocketOptions) {
+		ws.retryHandler = handler
+	}
+}
+
+// ConstantBackoff returns a RetryPolicy waiting delay before every attempt.
+func ConstantBackoff(delay time.Duration) RetryPolicy {
+	return constantBackoff(delay)
+}
+
+type constantBackoff time.Duration
+
+func (b constantBackoff) Delay(int, error) time.Duration {
+	return time.Duration(b)
+}
+
+// ExponentialBackoff returns a RetryPolicy doubling the delay from base at
+// every attempt, up to max. The delay is randomized between half and all of
+// it, so that clients do not reconnect in lockstep.
+func ExponentialBackoff(base time.Duration, max time.Duration) RetryPolicy {
+	return &exponentialBackoff{base: base, max: max, random: newRandom()}
+}
+
+type exponentialBackoff struct {
+	base   time.Duration
+	max    time.Duration
+	random func() float64
+}
+
+func (b *exponentialBackoff) Delay(attempt int, _ error) time.Duration {
+	delay := b.base
+	for i := 1; i < attempt && delay > 0 && delay < b.max; i++ {
+		delay *= 2
+	}
+	if delay > b.max {
+		delay = b.max
+	}
+	return delay/2 + time.Duration(b.random()*float64(delay-delay/2))
+}
+
+// DecorrelatedJitter returns a RetryPolicy picking a random delay between base
+// and three times the previous delay, up to max, c.f. "Exponential Backoff And
+// Jitter" on the AWS Architecture Blog. It spreads reconnects more than
+// ExponentialBackoff, and is not meant to be shared between clients.
+func DecorrelatedJitter(base time.Duration, max time.Duration) RetryPolicy {
+	return &decorrelatedJitter{base: base, max: max, random: newRandom()}
+}
+
+type decorrelatedJitter struct {
+	base   time.Duration
+	max    time.Duration
+	random func() float64
+
+	mu       sync.Mutex
+	previous time.Duration
+}
+
+func (j *decorrelatedJitter) Delay(attempt int, _ error) time.Duration {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if attempt <= 1 || j.previous < j.base {
+		j.previous = j.base
+	}
+	upper := 3 * j.previous
+	if upper > j.max || upper < 0 {
+		upper = j.max
+	}
+	delay := j.base
+	if upper > j.base {
+		delay += time.Duration(j.random() * float64(upper-j.base))
+	}
+	if delay > j.max {
+		delay = j.max
+	}
+	j.previous = delay
+	return delay
+}
+
+// newRandom returns a source of random numbers in [0, 1), safe for concurrent
+// use.
+func newRandom() func() float64 {
+	var mu sync.Mutex
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	return func() float64 {
+		mu.Lock()
+		defer mu.Unlock()
+		return r.Float64()
+	}
+}
+
+// clock lets tests control the delays of the connection loop.
+type clock interface {
+	After(d time.Duration) <-chan time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
@@ -0,0 +1,142 @@
+// Copyright 2019, 1533 Systems, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package websocket
+
+import (
+	"context"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeClock records the delays of the connection loop and does not wait.
+type fakeClock struct {
+	delays chan time.Duration
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.delays <- d
+	ch := make(chan time.Time, 1)
+	ch <- time.Time{}
+	return ch
+}
+
+func fixedRandom(values ...float64) func() float64 {
+	return func() float64 {
+		v := values[0]
+		if len(values) > 1 {
+			values = values[1:]
+		}
+		return v
+	}
+}
+
+func TestRetryPolicies(t *testing.T) {
+	delays := func(policy RetryPolicy, attempts int) []time.Duration {
+		var delays []time.Duration
+		for attempt := 1; attempt <= attempts; attempt++ {
+			delays = append(delays, policy.Delay(attempt, nil))
+		}
+		return delays
+	}
+
+	t.Run("constant", func(t *testing.T) {
+		if !reflect.DeepEqual(delays(ConstantBackoff(time.Second), 3), []time.Duration{time.Second, time.Second, time.Second}) {
+			t.Fail()
+		}
+	})
+	t.Run("exponential", func(t *testing.T) {
+		policy := &exponentialBackoff{base: time.Second, max: 10 * time.Second, random: fixedRandom(1)}
+		expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second}
+		if !reflect.DeepEqual(delays(policy, 6), expected) {
+			t.Fail()
+		}
+		if policy.Delay(1000, nil) != 10*time.Second {
+			t.Fail()
+		}
+	})
+	t.Run("exponential with jitter", func(t *testing.T) {
+		policy := &exponentialBackoff{base: time.Second, max: 10 * time.Second, random: fixedRandom(0, 0.5)}
+		expected := []time.Duration{500 * time.Millisecond, 1500 * time.Millisecond}
+		if !reflect.DeepEqual(delays(policy, 2), expected) {
+			t.Fail()
+		}
+		random := ExponentialBackoff(time.Second, time.Minute)
+		for attempt := 1; attempt < 100; attempt++ {
+			if d := random.Delay(attempt, nil); d < 0 || d > time.Minute {
+				t.Fatalf("attempt %d: %s", attempt, d)
+			}
+		}
+	})
+	t.Run("decorrelated jitter", func(t *testing.T) {
+		policy := &decorrelatedJitter{base: time.Second, max: 20 * time.Second, random: fixedRandom(1, 1, 1, 0, 1)}
+		// Each delay is up to three times the previous one, and at least base.
+		expected := []time.Duration{3 * time.Second, 9 * time.Second, 20 * time.Second, time.Second, 3 * time.Second}
+		if !reflect.DeepEqual(delays(policy, 5), expected) {
+			t.Fail()
+		}
+		// The delay is reset on the first attempt.
+		if policy.Delay(1, nil) != 3*time.Second {
+			t.Fail()
+		}
+		random := DecorrelatedJitter(time.Second, time.Minute)
+		for attempt := 1; attempt < 100; attempt++ {
+			if d := random.Delay(attempt, nil); d < time.Second || d > time.Minute {
+				t.Fatalf("attempt %d: %s", attempt, d)
+			}
+		}
+	})
+}
+
+func TestRetry(t *testing.T) {
+	down := httptest.NewServer(nil)
+	endpoint := "ws" + strings.TrimPrefix(down.URL, "http")
+	down.Close()
+
+	clock := &fakeClock{delays: make(chan time.Duration, 8)}
+	var events []RetryEvent
+	_, err := New(context.Background(), endpoint,
+		MaxReconnect(4),
+		Retry(&exponentialBackoff{base: time.Second, max: time.Minute, random: fixedRandom(1)}),
+		OnRetry(func(event RetryEvent) { events = append(events, event) }),
+		func(o *webSocketOptions) { o.clock = clock })
+	if err != ErrMaxReconnect {
+		t.Fatal(err)
+	}
+	close(clock.delays)
+	var waited []time.Duration
+	for d := range clock.delays {
+		waited = append(waited, d)
+	}
+	if !reflect.DeepEqual(waited, []time.Duration{0, time.Second, 2 * time.Second, 4 * time.Second}) {
+		t.Errorf("waited %v", waited)
+	}
+	if len(events) != 3 {
+		t.Fatalf("%d events", len(events))
+	}
+	for i, event := range events {
+		if event.Attempt != i+1 || event.Err == nil || event.Delay != waited[i+1] {
+			t.Errorf("event %d: %+v", i, event)
+		}
+	}
+}
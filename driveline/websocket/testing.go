@@ -54,6 +54,14 @@ func (ws *FakeWebSocket) Disconnect() {
 }
 
 func (ws *FakeWebSocket) Reconnect() {
+	err := ws.opts.handshakeHandler(func(buf []byte) error {
+		_, err := ws.WriteHandler(buf)
+		return err
+	})
+	if err != nil {
+		ws.opts.errorHandler(err)
+		return
+	}
 	ws.opts.connectHandler()
 }
 
@@ -0,0 +1,161 @@
+// Copyright 2019, 1533 Systems, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package websocket
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+// ErrTLSTransport is returned by New when TLS options are set with an HTTP
+// client whose transport is not an *http.Transport, c.f. HTTPClient.
+var ErrTLSTransport = errors.New("TLS options require an HTTP client with an *http.Transport")
+
+// tlsOptions configure the TLS connections of wss:// endpoints. Certificate
+// files are loaded again for every connection, so that renewed certificates
+// are used when reconnecting.
+type tlsOptions struct {
+	config      *tls.Config
+	certFile    string
+	keyFile     string
+	rootCAsFile string
+}
+
+func (o *tlsOptions) isSet() bool {
+	return o.config != nil || o.certFile != "" || o.rootCAsFile != ""
+}
+
+// load returns the TLS configuration of a new connection.
+func (o *tlsOptions) load() (*tls.Config, error) {
+	config, err := o.newConfig()
+	if err != nil {
+		return nil, err
+	}
+	if o.certFile != "" {
+		cert, err := tls.LoadX509KeyPair(o.certFile, o.keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// transportConfig returns the TLS configuration of the transport of an HTTP
+// client. The transport is kept across connections, so the client certificate
+// is loaded at every TLS handshake instead, and the root CAs only once.
+func (o *tlsOptions) transportConfig() (*tls.Config, error) {
+	config, err := o.newConfig()
+	if err != nil {
+		return nil, err
+	}
+	if o.certFile != "" {
+		certFile, keyFile := o.certFile, o.keyFile
+		config.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+			if err != nil {
+				return nil, err
+			}
+			return &cert, nil
+		}
+	}
+	return config, nil
+}
+
+func (o *tlsOptions) newConfig() (*tls.Config, error) {
+	config := new(tls.Config)
+	if o.config != nil {
+		config = o.config.Clone()
+	}
+	if o.rootCAsFile != "" {
+		pem, err := ioutil.ReadFile(o.rootCAsFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", o.rootCAsFile)
+		}
+	}
+	// The WebSocket upgrade requires HTTP/1.1.
+	config.NextProtos = []string{"http/1.1"}
+	return config, nil
+}
+
+// configureHTTPClient applies the TLS options to the HTTP client, c.f.
+// HTTPClient. The client and its transport are cloned once, so that the
+// connections of the transport are reused across reconnections and the client
+// of the caller is left untouched.
+func (o *webSocketOptions) configureHTTPClient() error {
+	if o.httpClient == nil || !o.tls.isSet() {
+		return nil
+	}
+	roundTripper := o.httpClient.Transport
+	if roundTripper == nil {
+		roundTripper = http.DefaultTransport
+	}
+	transport, ok := roundTripper.(*http.Transport)
+	if !ok {
+		return ErrTLSTransport
+	}
+	config, err := o.tls.transportConfig()
+	if err != nil {
+		return err
+	}
+	transport = transport.Clone()
+	transport.TLSClientConfig = config
+	client := *o.httpClient
+	client.Transport = transport
+	o.httpClient = &client
+	return nil
+}
+
+// TLSConfig sets the TLS configuration of wss:// endpoints, for instance to
+// set the server name or to verify the certificate of the server. With
+// HTTPClient, it is set on a clone of the transport of the HTTP client, which
+// must be an *http.Transport.
+func TLSConfig(config *tls.Config) Option {
+	return func(ws *webSocketOptions) {
+		ws.tls.config = config
+	}
+}
+
+// ClientCertificate sets the PEM files of the certificate and key presented to
+// the server. They are loaded again at every connection. c.f. TLSConfig.
+func ClientCertificate(certFile string, keyFile string) Option {
+	return func(ws *webSocketOptions) {
+		ws.tls.certFile = certFile
+		ws.tls.keyFile = keyFile
+	}
+}
+
+// RootCAs sets the PEM file of the certificate authorities trusted to verify
+// the server. It is loaded again at every connection, but only once with
+// HTTPClient. c.f. TLSConfig.
+func RootCAs(pemFile string) Option {
+	return func(ws *webSocketOptions) {
+		ws.tls.rootCAsFile = pemFile
+	}
+}
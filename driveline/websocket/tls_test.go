@@ -0,0 +1,270 @@
+// Copyright 2019, 1533 Systems, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package websocket
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testPKI is a certificate authority issuing the certificates of a test.
+type testPKI struct {
+	dir  string
+	ca   *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	dir, err := ioutil.TempDir("", "websocket-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &testPKI{dir: dir, pool: x509.NewCertPool()}
+	template := &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "test CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	p.ca, p.key = p.create(t, template, nil, nil)
+	p.pool.AddCert(p.ca)
+	p.write(t, "ca.pem", "CERTIFICATE", p.ca.Raw)
+	return p
+}
+
+func (p *testPKI) close() {
+	os.RemoveAll(p.dir)
+}
+
+// issue writes a certificate and its key to file.pem and file-key.pem.
+func (p *testPKI) issue(t *testing.T, file string, commonName string, usage x509.ExtKeyUsage) tls.Certificate {
+	template := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: commonName},
+		DNSNames:    []string{"localhost"},
+		IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{usage},
+	}
+	cert, key := p.create(t, template, p.ca, p.key)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.write(t, file+".pem", "CERTIFICATE", cert.Raw)
+	p.write(t, file+"-key.pem", "EC PRIVATE KEY", keyDER)
+	return tls.Certificate{Certificate: [][]byte{cert.Raw}, PrivateKey: key}
+}
+
+func (p *testPKI) create(t *testing.T, template *x509.Certificate, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func (p *testPKI) write(t *testing.T, name string, blockType string, der []byte) {
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := ioutil.WriteFile(p.path(name), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (p *testPKI) path(name string) string {
+	return filepath.Join(p.dir, name)
+}
+
+// newTLSTestServer starts a WebSocket server requiring client certificates. It
+// reports the common name of the client certificate of each connection.
+func newTLSTestServer(t *testing.T, p *testPKI) (*httptest.Server, <-chan *testConn, <-chan string) {
+	conns := make(chan *testConn, 4)
+	names := make(chan string, 4)
+	upgrade := upgradeHandler(t, conns)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		names <- r.TLS.PeerCertificates[0].Subject.CommonName
+		upgrade.ServeHTTP(w, r)
+	}))
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{p.issue(t, "server", "server", x509.ExtKeyUsageServerAuth)},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    p.pool,
+	}
+	server.StartTLS()
+	return server, conns, names
+}
+
+func TestTLS(t *testing.T) {
+	p := newTestPKI(t)
+	defer p.close()
+	server, conns, names := newTLSTestServer(t, p)
+	defer server.Close()
+	endpoint := "wss" + strings.TrimPrefix(server.URL, "https")
+
+	t.Run("connects with a client certificate and reloads it on reconnect", func(t *testing.T) {
+		p.issue(t, "client", "client-1", x509.ExtKeyUsageClientAuth)
+		ws, err := New(context.Background(), endpoint,
+			ReconnectWait(time.Millisecond),
+			RootCAs(p.path("ca.pem")),
+			ClientCertificate(p.path("client.pem"), p.path("client-key.pem")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ws.Close()
+		conn := <-conns
+		if name := <-names; name != "client-1" {
+			t.Fatalf("unexpected client certificate %s", name)
+		}
+
+		p.issue(t, "client", "client-2", x509.ExtKeyUsageClientAuth)
+		conn.Close()
+		select {
+		case conn := <-conns:
+			conn.Close()
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected a new connection")
+		}
+		if name := <-names; name != "client-2" {
+			t.Fatalf("unexpected client certificate %s", name)
+		}
+	})
+	t.Run("uses the TLS configuration", func(t *testing.T) {
+		cert := p.issue(t, "other", "client-3", x509.ExtKeyUsageClientAuth)
+		ws, err := New(context.Background(), endpoint, TLSConfig(&tls.Config{
+			RootCAs:      p.pool,
+			Certificates: []tls.Certificate{cert},
+		}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ws.Close()
+		(<-conns).Close()
+		if name := <-names; name != "client-3" {
+			t.Fatalf("unexpected client certificate %s", name)
+		}
+	})
+	t.Run("sets the TLS configuration on a clone of the transport of the HTTP client", func(t *testing.T) {
+		p.issue(t, "client", "client-4", x509.ExtKeyUsageClientAuth)
+		config := new(tls.Config)
+		transport := &http.Transport{TLSClientConfig: config}
+		client := &http.Client{Transport: transport}
+		ws, err := New(context.Background(), endpoint,
+			ReconnectWait(time.Millisecond),
+			HTTPClient(client),
+			RootCAs(p.path("ca.pem")),
+			ClientCertificate(p.path("client.pem"), p.path("client-key.pem")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ws.Close()
+		conn := <-conns
+		if name := <-names; name != "client-4" {
+			t.Fatalf("unexpected client certificate %s", name)
+		}
+		if client.Transport != transport || transport.TLSClientConfig != config || config.RootCAs != nil {
+			t.Fatal("expected the HTTP client to be left untouched")
+		}
+		clone := ws.(*webSocket).httpClient.Transport
+
+		p.issue(t, "client", "client-5", x509.ExtKeyUsageClientAuth)
+		conn.Close()
+		select {
+		case conn := <-conns:
+			conn.Close()
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected a new connection")
+		}
+		if name := <-names; name != "client-5" {
+			t.Fatalf("unexpected client certificate %s", name)
+		}
+		if ws.(*webSocket).httpClient.Transport != clone {
+			t.Fatal("expected the transport to be kept across connections")
+		}
+	})
+	t.Run("fails with an HTTP client without an http.Transport", func(t *testing.T) {
+		client := &http.Client{Transport: roundTripperFunc(http.DefaultTransport.RoundTrip)}
+		_, err := New(context.Background(), endpoint, HTTPClient(client), RootCAs(p.path("ca.pem")))
+		if err != ErrTLSTransport {
+			t.Fatalf("unexpected error %v", err)
+		}
+	})
+	t.Run("fails with an untrusted server", func(t *testing.T) {
+		errs := make(chan error, 1)
+		_, err := New(context.Background(), endpoint,
+			MaxReconnect(1),
+			ReconnectWait(time.Millisecond),
+			ClientCertificate(p.path("client.pem"), p.path("client-key.pem")),
+			ErrorHandler(func(err error) { errs <- err }))
+		if err != ErrMaxReconnect || <-errs == nil {
+			t.Fail()
+		}
+	})
+	t.Run("fails with missing certificate files", func(t *testing.T) {
+		errs := make(chan error, 1)
+		_, err := New(context.Background(), endpoint,
+			MaxReconnect(1),
+			ReconnectWait(time.Millisecond),
+			ClientCertificate(p.path("missing.pem"), p.path("missing-key.pem")),
+			ErrorHandler(func(err error) { errs <- err }))
+		if err != ErrMaxReconnect {
+			t.Fail()
+		}
+		if _, ok := (<-errs).(*os.PathError); !ok {
+			t.Fail()
+		}
+	})
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
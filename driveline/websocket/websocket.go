@@ -21,7 +21,7 @@
 package websocket
 
 import (
-	"bufio"
+	"bytes"
 	"context"
 	"encoding/binary"
 	"errors"
@@ -37,6 +37,7 @@ var (
 	ErrMaxReconnect          = errors.New("maximum reconnection attempts reached")
 	ErrUnexpectedEndOfStream = errors.New("unexpected end of stream")
 	ErrInvalidFrameType      = errors.New("unexpected frame type received")
+	ErrPongTimeout           = errors.New("no pong received in time")
 )
 
 type frameOpCode byte
@@ -47,8 +48,9 @@ const (
 	defaultConnectTimeout   = 2 * time.Second
 	defaultReconnectWait    = 1 * time.Second
 	defaultMaxReconnectWait = defaultReconnectWait * maxReconnectWaitRatio
+	defaultCloseTimeout     = 1 * time.Second
 
-	maxOutputBuffer = 32 * 1024 * 1024
+	maxOutputBuffer = 64 * 1024
 	readBufferSize  = 1024*1024 + 65536 + 1024
 	maxInputBuffer  = 16 * 1024 * 1024
 
@@ -64,30 +66,100 @@ type WebSocket interface {
 	io.WriteCloser
 }
 
-var _ WebSocket = (*webSocket)(nil)
+// Buffer is a message whose memory can be reused once it is written.
+type Buffer interface {
+	// Bytes returns the message. The WebSocket may modify it.
+	Bytes() []byte
+	// Release is called once the message is written or dropped.
+	Release()
+}
+
+// BufferWriter is implemented by the WebSockets that take ownership of the
+// messages they write, and hand them back with Release.
+type BufferWriter interface {
+	WriteBuffer(b Buffer) error
+}
+
+var (
+	_ WebSocket    = (*webSocket)(nil)
+	_ BufferWriter = (*webSocket)(nil)
+)
 
 type webSocket struct {
-	endpoint   string
+	endpoints  *endpointSet
 	outputLock sync.Locker
 	cnx        io.ReadWriteCloser
-	readBuffer []byte
+	deflate    *deflateParams // nil unless permessage-deflate was negotiated
 	closeErr   error
-	dataFrames chan []byte
-	pongFrames chan []byte
+	dataFrames chan dataFrame
 	cancel     func()
+	done       chan struct{}
+
+	stateLock sync.Mutex
+	closed    bool
+	session   *session
 	webSocketOptions
 }
 
+// dataFrame is a queued message. buffer is set when the message is owned by
+// the WebSocket.
+type dataFrame struct {
+	payload []byte
+	buffer  Buffer
+}
+
+type controlFrame struct {
+	opCode  frameOpCode
+	payload []byte
+}
+
+// session is the state of a single connection.
+type session struct {
+	controlFrames chan controlFrame
+	closeSent     chan struct{} // closed once the writer sent a Close frame
+	pongs         chan []byte
+	compressor    *messageCompressor
+}
+
+func newSession() *session {
+	return &session{
+		controlFrames: make(chan controlFrame, 2),
+		closeSent:     make(chan struct{}),
+		pongs:         make(chan []byte, 1),
+	}
+}
+
+// sendControl queues a control frame, ahead of the data frames.
+func (s *session) sendControl(opCode frameOpCode, payload []byte, stopCh <-chan struct{}) bool {
+	select {
+	case s.controlFrames <- controlFrame{opCode: opCode, payload: payload}:
+		return true
+	case <-stopCh:
+		return false
+	}
+}
+
+func (s *session) isCloseSent() bool {
+	select {
+	case <-s.closeSent:
+		return true
+	default:
+		return false
+	}
+}
+
 func New(ctx context.Context, endpoint string, options ...Option) (WebSocket, error) {
 	ws := &webSocket{
-		endpoint:   endpoint,
-		readBuffer: make([]byte, readBufferSize),
 		outputLock: new(sync.Mutex),
-		pongFrames: make(chan []byte, 1),
 		cancel:     func() {},
+		done:       make(chan struct{}),
 	}
 	ws.webSocketOptions.configure(options)
-	ws.dataFrames = make(chan []byte, ws.maxInFlight)
+	if err := ws.configureHTTPClient(); err != nil {
+		return nil, err
+	}
+	ws.endpoints = newEndpointSet(append([]string{endpoint}, ws.extraEndpoints...))
+	ws.dataFrames = make(chan dataFrame, ws.maxInFlight)
 	if err := ws.run(ctx); err != nil {
 		ws.Close()
 		return nil, err
@@ -95,20 +167,46 @@ func New(ctx context.Context, endpoint string, options ...Option) (WebSocket, er
 	return ws, nil
 }
 
+// Close runs the closing handshake when connected: it sends a Close frame with
+// a normal closure status, once the queued frames are written, and waits for
+// the Close frame of the server up to the close timeout.
 func (ws *webSocket) Close() error {
-	if ws.isClosed() {
+	ws.stateLock.Lock()
+	if ws.closed {
+		ws.stateLock.Unlock()
 		return nil
 	}
-	ws.endpoint = ""
+	ws.closed = true
+	s := ws.session
+	ws.stateLock.Unlock()
+	if s != nil {
+		timeout := time.NewTimer(ws.closeTimeout)
+		defer timeout.Stop()
+		select {
+		case s.controlFrames <- controlFrame{opCode: closeFrame, payload: encodeClose(CloseNormalClosure, "")}:
+			select {
+			case <-ws.done:
+			case <-timeout.C:
+			}
+		case <-timeout.C:
+		}
+	}
 	ws.cancel()
 	return nil
 }
 
 func (ws *webSocket) Write(buf []byte) (int, error) {
-	ws.dataFrames <- buf
+	ws.dataFrames <- dataFrame{payload: buf}
 	return len(buf), nil
 }
 
+// WriteBuffer queues b like Write, but the WebSocket masks the message in
+// place rather than copying it, and releases b once it is written.
+func (ws *webSocket) WriteBuffer(b Buffer) error {
+	ws.dataFrames <- dataFrame{payload: b.Bytes(), buffer: b}
+	return nil
+}
+
 var errInterrupted = errors.New("goroutine interrupted")
 
 func (ws *webSocket) run(ctx context.Context) error {
@@ -116,7 +214,10 @@ func (ws *webSocket) run(ctx context.Context) error {
 	defer close(startResult)
 	loopCtx, cancel := context.WithCancel(context.Background())
 	ws.cancel = cancel
-	go ws.wsLoop(loopCtx, startResult)
+	go func() {
+		defer close(ws.done)
+		ws.wsLoop(loopCtx, startResult)
+	}()
 	select {
 	case <-ctx.Done():
 		if err := ws.Close(); err != nil {
@@ -129,36 +230,93 @@ func (ws *webSocket) run(ctx context.Context) error {
 }
 
 func (ws *webSocket) isClosed() bool {
-	return len(ws.endpoint) == 0
+	ws.stateLock.Lock()
+	defer ws.stateLock.Unlock()
+	return ws.closed
 }
 
 func (ws *webSocket) wsLoop(ctx context.Context, startResult chan<- error) {
 	var (
-		err       error
 		errWriter error
 		errReader error
+		lastErr   error
 	)
 	for attempt := 0; attempt < ws.maxReconnect || ws.maxReconnect == -1; attempt += 1 {
+		var delay time.Duration
+		if attempt > 0 {
+			delay = ws.retryPolicy.Delay(attempt, lastErr)
+			ws.retryHandler(RetryEvent{Attempt: attempt, Err: lastErr, Delay: delay})
+		}
 		select {
 		case <-ctx.Done():
 			ws.failureHandler(ErrConnClosed)
 			return
-		case <-time.After(ws.timeWaitForAttempt(attempt)):
+		case <-ws.clock.After(delay):
 			break
 		}
-		if err = ws.connect(ws.endpoint); err != nil {
+		endpoint, err := ws.nextEndpoint(ctx)
+		if err != nil {
+			lastErr = err
+			ws.errorHandler(err)
 			continue
 		}
+		ws.attemptHandler(endpoint, attempt)
+		if err = ws.connect(endpoint); err != nil {
+			lastErr = err
+			ws.endpoints.failed(endpoint)
+			ws.errorHandler(err)
+			continue
+		}
+		s := newSession()
+		in := newFrameReader(ws.cnx, readBufferSize, ws.maxMessageSize)
+		in.borrow = ws.borrowMessages
+		if ws.deflate != nil {
+			s.compressor = newMessageCompressor(ws.deflate.clientNoContextTakeover, ws.compressionThreshold)
+			in.decompressor = newMessageDecompressor(ws.deflate.serverNoContextTakeover, ws.maxMessageSize)
+		}
+		if err = ws.handshake(s); err != nil {
+			lastErr = err
+			ws.endpoints.failed(endpoint)
+			ws.errorHandler(err)
+			ws.cnx.Close()
+			continue
+		}
+		ws.endpoints.succeeded(endpoint)
 		if startResult != nil {
 			startResult <- nil
 			startResult = nil
 		}
 		attempt = 0
 
+		ws.stateLock.Lock()
+		ws.session = s
+		ws.stateLock.Unlock()
+
 		ws.connectHandler()
 		runnerCtx, cancelRunner := context.WithCancel(ctx)
-
-		var wg sync.WaitGroup
+		go func(cnx io.Closer) {
+			// Unblocks the reader once the session ends.
+			<-runnerCtx.Done()
+			cnx.Close()
+		}(ws.cnx)
+
+		var (
+			wg        sync.WaitGroup
+			errPinger error
+		)
+		if ws.pingInterval > 0 {
+			wg.Add(1)
+			go func() {
+				defer func() {
+					if crash := recover(); crash != nil {
+						errPinger = fmt.Errorf("recovered pinger panic %v", crash)
+					}
+					cancelRunner()
+					wg.Done()
+				}()
+				errPinger = ws.runPingLoop(s, runnerCtx.Done())
+			}()
+		}
 		wg.Add(2)
 		go func() {
 			defer func() {
@@ -168,7 +326,7 @@ func (ws *webSocket) wsLoop(ctx context.Context, startResult chan<- error) {
 				cancelRunner()
 				wg.Done()
 			}()
-			errWriter = ws.runWriterLoop(runnerCtx.Done())
+			errWriter = ws.runWriterLoop(s, runnerCtx.Done())
 		}()
 		go func() {
 			defer func() {
@@ -178,9 +336,12 @@ func (ws *webSocket) wsLoop(ctx context.Context, startResult chan<- error) {
 				cancelRunner()
 				wg.Done()
 			}()
-			errReader = ws.runReaderLoop(runnerCtx.Done())
+			errReader = ws.runReaderLoop(s, in, runnerCtx.Done())
 		}()
 		wg.Wait()
+		ws.stateLock.Lock()
+		ws.session = nil
+		ws.stateLock.Unlock()
 
 		if errWriter != nil && errWriter != errInterrupted {
 			ws.errorHandler(errWriter)
@@ -188,8 +349,21 @@ func (ws *webSocket) wsLoop(ctx context.Context, startResult chan<- error) {
 		if errReader != nil && errReader != errInterrupted {
 			ws.errorHandler(errReader)
 		}
+		if errPinger != nil && errPinger != errInterrupted {
+			ws.errorHandler(errPinger)
+		}
+		lastErr = nil
+		for _, err := range []error{errReader, errWriter, errPinger} {
+			if err != nil && err != errInterrupted {
+				lastErr = err
+				break
+			}
+		}
 		ws.disconnectHandler()
-		if len(ws.endpoint) == 0 {
+		if ws.discardOnDisconnect {
+			ws.discardPending()
+		}
+		if ws.isClosed() {
 			return
 		}
 	}
@@ -200,60 +374,164 @@ func (ws *webSocket) wsLoop(ctx context.Context, startResult chan<- error) {
 	ws.failureHandler(ErrMaxReconnect)
 }
 
-func (ws *webSocket) timeWaitForAttempt(attempt int) time.Duration {
-	timeWait := time.Duration(2^attempt-1) * ws.reconnectWait / 2
-	if timeWait > ws.maxReconnectWait {
-		timeWait = ws.maxReconnectWait
+// handshake lets the handshake handler write its frames directly on the new
+// connection, before the writer loop starts sending queued frames.
+func (ws *webSocket) handshake(s *session) error {
+	out := newFrameWriter(ws.cnx, maskChunkSize)
+	out.compressor = s.compressor
+	err := ws.handshakeHandler(func(frame []byte) error {
+		return out.writeMessage(frame)
+	})
+	if err != nil {
+		return err
 	}
-	return timeWait
+	return out.Flush()
 }
 
-func (ws *webSocket) runReaderLoop(stopCh <-chan struct{}) error {
+func (ws *webSocket) discardPending() {
 	for {
 		select {
-		case <-stopCh:
-			return errInterrupted
+		case frame := <-ws.dataFrames:
+			if frame.buffer != nil {
+				frame.buffer.Release()
+			}
 		default:
-			break
+			return
 		}
-		opCode, frame, err := readFrame(ws.cnx)
+	}
+}
+
+func (ws *webSocket) runReaderLoop(s *session, in *frameReader, stopCh <-chan struct{}) error {
+	defer in.release()
+	for {
+		opCode, frame, err := in.readMessage()
 		if err != nil {
+			select {
+			case <-stopCh:
+				return errInterrupted
+			default:
+			}
+			switch err {
+			case ErrInvalidWebSocketFrame:
+				ws.failSession(s, CloseProtocolError, stopCh)
+			case ErrMessageTooLarge:
+				ws.failSession(s, CloseMessageTooBig, stopCh)
+			}
 			return err
 		}
 		switch opCode {
 		case binaryFrame:
 			ws.messageHandler(frame)
+			in.release()
 		case closeFrame:
-			// TODO: must send a Close frame
+			if s.isCloseSent() {
+				// The server answered our Close frame.
+				return errInterrupted
+			}
+			closeErr := decodeClose(frame)
+			code := closeErr.Code
+			if code == CloseNoStatusReceived {
+				code = 0
+			}
+			ws.failSession(s, code, stopCh)
+			return closeErr
 		case pingFrame:
-			ws.pongFrames <- frame
+			if !s.sendControl(pongFrame, frame, stopCh) {
+				return errInterrupted
+			}
 		case pongFrame:
-			// TODO: add ping/pong support?
+			select {
+			case s.pongs <- frame:
+			default:
+				// Unsolicited pongs are ignored.
+			}
 		default:
 			ws.errorHandler(ErrInvalidFrameType)
 		}
 	}
 }
 
-func (ws *webSocket) runWriterLoop(stopCh <-chan struct{}) error {
-	out := bufio.NewWriterSize(ws.cnx, maxOutputBuffer)
-	var frame []byte
+// runPingLoop sends a ping every ping interval, and fails when its pong is not
+// received within the pong timeout. The payload of pings is the time they were
+// sent, which gives the round-trip time of their pong.
+func (ws *webSocket) runPingLoop(s *session, stopCh <-chan struct{}) error {
+	ticker := time.NewTicker(ws.pingInterval)
+	defer ticker.Stop()
+	timeout := time.NewTimer(ws.pongTimeout)
+	timeout.Stop()
+	var (
+		sentAt  time.Time
+		pending []byte
+	)
+	for {
+		select {
+		case <-stopCh:
+			return errInterrupted
+		case <-ticker.C:
+			if pending != nil {
+				continue
+			}
+			sentAt = time.Now()
+			pending = make([]byte, 8)
+			binary.BigEndian.PutUint64(pending, uint64(sentAt.UnixNano()))
+			if !s.sendControl(pingFrame, pending, stopCh) {
+				return errInterrupted
+			}
+			timeout.Reset(ws.pongTimeout)
+		case pong := <-s.pongs:
+			if pending == nil || !bytes.Equal(pong, pending) {
+				continue
+			}
+			pending = nil
+			if !timeout.Stop() {
+				<-timeout.C
+			}
+			ws.roundTripHandler(time.Since(sentAt))
+		case <-timeout.C:
+			return ErrPongTimeout
+		}
+	}
+}
+
+// failSession sends a Close frame with code, and waits for it to be written up
+// to the close timeout.
+func (ws *webSocket) failSession(s *session, code int, stopCh <-chan struct{}) {
+	timeout := time.NewTimer(ws.closeTimeout)
+	defer timeout.Stop()
+	if !s.sendControl(closeFrame, encodeClose(code, ""), stopCh) {
+		return
+	}
+	select {
+	case <-s.closeSent:
+	case <-stopCh:
+	case <-timeout.C:
+	}
+}
+
+func (ws *webSocket) runWriterLoop(s *session, stopCh <-chan struct{}) error {
+	out := newFrameWriter(ws.cnx, maxOutputBuffer)
+	out.compressor = s.compressor
 	for {
 		select {
 		case <-stopCh:
 			return errInterrupted
-		case frame = <-ws.pongFrames:
-			if err := writeFrame(out, binaryFrame, frame); err != nil {
+		case control := <-s.controlFrames:
+			if control.opCode == closeFrame {
+				return ws.writeClose(s, out, control.payload, stopCh)
+			}
+			if err := out.writeFrame(control.opCode, control.payload); err != nil {
+				return err
+			}
+			if err := out.Flush(); err != nil {
 				return err
 			}
-		case frame = <-ws.dataFrames:
-			if err := writeFrame(out, binaryFrame, frame); err != nil {
+		case frame := <-ws.dataFrames:
+			if err := writeData(out, frame); err != nil {
 				return err
 			}
 			if messageCnt := len(ws.dataFrames); messageCnt > 0 {
 				for i := 0; i < messageCnt; i++ {
-					frame = <-ws.dataFrames
-					if err := writeFrame(out, binaryFrame, frame); err != nil {
+					if err := writeData(out, <-ws.dataFrames); err != nil {
 						return err
 					}
 				}
@@ -265,76 +543,32 @@ func (ws *webSocket) runWriterLoop(stopCh <-chan struct{}) error {
 	}
 }
 
-func readFrame(in io.Reader) (frameOpCode, []byte, error) {
-	var hdr [14]byte
-	read, err := in.Read(hdr[:2])
-	if err != nil {
-		return 0, nil, err
-	}
-	if read < 2 {
-		return 0, nil, ErrUnexpectedEndOfStream
+// writeData writes a queued message. The bufio.Writer of out copies or writes
+// out the message before returning, so owned messages are released right away.
+func writeData(out *frameWriter, frame dataFrame) error {
+	if frame.buffer == nil {
+		return out.writeMessage(frame.payload)
 	}
-
-	opCode := frameOpCode(0x7F & hdr[0])
-
-	fin := hdr[0]&0x80 != 0
-	isMasked := (hdr[1] & 0x80) != 0
-
-	if isMasked || !fin {
-		return 0, nil, ErrInvalidWebSocketFrame
-	}
-
-	frameLen := uint64(0X7F & hdr[1])
-	switch frameLen {
-	case 126:
-		read, err = in.Read(hdr[2:4])
-		if err != nil {
-			return 0, nil, err
-		}
-		frameLen = uint64(binary.BigEndian.Uint16(hdr[2:]))
-
-	case 127:
-		read, err = in.Read(hdr[2:10])
-		if err != nil {
-			return 0, nil, err
-		}
-		frameLen = binary.BigEndian.Uint64(hdr[2:])
-	}
-
-	frame := make([]byte, frameLen)
-	if _, err := io.ReadFull(in, frame); err != nil {
-		return 0, nil, err
-	}
-
-	return opCode, frame, nil
+	err := out.writeOwnedMessage(frame.payload)
+	frame.buffer.Release()
+	return err
 }
 
-func writeFrame(out io.Writer, opCode frameOpCode, frame []byte) error {
-	var hdr [10]byte
-	l := uint64(len(frame))
-
-	hdr[0] = 0x80 | byte(opCode)
-	switch {
-	case l < 126:
-		hdr[1] = byte(l)
-		if _, err := out.Write(hdr[0:2]); err != nil {
-			return err
-		}
-	case l < 0x10000:
-		hdr[1] = 126
-		binary.BigEndian.PutUint16(hdr[2:], uint16(l))
-		if _, err := out.Write(hdr[0:4]); err != nil {
-			return err
-		}
-	default:
-		hdr[1] = 127
-		binary.BigEndian.PutUint64(hdr[2:], l)
-		if _, err := out.Write(hdr[0:10]); err != nil {
+// writeClose writes the frames already queued, then the Close frame. No frame
+// may follow a Close frame, so the writer then waits for the session to end.
+func (ws *webSocket) writeClose(s *session, out *frameWriter, payload []byte, stopCh <-chan struct{}) error {
+	for messageCnt := len(ws.dataFrames); messageCnt > 0; messageCnt-- {
+		if err := writeData(out, <-ws.dataFrames); err != nil {
 			return err
 		}
 	}
-	if _, err := out.Write(frame); err != nil {
+	if err := out.writeFrame(closeFrame, payload); err != nil {
 		return err
 	}
-	return nil
+	if err := out.Flush(); err != nil {
+		return err
+	}
+	close(s.closeSent)
+	<-stopCh
+	return errInterrupted
 }
@@ -21,42 +21,81 @@
 package websocket
 
 import (
+	"context"
+	"net"
 	"net/http"
+	"net/url"
 	"time"
 )
 
 type Option func(options *webSocketOptions)
 
 type webSocketOptions struct {
-	maxReconnect      int
-	reconnectWait     time.Duration
-	maxReconnectWait  time.Duration
-	maxInFlight       int
-	connectHandler    func()
-	disconnectHandler func()
-	messageHandler    func([]byte)
-	failureHandler    func(error)
-	errorHandler      func(error)
-	connectTimeout    time.Duration
-	httpHeaders       http.Header
-	httpClient        *http.Client
+	maxReconnect        int
+	reconnectWait       time.Duration
+	maxReconnectWait    time.Duration
+	retryPolicy         RetryPolicy
+	retryHandler        func(RetryEvent)
+	clock               clock
+	maxInFlight         int
+	connectHandler      func()
+	attemptHandler      func(endpoint string, attempt int)
+	handshakeHandler    func(send func([]byte) error) error
+	disconnectHandler   func()
+	messageHandler      func([]byte)
+	failureHandler      func(error)
+	errorHandler        func(error)
+	connectTimeout      time.Duration
+	closeTimeout        time.Duration
+	maxMessageSize      int
+	pingInterval        time.Duration
+	pongTimeout         time.Duration
+	roundTripHandler    func(time.Duration)
+	httpHeaders         http.Header
+	httpClient          *http.Client
+	dialer              *net.Dialer
+	proxy               func(*url.URL) (*url.URL, error)
+	discardOnDisconnect bool
+	borrowMessages      bool
+	tls                 tlsOptions
+	authenticate        func(context.Context, *http.Request, bool) error
+	extraEndpoints      []string
+	resolver            EndpointResolver
+
+	compression                  bool
+	compressionNoContextTakeover bool
+	compressionThreshold         int
 }
 
 func (o *webSocketOptions) configure(options []Option) {
 	o.connectTimeout = defaultConnectTimeout
+	o.closeTimeout = defaultCloseTimeout
+	o.maxMessageSize = maxInputBuffer
 	o.maxReconnect = -1
 	o.reconnectWait = defaultReconnectWait
 	o.maxReconnectWait = defaultMaxReconnectWait
 	o.maxInFlight = defaultMaxInFlight
 	o.connectHandler = func() {}
+	o.attemptHandler = func(string, int) {}
+	o.handshakeHandler = func(func([]byte) error) error { return nil }
 	o.disconnectHandler = func() {}
 	o.messageHandler = func([]byte) {}
 	o.failureHandler = func(error) {}
 	o.errorHandler = func(error) {}
+	o.roundTripHandler = func(time.Duration) {}
+	o.retryHandler = func(RetryEvent) {}
+	o.clock = systemClock{}
+	o.compressionThreshold = defaultCompressionThreshold
 	o.configureHTTP()
 	for _, configure := range options {
 		configure(o)
 	}
+	if o.retryPolicy == nil {
+		o.retryPolicy = ExponentialBackoff(o.reconnectWait, o.maxReconnectWait)
+	}
+	if o.pongTimeout <= 0 {
+		o.pongTimeout = o.pingInterval
+	}
 }
 
 func MaxReconnect(max int) Option {
@@ -93,12 +132,48 @@ func OnMessage(handler func([]byte)) Option {
 	}
 }
 
+// BorrowMessages lends the messages passed to the OnMessage handler instead of
+// handing them over: a message is only valid until the handler returns, as its
+// buffer is then reused for the messages that follow. This saves allocating a
+// buffer per message.
+func BorrowMessages() Option {
+	return func(ws *webSocketOptions) {
+		ws.borrowMessages = true
+	}
+}
+
+// OnAttempt sets a handler called before every connection attempt, with the
+// endpoint and the number of the attempt, starting at 0.
+func OnAttempt(handler func(endpoint string, attempt int)) Option {
+	return func(ws *webSocketOptions) {
+		ws.attemptHandler = handler
+	}
+}
+
 func OnConnect(handler func()) Option {
 	return func(ws *webSocketOptions) {
 		ws.connectHandler = handler
 	}
 }
 
+// OnHandshake sets a handler that runs once a connection is established, before
+// any queued frame is written. Frames passed to send are written ahead of the
+// queue. If the handler returns an error the connection is dropped and retried.
+func OnHandshake(handler func(send func([]byte) error) error) Option {
+	return func(ws *webSocketOptions) {
+		ws.handshakeHandler = handler
+	}
+}
+
+// DiscardOnDisconnect drops the frames still queued when a connection is lost,
+// instead of sending them on the next connection. It is meant for callers that
+// replay what they need from OnHandshake.
+func DiscardOnDisconnect() Option {
+	return func(ws *webSocketOptions) {
+		ws.discardOnDisconnect = true
+	}
+}
+
 func OnDisconnect(handler func()) Option {
 	return func(ws *webSocketOptions) {
 		ws.disconnectHandler = handler
@@ -117,6 +192,73 @@ func MaxInFlight(count int) Option {
 	}
 }
 
+// MaxMessageSize sets the maximum size of a message received from the server,
+// once its fragments are reassembled. Larger messages close the connection with
+// CloseMessageTooBig. It defaults to 16 MiB.
+func MaxMessageSize(size int) Option {
+	return func(ws *webSocketOptions) {
+		ws.maxMessageSize = size
+	}
+}
+
+// CloseTimeout sets how long the closing handshake may last before the
+// connection is dropped. It defaults to one second.
+func CloseTimeout(d time.Duration) Option {
+	return func(ws *webSocketOptions) {
+		ws.closeTimeout = d
+	}
+}
+
+// PingInterval sets how often a ping is sent to the server. The connection is
+// dropped, and reconnected, when its pong is not received within the pong
+// timeout. Pings are disabled by default.
+func PingInterval(d time.Duration) Option {
+	return func(ws *webSocketOptions) {
+		ws.pingInterval = d
+	}
+}
+
+// PongTimeout sets how long to wait for the pong of a ping. It defaults to the
+// ping interval.
+func PongTimeout(d time.Duration) Option {
+	return func(ws *webSocketOptions) {
+		ws.pongTimeout = d
+	}
+}
+
+// OnRoundTrip sets a handler that receives the round-trip time measured for
+// each ping.
+func OnRoundTrip(handler func(time.Duration)) Option {
+	return func(ws *webSocketOptions) {
+		ws.roundTripHandler = handler
+	}
+}
+
+// Compression offers the permessage-deflate extension to the server. When the
+// server accepts it, messages are compressed in both directions.
+func Compression() Option {
+	return func(ws *webSocketOptions) {
+		ws.compression = true
+	}
+}
+
+// CompressionNoContextTakeover asks that every message is compressed on its own,
+// instead of sharing a compression context with the previous messages. It saves
+// memory at the expense of the compression ratio. c.f. Compression.
+func CompressionNoContextTakeover() Option {
+	return func(ws *webSocketOptions) {
+		ws.compressionNoContextTakeover = true
+	}
+}
+
+// CompressionThreshold sets the size under which messages are sent
+// uncompressed. It defaults to 128 bytes. c.f. Compression.
+func CompressionThreshold(size int) Option {
+	return func(ws *webSocketOptions) {
+		ws.compressionThreshold = size
+	}
+}
+
 func ErrorHandler(handler func(error)) Option {
 	return func(ws *webSocketOptions) {
 		ws.errorHandler = handler
@@ -0,0 +1,379 @@
+// Copyright 2019, 1533 Systems, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testConn is the server side of a connection.
+type testConn struct {
+	net.Conn
+	in *bufio.Reader
+}
+
+func (c *testConn) readFrame() (frameOpCode, []byte, error) {
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, opCode, _, payload, err := readTestFrame(c.in)
+	return opCode, payload, err
+}
+
+func (c *testConn) writeFrame(opCode frameOpCode, payload []byte) error {
+	_, err := c.Write(serverFrame(true, opCode, payload))
+	return err
+}
+
+// newTestServer starts a WebSocket server that hands its connections over to
+// the test, through the returned channel.
+func newTestServer(t testing.TB) (*httptest.Server, <-chan *testConn) {
+	conns := make(chan *testConn, 4)
+	return httptest.NewServer(upgradeHandler(t, conns)), conns
+}
+
+func upgradeHandler(t testing.TB, conns chan<- *testConn) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		netConn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+		rw.WriteString("Connection: Upgrade\r\nUpgrade: websocket\r\n")
+		rw.WriteString("Sec-WebSocket-Accept: " + acceptKey(r.Header.Get("Sec-WebSocket-Key")) + "\r\n")
+		rw.WriteString("Sec-WebSocket-Protocol: driveline\r\n\r\n")
+		rw.Flush()
+		conns <- &testConn{Conn: netConn, in: rw.Reader}
+	})
+}
+
+func dialTestServer(t testing.TB, server *httptest.Server, options ...Option) WebSocket {
+	endpoint := "ws" + strings.TrimPrefix(server.URL, "http")
+	options = append([]Option{ReconnectWait(time.Millisecond)}, options...)
+	ws, err := New(context.Background(), endpoint, options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ws
+}
+
+func TestWebSocket(t *testing.T) {
+	t.Run("answers ping with pong", func(t *testing.T) {
+		server, conns := newTestServer(t)
+		defer server.Close()
+		ws := dialTestServer(t, server)
+		defer ws.Close()
+		conn := <-conns
+		defer conn.Close()
+
+		conn.writeFrame(pingFrame, []byte("hello"))
+		opCode, payload, err := conn.readFrame()
+		if err != nil || opCode != pongFrame || string(payload) != "hello" {
+			t.Fail()
+		}
+	})
+	t.Run("measures the round-trip time of pings", func(t *testing.T) {
+		server, conns := newTestServer(t)
+		defer server.Close()
+		rtts := make(chan time.Duration, 1)
+		ws := dialTestServer(t, server, PingInterval(10*time.Millisecond), OnRoundTrip(func(rtt time.Duration) {
+			select {
+			case rtts <- rtt:
+			default:
+			}
+		}))
+		defer ws.Close()
+		conn := <-conns
+		defer conn.Close()
+
+		opCode, payload, err := conn.readFrame()
+		if err != nil || opCode != pingFrame {
+			t.Fatal("expected a ping")
+		}
+		time.Sleep(5 * time.Millisecond)
+		conn.writeFrame(pongFrame, payload)
+		if rtt := <-rtts; rtt < 5*time.Millisecond {
+			t.Errorf("unexpected round-trip time %s", rtt)
+		}
+	})
+	t.Run("reconnects when pongs stop", func(t *testing.T) {
+		server, conns := newTestServer(t)
+		defer server.Close()
+		errs := make(chan error, 4)
+		ws := dialTestServer(t, server,
+			PingInterval(10*time.Millisecond),
+			PongTimeout(20*time.Millisecond),
+			ErrorHandler(func(err error) { errs <- err }))
+		defer ws.Close()
+		conn := <-conns
+		defer conn.Close()
+
+		if err := <-errs; err != ErrPongTimeout {
+			t.Fatal(err)
+		}
+		for {
+			opCode, _, err := conn.readFrame()
+			if err == io.EOF {
+				break
+			}
+			if err != nil || opCode != pingFrame {
+				t.Fatal("expected the connection to be dropped")
+			}
+		}
+		select {
+		case conn := <-conns:
+			conn.Close()
+		case <-time.After(5 * time.Second):
+			t.Error("expected a new connection")
+		}
+	})
+	t.Run("runs the closing handshake on Close", func(t *testing.T) {
+		server, conns := newTestServer(t)
+		defer server.Close()
+		ws := dialTestServer(t, server, CloseTimeout(5*time.Second))
+		conn := <-conns
+		defer conn.Close()
+
+		ws.Write([]byte("pending"))
+		closed := make(chan struct{})
+		go func() {
+			ws.Close()
+			close(closed)
+		}()
+		opCode, payload, err := conn.readFrame()
+		if err != nil || opCode != binaryFrame || string(payload) != "pending" {
+			t.Fatal("expected the pending frame before the Close frame")
+		}
+		opCode, payload, err = conn.readFrame()
+		if err != nil || opCode != closeFrame || decodeClose(payload).Code != CloseNormalClosure {
+			t.Fatal("expected a Close frame")
+		}
+		conn.writeFrame(closeFrame, payload)
+		select {
+		case <-closed:
+		case <-time.After(time.Second):
+			t.Fatal("Close did not complete the handshake")
+		}
+		if _, err := conn.in.ReadByte(); err != io.EOF {
+			t.Error("expected the connection to be closed")
+		}
+	})
+	t.Run("answers the Close frame of the server", func(t *testing.T) {
+		server, conns := newTestServer(t)
+		defer server.Close()
+		errs := make(chan error, 1)
+		ws := dialTestServer(t, server, ErrorHandler(func(err error) { errs <- err }))
+		defer ws.Close()
+		conn := <-conns
+		defer conn.Close()
+
+		conn.writeFrame(closeFrame, encodeClose(CloseGoingAway, "restarting"))
+		opCode, payload, err := conn.readFrame()
+		if err != nil || opCode != closeFrame || decodeClose(payload).Code != CloseGoingAway {
+			t.Fatal("expected a Close frame")
+		}
+		closeErr, ok := (<-errs).(*CloseError)
+		if !ok || closeErr.Code != CloseGoingAway || closeErr.Reason != "restarting" {
+			t.Fail()
+		}
+	})
+	t.Run("closes the connection on messages too large", func(t *testing.T) {
+		server, conns := newTestServer(t)
+		defer server.Close()
+		errs := make(chan error, 1)
+		ws := dialTestServer(t, server, MaxMessageSize(4), ErrorHandler(func(err error) { errs <- err }))
+		defer ws.Close()
+		conn := <-conns
+		defer conn.Close()
+
+		conn.Write(serverFrame(false, binaryFrame, []byte("abc")))
+		conn.Write(serverFrame(true, continuationFrame, []byte("def")))
+		opCode, payload, err := conn.readFrame()
+		if err != nil || opCode != closeFrame || decodeClose(payload).Code != CloseMessageTooBig {
+			t.Fatal("expected a Close frame")
+		}
+		if err := <-errs; err != ErrMessageTooLarge {
+			t.Fail()
+		}
+	})
+	t.Run("reassembles fragmented messages", func(t *testing.T) {
+		server, conns := newTestServer(t)
+		defer server.Close()
+		messages := make(chan []byte, 1)
+		ws := dialTestServer(t, server, OnMessage(func(message []byte) { messages <- message }))
+		defer ws.Close()
+		conn := <-conns
+		defer conn.Close()
+
+		conn.Write(serverFrame(false, binaryFrame, []byte("frag")))
+		conn.Write(serverFrame(true, continuationFrame, []byte("mented")))
+		if message := <-messages; string(message) != "fragmented" {
+			t.Fail()
+		}
+	})
+	t.Run("lends messages to the handler", func(t *testing.T) {
+		server, conns := newTestServer(t)
+		defer server.Close()
+		messages := make(chan string, 2)
+		ws := dialTestServer(t, server, BorrowMessages(), OnMessage(func(message []byte) {
+			messages <- string(message)
+		}))
+		defer ws.Close()
+		conn := <-conns
+		defer conn.Close()
+
+		conn.writeFrame(binaryFrame, []byte("hello"))
+		conn.writeFrame(binaryFrame, []byte("world"))
+		if <-messages != "hello" || <-messages != "world" {
+			t.Fail()
+		}
+	})
+	t.Run("releases buffers once written", func(t *testing.T) {
+		server, conns := newTestServer(t)
+		defer server.Close()
+		ws := dialTestServer(t, server)
+		defer ws.Close()
+		conn := <-conns
+		defer conn.Close()
+
+		b := &testBuffer{payload: []byte("hello"), released: make(chan *testBuffer, 1)}
+		if err := ws.(BufferWriter).WriteBuffer(b); err != nil {
+			t.Fatal(err)
+		}
+		opCode, payload, err := conn.readFrame()
+		if err != nil || opCode != binaryFrame || string(payload) != "hello" {
+			t.Fail()
+		}
+		if <-b.released != b {
+			t.Fail()
+		}
+	})
+}
+
+type testBuffer struct {
+	payload  []byte
+	released chan *testBuffer
+}
+
+func (b *testBuffer) Bytes() []byte {
+	return b.payload
+}
+
+func (b *testBuffer) Release() {
+	b.released <- b
+}
+
+func BenchmarkWriteBuffer(b *testing.B) {
+	server, conns := newTestServer(b)
+	defer server.Close()
+	ws := dialTestServer(b, server)
+	defer ws.Close()
+	conn := <-conns
+	defer conn.Close()
+	go io.Copy(ioutil.Discard, conn)
+
+	record := make([]byte, 1024)
+	free := make(chan *testBuffer, defaultMaxInFlight+1)
+	for i := 0; i < cap(free); i++ {
+		free <- &testBuffer{payload: make([]byte, len(record)), released: free}
+	}
+	b.ReportAllocs()
+	b.SetBytes(int64(len(record)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buf := <-free
+		copy(buf.payload, record)
+		if err := ws.(BufferWriter).WriteBuffer(buf); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkReadMessage(b *testing.B) {
+	for _, borrow := range []bool{false, true} {
+		name := "owned"
+		if borrow {
+			name = "borrowed"
+		}
+		b.Run(name, func(b *testing.B) {
+			frame := serverFrame(true, binaryFrame, make([]byte, 1024))
+			in := bytes.NewReader(frame)
+			r := newFrameReader(in, 64*1024, maxInputBuffer)
+			r.borrow = borrow
+			b.ReportAllocs()
+			b.SetBytes(int64(len(frame)))
+			for i := 0; i < b.N; i++ {
+				in.Reset(frame)
+				r.in.Reset(in)
+				if _, _, err := r.readMessage(); err != nil {
+					b.Fatal(err)
+				}
+				r.release()
+			}
+		})
+	}
+}
+
+// readTestFrame reads a frame as a server would, unmasking it.
+func readTestFrame(in io.Reader) (bool, frameOpCode, bool, []byte, error) {
+	var hdr [8]byte
+	if _, err := io.ReadFull(in, hdr[:2]); err != nil {
+		return false, 0, false, nil, err
+	}
+	fin := hdr[0]&0x80 != 0
+	opCode := frameOpCode(hdr[0] & 0x0F)
+	masked := hdr[1]&0x80 != 0
+	size := uint64(hdr[1] & 0x7F)
+	switch size {
+	case 126:
+		if _, err := io.ReadFull(in, hdr[:2]); err != nil {
+			return false, 0, false, nil, err
+		}
+		size = uint64(binary.BigEndian.Uint16(hdr[:2]))
+	case 127:
+		if _, err := io.ReadFull(in, hdr[:8]); err != nil {
+			return false, 0, false, nil, err
+		}
+		size = binary.BigEndian.Uint64(hdr[:8])
+	}
+	var key [4]byte
+	if masked {
+		if _, err := io.ReadFull(in, key[:]); err != nil {
+			return false, 0, false, nil, err
+		}
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(in, payload); err != nil {
+		return false, 0, false, nil, err
+	}
+	maskBytes(key, 0, payload)
+	return fin, opCode, masked, payload, nil
+}
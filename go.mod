@@ -1,3 +1,3 @@
 module github.com/1533-systems/golang-sdk
 
-go 1.12
+go 1.18